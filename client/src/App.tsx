@@ -1,6 +1,6 @@
 import { Routes, Route, useNavigate, useLocation } from 'react-router-dom';
 import { useEffect, useState } from 'react';
-import { sourcesApi } from './api';
+import { authApi, sourcesApi, type User } from './api';
 import Sidebar from './components/Sidebar';
 import Player from './components/Player';
 import Queue from './components/Queue';
@@ -11,13 +11,38 @@ import Folders from './pages/Folders';
 import Artists from './pages/Artists';
 import Playlists from './pages/Playlists';
 import Settings from './pages/Settings';
+import Login from './pages/Login';
 
 function App() {
   const [hasSources, setHasSources] = useState<boolean | null>(null);
+  const [user, setUser] = useState<User | null | undefined>(undefined);
+  const [setupRequired, setSetupRequired] = useState(false);
   const navigate = useNavigate();
   const location = useLocation();
 
   useEffect(() => {
+    const checkAuth = async () => {
+      try {
+        const res = await authApi.me();
+        setUser(res.data);
+      } catch {
+        try {
+          const status = await authApi.status();
+          setSetupRequired(status.data.setupRequired);
+        } catch { /* server unreachable, show sign in */ }
+        setUser(null);
+      }
+    };
+    checkAuth();
+  }, []);
+
+  useEffect(() => {
+    if (!user) return;
+    // Only admins can list sources
+    if (user.role !== 'admin') {
+      setHasSources(true);
+      return;
+    }
     const init = async () => {
       try {
         const res = await sourcesApi.getAll();
@@ -32,7 +57,7 @@ function App() {
       }
     };
     init();
-  }, []);
+  }, [user]);
 
   // Poll only if NO sources exist, to detect when one is added
   useEffect(() => {
@@ -51,6 +76,11 @@ function App() {
     return () => clearInterval(interval);
   }, [hasSources]);
 
+  if (user === undefined) return <div className="h-screen bg-spotify-dark" />;
+  if (user === null) {
+    return <Login setupRequired={setupRequired} onLogin={u => { setSetupRequired(false); setUser(u); }} />;
+  }
+
   return (
     <div className="flex h-screen bg-spotify-dark">
       {/* Sidebar */}
@@ -5,6 +5,155 @@ const api = axios.create({
   baseURL: '/api',
 });
 
+// Every failed API request answers with { error: ApiError }
+export interface ApiError {
+  code: string;
+  message: string;
+  details?: unknown;
+  requestId?: string;
+}
+
+// Validation failures list each offending field in details
+export interface FieldError {
+  field: string;
+  message: string;
+}
+
+export function apiError(err: any): ApiError | undefined {
+  return err?.response?.data?.error;
+}
+
+export function errorMessage(err: any, fallback: string): string {
+  const e = apiError(err);
+  const details = e?.details;
+  if (Array.isArray(details) && details.length > 1) {
+    return (details as FieldError[]).map(d => `${d.field} ${d.message}`).join(', ');
+  }
+  return e?.message || fallback;
+}
+
+export interface User {
+  id: string;
+  username: string;
+  role: 'admin' | 'user';
+  createdAt: string;
+  updatedAt: string;
+}
+
+export const authApi = {
+  status: () => api.get<{ setupRequired: boolean }>('/auth/status'),
+  setup: (username: string, password: string) => api.post<{ user: User; token: string }>('/auth/setup', { username, password }),
+  login: (username: string, password: string) => api.post<{ user: User; token: string }>('/auth/login', { username, password }),
+  logout: () => api.post('/auth/logout'),
+  me: () => api.get<User>('/auth/me'),
+  changePassword: (currentPassword: string, newPassword: string) =>
+    api.post<{ user: User; token: string }>('/auth/password', { currentPassword, newPassword }),
+  getTokens: () => api.get<any[]>('/auth/tokens'),
+  createToken: (name: string, scopes: string[], expiresInDays?: number) =>
+    api.post<{ token: any; secret: string }>('/auth/tokens', { name, scopes, expiresInDays }),
+  deleteToken: (id: string) => api.delete(`/auth/tokens/${id}`),
+  resetSubsonicPassword: () => api.post<{ password: string }>('/auth/subsonic-password'),
+  clearSubsonicPassword: () => api.delete('/auth/subsonic-password'),
+};
+
+export const usersApi = {
+  getAll: () => api.get<User[]>('/users'),
+  create: (data: { username: string; password: string; role?: User['role'] }) => api.post<User>('/users', data),
+  update: (id: string, data: { password?: string; role?: User['role'] }) => api.patch<User>(`/users/${id}`, data),
+  delete: (id: string) => api.delete(`/users/${id}`),
+};
+
+export interface ImportResult {
+  sources: number;
+  playlists: number;
+  favorites: number;
+  skipped: number;
+  missing: number;
+}
+
+export const backupApi = {
+  download: () => api.get<Blob>('/backup', { responseType: 'blob' }),
+  exportLibrary: (credentials = false) => api.get<any>('/export', { params: { credentials } }),
+  importLibrary: (data: any) => api.post<ImportResult>('/import', data),
+};
+
+// These mirror the server's response types; /api/openapi.json describes them.
+export interface AlbumSummary {
+  id: string;
+  name: string;
+  artistId: string;
+  artist: string;
+  imageUrl?: string;
+  trackCount: number;
+  duration: number;
+  year?: number;
+  createdAt: string;
+}
+
+export interface AlbumDetail extends AlbumSummary {
+  tracks: Track[];
+}
+
+export interface Folder {
+  id: string;
+  name: string;
+  trackCount: number;
+  imageUrl?: string;
+}
+
+export interface PlaylistSummary {
+  id: string;
+  name: string;
+  smart: boolean;
+  rules?: any;
+  sourceId?: string;
+  sourcePath?: string;
+  ownerId?: string;
+  owner?: string;
+  visibility: 'private' | 'shared' | 'public';
+  readOnly: boolean;
+  access: 'none' | 'view' | 'edit' | 'owner';
+  following: boolean;
+  trackCount: number;
+  duration: number;
+  createdAt: string;
+  updatedAt: string;
+}
+
+export interface PlaylistItem {
+  id: string;
+  playlistId: string;
+  trackId: string;
+  order: number;
+  createdAt: string;
+}
+
+export interface PlaylistDetail extends PlaylistSummary {
+  tracks: Track[];
+  items: PlaylistItem[];
+}
+
+export interface SourceStatus {
+  sourceId: string;
+  status: string;
+  progress: number;
+  totalFiles: number;
+  scannedFiles: number;
+  lastError?: string;
+  lastScan?: string;
+}
+
+export interface SourceSummary extends Source {
+  status: SourceStatus;
+  stats: {
+    activeStreams: number;
+    maxStreams: number;
+    maxBandwidth: number;
+    bytesRead: number;
+    rejected: number;
+  };
+}
+
 export const tracksApi = {
   getAll: () => api.get<Track[]>('/tracks'),
   getOne: (id: string) => api.get<Track>(`/tracks/${id}`),
@@ -12,8 +161,8 @@ export const tracksApi = {
 };
 
 export const albumsApi = {
-  getAll: () => api.get<any[]>('/albums'),
-  getOne: (id: string) => api.get<any>(`/albums/${id}`),
+  getAll: () => api.get<AlbumSummary[]>('/albums'),
+  getOne: (id: string) => api.get<AlbumDetail>(`/albums/${id}`),
 };
 
 export const artistsApi = {
@@ -22,42 +171,79 @@ export const artistsApi = {
 };
 
 export const foldersApi = {
-  getAll: () => api.get<any[]>('/folders'),
-  getTracks: (path: string) => api.get<any[]>(`/folders/tracks?path=${encodeURIComponent(path)}`),
+  getAll: () => api.get<Folder[]>('/folders'),
+  getTracks: (path: string) => api.get<Track[]>(`/folders/tracks?path=${encodeURIComponent(path)}`),
 };
 
 export const sourcesApi = {
-  getAll: () => api.get<Source[]>('/sources'),
+  getAll: () => api.get<SourceSummary[]>('/sources'),
   create: (data: Partial<Source>) => api.post<Source>('/sources', data),
   update: (id: string, data: Partial<Source>) => api.patch<Source>(`/sources/${id}`, data),
   delete: (id: string) => api.delete(`/sources/${id}`),
   scan: (id: string) => api.post(`/sources/${id}/scan`),
   scanAll: () => api.post('/scan'),
   discover: () => api.get<any[]>('/discover'),
-  getStatus: (id: string) => api.get<any>(`/sources/${id}/status`),
+  getStatus: (id: string) => api.get<SourceStatus>(`/sources/${id}/status`),
   test: (source: string | Partial<Source>) => {
     if (typeof source === 'string') {
       return api.post<{ success: boolean; message?: string }>(`/sources/${source}/test`);
     }
     return api.post<{ success: boolean; message?: string }>('/sources/test', source);
   },
-  enumerateShares: (data: { host: string; username?: string; password?: string; domain?: string }) => 
+  enumerateShares: (data: { host: string; username?: string; password?: string; domain?: string; sourceId?: string }) => 
     api.post<string[]>('/smb/enumerate-shares', data),
 };
 
 export const playlistsApi = {
-  getAll: () => api.get<any[]>('/playlists'),
+  getAll: () => api.get<PlaylistSummary[]>('/playlists'),
   create: (name: string) => api.post<any>('/playlists', { name }),
-  getOne: (id: string) => api.get<any>(`/playlists/${id}`),
+  createSmart: (name: string, rules: any) => api.post<any>('/playlists', { name, smart: true, rules }),
+  updateRules: (id: string, rules: any) => api.patch<PlaylistDetail>(`/playlists/${id}`, { rules }),
+  getOne: (id: string) => api.get<PlaylistDetail>(`/playlists/${id}`),
   delete: (id: string) => api.delete(`/playlists/${id}`),
   addTrack: (playlistId: string, trackId: string) => 
     api.post(`/playlists/${playlistId}/tracks`, { trackId }),
   removeTrack: (playlistId: string, trackId: string) => 
     api.delete(`/playlists/${playlistId}/tracks/${trackId}`),
+  rename: (id: string, name: string) => api.patch<PlaylistDetail>(`/playlists/${id}`, { name }),
+  addTracks: (playlistId: string, data: { trackIds?: string[]; albumId?: string; folderPath?: string }) =>
+    api.post(`/playlists/${playlistId}/tracks`, data),
+  clear: (id: string) => api.delete(`/playlists/${id}/tracks`),
+  moveItem: (playlistId: string, itemId: string, position: number) =>
+    api.patch(`/playlists/${playlistId}/items/${itemId}`, { position }),
+  removeItem: (playlistId: string, itemId: string) =>
+    api.delete(`/playlists/${playlistId}/items/${itemId}`),
+  exportUrl: (id: string, format: 'm3u' | 'm3u8' | 'pls' | 'xspf' = 'm3u8') =>
+    `/api/playlists/${id}/export?format=${format}`,
+  import: (file: File, name?: string) => {
+    const form = new FormData();
+    form.append('file', file);
+    if (name) form.append('name', name);
+    return api.post<any>('/playlists/import', form);
+  },
+  getPublic: () => api.get<PlaylistSummary[]>('/playlists?scope=public'),
+  getFollowed: () => api.get<PlaylistSummary[]>('/playlists/followed'),
+  setVisibility: (id: string, visibility: 'private' | 'shared' | 'public') =>
+    api.patch<PlaylistDetail>(`/playlists/${id}`, { visibility }),
+  getCollaborators: (id: string) => api.get<any[]>(`/playlists/${id}/collaborators`),
+  setCollaborator: (id: string, username: string, permission: 'view' | 'edit' = 'view') =>
+    api.post<any[]>(`/playlists/${id}/collaborators`, { username, permission }),
+  removeCollaborator: (id: string, userId: string) => api.delete(`/playlists/${id}/collaborators/${userId}`),
+  follow: (id: string) => api.put(`/playlists/${id}/follow`),
+  unfollow: (id: string) => api.delete(`/playlists/${id}/follow`),
 };
 
 export function getStreamUrl(trackId: string) {
   return `/api/stream/${trackId}`;
 }
 
+export function getDownloadUrl(kind: 'albums' | 'playlists', id: string, profile?: string) {
+  const query = profile ? `?profile=${encodeURIComponent(profile)}` : '';
+  return `/api/${kind}/${id}/download${query}`;
+}
+
+export function getHlsUrl(trackId: string) {
+  return `/api/stream/${trackId}/playlist.m3u8`;
+}
+
 export default api;
\ No newline at end of file
@@ -0,0 +1,49 @@
+import { useState } from 'react';
+import { authApi, errorMessage, type User } from '../api';
+
+interface LoginProps {
+  setupRequired: boolean;
+  onLogin: (user: User) => void;
+}
+
+export default function Login({ setupRequired, onLogin }: LoginProps) {
+  const [username, setUsername] = useState('');
+  const [password, setPassword] = useState('');
+  const [error, setError] = useState<string | null>(null);
+  const [submitting, setSubmitting] = useState(false);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setSubmitting(true);
+    setError(null);
+    try {
+      const res = setupRequired
+        ? await authApi.setup(username, password)
+        : await authApi.login(username, password);
+      onLogin(res.data.user);
+    } catch (err: any) {
+      setError(errorMessage(err, 'Sign in failed'));
+    } finally {
+      setSubmitting(false);
+    }
+  };
+
+  return (
+    <div className="flex h-screen items-center justify-center bg-spotify-dark">
+      <form onSubmit={handleSubmit} className="w-full max-w-sm p-8 bg-white/5 rounded-xl space-y-4">
+        <div>
+          <h1 className="text-2xl font-bold text-white">{setupRequired ? 'Create admin account' : 'Sign in'}</h1>
+          {setupRequired && (
+            <p className="text-sm text-spotify-gray mt-1">This account will manage sources and other users.</p>
+          )}
+        </div>
+        <input value={username} onChange={e => setUsername(e.target.value)} placeholder="Username" autoComplete="username" className="w-full px-3 py-2 bg-white/5 border border-white/10 focus:border-spotify-green outline-none rounded-lg text-white text-sm transition-all" />
+        <input type="password" value={password} onChange={e => setPassword(e.target.value)} placeholder="Password" autoComplete={setupRequired ? 'new-password' : 'current-password'} className="w-full px-3 py-2 bg-white/5 border border-white/10 focus:border-spotify-green outline-none rounded-lg text-white text-sm transition-all" />
+        {error && <p className="text-sm text-red-400">{error}</p>}
+        <button type="submit" disabled={submitting || !username || !password} className="w-full py-2 bg-spotify-green text-black font-bold rounded-full disabled:opacity-50">
+          {submitting ? 'Please wait...' : setupRequired ? 'Create account' : 'Sign in'}
+        </button>
+      </form>
+    </div>
+  );
+}
@@ -1,19 +1,9 @@
 import { useState, useEffect } from 'react';
-import { playlistsApi } from '../api';
-import { usePlayer, type Track } from '../store/player';
-
-interface Playlist {
-  id: string;
-  name: string;
-  trackCount: number;
-}
-
-interface PlaylistDetail extends Playlist {
-  tracks: (Track & { position: number })[];
-}
+import { playlistsApi, type PlaylistSummary, type PlaylistDetail } from '../api';
+import { usePlayer } from '../store/player';
 
 export default function Playlists({ hasSources }: { hasSources: boolean | null }) {
-  const [playlists, setPlaylists] = useState<Playlist[]>([]);
+  const [playlists, setPlaylists] = useState<PlaylistSummary[]>([]);
   const [selected, setSelected] = useState<PlaylistDetail | null>(null);
   const [loading, setLoading] = useState(true);
   const [creating, setCreating] = useState(false);
@@ -52,7 +42,7 @@ export default function Playlists({ hasSources }: { hasSources: boolean | null }
     }
   };
 
-  const openPlaylist = async (pl: Playlist) => {
+  const openPlaylist = async (pl: PlaylistSummary) => {
     try {
       const res = await playlistsApi.getOne(pl.id);
       setSelected(res.data);
@@ -92,11 +82,11 @@ export default function Playlists({ hasSources }: { hasSources: boolean | null }
                 <div key={track.id} onClick={() => playTrack(track, selected.tracks)} className="flex items-center gap-4 px-4 py-3 hover:bg-spotify-light/30 cursor-pointer group">
                   <span className="w-6 text-center text-spotify-gray">{i + 1}</span>
                   <div className="w-10 h-10 bg-spotify-light rounded flex items-center justify-center text-spotify-gray overflow-hidden">
-                    {track.album?.imageUrl ? <img src={track.album.imageUrl} alt={track.album.name} className="w-full h-full object-cover" /> : '🎵'}
+                    {track.imageUrl ? <img src={track.imageUrl} alt={track.album} className="w-full h-full object-cover" /> : '🎵'}
                   </div>
                   <div className="flex-1 min-w-0">
                     <p className="text-white truncate">{track.title}</p>
-                    <p className="text-xs text-spotify-gray truncate">{track.artist?.name || 'Unknown'}</p>
+                    <p className="text-xs text-spotify-gray truncate">{track.artistsDisplay || track.artist || 'Unknown'}</p>
                   </div>
                 </div>
               ))}
@@ -1,5 +1,5 @@
 import { useState, useEffect, useCallback } from 'react';
-import { sourcesApi } from '../api';
+import { errorMessage, sourcesApi } from '../api';
 import { usePlayer, type Source } from '../store/player';
 import { formatDistanceToNow } from 'date-fns';
 
@@ -126,6 +126,7 @@ export default function Settings() {
         port: editing.port,
         username: editing.username,
         password: editing.password,
+        clearPassword: editing.clearPassword || undefined,
         domain: editing.domain,
         share: editing.share,
         basePath: editing.basePath,
@@ -143,11 +144,7 @@ export default function Settings() {
       loadSources();
     } catch (err: any) {
       console.error('Save failed:', err);
-      const details = err.response?.data?.error;
-      const message = Array.isArray(details) 
-        ? details.map((d: any) => `${d.path.join('.')}: ${d.message}`).join(', ')
-        : (typeof details === 'string' ? details : err.message);
-      showNotification(`Save failed: ${message}`, 'error');
+      showNotification(`Save failed: ${errorMessage(err, err.message)}`, 'error');
     } finally {
       setSaving(false);
     }
@@ -178,14 +175,14 @@ export default function Settings() {
         showNotification(`Connection failed: ${res.data.message}`, 'error');
       }
     } catch (err: any) {
-      showNotification(`Test failed: ${err.response?.data?.error || err.message}`, 'error');
+      showNotification(`Test failed: ${errorMessage(err, err.message)}`, 'error');
     } finally {
       setTesting(null);
     }
   };
 
   const handleContinue = async () => {
-    if (!editing?.host || !editing?.username || !editing?.password) {
+    if (!editing?.host || !editing?.username || !(editing?.password || editing?.hasPassword)) {
       showNotification('Host, Username and Password are required', 'error');
       return;
     }
@@ -203,6 +200,7 @@ export default function Settings() {
         username: editing.username || '',
         password: editing.password || '',
         domain: editing.domain || '',
+        sourceId: editing.id,
       });
       
       const shares = res.data;
@@ -220,7 +218,7 @@ export default function Settings() {
       setWizardStep(2);
     } catch (err: any) {
       console.error('Enumerate failed:', err);
-      const msg = err.response?.data?.error || 'Failed to list shares on the server.';
+      const msg = errorMessage(err, 'Failed to list shares on the server.');
       showNotification(msg, 'error');
     } finally {
       setIsEnumerating(false);
@@ -438,7 +436,13 @@ export default function Settings() {
                       </div>
                       <div>
                         <label className="block text-[10px] font-bold text-spotify-gray uppercase tracking-widest mb-1.5 ml-1">Password</label>
-                        <input type="password" value={editing.password || ''} onChange={e => setEditing({ ...editing, password: e.target.value })} placeholder="••••••••" className="w-full px-3 py-2 bg-white/5 border border-white/10 focus:border-spotify-green outline-none rounded-lg text-white text-sm transition-all" />
+                        <input type="password" value={editing.password || ''} onChange={e => setEditing({ ...editing, password: e.target.value, clearPassword: false })} placeholder={editing.hasPassword ? 'Leave blank to keep the current password' : '••••••••'} className="w-full px-3 py-2 bg-white/5 border border-white/10 focus:border-spotify-green outline-none rounded-lg text-white text-sm transition-all" />
+                        {editing.id && editing.hasPassword && editing.type === 'smb' && (
+                          <label className="flex items-center gap-2 mt-2 ml-1 text-xs text-spotify-gray">
+                            <input type="checkbox" checked={!!editing.clearPassword} onChange={e => setEditing({ ...editing, clearPassword: e.target.checked, password: '' })} />
+                            Remove the saved password (guest access)
+                          </label>
+                        )}
                       </div>
                     </div>
                   </div>
@@ -25,6 +25,8 @@ export interface Track {
   size?: number;
   sourceId: string;
   imageUrl?: string;
+  folderPath?: string;
+  artistsDisplay?: string;
   sourceMtime?: string;
 }
 
@@ -35,7 +37,11 @@ export interface Source {
   host: string;
   port?: number;
   username?: string;
+  // Only ever sent; responses carry hasPassword instead
   password?: string;
+  hasPassword?: boolean;
+  // Removes the saved password when sent
+  clearPassword?: boolean;
   domain?: string;
   share?: string;
   basePath: string;
@@ -2,74 +2,223 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"homemusic-server/internal/api"
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/config"
 	"homemusic-server/internal/db"
+	"homemusic-server/internal/dlna"
+	"homemusic-server/internal/logging"
+	"homemusic-server/internal/mpd"
 	"homemusic-server/internal/scanner"
+	"homemusic-server/internal/subsonic"
+	"homemusic-server/internal/types"
 )
 
+// version is announced to clients; release builds set it with
+// -ldflags "-X main.version=...".
+var version = "1.0.0"
+
 func main() {
+	pendingMigrations := flag.Bool("pending-migrations", false, "list schema migrations not yet applied to the database and exit")
+	backupTo := flag.String("backup", "", "write a backup of the database to `file` and exit")
+	restoreFrom := flag.String("restore", "", "replace the database with the backup in `file` and exit; stop the server first")
+	settings := config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := settings.Load(os.Getenv)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if settings.Print {
+		if err := cfg.WriteYAML(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	logging.SetLevel(cfg.Level())
+	scanner.ArtDir = cfg.ArtDir
+	scanner.SetConcurrency(cfg.ScanConcurrency)
+	api.HLSCacheDir = filepath.Join(cfg.CacheDir, "hls")
+
 	// Initialize database
-	dbPath := os.Getenv("DATABASE_URL")
-	if dbPath == "" {
-		dbPath = "music.db"
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		log.Fatalf("Failed to create data directory: %v", err)
+	}
+	dbPath := cfg.Database
+	if *pendingMigrations {
+		printPendingMigrations(dbPath)
+		return
+	}
+	if *restoreFrom != "" {
+		if err := db.Restore(*restoreFrom, dbPath); err != nil {
+			log.Fatalf("Restore failed: %v", err)
+		}
+		fmt.Printf("Restored %s from %s\n", dbPath, *restoreFrom)
+		return
 	}
 	if err := db.InitDB(dbPath); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	if *backupTo != "" {
+		if err := db.Backup(*backupTo); err != nil {
+			log.Fatalf("Backup failed: %v", err)
+		}
+		fmt.Printf("Backed up %s to %s\n", dbPath, *backupTo)
+		return
+	}
+
+	store := db.SQLiteStore()
+	scans := scanner.New(store)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Background services main waits for, so they can finish their work or
+	// say goodbye on the network before the process exits
+	var background sync.WaitGroup
+
+	// Keep rotated backups when a directory is configured
+	if dir := cfg.Backup.Dir; dir != "" {
+		interval, keep := cfg.BackupInterval(), cfg.Backup.Keep
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			db.ScheduleBackups(ctx, dir, interval, keep)
+		}()
+		log.Printf("💾 Backing up to %s every %s, keeping %d", dir, interval, keep)
+	}
+
 	// Start Network Discovery
-	scanner.GlobalDiscoveryManager.Start(context.Background())
+	if cfg.Discovery {
+		scanner.GlobalDiscoveryManager.Start(ctx)
+	}
 
 	r := chi.NewRouter()
 
-	r.Use(middleware.Logger)
+	r.Use(middleware.RequestID)
+	if logging.Enabled(logging.LevelInfo) {
+		r.Use(api.RequestLogger)
+	}
 	r.Use(middleware.Recoverer)
+	// Only trusted origins may make credentialed cross-site requests
 	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},
+		AllowedOrigins:   cfg.CORSAllowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "X-Request-Id"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
+	if count, err := db.CountUsers(); err == nil && count == 0 {
+		log.Println("🔐 No user accounts yet: open the web UI to create the admin account")
+	}
+
 	r.Route("/api", func(r chi.Router) {
-		api.RegisterSourceRoutes(r)
-		api.RegisterLibraryRoutes(r)
-		api.RegisterPlaylistRoutes(r)
-		api.RegisterStreamRoutes(r)
-		
-		// Serve Album Artwork under /api/art/
-		artPath := filepath.Join("public", "art")
-		os.MkdirAll(artPath, 0755)
-		
-		// Correctly handle the prefix for artwork
-		fs := http.FileServer(http.Dir(artPath))
-		r.Handle("/art/*", http.StripPrefix("/api/art/", fs))
+		r.NotFound(apierr.NotFoundHandler)
+		r.MethodNotAllowed(apierr.MethodNotAllowedHandler)
+		api.RegisterAuthRoutes(r)
 
 		r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte("ok"))
 		})
+		r.Get("/openapi.json", api.OpenAPIHandler(version))
+
+		// Everything else requires a signed-in user
+		r.Group(func(r chi.Router) {
+			r.Use(auth.RequireUser)
+
+			api.RegisterAccountRoutes(r)
+
+			// API tokens are limited to the scopes they were issued with
+			r.Group(func(r chi.Router) {
+				r.Use(auth.RequireScope(types.ScopeLibrary))
+				api.RegisterLibraryRoutes(store)(r)
+
+				// Serve Album Artwork under /api/art/
+				artPath := cfg.ArtDir
+				os.MkdirAll(artPath, 0755)
+
+				// Correctly handle the prefix for artwork
+				fs := http.FileServer(http.Dir(artPath))
+				r.Handle("/art/*", http.StripPrefix("/api/art/", fs))
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(auth.RequireScope(types.ScopeLibrary), auth.RequireWriteScope(types.ScopePlaylists))
+				api.RegisterPlaylistRoutes(store)(r)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(auth.RequireScope(types.ScopeStream))
+				api.RegisterStreamRoutes(store)(r)
+			})
+
+			// Sources hold credentials for other machines, so only admins manage them
+			r.Group(func(r chi.Router) {
+				r.Use(auth.RequireAdmin)
+				r.With(auth.RequireScope(types.ScopeSources)).Group(api.RegisterSourceRoutes(store, scans))
+				r.With(auth.RequireScope(types.ScopeAdmin)).Group(api.RegisterUserRoutes)
+				r.With(auth.RequireScope(types.ScopeAdmin)).Group(api.RegisterBackupRoutes)
+			})
+		})
 	})
 
+	// Subsonic clients authenticate per request with their own parameters
+	r.Route("/rest", api.RegisterSubsonicRoutes(store))
+
+	portNum := cfg.Port()
+
+	// UPnP has no authentication, so the MediaServer is opt-in
+	if cfg.DLNA.Enabled {
+		name := cfg.DLNA.Name
+		device := dlna.NewDevice(name, "/dlna")
+		r.Route(device.MountPath, api.RegisterDLNARoutes(store, device))
+
+		advertiser := &dlna.Advertiser{Device: device, Scheme: cfg.Scheme(), Port: portNum}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			if err := advertiser.Run(ctx); err != nil {
+				log.Printf("[DLNA] SSDP advertising stopped: %v", err)
+			}
+		}()
+		log.Printf("📺 DLNA media server %q enabled", name)
+	}
+
+	// MPD clients sign in with an API token as their password
+	if addr := cfg.MPD.Listen; addr != "" {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			if err := mpd.NewServer(addr, store, scans).ListenAndServe(ctx); err != nil {
+				log.Printf("[MPD] Server stopped: %v", err)
+			}
+		}()
+	}
+
 	// Serve Frontend Static Files & SPA Catch-all
-	distPath := filepath.Join("..", "client", "dist")
-	if _, err := os.Stat(distPath); err == nil {
+	distPath := cfg.FrontendDir
+	if _, err := os.Stat(distPath); distPath != "" && err == nil {
 		r.Get("/*", func(w http.ResponseWriter, r *http.Request) {
 			path := r.URL.Path
 			
 			// Exclude internal routes from SPA fallback
-			if strings.HasPrefix(path, "/api") {
+			if strings.HasPrefix(path, "/api") || strings.HasPrefix(path, "/dlna") {
 				http.NotFound(w, r)
 				return
 			}
@@ -86,11 +235,69 @@ func main() {
 		})
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3001"
+	// Announce the server so apps on the network can find it by name
+	if cfg.AdvertiseMDNS() {
+		name := cfg.MDNS.Name
+		if name == "" {
+			name = "HomeMusic"
+			if hostname, err := os.Hostname(); err == nil {
+				name += " on " + hostname
+			}
+		}
+		tls := "tls=0"
+		if cfg.TLSEnabled() {
+			tls = "tls=1"
+		}
+		advertiser, err := scanner.Advertise([]scanner.Announcement{
+			{Instance: name, Service: "_homemusic._tcp", Port: portNum, Text: []string{"version=" + version, tls, "path=/api"}},
+			{Instance: name, Service: "_subsonic._tcp", Port: portNum, Text: []string{"version=" + subsonic.APIVersion, tls, "path=/rest"}},
+		})
+		if err != nil {
+			log.Printf("[Discovery] mDNS advertising failed: %v", err)
+		} else {
+			defer advertiser.Shutdown()
+		}
 	}
 
-	fmt.Printf("🎵 HomeMusic Go Server running at http://localhost:%s\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	srv := &http.Server{Addr: cfg.Listen, Handler: r}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		log.Printf("Shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("HTTP shutdown: %v", err)
+		}
+	}()
+
+	scheme := cfg.Scheme()
+	serve := srv.ListenAndServe
+	if cfg.TLSEnabled() {
+		serve = func() error { return srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile) }
+	}
+	fmt.Printf("🎵 HomeMusic Go Server running at %s://localhost:%d\n", scheme, portNum)
+	if err := serve(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
+	background.Wait()
+}
+
+func printPendingMigrations(dbPath string) {
+	if err := db.Open(dbPath); err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		log.Fatalf("Failed to read migrations: %v", err)
+	}
+	if len(pending) == 0 {
+		fmt.Println("No pending migrations")
+		return
+	}
+	for _, m := range pending {
+		fmt.Println(m)
+	}
 }
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/types"
+)
+
+// RegisterAuthRoutes mounts the endpoints reachable without a session.
+func RegisterAuthRoutes(r chi.Router) {
+	r.Get("/auth/status", handleAuthStatus)
+	r.Post("/auth/setup", handleAuthSetup)
+	r.Post("/auth/login", handleLogin)
+	r.Post("/auth/logout", handleLogout)
+}
+
+// RegisterAccountRoutes mounts the signed-in user's own account endpoints.
+func RegisterAccountRoutes(r chi.Router) {
+	r.Get("/auth/me", handleGetMe)
+
+	// A leaked API token must not be able to lock the owner out or mint more
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireSession)
+		r.Post("/auth/password", handleChangePassword)
+		r.Get("/auth/tokens", handleGetAPITokens)
+		r.Post("/auth/tokens", handleCreateAPIToken)
+		r.Delete("/auth/tokens/{id}", handleDeleteAPIToken)
+		r.Post("/auth/subsonic-password", handleResetSubsonicPassword)
+		r.Delete("/auth/subsonic-password", handleClearSubsonicPassword)
+	})
+}
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func handleAuthStatus(w http.ResponseWriter, r *http.Request) {
+	count, err := db.CountUsers()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]bool{"setupRequired": count == 0})
+}
+
+// handleAuthSetup creates the first admin account. It only works while no
+// users exist.
+func handleAuthSetup(w http.ResponseWriter, r *http.Request) {
+	var req credentials
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	count, err := db.CountUsers()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if count > 0 {
+		writeError(w, r, apierr.Conflict("Setup has already been completed"))
+		return
+	}
+
+	user, ok := createUser(w, r, req, db.CreateFirstAdmin)
+	if !ok {
+		return
+	}
+	log.Printf("[Auth] Created initial admin account %q", user.Username)
+
+	token, err := auth.StartSession(w, r, user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"user": user, "token": token})
+}
+
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req credentials
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	user, err := auth.Authenticate(strings.TrimSpace(req.Username), req.Password)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if user == nil {
+		log.Printf("[Auth] Failed login for %q from %s", req.Username, r.RemoteAddr)
+		writeError(w, r, apierr.Unauthorized("Invalid username or password"))
+		return
+	}
+
+	token, err := auth.StartSession(w, r, user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"user": user, "token": token})
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if err := auth.EndSession(w, r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleGetMe(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(auth.UserFromContext(r.Context()))
+}
+
+func handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	current := auth.UserFromContext(r.Context())
+	user, err := auth.Authenticate(current.Username, req.CurrentPassword)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if user == nil {
+		writeError(w, r, apierr.Unauthorized("Current password is incorrect"))
+		return
+	}
+
+	hash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := db.UpdateUserPassword(user.ID, hash); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Changing the password ends every session, so issue a fresh one
+	token, err := auth.StartSession(w, r, user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"user": user, "token": token})
+}
+
+// handleResetSubsonicPassword generates a new app password for Subsonic
+// clients. It is only shown in this response.
+func handleResetSubsonicPassword(w http.ResponseWriter, r *http.Request) {
+	token, _, err := auth.NewToken()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	password := token[:24]
+	if err := db.SetSubsonicPassword(auth.UserFromContext(r.Context()).ID, &password); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"password": password})
+}
+
+func handleClearSubsonicPassword(w http.ResponseWriter, r *http.Request) {
+	if err := db.SetSubsonicPassword(auth.UserFromContext(r.Context()).ID, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createUser validates a new account and stores it with create, writing the
+// error response itself when it fails.
+func createUser(w http.ResponseWriter, r *http.Request, req credentials, create func(username, passwordHash string) (*types.User, error)) (*types.User, bool) {
+	username := strings.TrimSpace(req.Username)
+	if username == "" {
+		writeError(w, r, apierr.Invalid("Username is required"))
+		return nil, false
+	}
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		writeError(w, r, err)
+		return nil, false
+	}
+
+	user, err := create(username, hash)
+	if err != nil {
+		writeError(w, r, err)
+		return nil, false
+	}
+	return user, true
+}
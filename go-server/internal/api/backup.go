@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/logging"
+)
+
+// RegisterBackupRoutes mounts database backup and library export; callers
+// must require admin.
+func RegisterBackupRoutes(r chi.Router) {
+	r.Get("/backup", handleDownloadBackup)
+	r.Get("/export", handleExportLibrary)
+	r.Post("/import", handleImportLibrary)
+}
+
+// handleDownloadBackup sends a snapshot of the whole database, including
+// accounts and source credentials.
+func handleDownloadBackup(w http.ResponseWriter, r *http.Request) {
+	dir, err := os.MkdirTemp("", "homemusic-backup-")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	name := db.BackupName(time.Now())
+	path := filepath.Join(dir, name)
+	if err := db.Backup(path); err != nil {
+		log.Printf("[API] Backup failed: %v", err)
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeFile(w, r, path)
+}
+
+func handleExportLibrary(w http.ResponseWriter, r *http.Request) {
+	credentials, _ := strconv.ParseBool(r.URL.Query().Get("credentials"))
+	doc, err := db.ExportLibrary(credentials)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "homemusic-export-"+doc.ExportedAt.Format("20060102")+".json"))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(doc)
+}
+
+func handleImportLibrary(w http.ResponseWriter, r *http.Request) {
+	var doc db.LibraryExport
+	if err := readJSON(r, &doc); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateImportedSources(&doc); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	result, err := db.ImportLibrary(&doc, auth.UserFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	logging.Infof("[API] Imported %d sources, %d playlists and %d favorites (%d skipped, %d missing)",
+		result.Sources, result.Playlists, result.Favorites, result.Skipped, result.Missing)
+	json.NewEncoder(w).Encode(result)
+}
+
+// validateImportedSources normalizes the document's sources and applies the
+// checks POST /sources makes, except that passwords may be missing: exports
+// taken without credentials leave them out, to be entered again later.
+func validateImportedSources(doc *db.LibraryExport) error {
+	var problems []string
+	for i := range doc.Sources {
+		s := &doc.Sources[i]
+		s.ID = strings.TrimSpace(s.ID)
+		normalizeSource(s)
+		for _, e := range validateSource(s) {
+			if e.Field == "password" && s.Password == nil {
+				continue
+			}
+			problems = append(problems, fmt.Sprintf("sources[%d].%s %s", i, e.Field, e.Message))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", db.ErrInvalidExport, strings.Join(problems, "; "))
+	}
+	return nil
+}
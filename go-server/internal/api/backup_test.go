@@ -0,0 +1,44 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/types"
+)
+
+func TestValidateImportedSources(t *testing.T) {
+	share, user := " Music/ ", "me"
+	doc := &db.LibraryExport{Sources: []types.Source{
+		{ID: " nas ", Type: types.SourceTypeSMB, Host: " nas.local ", Share: &share},
+		// Exported without credentials
+		{ID: "pi", Type: types.SourceTypeSSH, Host: "pi", Username: &user},
+	}}
+	if err := validateImportedSources(doc); err != nil {
+		t.Fatal(err)
+	}
+	nas := doc.Sources[0]
+	if nas.ID != "nas" || nas.Name != "nas.local" || nas.Port != 445 || *nas.Share != "Music" {
+		t.Errorf("source not normalized: %+v", nas)
+	}
+
+	doc = &db.LibraryExport{Sources: []types.Source{
+		{ID: "ok", Type: types.SourceTypeSSH, Host: "pi", Username: &user},
+		{ID: "bad", Type: types.SourceTypeSMB, Host: "smb://nas", Port: 70000},
+		{ID: "ftp", Type: "ftp", Host: "ftp"},
+	}}
+	err := validateImportedSources(doc)
+	if !errors.Is(err, db.ErrInvalidExport) {
+		t.Fatalf("err = %v, want ErrInvalidExport", err)
+	}
+	for _, want := range []string{"sources[1].host", "sources[1].port", "sources[1].share", "sources[2].type"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "sources[0]") {
+		t.Errorf("valid source reported: %v", err)
+	}
+}
@@ -0,0 +1,522 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/dlna"
+	"homemusic-server/internal/types"
+)
+
+// Top-level containers of the ContentDirectory. Other object IDs are a
+// kind prefix and the database ID, such as "album/<id>".
+const (
+	dlnaRootID      = "0"
+	dlnaArtistsID   = "artists"
+	dlnaAlbumsID    = "albums"
+	dlnaFoldersID   = "folders"
+	dlnaPlaylistsID = "playlists"
+)
+
+var errDLNANoSuchObject = errors.New("no such object")
+
+func init() {
+	chi.RegisterMethod("SUBSCRIBE")
+	chi.RegisterMethod("UNSUBSCRIBE")
+}
+
+// RegisterDLNARoutes serves the UPnP MediaServer description and control
+// endpoints for the library in store. UPnP has no authentication, so anyone on the network can
+// browse the library and public playlists; resource URLs are signed so
+// renderers can stream without signing in.
+func RegisterDLNARoutes(store *db.Store, device *dlna.Device) func(chi.Router) {
+	return func(r chi.Router) {
+		r.Get(dlna.DescriptionPath, func(w http.ResponseWriter, r *http.Request) {
+			writeDLNAXML(w, string(device.Description()))
+		})
+		r.Get(dlna.ContentDirectorySCPD, func(w http.ResponseWriter, r *http.Request) {
+			writeDLNAXML(w, dlna.ContentDirectorySCPDXML)
+		})
+		r.Get(dlna.ConnectionManagerSCPD, func(w http.ResponseWriter, r *http.Request) {
+			writeDLNAXML(w, dlna.ConnectionManagerSCPDXML)
+		})
+		r.Post(dlna.ContentDirectoryCtl, func(w http.ResponseWriter, r *http.Request) {
+			handleDLNAContentDirectory(w, r, store, device)
+		})
+		r.Post(dlna.ConnectionManagerCtl, handleDLNAConnectionManager)
+
+		// No events are sent, but some control points refuse to browse when
+		// subscribing fails
+		for _, path := range []string{dlna.ContentDirectoryEvt, dlna.ConnectionManagerEvt} {
+			r.MethodFunc("SUBSCRIBE", path, handleDLNASubscribe)
+			r.MethodFunc("UNSUBSCRIBE", path, func(w http.ResponseWriter, r *http.Request) {})
+		}
+	}
+}
+
+func writeDLNAXML(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Write([]byte(body))
+}
+
+func handleDLNASubscribe(w http.ResponseWriter, r *http.Request) {
+	sid := r.Header.Get("SID")
+	if sid == "" {
+		sid = "uuid:" + uuid.New().String()
+	}
+	w.Header().Set("SID", sid)
+	w.Header().Set("TIMEOUT", "Second-1800")
+}
+
+func handleDLNAConnectionManager(w http.ResponseWriter, r *http.Request) {
+	action, err := dlna.ReadAction(r)
+	if err != nil {
+		dlna.WriteFault(w, dlna.ErrInvalidArgs, err.Error())
+		return
+	}
+	switch action.Name {
+	case "GetProtocolInfo":
+		var formats []string
+		for _, ct := range audioContentTypes {
+			formats = append(formats, "http-get:*:"+dlna.MediaType(ct)+":*")
+		}
+		slices.Sort(formats)
+		formats = slices.Compact(formats)
+		dlna.WriteResponse(w, action, dlna.Arg{Name: "Source", Value: strings.Join(formats, ",")}, dlna.Arg{Name: "Sink"})
+	case "GetCurrentConnectionIDs":
+		dlna.WriteResponse(w, action, dlna.Arg{Name: "ConnectionIDs", Value: "0"})
+	case "GetCurrentConnectionInfo":
+		dlna.WriteResponse(w, action,
+			dlna.Arg{Name: "RcsID", Value: "-1"},
+			dlna.Arg{Name: "AVTransportID", Value: "-1"},
+			dlna.Arg{Name: "ProtocolInfo"},
+			dlna.Arg{Name: "PeerConnectionManager"},
+			dlna.Arg{Name: "PeerConnectionID", Value: "-1"},
+			dlna.Arg{Name: "Direction", Value: "Output"},
+			dlna.Arg{Name: "Status", Value: "OK"},
+		)
+	default:
+		dlna.WriteFault(w, dlna.ErrInvalidAction, "Unknown action "+action.Name)
+	}
+}
+
+func handleDLNAContentDirectory(w http.ResponseWriter, r *http.Request, store *db.Store, device *dlna.Device) {
+	action, err := dlna.ReadAction(r)
+	if err != nil {
+		dlna.WriteFault(w, dlna.ErrInvalidArgs, err.Error())
+		return
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	b := &dlnaBrowser{store: store, base: scheme + "://" + r.Host, device: device}
+
+	switch action.Name {
+	case "GetSearchCapabilities":
+		dlna.WriteResponse(w, action, dlna.Arg{Name: "SearchCaps", Value: "dc:title,dc:creator,upnp:artist,upnp:album,upnp:class"})
+	case "GetSortCapabilities":
+		dlna.WriteResponse(w, action, dlna.Arg{Name: "SortCaps"})
+	case "GetSystemUpdateID":
+		dlna.WriteResponse(w, action, dlna.Arg{Name: "Id", Value: "0"})
+	case "Browse":
+		id := action.Args["ObjectID"]
+		var didl *dlna.DIDL
+		switch action.Args["BrowseFlag"] {
+		case "BrowseMetadata":
+			didl, err = b.metadata(id)
+		case "BrowseDirectChildren":
+			didl, err = b.children(id)
+		default:
+			dlna.WriteFault(w, dlna.ErrInvalidArgs, "Invalid BrowseFlag")
+			return
+		}
+		writeDLNAResult(w, r, action, didl, err)
+	case "Search":
+		didl, err := b.search(action.Args["SearchCriteria"])
+		writeDLNAResult(w, r, action, didl, err)
+	default:
+		dlna.WriteFault(w, dlna.ErrInvalidAction, "Unknown action "+action.Name)
+	}
+}
+
+// writeDLNAFailure is writeError for SOAP actions. Server errors are logged
+// with the request ID and reported with a generic message.
+func writeDLNAFailure(w http.ResponseWriter, r *http.Request, err error) {
+	var e *apierr.Error
+	if !errors.As(apiError(err), &e) {
+		e = apierr.Internal(err)
+	}
+	if e.Status >= http.StatusInternalServerError {
+		log.Printf("[DLNA] %s %s failed (request %s): %v", r.Method, r.URL.Path, middleware.GetReqID(r.Context()), err)
+	}
+	dlna.WriteFault(w, dlna.ErrActionFailed, e.Message)
+}
+
+// writeDLNAResult pages a Browse or Search result by StartingIndex and
+// RequestedCount and sends it.
+func writeDLNAResult(w http.ResponseWriter, r *http.Request, action *dlna.Action, didl *dlna.DIDL, err error) {
+	if errors.Is(err, errDLNANoSuchObject) || errors.Is(err, db.ErrNotFound) {
+		dlna.WriteFault(w, dlna.ErrNoSuchObject, "No such object")
+		return
+	}
+	if err != nil {
+		writeDLNAFailure(w, r, err)
+		return
+	}
+	start, _ := strconv.Atoi(action.Args["StartingIndex"])
+	count, _ := strconv.Atoi(action.Args["RequestedCount"])
+	total := didl.Len()
+	didl.Slice(max(start, 0), max(count, 0))
+
+	result, err := didl.Marshal()
+	if err != nil {
+		writeDLNAFailure(w, r, err)
+		return
+	}
+	dlna.WriteResponse(w, action,
+		dlna.Arg{Name: "Result", Value: result},
+		dlna.Arg{Name: "NumberReturned", Value: strconv.Itoa(didl.Len())},
+		dlna.Arg{Name: "TotalMatches", Value: strconv.Itoa(total)},
+		dlna.Arg{Name: "UpdateID", Value: "0"},
+	)
+}
+
+// dlnaBrowser builds DIDL-Lite objects with URLs on the host the control
+// point reached us by.
+type dlnaBrowser struct {
+	store  *db.Store
+	base   string
+	device *dlna.Device
+}
+
+func (b *dlnaBrowser) metadata(id string) (*dlna.DIDL, error) {
+	didl := &dlna.DIDL{}
+	kind, key, _ := strings.Cut(id, "/")
+	switch kind {
+	case dlnaRootID:
+		didl.Containers = append(didl.Containers, dlna.Container{
+			ID: dlnaRootID, ParentID: "-1", Restricted: 1, Searchable: 1, ChildCount: 4,
+			Title: b.device.FriendlyName, Class: dlna.ClassStorageFolder,
+		})
+	case dlnaArtistsID, dlnaAlbumsID, dlnaFoldersID, dlnaPlaylistsID:
+		root, err := b.children(dlnaRootID)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range root.Containers {
+			if c.ID == id {
+				didl.Containers = append(didl.Containers, c)
+			}
+		}
+	case "artist":
+		artist, err := db.GetArtistSummary(key)
+		if err != nil || artist == nil {
+			return nil, orNoSuchObject(err)
+		}
+		didl.Containers = append(didl.Containers, b.artistContainer(*artist))
+	case "album":
+		album, err := db.GetAlbumSummary(key)
+		if err != nil || album == nil {
+			return nil, orNoSuchObject(err)
+		}
+		didl.Containers = append(didl.Containers, b.albumContainer(*album, dlnaAlbumsID))
+	case "folder":
+		tracks, err := b.store.Library.GetTracksByFolder(key)
+		if err != nil || len(tracks) == 0 {
+			return nil, orNoSuchObject(err)
+		}
+		didl.Containers = append(didl.Containers, b.folderContainer(key, len(tracks), tracks[0].ImageUrl))
+	case "playlist":
+		playlist, err := dlnaGuestPlaylist(key)
+		if err != nil || playlist == nil {
+			return nil, orNoSuchObject(err)
+		}
+		didl.Containers = append(didl.Containers, b.playlistContainer(*playlist))
+	case "track":
+		track, err := b.store.Library.GetTrack(key)
+		if err != nil || track == nil {
+			return nil, orNoSuchObject(err)
+		}
+		parent := dlnaFoldersID
+		if track.AlbumID != nil {
+			parent = "album/" + *track.AlbumID
+		} else if track.FolderPath != nil {
+			parent = "folder/" + *track.FolderPath
+		}
+		didl.Items = append(didl.Items, b.trackItem(*track, parent))
+	default:
+		return nil, errDLNANoSuchObject
+	}
+	if didl.Len() == 0 {
+		return nil, errDLNANoSuchObject
+	}
+	return didl, nil
+}
+
+func (b *dlnaBrowser) children(id string) (*dlna.DIDL, error) {
+	didl := &dlna.DIDL{}
+	kind, key, _ := strings.Cut(id, "/")
+	switch kind {
+	case dlnaRootID:
+		artists, err := db.GetArtistSummaries("")
+		if err != nil {
+			return nil, err
+		}
+		albums, err := db.GetAlbumSummaries(db.AlbumQuery{})
+		if err != nil {
+			return nil, err
+		}
+		folders, err := b.store.Library.GetFolders()
+		if err != nil {
+			return nil, err
+		}
+		playlists, err := db.GetGuestPlaylists()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range []struct {
+			id, title string
+			count     int
+		}{
+			{dlnaArtistsID, "Artists", len(artists)},
+			{dlnaAlbumsID, "Albums", len(albums)},
+			{dlnaFoldersID, "Folders", len(folders)},
+			{dlnaPlaylistsID, "Playlists", len(playlists)},
+		} {
+			didl.Containers = append(didl.Containers, dlna.Container{
+				ID: c.id, ParentID: dlnaRootID, Restricted: 1, ChildCount: c.count,
+				Title: c.title, Class: dlna.ClassStorageFolder,
+			})
+		}
+	case dlnaArtistsID:
+		artists, err := db.GetArtistSummaries("")
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range artists {
+			didl.Containers = append(didl.Containers, b.artistContainer(a))
+		}
+	case dlnaAlbumsID:
+		albums, err := db.GetAlbumSummaries(db.AlbumQuery{Sort: db.AlbumsByName})
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range albums {
+			didl.Containers = append(didl.Containers, b.albumContainer(a, dlnaAlbumsID))
+		}
+	case dlnaFoldersID:
+		folders, err := b.store.Library.GetFolders()
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range folders {
+			didl.Containers = append(didl.Containers, b.folderContainer(f.ID, f.TrackCount, f.ImageUrl))
+		}
+	case dlnaPlaylistsID:
+		playlists, err := db.GetGuestPlaylists()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range playlists {
+			didl.Containers = append(didl.Containers, b.playlistContainer(p))
+		}
+	case "artist":
+		artist, err := db.GetArtistSummary(key)
+		if err != nil || artist == nil {
+			return nil, orNoSuchObject(err)
+		}
+		albums, err := db.GetAlbumSummaries(db.AlbumQuery{Sort: db.AlbumsByArtist, ArtistID: key})
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range albums {
+			didl.Containers = append(didl.Containers, b.albumContainer(a, id))
+		}
+	case "album":
+		album, err := db.GetAlbumSummary(key)
+		if err != nil || album == nil {
+			return nil, orNoSuchObject(err)
+		}
+		tracks, err := b.store.Library.GetTracksByAlbum(key)
+		if err != nil {
+			return nil, err
+		}
+		b.addTracks(didl, tracks, id)
+	case "folder":
+		tracks, err := b.store.Library.GetTracksByFolder(key)
+		if err != nil || len(tracks) == 0 {
+			return nil, orNoSuchObject(err)
+		}
+		b.addTracks(didl, tracks, id)
+	case "playlist":
+		playlist, err := dlnaGuestPlaylist(key)
+		if err != nil || playlist == nil {
+			return nil, orNoSuchObject(err)
+		}
+		detail, err := b.store.Playlists.GetPlaylist(key)
+		if err != nil {
+			return nil, err
+		}
+		b.addTracks(didl, detail.Tracks, id)
+	default:
+		return nil, errDLNANoSuchObject
+	}
+	return didl, nil
+}
+
+// dlnaCriterion matches the text conditions of a UPnP search criteria
+// string, such as: dc:title contains "blue".
+var dlnaCriterion = regexp.MustCompile(`(dc:title|dc:creator|upnp:artist|upnp:album)\s+(?:contains|=)\s+"((?:[^"\\]|\\.)*)"`)
+
+// search supports the criteria control points actually send: one text
+// condition, optionally restricted to artists or albums by upnp:class.
+// Conditions are not combined; the first text condition wins.
+func (b *dlnaBrowser) search(criteria string) (*dlna.DIDL, error) {
+	query := ""
+	if m := dlnaCriterion.FindStringSubmatch(criteria); m != nil {
+		query = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(m[2])
+	} else if strings.TrimSpace(criteria) != "*" {
+		return nil, fmt.Errorf("unsupported search criteria: %s", criteria)
+	}
+
+	didl := &dlna.DIDL{}
+	switch {
+	case strings.Contains(criteria, "object.container.person"):
+		artists, err := db.SearchArtists(query, "", 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range artists {
+			didl.Containers = append(didl.Containers, b.artistContainer(a))
+		}
+	case strings.Contains(criteria, "object.container.album"):
+		albums, err := db.SearchAlbums(query, "", 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range albums {
+			didl.Containers = append(didl.Containers, b.albumContainer(a, dlnaAlbumsID))
+		}
+	default:
+		tracks, err := db.SearchTracks(query, "", 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tracks {
+			parent := dlnaFoldersID
+			if t.AlbumID != nil {
+				parent = "album/" + *t.AlbumID
+			}
+			didl.Items = append(didl.Items, b.trackItem(t, parent))
+		}
+	}
+	return didl, nil
+}
+
+func orNoSuchObject(err error) error {
+	if err != nil {
+		return err
+	}
+	return errDLNANoSuchObject
+}
+
+// dlnaGuestPlaylist returns the playlist if anonymous clients may see it.
+func dlnaGuestPlaylist(id string) (*types.PlaylistSummary, error) {
+	playlists, err := db.GetGuestPlaylists()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range playlists {
+		if p.ID == id {
+			return &p, nil
+		}
+	}
+	return nil, nil
+}
+
+// signedURL makes a server path fetchable by a renderer without credentials.
+func (b *dlnaBrowser) signedURL(path *string) string {
+	if path == nil || *path == "" {
+		return ""
+	}
+	return b.base + auth.SignedURL(*path)
+}
+
+func (b *dlnaBrowser) artistContainer(a types.ArtistSummary) dlna.Container {
+	return dlna.Container{
+		ID: "artist/" + a.ID, ParentID: dlnaArtistsID, Restricted: 1, ChildCount: a.AlbumCount,
+		Title: a.Name, Class: dlna.ClassMusicArtist, AlbumArt: b.signedURL(a.ImageUrl),
+	}
+}
+
+func (b *dlnaBrowser) albumContainer(a types.AlbumSummary, parentID string) dlna.Container {
+	return dlna.Container{
+		ID: "album/" + a.ID, ParentID: parentID, Restricted: 1, ChildCount: a.TrackCount,
+		Title: a.Name, Class: dlna.ClassMusicAlbum, Artist: a.Artist, AlbumArt: b.signedURL(a.ImageUrl),
+	}
+}
+
+func (b *dlnaBrowser) folderContainer(path string, count int, imageURL *string) dlna.Container {
+	return dlna.Container{
+		ID: "folder/" + path, ParentID: dlnaFoldersID, Restricted: 1, ChildCount: count,
+		Title: filepath.Base(path), Class: dlna.ClassStorageFolder, AlbumArt: b.signedURL(imageURL),
+	}
+}
+
+func (b *dlnaBrowser) playlistContainer(p types.PlaylistSummary) dlna.Container {
+	return dlna.Container{
+		ID: "playlist/" + p.ID, ParentID: dlnaPlaylistsID, Restricted: 1, ChildCount: p.TrackCount,
+		Title: p.Name, Class: dlna.ClassPlaylist,
+	}
+}
+
+func (b *dlnaBrowser) addTracks(didl *dlna.DIDL, tracks []types.Track, parentID string) {
+	for _, t := range tracks {
+		didl.Items = append(didl.Items, b.trackItem(t, parentID))
+	}
+}
+
+func (b *dlnaBrowser) trackItem(t types.Track, parentID string) dlna.Item {
+	streamPath := "/api/stream/" + t.ID
+	item := dlna.Item{
+		ID:         "track/" + t.ID,
+		ParentID:   parentID,
+		Restricted: 1,
+		Title:      t.Title,
+		Creator:    t.Artist,
+		Class:      dlna.ClassMusicTrack,
+		Artist:     t.Artist,
+		Album:      t.Album,
+		AlbumArt:   b.signedURL(t.ImageUrl),
+		Res: dlna.Resource{
+			ProtocolInfo: dlna.ProtocolInfo(audioContentType(t.Path)),
+			Duration:     dlna.FormatDuration(t.Duration),
+			URL:          b.signedURL(&streamPath),
+		},
+	}
+	if t.ArtistsDisplay != nil && *t.ArtistsDisplay != "" {
+		item.Artist = *t.ArtistsDisplay
+		item.Creator = *t.ArtistsDisplay
+	}
+	if t.TrackNumber != nil {
+		item.TrackNumber = *t.TrackNumber
+	}
+	if t.Year != nil && *t.Year > 0 {
+		item.Date = fmt.Sprintf("%04d-01-01", *t.Year)
+	}
+	return item
+}
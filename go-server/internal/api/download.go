@@ -0,0 +1,214 @@
+package api
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/sources"
+	"homemusic-server/internal/transcode"
+	"homemusic-server/internal/types"
+)
+
+func (h *libraryHandlers) handleDownloadAlbum(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	album, err := h.store.Library.GetAlbum(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if album == nil {
+		writeError(w, r, apierr.NotFound("Album not found"))
+		return
+	}
+
+	name := album.Name
+	if album.Artist != "" {
+		name = album.Artist + " - " + name
+	}
+	streamZip(w, r, h.store.Sources, openTrackReader, name, album.Tracks, false)
+}
+
+func (h *playlistHandlers) handleDownloadPlaylist(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.authorizePlaylist(w, r, id, db.AccessView); !ok {
+		return
+	}
+	playlist, err := h.store.Playlists.GetPlaylist(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if playlist == nil {
+		writeError(w, r, apierr.NotFound("Playlist not found"))
+		return
+	}
+
+	streamZip(w, r, h.store.Sources, openTrackReader, playlist.Name, playlist.Tracks, true)
+}
+
+// streamZip writes the tracks into a ZIP archive directly onto the response.
+// Entries are stored uncompressed since audio does not deflate usefully.
+// Once the archive has started, a track that fails aborts the response, so
+// the client sees a failed download rather than a valid archive missing
+// tracks.
+func streamZip(w http.ResponseWriter, r *http.Request, sources db.SourceStore, open trackOpener, name string, tracks []types.Track, withM3U bool) {
+	var profile *transcode.Profile
+	if pn := r.URL.Query().Get("profile"); pn != "" {
+		p, ok := transcode.FindProfile(pn)
+		if !ok {
+			writeError(w, r, apierr.Invalid("Unknown transcoding profile: "+pn))
+			return
+		}
+		if !transcode.Available() {
+			writeError(w, r, apierr.Unavailable("Transcoding requires ffmpeg"))
+			return
+		}
+		profile = &p
+	}
+
+	// Resolve every source up front so we can still fail with a proper status
+	sourceCache := map[string]*types.Source{}
+	for _, t := range tracks {
+		if _, ok := sourceCache[t.SourceID]; ok {
+			continue
+		}
+		s, err := sources.GetSource(t.SourceID)
+		if err != nil || s == nil {
+			writeError(w, r, apierr.NotFound("Source not found for track "+t.ID))
+			return
+		}
+		sourceCache[t.SourceID] = s
+	}
+
+	folder := sanitizeFilename(name)
+	if folder == "" {
+		folder = "download"
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q; filename*=UTF-8''%s",
+		folder+".zip", url.PathEscape(folder+".zip")))
+
+	zw := zip.NewWriter(w)
+
+	used := map[string]bool{}
+	var entries []string
+	for _, t := range tracks {
+		filename := uniqueFilename(zipTrackFilename(t, profile), used)
+
+		fh := &zip.FileHeader{
+			Name:     folder + "/" + filename,
+			Method:   zip.Store,
+			Modified: time.Now(),
+		}
+		if t.SourceMtime != nil {
+			fh.Modified = *t.SourceMtime
+		}
+		entry, err := zw.CreateHeader(fh)
+		if err != nil {
+			log.Printf("[Download] Failed to write zip entry %s: %v", filename, err)
+			panic(http.ErrAbortHandler)
+		}
+
+		if err := copyTrackTo(r, entry, open, sourceCache[t.SourceID], &t, profile); err != nil {
+			// Headers are already sent, so all we can do is cut the
+			// response off before the archive is finished
+			log.Printf("[Download] Failed to add %s: %v", t.Path, err)
+			panic(http.ErrAbortHandler)
+		}
+		entries = append(entries, filename)
+	}
+
+	if withM3U {
+		fh := &zip.FileHeader{Name: folder + "/" + folder + ".m3u8", Method: zip.Deflate, Modified: time.Now()}
+		entry, err := zw.CreateHeader(fh)
+		if err != nil {
+			log.Printf("[Download] Failed to write playlist file: %v", err)
+			panic(http.ErrAbortHandler)
+		}
+		var b strings.Builder
+		b.WriteString("#EXTM3U\n")
+		for i, t := range tracks {
+			fmt.Fprintf(&b, "#EXTINF:%d,%s - %s\n%s\n", int(t.Duration), t.Artist, t.Title, entries[i])
+		}
+		if _, err := io.WriteString(entry, b.String()); err != nil {
+			log.Printf("[Download] Failed to write playlist file: %v", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("[Download] Failed to finish %s.zip: %v", folder, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func copyTrackTo(r *http.Request, dst io.Writer, open trackOpener, source *types.Source, track *types.Track, profile *transcode.Profile) error {
+	reader, closeFunc, err := open(r.Context(), source, track.Path)
+	// The archive is already half sent, so wait for a free slot instead of failing
+	for errors.Is(err, sources.ErrSourceSaturated) {
+		select {
+		case <-r.Context().Done():
+			return r.Context().Err()
+		case <-time.After(sourceBusyRetrySeconds * time.Second):
+		}
+		reader, closeFunc, err = open(r.Context(), source, track.Path)
+	}
+	if err != nil {
+		return err
+	}
+	defer closeFunc()
+
+	if profile != nil {
+		return transcode.Stream(r.Context(), reader, dst, *profile)
+	}
+	_, err = io.Copy(dst, reader)
+	return err
+}
+
+func zipTrackFilename(t types.Track, profile *transcode.Profile) string {
+	filename := sanitizeFilename(path.Base(strings.ReplaceAll(t.Path, "\\", "/")))
+	if filename == "" {
+		filename = sanitizeFilename(t.Title)
+	}
+	if profile != nil {
+		filename = strings.TrimSuffix(filename, path.Ext(filename)) + profile.Ext
+	}
+	return filename
+}
+
+func uniqueFilename(name string, used map[string]bool) string {
+	candidate := name
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// sanitizeFilename strips characters that are invalid in file names on
+// common desktop filesystems.
+func sanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, name)
+	return strings.Trim(strings.TrimSpace(name), ".")
+}
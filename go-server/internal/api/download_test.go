@@ -0,0 +1,80 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"homemusic-server/internal/types"
+)
+
+// brokenReader fails after the bytes in r, like a source that drops the
+// connection partway through a file.
+type brokenReader struct{ r io.Reader }
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func (b *brokenReader) Seek(int64, int) (int64, error) { return 0, nil }
+
+func TestStreamZip(t *testing.T) {
+	mem := newTestStore(t)
+	mem.CreateSource(types.Source{ID: "src", Name: "NAS", Type: types.SourceTypeSSH})
+	addTrack(mem, "t1", "One", "al1", "/music/a", 1)
+	addTrack(mem, "t2", "Two", "al1", "/music/a", 2)
+	tracks, _ := mem.GetTracksByAlbum("al1")
+
+	download := func(broken string) (*httptest.ResponseRecorder, interface{}) {
+		open := func(_ context.Context, _ *types.Source, path string) (io.ReadSeeker, func(), error) {
+			data := bytes.Repeat([]byte(path), 100)
+			if path == broken {
+				return &brokenReader{bytes.NewReader(data)}, func() {}, nil
+			}
+			return bytes.NewReader(data), func() {}, nil
+		}
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/albums/al1/download", nil)
+		var aborted interface{}
+		func() {
+			defer func() { aborted = recover() }()
+			streamZip(rec, req, mem.Stores().Sources, open, "Album", tracks, true)
+		}()
+		return rec, aborted
+	}
+
+	rec, aborted := download("")
+	if aborted != nil {
+		t.Fatalf("download panicked: %v", aborted)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if len(names) != 3 || names[0] != "Album/t1.mp3" || names[1] != "Album/t2.mp3" || names[2] != "Album/Album.m3u8" {
+		t.Errorf("entries = %v", names)
+	}
+
+	// A track that fails partway aborts the response instead of finishing
+	// an archive without it
+	rec, aborted = download("/music/a/t2.mp3")
+	if aborted != http.ErrAbortHandler {
+		t.Fatalf("recovered %v, want http.ErrAbortHandler", aborted)
+	}
+	if _, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len())); err == nil {
+		t.Error("a broken download is still a valid archive")
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/sources"
+)
+
+// writeError sends err as a JSON error response. Store and source errors
+// get their own statuses; anything unrecognised is a 500 whose text is only
+// logged.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, sources.ErrSourceSaturated) {
+		w.Header().Set("Retry-After", strconv.Itoa(sourceBusyRetrySeconds))
+	}
+	apierr.Write(w, r, apiError(err))
+}
+
+func apiError(err error) error {
+	var e *apierr.Error
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.As(err, &tooLarge):
+		return apierr.New(http.StatusRequestEntityTooLarge, apierr.CodeTooLarge, "Request body must be at most "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes")
+	case errors.Is(err, db.ErrNotFound):
+		return apierr.NotFound("Not found")
+	case errors.Is(err, db.ErrPlaylistReadOnly):
+		return apierr.Conflict("This playlist is read-only: smart playlists follow their rules and source playlists follow their file")
+	case errors.Is(err, db.ErrInvalidSmartRules), errors.Is(err, db.ErrInvalidCollaborator),
+		errors.Is(err, db.ErrInvalidExport), errors.Is(err, auth.ErrInvalidPassword):
+		return apierr.Invalid(err.Error())
+	case errors.Is(err, db.ErrUsernameTaken):
+		return apierr.Conflict("Username already exists")
+	case errors.Is(err, db.ErrSetupDone):
+		return apierr.Conflict("Setup has already been completed")
+	case errors.Is(err, db.ErrLastAdmin):
+		return apierr.Conflict("At least one admin account is required")
+	case errors.Is(err, sources.ErrSourceSaturated):
+		return apierr.Unavailable("Source is busy, try again shortly")
+	}
+	return err
+}
+
+// readJSON decodes the request body into v.
+func readJSON(r *http.Request, v interface{}) error {
+	return decodeBody(json.NewDecoder(r.Body), v)
+}
+
+// readStrictJSON is readJSON rejecting fields v does not have, so typos
+// and stale clients fail loudly instead of being ignored.
+func readStrictJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	return decodeBody(dec, v)
+}
+
+func decodeBody(dec *json.Decoder, v interface{}) error {
+	err := dec.Decode(v)
+	if err == nil {
+		return nil
+	}
+	var tooLarge *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &tooLarge):
+		return err
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return invalidFields(apierr.FieldError{Field: typeErr.Field, Message: "must be " + jsonKind(typeErr.Type.Kind())})
+	}
+	// encoding/json has no error type for unknown fields
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		if field, uerr := strconv.Unquote(name); uerr == nil {
+			return invalidFields(apierr.FieldError{Field: field, Message: "is not a known field"})
+		}
+	}
+	return apierr.Invalid("Invalid request body: " + err.Error())
+}
+
+// invalidFields reports a request body that failed validation.
+func invalidFields(errs ...apierr.FieldError) *apierr.Error {
+	msg := "Invalid request body"
+	if len(errs) > 0 {
+		msg += ": " + errs[0].Field + " " + errs[0].Message
+		if len(errs) > 1 {
+			msg += " (and " + strconv.Itoa(len(errs)-1) + " more)"
+		}
+	}
+	return apierr.Invalid(msg).WithDetails(errs)
+}
+
+func jsonKind(k reflect.Kind) string {
+	switch k {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	default:
+		return "an object"
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/sources"
+	"homemusic-server/internal/subsonic"
+)
+
+type errorBody struct {
+	Error apierr.Error `json:"error"`
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("getting playlist: %w", db.ErrNotFound), http.StatusNotFound, apierr.CodeNotFound},
+		{db.ErrPlaylistReadOnly, http.StatusConflict, apierr.CodeConflict},
+		{fmt.Errorf("%w: unknown field", db.ErrInvalidSmartRules), http.StatusBadRequest, apierr.CodeInvalid},
+		{db.ErrUsernameTaken, http.StatusConflict, apierr.CodeConflict},
+		{db.ErrSetupDone, http.StatusConflict, apierr.CodeConflict},
+		{sources.ErrSourceSaturated, http.StatusServiceUnavailable, apierr.CodeUnavailable},
+		{apierr.SourceUnreachable(errors.New("connection refused")), http.StatusBadGateway, apierr.CodeSourceUnreachable},
+		{&http.MaxBytesError{Limit: 10}, http.StatusRequestEntityTooLarge, apierr.CodeTooLarge},
+		{errors.New("SQL logic error: no such table: tracks"), http.StatusInternalServerError, apierr.CodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			h := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, r, tt.err)
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+			expectStatus(t, rec, tt.status)
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			var body errorBody
+			decode(t, rec, &body)
+			if body.Error.Code != tt.code {
+				t.Errorf("code = %q, want %q", body.Error.Code, tt.code)
+			}
+			if body.Error.Message == "" || body.Error.RequestID == "" {
+				t.Errorf("message and request ID must be set: %+v", body.Error)
+			}
+			if body.Error.RequestID != rec.Header().Get("X-Request-Id") {
+				t.Errorf("requestId %q does not match header %q", body.Error.RequestID, rec.Header().Get("X-Request-Id"))
+			}
+			if strings.Contains(rec.Body.String(), "SQL") {
+				t.Errorf("database error leaked: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	mem := newTestStore(t)
+	rec := do(t, mem.playlists, alice, "POST", "/playlists", "not an object")
+	expectStatus(t, rec, http.StatusBadRequest)
+	var body errorBody
+	decode(t, rec, &body)
+	if body.Error.Code != apierr.CodeInvalid || !strings.HasPrefix(body.Error.Message, "Invalid request body") {
+		t.Errorf("error = %+v", body.Error)
+	}
+}
+
+func TestWriteSubsonicFailure(t *testing.T) {
+	tests := []struct {
+		err     error
+		code    int
+		message string
+		logged  bool
+	}{
+		{db.ErrPlaylistReadOnly, subsonic.ErrGeneric, "This playlist is read-only", false},
+		{fmt.Errorf("getting track: %w", db.ErrNotFound), subsonic.ErrNotFound, "Not found", false},
+		{apierr.Forbidden("Only the playlist owner can do this"), subsonic.ErrNotAuthorized, "Only the playlist owner can do this", false},
+		{errors.New("SQL logic error: no such table: tracks"), subsonic.ErrGeneric, "Internal server error", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			h := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				writeSubsonicFailure(w, r, tt.err)
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/rest/getSong?f=json", nil))
+
+			var body struct {
+				Response subsonic.Response `json:"subsonic-response"`
+			}
+			decode(t, rec, &body)
+			if e := body.Response.Error; e == nil || e.Code != tt.code || !strings.HasPrefix(e.Message, tt.message) {
+				t.Errorf("error = %+v, want code %d and message %q", e, tt.code, tt.message)
+			}
+			if strings.Contains(rec.Body.String(), "SQL") {
+				t.Errorf("database error leaked: %s", rec.Body.String())
+			}
+			id := rec.Header().Get("X-Request-Id")
+			if logged := id != "" && strings.Contains(logs.String(), id) && strings.Contains(logs.String(), tt.err.Error()); logged != tt.logged {
+				t.Errorf("logged = %v, want %v: %q", logged, tt.logged, logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/logging"
+	"homemusic-server/internal/transcode"
+	"homemusic-server/internal/types"
+)
+
+// HLSCacheDir holds downloaded sources and generated segments, one
+// directory per track.
+var HLSCacheDir = filepath.Join("cache", "hls")
+
+var hlsSegmentPattern = regexp.MustCompile(`^seg_\d+\.ts$`)
+
+// HLSCacheMaxBytes caps the size of HLSCacheDir. Once a new variant
+// pushes it over, the least recently played tracks are removed.
+var HLSCacheMaxBytes int64 = 2 << 30
+
+// hlsLocks serialises cache generation per track, while requests serving
+// files from the cache share the lock so nothing is rebuilt under them.
+// Entries live only while someone holds or waits for them; eviction skips
+// their tracks.
+var (
+	hlsLocksMu sync.Mutex
+	hlsLocks   = make(map[string]*hlsLock)
+)
+
+type hlsLock struct {
+	sync.RWMutex
+	users int
+}
+
+func lockHLSTrack(trackID string) func() {
+	l := holdHLSTrack(trackID)
+	l.Lock()
+	return func() {
+		l.Unlock()
+		releaseHLSTrack(trackID, l)
+	}
+}
+
+func rlockHLSTrack(trackID string) func() {
+	l := holdHLSTrack(trackID)
+	l.RLock()
+	return func() {
+		l.RUnlock()
+		releaseHLSTrack(trackID, l)
+	}
+}
+
+func holdHLSTrack(trackID string) *hlsLock {
+	hlsLocksMu.Lock()
+	defer hlsLocksMu.Unlock()
+	l, ok := hlsLocks[trackID]
+	if !ok {
+		l = &hlsLock{}
+		hlsLocks[trackID] = l
+	}
+	l.users++
+	return l
+}
+
+func releaseHLSTrack(trackID string, l *hlsLock) {
+	hlsLocksMu.Lock()
+	defer hlsLocksMu.Unlock()
+	l.users--
+	if l.users == 0 {
+		delete(hlsLocks, trackID)
+	}
+}
+
+func (h *streamHandlers) handleHLSMaster(w http.ResponseWriter, r *http.Request) {
+	trackID := chi.URLParam(r, "trackId")
+	track, err := h.store.Library.GetTrack(trackID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if track == nil {
+		writeError(w, r, apierr.NotFound("Track not found"))
+		return
+	}
+	if !transcode.Available() {
+		writeError(w, r, apierr.Unavailable("HLS streaming requires ffmpeg"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	io.WriteString(w, transcode.MasterPlaylist())
+}
+
+func (h *streamHandlers) handleHLSFile(w http.ResponseWriter, r *http.Request) {
+	trackID := chi.URLParam(r, "trackId")
+	variant, ok := transcode.FindHLSVariant(chi.URLParam(r, "variant"))
+	if !ok {
+		writeError(w, r, apierr.NotFound("Unknown variant"))
+		return
+	}
+	file := chi.URLParam(r, "file")
+	if file != "index.m3u8" && !hlsSegmentPattern.MatchString(file) {
+		writeError(w, r, apierr.NotFound("Not found"))
+		return
+	}
+
+	track, err := h.store.Library.GetTrack(trackID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if track == nil {
+		writeError(w, r, apierr.NotFound("Track not found"))
+		return
+	}
+	if !transcode.Available() {
+		writeError(w, r, apierr.Unavailable("HLS streaming requires ffmpeg"))
+		return
+	}
+
+	variantDir, done, err := ensureHLSVariant(r.Context(), h.store.Sources, track, variant)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer done()
+
+	if file == "index.m3u8" {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	} else {
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	http.ServeFile(w, r, filepath.Join(variantDir, file))
+}
+
+// ensureHLSVariant returns the directory holding the segments of one
+// variant, transcoding it first if it is missing or stale. The caller
+// serves from the directory and then calls done; until then the track is
+// neither rebuilt nor evicted.
+func ensureHLSVariant(ctx context.Context, sources db.SourceStore, track *types.Track, variant transcode.HLSVariant) (variantDir string, done func(), err error) {
+	for {
+		if variantDir, err = buildHLSVariant(ctx, sources, track, variant); err != nil {
+			return "", nil, err
+		}
+		done = rlockHLSTrack(track.ID)
+		// The cache may have been rebuilt for a changed file in between
+		if _, err := os.Stat(filepath.Join(variantDir, "index.m3u8")); err == nil {
+			return variantDir, done, nil
+		}
+		done()
+	}
+}
+
+// buildHLSVariant transcodes one variant into the cache unless it is
+// already there and current, and returns its directory.
+func buildHLSVariant(ctx context.Context, sources db.SourceStore, track *types.Track, variant transcode.HLSVariant) (string, error) {
+	unlock := lockHLSTrack(track.ID)
+	defer unlock()
+
+	trackDir := filepath.Join(HLSCacheDir, track.ID)
+	// The directory's mtime records when the track was last played
+	now := time.Now()
+	os.Chtimes(trackDir, now, now)
+	version := hlsCacheVersion(track)
+	versionFile := filepath.Join(trackDir, "version")
+	if current, err := os.ReadFile(versionFile); err != nil || string(current) != version {
+		// Source file changed since the cache was built (or no cache yet)
+		if err := os.RemoveAll(trackDir); err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(trackDir, 0755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(versionFile, []byte(version), 0644); err != nil {
+			return "", err
+		}
+	}
+
+	variantDir := filepath.Join(trackDir, variant.Name)
+	if _, err := os.Stat(filepath.Join(variantDir, "index.m3u8")); err == nil {
+		return variantDir, nil
+	}
+
+	// Keep downloading and transcoding even if the requesting client goes
+	// away; the result is cached
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Minute)
+	defer cancel()
+
+	srcPath, err := ensureHLSSource(ctx, sources, track, trackDir)
+	if err != nil {
+		return "", err
+	}
+
+	// Transcode into a scratch directory so readers never see partial output
+	tmpDir, err := os.MkdirTemp(trackDir, variant.Name+".tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	start := time.Now()
+	if err := transcode.GenerateHLS(ctx, srcPath, tmpDir, variant); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpDir, variantDir); err != nil {
+		return "", err
+	}
+	logging.Infof("[HLS] Generated %s variant for %s in %s", variant.Name, track.Title, time.Since(start).Round(time.Millisecond))
+	evictHLSCache(HLSCacheMaxBytes)
+	return variantDir, nil
+}
+
+// evictHLSCache removes the least recently played tracks until the cache
+// holds at most maxBytes. Tracks being generated or waited on are kept.
+func evictHLSCache(maxBytes int64) {
+	entries, err := os.ReadDir(HLSCacheDir)
+	if err != nil {
+		return
+	}
+	type cachedTrack struct {
+		id   string
+		used time.Time
+		size int64
+	}
+	var tracks []cachedTrack
+	var total int64
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		t := cachedTrack{id: e.Name(), used: info.ModTime()}
+		filepath.WalkDir(filepath.Join(HLSCacheDir, t.id), func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				if fi, err := d.Info(); err == nil {
+					t.size += fi.Size()
+				}
+			}
+			return nil
+		})
+		tracks = append(tracks, t)
+		total += t.size
+	}
+	if total <= maxBytes {
+		return
+	}
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].used.Before(tracks[j].used) })
+
+	// Holding the map keeps tracks from being locked while they are removed
+	hlsLocksMu.Lock()
+	defer hlsLocksMu.Unlock()
+	for _, t := range tracks {
+		if total <= maxBytes {
+			break
+		}
+		if _, busy := hlsLocks[t.id]; busy {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(HLSCacheDir, t.id)); err != nil {
+			log.Printf("[HLS] Failed to evict %s from the cache: %v", t.id, err)
+			continue
+		}
+		total -= t.size
+	}
+}
+
+// ensureHLSSource copies the remote file next to the segments so every
+// variant can be transcoded without reconnecting to the source.
+func ensureHLSSource(ctx context.Context, sources db.SourceStore, track *types.Track, trackDir string) (string, error) {
+	srcPath := filepath.Join(trackDir, "source"+strings.ToLower(filepath.Ext(track.Path)))
+	if _, err := os.Stat(srcPath); err == nil {
+		return srcPath, nil
+	}
+
+	source, err := sources.GetSource(track.SourceID)
+	if err != nil {
+		return "", err
+	}
+	if source == nil {
+		return "", fmt.Errorf("source not found")
+	}
+
+	reader, closeFunc, err := openTrackReader(ctx, source, track.Path)
+	if err != nil {
+		return "", err
+	}
+	defer closeFunc()
+
+	tmp, err := os.CreateTemp(trackDir, "source.tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, reader); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to download source: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), srcPath); err != nil {
+		return "", err
+	}
+	return srcPath, nil
+}
+
+func hlsCacheVersion(track *types.Track) string {
+	if track.SourceMtime != nil {
+		return track.SourceMtime.UTC().Format(time.RFC3339Nano)
+	}
+	return track.CreatedAt.UTC().Format(time.RFC3339Nano)
+}
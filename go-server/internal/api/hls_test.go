@@ -0,0 +1,92 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHLSCacheEviction(t *testing.T) {
+	prev := HLSCacheDir
+	HLSCacheDir = t.TempDir()
+	t.Cleanup(func() { HLSCacheDir = prev })
+
+	// Three tracks of 100 bytes each, played oldest first
+	for i, id := range []string{"old", "busy", "new"} {
+		dir := filepath.Join(HLSCacheDir, id, "low")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "seg_0000.ts"), make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		used := time.Now().Add(time.Duration(i-3) * time.Hour)
+		os.Chtimes(filepath.Join(HLSCacheDir, id), used, used)
+	}
+
+	unlock := lockHLSTrack("busy")
+	evictHLSCache(150)
+	unlock()
+
+	for id, want := range map[string]bool{"old": false, "busy": true, "new": false} {
+		_, err := os.Stat(filepath.Join(HLSCacheDir, id))
+		if got := err == nil; got != want {
+			t.Errorf("%s kept = %v, want %v", id, got, want)
+		}
+	}
+	if len(hlsLocks) != 0 {
+		t.Errorf("lock entries left after unlocking: %v", hlsLocks)
+	}
+}
+
+func TestHLSLocksAreReleased(t *testing.T) {
+	unlock := lockHLSTrack("t1")
+	done := make(chan struct{})
+	go func() {
+		lockHLSTrack("t1")()
+		close(done)
+	}()
+	unlock()
+	<-done
+	if len(hlsLocks) != 0 {
+		t.Errorf("lock entries left after unlocking: %v", hlsLocks)
+	}
+}
+
+func TestHLSReadersHoldOffRebuilds(t *testing.T) {
+	prev := HLSCacheDir
+	HLSCacheDir = t.TempDir()
+	t.Cleanup(func() { HLSCacheDir = prev })
+	if err := os.MkdirAll(filepath.Join(HLSCacheDir, "t1", "low"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(HLSCacheDir, "t1", "low", "seg_0000.ts"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two requests serving files share the track; eviction leaves it alone
+	done := rlockHLSTrack("t1")
+	rlockHLSTrack("t1")()
+	evictHLSCache(0)
+	if _, err := os.Stat(filepath.Join(HLSCacheDir, "t1")); err != nil {
+		t.Errorf("track evicted while serving: %v", err)
+	}
+
+	// A rebuild waits until the response is done
+	locked := make(chan struct{})
+	go func() {
+		lockHLSTrack("t1")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("rebuild locked the track while a file was being served")
+	case <-time.After(50 * time.Millisecond):
+	}
+	done()
+	<-locked
+	if len(hlsLocks) != 0 {
+		t.Errorf("lock entries left after unlocking: %v", hlsLocks)
+	}
+}
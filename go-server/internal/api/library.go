@@ -2,99 +2,111 @@ package api
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/auth"
 	"homemusic-server/internal/db"
+	"homemusic-server/internal/types"
 )
 
-func RegisterLibraryRoutes(r chi.Router) {
-	r.Get("/tracks", handleGetTracks)
-	r.Get("/albums", handleGetAlbums)
-	r.Get("/albums/{id}", handleGetAlbum)
-	r.Get("/artists", handleGetArtists)
-	r.Get("/artists/{id}", handleGetArtist)
-	r.Get("/folders", handleGetFolders)
-	r.Get("/folders/tracks", handleGetTracksByFolder)
+// libraryHandlers serve the library from store.
+type libraryHandlers struct {
+	store *db.Store
 }
 
-func handleGetFolders(w http.ResponseWriter, r *http.Request) {
-	folders, err := db.GetFolders()
+// RegisterLibraryRoutes serves the tracks, albums, artists and folders in
+// store.
+func RegisterLibraryRoutes(store *db.Store) func(chi.Router) {
+	h := &libraryHandlers{store: store}
+	return func(r chi.Router) {
+		r.Get("/tracks", h.handleGetTracks)
+		r.Get("/albums", h.handleGetAlbums)
+		r.Get("/albums/{id}", h.handleGetAlbum)
+		r.With(auth.RequireScope(types.ScopeStream)).Get("/albums/{id}/download", h.handleDownloadAlbum)
+		r.Get("/artists", h.handleGetArtists)
+		r.Get("/artists/{id}", h.handleGetArtist)
+		r.Get("/folders", h.handleGetFolders)
+		r.Get("/folders/tracks", h.handleGetTracksByFolder)
+	}
+}
+
+func (h *libraryHandlers) handleGetFolders(w http.ResponseWriter, r *http.Request) {
+	folders, err := h.store.Library.GetFolders()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	json.NewEncoder(w).Encode(folders)
 }
 
-func handleGetTracksByFolder(w http.ResponseWriter, r *http.Request) {
+func (h *libraryHandlers) handleGetTracksByFolder(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
-		http.Error(w, "Path is required", http.StatusBadRequest)
+		writeError(w, r, apierr.Invalid("Path is required"))
 		return
 	}
 
-	tracks, err := db.GetTracksByFolder(path)
+	tracks, err := h.store.Library.GetTracksByFolder(path)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	json.NewEncoder(w).Encode(tracks)
 }
 
-func handleGetTracks(w http.ResponseWriter, r *http.Request) {
-	tracks, err := db.GetAllTracks()
+func (h *libraryHandlers) handleGetTracks(w http.ResponseWriter, r *http.Request) {
+	tracks, err := h.store.Library.GetAllTracks()
 	if err != nil {
-		log.Printf("[API] Failed to get tracks: %v", err)
-		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(tracks)
 }
 
-func handleGetAlbums(w http.ResponseWriter, r *http.Request) {
-	albums, err := db.GetAllAlbums()
+func (h *libraryHandlers) handleGetAlbums(w http.ResponseWriter, r *http.Request) {
+	albums, err := h.store.Library.GetAllAlbums()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	json.NewEncoder(w).Encode(albums)
 }
 
-func handleGetAlbum(w http.ResponseWriter, r *http.Request) {
+func (h *libraryHandlers) handleGetAlbum(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	album, err := db.GetAlbum(id)
+	album, err := h.store.Library.GetAlbum(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	if album == nil {
-		http.Error(w, "Album not found", http.StatusNotFound)
+		writeError(w, r, apierr.NotFound("Album not found"))
 		return
 	}
 	json.NewEncoder(w).Encode(album)
 }
 
-func handleGetArtists(w http.ResponseWriter, r *http.Request) {
-	artists, err := db.GetAllArtists()
+func (h *libraryHandlers) handleGetArtists(w http.ResponseWriter, r *http.Request) {
+	artists, err := h.store.Library.GetAllArtists()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	json.NewEncoder(w).Encode(artists)
 }
 
-func handleGetArtist(w http.ResponseWriter, r *http.Request) {
+func (h *libraryHandlers) handleGetArtist(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	artist, err := db.GetArtist(id)
+	artist, err := h.store.Library.GetArtist(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	if artist == nil {
-		http.Error(w, "Artist not found", http.StatusNotFound)
+		writeError(w, r, apierr.NotFound("Artist not found"))
 		return
 	}
 	
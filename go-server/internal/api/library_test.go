@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"homemusic-server/internal/types"
+)
+
+func TestGetTracks(t *testing.T) {
+	mem := newTestStore(t)
+	addTrack(mem, "t1", "One", "al1", "/music/a", 1)
+	addTrack(mem, "t2", "Two", "al1", "/music/a", 2)
+
+	rec := do(t, mem.library, alice, http.MethodGet, "/tracks", nil)
+	expectStatus(t, rec, http.StatusOK)
+	var tracks []struct{ ID string }
+	decode(t, rec, &tracks)
+	if len(tracks) != 2 {
+		t.Fatalf("got %d tracks, want 2", len(tracks))
+	}
+}
+
+func TestGetAlbum(t *testing.T) {
+	mem := newTestStore(t)
+	addTrack(mem, "t2", "Two", "al1", "/music/a", 2)
+	addTrack(mem, "t1", "One", "al1", "/music/a", 1)
+
+	rec := do(t, mem.library, alice, http.MethodGet, "/albums/al1", nil)
+	expectStatus(t, rec, http.StatusOK)
+	var album types.AlbumDetail
+	decode(t, rec, &album)
+	if album.Name != "Album al1" || album.Artist != "Artist al1" || album.ArtistID != "ar-al1" {
+		t.Errorf("album = %q by %q (%s)", album.Name, album.Artist, album.ArtistID)
+	}
+	if album.TrackCount != 2 || album.Duration != 360 {
+		t.Errorf("trackCount = %d, duration = %v", album.TrackCount, album.Duration)
+	}
+	if len(album.Tracks) != 2 || album.Tracks[0].ID != "t1" {
+		t.Errorf("tracks = %+v, want t1 first", album.Tracks)
+	}
+
+	rec = do(t, mem.library, alice, http.MethodGet, "/albums/missing", nil)
+	expectStatus(t, rec, http.StatusNotFound)
+}
+
+func TestGetTracksByFolder(t *testing.T) {
+	mem := newTestStore(t)
+	addTrack(mem, "t1", "One", "al1", "/music/a", 1)
+	addTrack(mem, "t2", "Two", "al2", "/music/b", 1)
+
+	rec := do(t, mem.library, alice, http.MethodGet, "/folders/tracks", nil)
+	expectStatus(t, rec, http.StatusBadRequest)
+
+	rec = do(t, mem.library, alice, http.MethodGet, "/folders/tracks?path=/music/b", nil)
+	expectStatus(t, rec, http.StatusOK)
+	var tracks []struct{ ID string }
+	decode(t, rec, &tracks)
+	if len(tracks) != 1 || tracks[0].ID != "t2" {
+		t.Errorf("tracks = %+v, want only t2", tracks)
+	}
+
+	rec = do(t, mem.library, alice, http.MethodGet, "/folders", nil)
+	expectStatus(t, rec, http.StatusOK)
+	var folders []struct {
+		ID         string
+		TrackCount int
+	}
+	decode(t, rec, &folders)
+	if len(folders) != 2 || folders[0].ID != "/music/a" || folders[0].TrackCount != 1 {
+		t.Errorf("folders = %+v", folders)
+	}
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/playlistfile"
+	"homemusic-server/internal/scanner"
+	"homemusic-server/internal/types"
+)
+
+// apiOperation describes one JSON endpoint for the OpenAPI document. The
+// request and response schemas are generated from the same Go types the
+// handlers decode and encode, so the document cannot drift from them.
+type apiOperation struct {
+	method  string
+	path    string
+	tag     string
+	summary string
+	query   []string
+	request interface{}
+	status  int
+	// response is nil for endpoints that answer without a JSON body
+	response interface{}
+}
+
+var apiOperations = []apiOperation{
+	{method: "GET", path: "/tracks", tag: "Library", summary: "List all tracks, newest first",
+		status: http.StatusOK, response: []types.Track{}},
+	{method: "GET", path: "/albums", tag: "Library", summary: "List albums by name",
+		status: http.StatusOK, response: []types.AlbumSummary{}},
+	{method: "GET", path: "/albums/{id}", tag: "Library", summary: "Get an album with its tracks",
+		status: http.StatusOK, response: types.AlbumDetail{}},
+	{method: "GET", path: "/albums/{id}/download", tag: "Library", summary: "Download an album as a ZIP archive", query: []string{"profile"},
+		status: http.StatusOK},
+	{method: "GET", path: "/artists", tag: "Library", summary: "List artists that have tracks",
+		status: http.StatusOK, response: []types.Artist{}},
+	{method: "GET", path: "/artists/{id}", tag: "Library", summary: "Get an artist",
+		status: http.StatusOK, response: types.Artist{}},
+	{method: "GET", path: "/folders", tag: "Library", summary: "List folders holding tracks",
+		status: http.StatusOK, response: []types.Folder{}},
+	{method: "GET", path: "/folders/tracks", tag: "Library", summary: "List the tracks in a folder", query: []string{"path"},
+		status: http.StatusOK, response: []types.Track{}},
+
+	{method: "GET", path: "/playlists", tag: "Playlists", summary: "List the caller's playlists, or with scope=public every public one", query: []string{"scope"},
+		status: http.StatusOK, response: []types.PlaylistSummary{}},
+	{method: "POST", path: "/playlists", tag: "Playlists", summary: "Create a playlist or smart playlist", request: createPlaylistRequest{},
+		status: http.StatusCreated, response: types.Playlist{}},
+	{method: "POST", path: "/playlists/import", tag: "Playlists", summary: "Import an M3U, PLS or XSPF file, sent as the file field of a multipart form, as a new playlist",
+		status: http.StatusCreated, response: playlistImport{}},
+	{method: "GET", path: "/playlists/followed", tag: "Playlists", summary: "List the playlists the caller follows",
+		status: http.StatusOK, response: []types.PlaylistSummary{}},
+	{method: "GET", path: "/playlists/{id}", tag: "Playlists", summary: "Get a playlist with its tracks",
+		status: http.StatusOK, response: types.PlaylistDetail{}},
+	{method: "GET", path: "/playlists/{id}/download", tag: "Playlists", summary: "Download a playlist as a ZIP archive", query: []string{"profile"},
+		status: http.StatusOK},
+	{method: "PATCH", path: "/playlists/{id}", tag: "Playlists", summary: "Rename a playlist, change its visibility or its smart rules", request: updatePlaylistRequest{},
+		status: http.StatusOK, response: types.PlaylistDetail{}},
+	{method: "DELETE", path: "/playlists/{id}", tag: "Playlists", summary: "Delete a playlist",
+		status: http.StatusNoContent},
+	{method: "GET", path: "/playlists/{id}/export", tag: "Playlists", summary: "Download a playlist as M3U, M3U8, PLS or XSPF", query: []string{"format", "location"},
+		status: http.StatusOK},
+	{method: "POST", path: "/playlists/{id}/tracks", tag: "Playlists", summary: "Append tracks", request: addTracksRequest{},
+		status: http.StatusCreated, response: addTracksResult{}},
+	{method: "DELETE", path: "/playlists/{id}/tracks", tag: "Playlists", summary: "Remove every track",
+		status: http.StatusNoContent},
+	{method: "DELETE", path: "/playlists/{id}/tracks/{trackId}", tag: "Playlists", summary: "Remove every entry of a track",
+		status: http.StatusNoContent},
+	{method: "PATCH", path: "/playlists/{id}/items/{itemId}", tag: "Playlists", summary: "Move an entry", request: moveItemRequest{},
+		status: http.StatusNoContent},
+	{method: "DELETE", path: "/playlists/{id}/items/{itemId}", tag: "Playlists", summary: "Remove an entry",
+		status: http.StatusNoContent},
+	{method: "GET", path: "/playlists/{id}/collaborators", tag: "Playlists", summary: "List collaborators",
+		status: http.StatusOK, response: []types.PlaylistCollaborator{}},
+	{method: "POST", path: "/playlists/{id}/collaborators", tag: "Playlists", summary: "Add a collaborator or change their permission", request: setCollaboratorRequest{},
+		status: http.StatusOK, response: []types.PlaylistCollaborator{}},
+	{method: "DELETE", path: "/playlists/{id}/collaborators/{userId}", tag: "Playlists", summary: "Remove a collaborator",
+		status: http.StatusNoContent},
+	{method: "PUT", path: "/playlists/{id}/follow", tag: "Playlists", summary: "Follow a playlist",
+		status: http.StatusNoContent},
+	{method: "DELETE", path: "/playlists/{id}/follow", tag: "Playlists", summary: "Stop following a playlist",
+		status: http.StatusNoContent},
+
+	{method: "GET", path: "/sources", tag: "Sources", summary: "List sources with their scan status",
+		status: http.StatusOK, response: []types.SourceSummary{}},
+	{method: "POST", path: "/sources", tag: "Sources", summary: "Add a source and start scanning it", request: sourceRequest{},
+		status: http.StatusCreated, response: types.Source{}},
+	{method: "GET", path: "/sources/{id}", tag: "Sources", summary: "Get a source",
+		status: http.StatusOK, response: types.Source{}},
+	{method: "PATCH", path: "/sources/{id}", tag: "Sources", summary: "Update a source", request: sourceRequest{},
+		status: http.StatusOK, response: types.Source{}},
+	{method: "DELETE", path: "/sources/{id}", tag: "Sources", summary: "Delete a source",
+		status: http.StatusNoContent},
+	{method: "POST", path: "/sources/{id}/test", tag: "Sources", summary: "Test the connection to a source",
+		status: http.StatusOK, response: connectionTest{}},
+	{method: "POST", path: "/sources/test", tag: "Sources", summary: "Test the connection to a source before adding it", request: types.Source{},
+		status: http.StatusOK, response: connectionTest{}},
+	{method: "POST", path: "/sources/{id}/scan", tag: "Sources", summary: "Start scanning a source",
+		status: http.StatusAccepted, response: scanStarted{}},
+	{method: "POST", path: "/scan", tag: "Sources", summary: "Start scanning every enabled source",
+		status: http.StatusAccepted, response: scanStarted{}},
+	{method: "GET", path: "/sources/{id}/status", tag: "Sources", summary: "Get a source's scan status",
+		status: http.StatusOK, response: types.SourceStatus{}},
+	{method: "GET", path: "/sources/{id}/stats", tag: "Sources", summary: "Get a source's stream statistics",
+		status: http.StatusOK, response: types.SourceStats{}},
+	{method: "POST", path: "/smb/enumerate-shares", tag: "Sources", summary: "List the shares an SMB server offers", request: EnumerateRequest{},
+		status: http.StatusOK, response: []string{}},
+	{method: "GET", path: "/discover", tag: "Sources", summary: "List servers found on the local network",
+		status: http.StatusOK, response: []scanner.DiscoveredService{}},
+}
+
+// schemaEnums lists the values of the string types the API accepts.
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(types.SourceType("")):             {string(types.SourceTypeSMB), string(types.SourceTypeSSH)},
+	reflect.TypeOf(types.PlaylistVisibility("")):     {string(types.VisibilityPrivate), string(types.VisibilityShared), string(types.VisibilityPublic)},
+	reflect.TypeOf(types.CollaboratorPermission("")): {string(types.PermissionView), string(types.PermissionEdit)},
+	reflect.TypeOf(types.PlaylistAccess(0)):          {types.AccessNone.String(), types.AccessView.String(), types.AccessEdit.String(), types.AccessOwner.String()},
+	reflect.TypeOf(playlistfile.Format("")): {string(playlistfile.FormatM3U), string(playlistfile.FormatM3U8),
+		string(playlistfile.FormatPLS), string(playlistfile.FormatXSPF)},
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// OpenAPIHandler serves the OpenAPI 3 document of the JSON API.
+func OpenAPIHandler(version string) http.HandlerFunc {
+	doc, err := json.Marshal(openAPIDocument(version))
+	if err != nil {
+		panic(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	}
+}
+
+type object = map[string]interface{}
+
+// errorResponse is the body of every failed request.
+type errorResponse struct {
+	Error apierr.Error `json:"error"`
+}
+
+func openAPIDocument(version string) object {
+	g := &schemaGenerator{schemas: object{}}
+	failure := object{
+		"description": "Error",
+		"content":     object{"application/json": object{"schema": g.schema(reflect.TypeOf(errorResponse{}))}},
+	}
+	paths := object{}
+	for _, op := range apiOperations {
+		item, ok := paths[op.path].(object)
+		if !ok {
+			item = object{}
+			paths[op.path] = item
+		}
+
+		var params []object
+		for _, m := range pathParam.FindAllStringSubmatch(op.path, -1) {
+			params = append(params, object{"name": m[1], "in": "path", "required": true, "schema": object{"type": "string"}})
+		}
+		for _, name := range op.query {
+			params = append(params, object{"name": name, "in": "query", "schema": object{"type": "string"}})
+		}
+
+		response := object{"description": http.StatusText(op.status)}
+		if op.response != nil {
+			response["content"] = object{"application/json": object{"schema": g.schema(reflect.TypeOf(op.response))}}
+		}
+		operation := object{
+			"tags":      []string{op.tag},
+			"summary":   op.summary,
+			"responses": object{strconv.Itoa(op.status): response, "default": failure},
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.request != nil {
+			operation["requestBody"] = object{
+				"required": true,
+				"content":  object{"application/json": object{"schema": g.schema(reflect.TypeOf(op.request))}},
+			}
+		}
+		item[strings.ToLower(op.method)] = operation
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   "HomeMusic API",
+			"version": version,
+		},
+		"servers":  []object{{"url": "/api"}},
+		"security": []object{{"bearerAuth": []string{}}, {"sessionCookie": []string{}}},
+		"paths":    paths,
+		"components": object{
+			"schemas": g.schemas,
+			"securitySchemes": object{
+				"bearerAuth":    object{"type": "http", "scheme": "bearer"},
+				"sessionCookie": object{"type": "apiKey", "in": "cookie", "name": auth.SessionCookieName},
+			},
+		},
+	}
+}
+
+// schemaGenerator turns Go types into JSON schemas the way encoding/json
+// would encode them. Named structs become shared component schemas.
+type schemaGenerator struct {
+	schemas object
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func (g *schemaGenerator) schema(t reflect.Type) object {
+	if t.Kind() == reflect.Pointer {
+		return g.schema(t.Elem())
+	}
+	if t == timeType {
+		return object{"type": "string", "format": "date-time"}
+	}
+	if values, ok := schemaEnums[t]; ok {
+		return object{"type": "string", "enum": values}
+	}
+	if t.Implements(textMarshalerType) {
+		return object{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return object{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return object{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return object{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return object{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := schemaName(t)
+		if _, ok := g.schemas[name]; !ok {
+			// Claim the name first so recursive types refer back to it
+			g.schemas[name] = object{}
+			g.schemas[name] = g.object(t)
+		}
+		return object{"$ref": "#/components/schemas/" + name}
+	}
+	// Interfaces hold any JSON value
+	return object{}
+}
+
+func (g *schemaGenerator) object(t reflect.Type) object {
+	properties := object{}
+	var required []string
+	g.addFields(t, properties, &required)
+	s := object{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// addFields adds t's encoded fields, promoting those of embedded structs.
+func (g *schemaGenerator) addFields(t reflect.Type, properties object, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.addFields(f.Type, properties, required)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		s := g.schema(f.Type)
+		omitempty := strings.Contains(opts, "omitempty")
+		if f.Type.Kind() == reflect.Pointer && !omitempty {
+			s = nullable(s)
+		}
+		properties[name] = s
+		if !omitempty {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// nullable marks s as also accepting null. References cannot carry
+// siblings in OpenAPI 3.0, so they are wrapped.
+func nullable(s object) object {
+	if _, ok := s["$ref"]; ok {
+		return object{"allOf": []object{s}, "nullable": true}
+	}
+	s["nullable"] = true
+	return s
+}
+
+func schemaName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
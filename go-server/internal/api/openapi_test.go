@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"homemusic-server/internal/types"
+)
+
+// openAPISpec returns the document as a client would decode it.
+func openAPISpec(t *testing.T) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(openAPIDocument("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	mem := newTestStore(t)
+	r := chi.NewRouter()
+	mem.library(r)
+	mem.playlists(r)
+	mem.sources(r)
+
+	var routed, documented []string
+	chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routed = append(routed, method+" "+route)
+		return nil
+	})
+	for _, op := range apiOperations {
+		documented = append(documented, op.method+" "+op.path)
+	}
+	sort.Strings(routed)
+	sort.Strings(documented)
+	if strings.Join(routed, "\n") != strings.Join(documented, "\n") {
+		t.Errorf("routes:\n%s\n\ndocumented:\n%s", strings.Join(routed, "\n"), strings.Join(documented, "\n"))
+	}
+}
+
+func TestResponsesMatchOpenAPI(t *testing.T) {
+	mem := newTestStore(t)
+	addTrack(mem, "t1", "One", "al1", "/music/a", 1)
+	addTrack(mem, "t2", "Two", "al2", "/music/b", 1)
+	mix, _ := mem.CreatePlaylist("Mix", alice.ID)
+	mem.AddTracksToPlaylist(mix.ID, []string{"t2", "t1"})
+	mem.SetPlaylistVisibility(mix.ID, types.VisibilityPublic)
+	mem.CreateSmartPlaylist("Short", alice.ID, &types.SmartRules{SmartRule: types.SmartRule{Field: "duration", Operator: "lt", Value: 200}})
+	mem.CreateSource(types.Source{ID: "s1", Name: "NAS", Type: types.SourceTypeSSH, Host: "nas.local", Port: 22})
+
+	doc := openAPISpec(t)
+	tests := []struct {
+		routes  func(chi.Router)
+		method  string
+		pattern string
+		url     string
+		body    interface{}
+	}{
+		{mem.library, "GET", "/tracks", "/tracks", nil},
+		{mem.library, "GET", "/albums", "/albums", nil},
+		{mem.library, "GET", "/albums/{id}", "/albums/al1", nil},
+		{mem.library, "GET", "/artists", "/artists", nil},
+		{mem.library, "GET", "/artists/{id}", "/artists/ar-al1", nil},
+		{mem.library, "GET", "/folders", "/folders", nil},
+		{mem.library, "GET", "/folders/tracks", "/folders/tracks?path=/music/a", nil},
+		{mem.playlists, "GET", "/playlists", "/playlists", nil},
+		{mem.playlists, "GET", "/playlists", "/playlists?scope=public", nil},
+		{mem.playlists, "GET", "/playlists/{id}", "/playlists/" + mix.ID, nil},
+		{mem.playlists, "PATCH", "/playlists/{id}", "/playlists/" + mix.ID, updatePlaylistRequest{Name: &mix.Name}},
+		{mem.playlists, "POST", "/playlists", "/playlists", createPlaylistRequest{Name: "New"}},
+		{mem.playlists, "POST", "/playlists/{id}/tracks", "/playlists/" + mix.ID + "/tracks", addTracksRequest{AlbumID: "al1"}},
+		{mem.sources, "GET", "/sources", "/sources", nil},
+		{mem.sources, "GET", "/sources/{id}", "/sources/s1", nil},
+		{mem.sources, "GET", "/sources/{id}/status", "/sources/s1/status", nil},
+		{mem.sources, "GET", "/sources/{id}/stats", "/sources/s1/stats", nil},
+		{mem.library, "GET", "/albums/{id}", "/albums/missing", nil},
+		{mem.playlists, "GET", "/playlists", "/playlists?scope=everyone", nil},
+		{mem.playlists, "POST", "/playlists/{id}/tracks", "/playlists/" + mix.ID + "/tracks", addTracksRequest{}},
+		{mem.sources, "GET", "/sources/{id}", "/sources/missing", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.url, func(t *testing.T) {
+			op, ok := lookup(doc, "paths", tt.pattern, strings.ToLower(tt.method)).(map[string]interface{})
+			if !ok {
+				t.Fatalf("%s %s is not documented", tt.method, tt.pattern)
+			}
+			rec := do(t, tt.routes, alice, tt.method, tt.url, tt.body)
+			response, ok := lookup(op, "responses", strconv.Itoa(rec.Code)).(map[string]interface{})
+			if !ok && rec.Code >= 400 {
+				response, ok = lookup(op, "responses", "default").(map[string]interface{})
+			}
+			if !ok {
+				t.Fatalf("status %d is not documented: %s", rec.Code, rec.Body.String())
+			}
+			schema := lookup(response, "content", "application/json", "schema")
+			var body interface{}
+			decode(t, rec, &body)
+			checkSchema(t, doc, schema.(map[string]interface{}), body, "response")
+		})
+	}
+}
+
+func lookup(v interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// checkSchema reports where v does not match s: missing required or
+// undocumented properties, wrong types and values outside an enum.
+func checkSchema(t *testing.T, doc map[string]interface{}, s map[string]interface{}, v interface{}, at string) {
+	t.Helper()
+	if ref, ok := s["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		s, ok = lookup(doc, "components", "schemas", name).(map[string]interface{})
+		if !ok {
+			t.Fatalf("%s: unresolved %s", at, ref)
+		}
+	}
+	if v == nil {
+		if s["nullable"] != true {
+			t.Errorf("%s: null is not allowed", at)
+		}
+		return
+	}
+	if all, ok := s["allOf"].([]interface{}); ok {
+		checkSchema(t, doc, all[0].(map[string]interface{}), v, at)
+		return
+	}
+
+	switch s["type"] {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			t.Errorf("%s: got %T, want object", at, v)
+			return
+		}
+		if extra, ok := s["additionalProperties"].(map[string]interface{}); ok {
+			for k, pv := range obj {
+				checkSchema(t, doc, extra, pv, at+"."+k)
+			}
+			return
+		}
+		props, _ := s["properties"].(map[string]interface{})
+		for k, pv := range obj {
+			ps, ok := props[k].(map[string]interface{})
+			if !ok {
+				t.Errorf("%s: undocumented property %q", at, k)
+				continue
+			}
+			checkSchema(t, doc, ps, pv, at+"."+k)
+		}
+		required, _ := s["required"].([]interface{})
+		for _, k := range required {
+			if _, ok := obj[k.(string)]; !ok {
+				t.Errorf("%s: missing required property %q", at, k)
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			t.Errorf("%s: got %T, want array", at, v)
+			return
+		}
+		for i, item := range arr {
+			checkSchema(t, doc, s["items"].(map[string]interface{}), item, at+"["+strconv.Itoa(i)+"]")
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			t.Errorf("%s: got %T, want string", at, v)
+			return
+		}
+		if enum, ok := s["enum"].([]interface{}); ok {
+			found := false
+			for _, e := range enum {
+				found = found || e == str
+			}
+			if !found {
+				t.Errorf("%s: %q is not one of %v", at, str, enum)
+			}
+		}
+	case "integer", "number":
+		if _, ok := v.(float64); !ok {
+			t.Errorf("%s: got %T, want %s", at, v, s["type"])
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			t.Errorf("%s: got %T, want boolean", at, v)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/playlistfile"
+	"homemusic-server/internal/types"
+)
+
+const maxPlaylistUpload = 5 << 20
+
+func (h *playlistHandlers) handleExportPlaylist(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	formatName := r.URL.Query().Get("format")
+	if formatName == "" {
+		formatName = string(playlistfile.FormatM3U8)
+	}
+	format, ok := playlistfile.ParseFormat(formatName)
+	if !ok {
+		writeError(w, r, apierr.Invalid("Unsupported format: "+formatName))
+		return
+	}
+
+	// "path" writes library paths (re-importable), "url" writes stream URLs
+	locationMode := r.URL.Query().Get("location")
+	if locationMode != "" && locationMode != "path" && locationMode != "url" {
+		writeError(w, r, apierr.Invalid("location must be path or url"))
+		return
+	}
+
+	if _, ok := h.authorizePlaylist(w, r, id, db.AccessView); !ok {
+		return
+	}
+	playlist, err := h.store.Playlists.GetPlaylist(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if playlist == nil {
+		writeError(w, r, apierr.NotFound("Playlist not found"))
+		return
+	}
+
+	name := playlist.Name
+	tracks := playlist.Tracks
+	entries := make([]playlistfile.Entry, 0, len(tracks))
+	for _, t := range tracks {
+		location := t.Path
+		if locationMode == "url" {
+			location = requestBaseURL(r) + "/api/stream/" + t.ID
+		}
+		entries = append(entries, playlistfile.Entry{
+			Location: location,
+			Title:    t.Title,
+			Artist:   t.Artist,
+			Album:    t.Album,
+			Duration: int(t.Duration),
+		})
+	}
+
+	filename := sanitizeFilename(name)
+	if filename == "" {
+		filename = "playlist"
+	}
+	filename += "." + string(format)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q; filename*=UTF-8''%s",
+		filename, url.PathEscape(filename)))
+	playlistfile.Write(w, name, entries, format)
+}
+
+// handleImportPlaylist creates a playlist from an uploaded playlist file,
+// sent either as multipart field "file" or as the raw request body.
+// Optional "name", "format" and "base" (the directory the file came from,
+// for resolving relative entries) may be given as form or query values.
+// playlistImport reports how many of an imported file's entries were found
+// in the library.
+type playlistImport struct {
+	Playlist  *types.Playlist      `json:"playlist"`
+	Format    playlistfile.Format  `json:"format"`
+	Total     int                  `json:"total"`
+	Matched   int                  `json:"matched"`
+	Unmatched []playlistfile.Entry `json:"unmatched"`
+}
+
+func (h *playlistHandlers) handleImportPlaylist(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxPlaylistUpload)
+
+	var data []byte
+	var filename string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, r, apierr.Invalid("A playlist file is required: "+err.Error()))
+			return
+		}
+		defer file.Close()
+		filename = header.Filename
+		if data, err = io.ReadAll(file); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	} else {
+		var err error
+		if data, err = io.ReadAll(r.Body); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	if len(data) == 0 {
+		writeError(w, r, apierr.Invalid("Playlist file is empty"))
+		return
+	}
+
+	format := playlistfile.DetectFormat(data)
+	if f, ok := playlistfile.FormatFromFilename(filename); ok {
+		format = f
+	}
+	if v := r.FormValue("format"); v != "" {
+		f, ok := playlistfile.ParseFormat(v)
+		if !ok {
+			writeError(w, r, apierr.Invalid("Unsupported format: "+v))
+			return
+		}
+		format = f
+	}
+
+	entries, err := playlistfile.Parse(data, format)
+	if err != nil {
+		writeError(w, r, apierr.Invalid(err.Error()))
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" && filename != "" {
+		name = strings.TrimSuffix(path.Base(strings.ReplaceAll(filename, "\\", "/")), path.Ext(filename))
+	}
+	if name == "" {
+		name = "Imported playlist"
+	}
+
+	resolver, err := newLibraryResolver(h.store.Library)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	base := r.FormValue("base")
+	var trackIDs []string
+	unmatched := []playlistfile.Entry{}
+	for _, e := range entries {
+		if id, ok := resolver.Resolve(e.Location, base, ""); ok {
+			trackIDs = append(trackIDs, id)
+		} else {
+			unmatched = append(unmatched, e)
+		}
+	}
+
+	p, err := h.store.Playlists.CreatePlaylist(name, auth.UserFromContext(r.Context()).ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	added, err := h.store.Playlists.AddTracksToPlaylist(p.ID, trackIDs)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(playlistImport{
+		Playlist:  p,
+		Format:    format,
+		Total:     len(entries),
+		Matched:   added,
+		Unmatched: unmatched,
+	})
+}
+
+func newLibraryResolver(library db.LibraryStore) (*playlistfile.Resolver, error) {
+	tracks, err := library.GetAllTracks()
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]playlistfile.TrackRef, len(tracks))
+	for i, t := range tracks {
+		refs[i] = playlistfile.TrackRef{ID: t.ID, SourceID: t.SourceID, Path: t.Path}
+		if t.FolderPath != nil {
+			refs[i].FolderPath = *t.FolderPath
+		}
+	}
+	return playlistfile.NewResolver(refs), nil
+}
+
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
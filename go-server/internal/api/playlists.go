@@ -2,99 +2,528 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/auth"
 	"homemusic-server/internal/db"
+	"homemusic-server/internal/types"
 )
 
-func RegisterPlaylistRoutes(r chi.Router) {
-	r.Get("/playlists", handleGetPlaylists)
-	r.Post("/playlists", handleCreatePlaylist)
-	r.Get("/playlists/{id}", handleGetPlaylist)
-	r.Delete("/playlists/{id}", handleDeletePlaylist)
-	r.Post("/playlists/{id}/tracks", handleAddTrackToPlaylist)
-	r.Delete("/playlists/{id}/tracks/{trackId}", handleRemoveTrackFromPlaylist)
+// playlistHandlers serve the playlists in store.
+type playlistHandlers struct {
+	store *db.Store
 }
 
-func handleGetPlaylists(w http.ResponseWriter, r *http.Request) {
-	playlists, err := db.GetAllPlaylists()
+// RegisterPlaylistRoutes serves the playlists in store, their items, and
+// who they are shared with.
+func RegisterPlaylistRoutes(store *db.Store) func(chi.Router) {
+	h := &playlistHandlers{store: store}
+	return func(r chi.Router) {
+		r.Get("/playlists", h.handleGetPlaylists)
+		r.Post("/playlists", h.handleCreatePlaylist)
+		r.Post("/playlists/import", h.handleImportPlaylist)
+		r.Get("/playlists/followed", h.handleGetFollowedPlaylists)
+		r.Get("/playlists/{id}", h.handleGetPlaylist)
+		r.Patch("/playlists/{id}", h.handleUpdatePlaylist)
+		r.Delete("/playlists/{id}", h.handleDeletePlaylist)
+		r.With(auth.RequireScope(types.ScopeStream)).Get("/playlists/{id}/download", h.handleDownloadPlaylist)
+		r.Get("/playlists/{id}/export", h.handleExportPlaylist)
+		r.Post("/playlists/{id}/tracks", h.handleAddTrackToPlaylist)
+		r.Delete("/playlists/{id}/tracks", h.handleClearPlaylist)
+		r.Delete("/playlists/{id}/tracks/{trackId}", h.handleRemoveTrackFromPlaylist)
+		r.Patch("/playlists/{id}/items/{itemId}", h.handleMovePlaylistItem)
+		r.Delete("/playlists/{id}/items/{itemId}", h.handleRemovePlaylistItem)
+		r.Get("/playlists/{id}/collaborators", h.handleGetCollaborators)
+		r.Post("/playlists/{id}/collaborators", h.handleSetCollaborator)
+		r.Delete("/playlists/{id}/collaborators/{userId}", h.handleRemoveCollaborator)
+		r.Put("/playlists/{id}/follow", h.handleFollowPlaylist)
+		r.Delete("/playlists/{id}/follow", h.handleUnfollowPlaylist)
+	}
+}
+
+type createPlaylistRequest struct {
+	Name       string                   `json:"name"`
+	Smart      bool                     `json:"smart"`
+	Rules      *types.SmartRules        `json:"rules,omitempty"`
+	Visibility types.PlaylistVisibility `json:"visibility,omitempty"`
+}
+
+// updatePlaylistRequest changes only the fields that are set.
+type updatePlaylistRequest struct {
+	Name       *string                   `json:"name,omitempty"`
+	Rules      *types.SmartRules         `json:"rules,omitempty"`
+	Visibility *types.PlaylistVisibility `json:"visibility,omitempty"`
+}
+
+// addTracksRequest adds the union of the listed tracks, an album's and a
+// folder's tracks.
+type addTracksRequest struct {
+	TrackID    string   `json:"trackId,omitempty"`
+	TrackIDs   []string `json:"trackIds,omitempty"`
+	AlbumID    string   `json:"albumId,omitempty"`
+	FolderPath string   `json:"folderPath,omitempty"`
+}
+
+type addTracksResult struct {
+	Success bool `json:"success"`
+	Added   int  `json:"added"`
+}
+
+type moveItemRequest struct {
+	Position *int `json:"position"`
+}
+
+// setCollaboratorRequest names the user by ID or username.
+type setCollaboratorRequest struct {
+	UserID     string                       `json:"userId,omitempty"`
+	Username   string                       `json:"username,omitempty"`
+	Permission types.CollaboratorPermission `json:"permission,omitempty"`
+}
+
+// handleGetPlaylists lists the caller's library, or with ?scope=public
+// every public playlist on the server.
+func (h *playlistHandlers) handleGetPlaylists(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	var playlists []types.PlaylistSummary
+	var err error
+	switch r.URL.Query().Get("scope") {
+	case "", "library":
+		playlists, err = h.store.Playlists.GetAllPlaylists(user)
+	case "public":
+		playlists, err = h.store.Playlists.GetPublicPlaylists(user)
+	default:
+		writeError(w, r, apierr.Invalid("scope must be library or public"))
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	json.NewEncoder(w).Encode(playlists)
 }
 
-func handleCreatePlaylist(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Name string `json:"name"`
+func (h *playlistHandlers) handleCreatePlaylist(w http.ResponseWriter, r *http.Request) {
+	var req createPlaylistRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if req.Visibility != "" && !validVisibility(req.Visibility) {
+		writeError(w, r, apierr.Invalid("Visibility must be private, shared or public"))
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		writeError(w, r, apierr.Invalid("Name is required"))
 		return
 	}
 
-	p, err := db.CreatePlaylist(req.Name)
+	owner := auth.UserFromContext(r.Context()).ID
+	var p *types.Playlist
+	var err error
+	if req.Smart || req.Rules != nil {
+		if req.Rules == nil {
+			writeError(w, r, apierr.Invalid("Rules are required for a smart playlist"))
+			return
+		}
+		p, err = h.store.Playlists.CreateSmartPlaylist(name, owner, req.Rules)
+	} else {
+		p, err = h.store.Playlists.CreatePlaylist(name, owner)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writePlaylistError(w, r, err, "Playlist not found")
 		return
 	}
+	if req.Visibility != "" && req.Visibility != p.Visibility {
+		if err := h.store.Playlists.SetPlaylistVisibility(p.ID, req.Visibility); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		p.Visibility = req.Visibility
+	}
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(p)
 }
 
-func handleGetPlaylist(w http.ResponseWriter, r *http.Request) {
+func (h *playlistHandlers) handleGetPlaylist(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	playlist, err := db.GetPlaylist(id)
+	access, ok := h.authorizePlaylist(w, r, id, db.AccessView)
+	if !ok {
+		return
+	}
+	h.writePlaylistDetail(w, r, id, access)
+}
+
+// writePlaylistDetail responds with the playlist as the caller sees it.
+func (h *playlistHandlers) writePlaylistDetail(w http.ResponseWriter, r *http.Request, id string, access db.PlaylistAccess) {
+	playlist, err := h.store.Playlists.GetPlaylist(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	if playlist == nil {
-		http.Error(w, "Playlist not found", http.StatusNotFound)
+		writeError(w, r, apierr.NotFound("Playlist not found"))
+		return
+	}
+	playlist.Access = access
+	playlist.Following, err = h.store.Playlists.IsFollowingPlaylist(auth.UserFromContext(r.Context()).ID, id)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 	json.NewEncoder(w).Encode(playlist)
 }
 
-func handleDeletePlaylist(w http.ResponseWriter, r *http.Request) {
+func (h *playlistHandlers) handleGetFollowedPlaylists(w http.ResponseWriter, r *http.Request) {
+	playlists, err := h.store.Playlists.GetFollowedPlaylists(auth.UserFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(playlists)
+}
+
+func (h *playlistHandlers) handleDeletePlaylist(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	if err := db.DeletePlaylist(id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if _, ok := h.authorizePlaylist(w, r, id, db.AccessOwner); !ok {
+		return
+	}
+	if err := h.store.Playlists.DeletePlaylist(id); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func handleAddTrackToPlaylist(w http.ResponseWriter, r *http.Request) {
+// handleAddTrackToPlaylist appends a single track, a list of tracks, or
+// every track of an album or folder.
+func (h *playlistHandlers) handleAddTrackToPlaylist(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	var req struct {
-		TrackID string `json:"trackId"`
+	var req addTracksRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	trackIDs := req.TrackIDs
+	if req.TrackID != "" {
+		trackIDs = append([]string{req.TrackID}, trackIDs...)
+	}
+	if req.AlbumID != "" {
+		tracks, err := h.store.Library.GetTracksByAlbum(req.AlbumID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		for _, t := range tracks {
+			trackIDs = append(trackIDs, t.ID)
+		}
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if req.FolderPath != "" {
+		tracks, err := h.store.Library.GetTracksByFolder(req.FolderPath)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		for _, t := range tracks {
+			trackIDs = append(trackIDs, t.ID)
+		}
+	}
+	if len(trackIDs) == 0 {
+		writeError(w, r, apierr.Invalid("trackId, trackIds, albumId or folderPath is required"))
+		return
+	}
+	if _, ok := h.authorizePlaylist(w, r, id, db.AccessEdit); !ok {
 		return
 	}
 
-	if err := db.AddTrackToPlaylist(id, req.TrackID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	added, err := h.store.Playlists.AddTracksToPlaylist(id, trackIDs)
+	if err != nil {
+		writePlaylistError(w, r, err, "Playlist not found")
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	json.NewEncoder(w).Encode(addTracksResult{Success: true, Added: added})
 }
 
-func handleRemoveTrackFromPlaylist(w http.ResponseWriter, r *http.Request) {
+// handleUpdatePlaylist renames a playlist, changes its visibility and, for
+// smart playlists, replaces its rules.
+func (h *playlistHandlers) handleUpdatePlaylist(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req updatePlaylistRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Name == nil && req.Rules == nil && req.Visibility == nil {
+		writeError(w, r, apierr.Invalid("Name, rules or visibility is required"))
+		return
+	}
+	if req.Visibility != nil && !validVisibility(*req.Visibility) {
+		writeError(w, r, apierr.Invalid("Visibility must be private, shared or public"))
+		return
+	}
+	var name string
+	if req.Name != nil {
+		if name = strings.TrimSpace(*req.Name); name == "" {
+			writeError(w, r, apierr.Invalid("Name is required"))
+			return
+		}
+	}
+	if req.Rules != nil {
+		if err := db.ValidateSmartRules(req.Rules); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	// Collaborators may edit, but only the owner decides who can see it
+	need := db.AccessEdit
+	if req.Visibility != nil {
+		need = db.AccessOwner
+	}
+	access, ok := h.authorizePlaylist(w, r, id, need)
+	if !ok {
+		return
+	}
+
+	if req.Rules != nil {
+		if err := h.store.Playlists.UpdateSmartPlaylistRules(id, req.Rules); err != nil {
+			writePlaylistError(w, r, err, "Playlist not found")
+			return
+		}
+	}
+	if req.Name != nil {
+		if err := h.store.Playlists.RenamePlaylist(id, name); err != nil {
+			writePlaylistError(w, r, err, "Playlist not found")
+			return
+		}
+	}
+	if req.Visibility != nil {
+		if err := h.store.Playlists.SetPlaylistVisibility(id, *req.Visibility); err != nil {
+			writePlaylistError(w, r, err, "Playlist not found")
+			return
+		}
+	}
+
+	h.writePlaylistDetail(w, r, id, access)
+}
+
+func (h *playlistHandlers) handleClearPlaylist(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.authorizePlaylist(w, r, id, db.AccessEdit); !ok {
+		return
+	}
+	err := h.store.Playlists.ClearPlaylist(id)
+	if err != nil {
+		writePlaylistError(w, r, err, "Playlist not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *playlistHandlers) handleMovePlaylistItem(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	itemID := chi.URLParam(r, "itemId")
+	var req moveItemRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Position == nil {
+		writeError(w, r, apierr.Invalid("Position is required"))
+		return
+	}
+	if _, ok := h.authorizePlaylist(w, r, id, db.AccessEdit); !ok {
+		return
+	}
+
+	err := h.store.Playlists.MovePlaylistItem(id, itemID, *req.Position)
+	if err != nil {
+		writePlaylistError(w, r, err, "Playlist item not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *playlistHandlers) handleRemovePlaylistItem(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	itemID := chi.URLParam(r, "itemId")
+	if _, ok := h.authorizePlaylist(w, r, id, db.AccessEdit); !ok {
+		return
+	}
+
+	err := h.store.Playlists.RemovePlaylistItem(id, itemID)
+	if err != nil {
+		writePlaylistError(w, r, err, "Playlist item not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *playlistHandlers) handleRemoveTrackFromPlaylist(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	trackID := chi.URLParam(r, "trackId")
+	if _, ok := h.authorizePlaylist(w, r, id, db.AccessEdit); !ok {
+		return
+	}
 
-	if err := db.RemoveTrackFromPlaylist(id, trackID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	err := h.store.Playlists.RemoveTrackFromPlaylist(id, trackID)
+	if err != nil {
+		writePlaylistError(w, r, err, "Playlist not found")
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (h *playlistHandlers) handleGetCollaborators(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.authorizePlaylist(w, r, id, db.AccessView); !ok {
+		return
+	}
+	collaborators, err := h.store.Playlists.GetPlaylistCollaborators(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(collaborators)
+}
+
+// handleSetCollaborator adds a collaborator by user ID or username, or
+// changes the permission of an existing one.
+func (h *playlistHandlers) handleSetCollaborator(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req setCollaboratorRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Permission == "" {
+		req.Permission = types.PermissionView
+	}
+	if req.Permission != types.PermissionView && req.Permission != types.PermissionEdit {
+		writeError(w, r, apierr.Invalid("Permission must be view or edit"))
+		return
+	}
+	if _, ok := h.authorizePlaylist(w, r, id, db.AccessOwner); !ok {
+		return
+	}
+
+	userID := req.UserID
+	if userID == "" {
+		if req.Username == "" {
+			writeError(w, r, apierr.Invalid("userId or username is required"))
+			return
+		}
+		user, err := h.store.Users.GetUserByUsername(strings.TrimSpace(req.Username))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if user == nil {
+			writeError(w, r, apierr.NotFound("User not found"))
+			return
+		}
+		userID = user.ID
+	}
+
+	if err := h.store.Playlists.SetPlaylistCollaborator(id, userID, req.Permission); err != nil {
+		if errors.Is(err, db.ErrInvalidCollaborator) {
+			writeError(w, r, apierr.Invalid("The owner cannot be a collaborator and the user must exist"))
+			return
+		}
+		writePlaylistError(w, r, err, "Playlist not found")
+		return
+	}
+
+	collaborators, err := h.store.Playlists.GetPlaylistCollaborators(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(collaborators)
+}
+
+// handleRemoveCollaborator lets the owner remove anyone, and a collaborator
+// remove themselves.
+func (h *playlistHandlers) handleRemoveCollaborator(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID := chi.URLParam(r, "userId")
+	need := db.AccessOwner
+	if userID == auth.UserFromContext(r.Context()).ID {
+		need = db.AccessView
+	}
+	if _, ok := h.authorizePlaylist(w, r, id, need); !ok {
+		return
+	}
+
+	if err := h.store.Playlists.RemovePlaylistCollaborator(id, userID); err != nil {
+		writePlaylistError(w, r, err, "Collaborator not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *playlistHandlers) handleFollowPlaylist(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.authorizePlaylist(w, r, id, db.AccessView); !ok {
+		return
+	}
+	if err := h.store.Playlists.FollowPlaylist(auth.UserFromContext(r.Context()).ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *playlistHandlers) handleUnfollowPlaylist(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := h.store.Playlists.UnfollowPlaylist(auth.UserFromContext(r.Context()).ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizePlaylist checks the signed-in user has at least need access to
+// the playlist, writing the error response itself when not. Playlists the
+// user may not see are reported as missing rather than forbidden.
+func (h *playlistHandlers) authorizePlaylist(w http.ResponseWriter, r *http.Request, id string, need db.PlaylistAccess) (db.PlaylistAccess, bool) {
+	access, err := h.store.Playlists.GetPlaylistAccess(id, auth.UserFromContext(r.Context()))
+	if errors.Is(err, db.ErrNotFound) || (err == nil && access == db.AccessNone) {
+		writeError(w, r, apierr.NotFound("Playlist not found"))
+		return access, false
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return access, false
+	}
+	if access < need {
+		msg := "You do not have permission to edit this playlist"
+		if need == db.AccessOwner {
+			msg = "Only the playlist owner can do this"
+		}
+		writeError(w, r, apierr.Forbidden(msg))
+		return access, false
+	}
+	return access, true
+}
+
+func validVisibility(v types.PlaylistVisibility) bool {
+	return v == types.VisibilityPrivate || v == types.VisibilityShared || v == types.VisibilityPublic
+}
+
+// writePlaylistError is writeError with a message naming what was not found.
+func writePlaylistError(w http.ResponseWriter, r *http.Request, err error, notFound string) {
+	if errors.Is(err, db.ErrNotFound) {
+		err = apierr.NotFound(notFound)
+	}
+	writeError(w, r, err)
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"homemusic-server/internal/auth"
+
+	"homemusic-server/internal/types"
+)
+
+type playlistResponse struct {
+	ID     string
+	Access string
+	Tracks []struct{ ID string }
+	Items  []struct{ ID, TrackID string }
+}
+
+func createPlaylist(t *testing.T, mem *testStore, name string) string {
+	t.Helper()
+	rec := do(t, mem.playlists, alice, http.MethodPost, "/playlists", map[string]string{"name": name})
+	expectStatus(t, rec, http.StatusCreated)
+	var p struct{ ID string }
+	decode(t, rec, &p)
+	return p.ID
+}
+
+func TestPlaylistEditing(t *testing.T) {
+	mem := newTestStore(t)
+	addTrack(mem, "t1", "One", "al1", "/music/a", 1)
+	addTrack(mem, "t2", "Two", "al1", "/music/a", 2)
+	addTrack(mem, "t3", "Three", "al2", "/music/b", 1)
+	id := createPlaylist(t, mem, "Mix")
+
+	rec := do(t, mem.playlists, alice, http.MethodPost, "/playlists/"+id+"/tracks",
+		map[string]interface{}{"trackIds": []string{"t3", "missing"}, "albumId": "al1"})
+	expectStatus(t, rec, http.StatusCreated)
+	var added struct{ Added int }
+	decode(t, rec, &added)
+	if added.Added != 3 {
+		t.Errorf("added = %d, want 3", added.Added)
+	}
+
+	var p playlistResponse
+	decode(t, do(t, mem.playlists, alice, http.MethodGet, "/playlists/"+id, nil), &p)
+	if got := trackIDs(p); got != "t3,t1,t2" {
+		t.Fatalf("tracks = %s, want t3,t1,t2", got)
+	}
+	if p.Access != "owner" {
+		t.Errorf("access = %q, want owner", p.Access)
+	}
+
+	rec = do(t, mem.playlists, alice, http.MethodPatch, "/playlists/"+id+"/items/"+p.Items[0].ID,
+		map[string]int{"position": 5})
+	expectStatus(t, rec, http.StatusNoContent)
+	rec = do(t, mem.playlists, alice, http.MethodDelete, "/playlists/"+id+"/tracks/t1", nil)
+	expectStatus(t, rec, http.StatusNoContent)
+
+	decode(t, do(t, mem.playlists, alice, http.MethodGet, "/playlists/"+id, nil), &p)
+	if got := trackIDs(p); got != "t2,t3" {
+		t.Errorf("tracks = %s, want t2,t3", got)
+	}
+}
+
+func TestPlaylistNameRequired(t *testing.T) {
+	mem := newTestStore(t)
+	rec := do(t, mem.playlists, alice, http.MethodPost, "/playlists", map[string]string{"name": " \t"})
+	expectStatus(t, rec, http.StatusBadRequest)
+	if playlists, _ := mem.GetAllPlaylists(alice); len(playlists) != 0 {
+		t.Errorf("playlists = %+v", playlists)
+	}
+
+	short := &types.SmartRules{SmartRule: types.SmartRule{Field: "duration", Operator: "lt", Value: 200.0}}
+	p, err := mem.CreateSmartPlaylist("Short", alice.ID, short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := map[string]interface{}{"field": "duration", "operator": "gt", "value": 600}
+
+	// Nothing is saved when any part of an update is invalid
+	rec = do(t, mem.playlists, alice, http.MethodPatch, "/playlists/"+p.ID,
+		map[string]interface{}{"name": "  ", "rules": long})
+	expectStatus(t, rec, http.StatusBadRequest)
+	rec = do(t, mem.playlists, alice, http.MethodPatch, "/playlists/"+p.ID,
+		map[string]interface{}{"name": "Long", "rules": map[string]string{"field": "bogus", "operator": "eq"}})
+	expectStatus(t, rec, http.StatusBadRequest)
+	detail, _ := mem.GetPlaylist(p.ID)
+	if detail.Name != "Short" || detail.Rules.Operator != "lt" {
+		t.Errorf("playlist = %s %+v, want it unchanged", detail.Name, detail.Rules)
+	}
+
+	rec = do(t, mem.playlists, alice, http.MethodPatch, "/playlists/"+p.ID,
+		map[string]interface{}{"name": " Long ", "rules": long})
+	expectStatus(t, rec, http.StatusOK)
+	detail, _ = mem.GetPlaylist(p.ID)
+	if detail.Name != "Long" || detail.Rules.Operator != "gt" {
+		t.Errorf("playlist = %s %+v", detail.Name, detail.Rules)
+	}
+}
+
+func TestPlaylistSharing(t *testing.T) {
+	mem := newTestStore(t)
+	addTrack(mem, "t1", "One", "al1", "/music/a", 1)
+	id := createPlaylist(t, mem, "Private")
+
+	// Private playlists look missing to everyone else
+	rec := do(t, mem.playlists, bob, http.MethodGet, "/playlists/"+id, nil)
+	expectStatus(t, rec, http.StatusNotFound)
+
+	rec = do(t, mem.playlists, alice, http.MethodPost, "/playlists/"+id+"/collaborators",
+		map[string]string{"userId": bob.ID, "permission": "view"})
+	expectStatus(t, rec, http.StatusOK)
+
+	rec = do(t, mem.playlists, bob, http.MethodPost, "/playlists/"+id+"/tracks", map[string]string{"trackId": "t1"})
+	expectStatus(t, rec, http.StatusForbidden)
+	rec = do(t, mem.playlists, bob, http.MethodPatch, "/playlists/"+id, map[string]string{"visibility": "public"})
+	expectStatus(t, rec, http.StatusForbidden)
+
+	rec = do(t, mem.playlists, alice, http.MethodPost, "/playlists/"+id+"/collaborators",
+		map[string]string{"username": " bob ", "permission": "edit"})
+	expectStatus(t, rec, http.StatusOK)
+	rec = do(t, mem.playlists, bob, http.MethodPost, "/playlists/"+id+"/tracks", map[string]string{"trackId": "t1"})
+	expectStatus(t, rec, http.StatusCreated)
+
+	var lists []struct{ ID, Access string }
+	decode(t, do(t, mem.playlists, bob, http.MethodGet, "/playlists", nil), &lists)
+	if len(lists) != 1 || lists[0].ID != id || lists[0].Access != "edit" {
+		t.Errorf("bob's playlists = %+v", lists)
+	}
+
+	rec = do(t, mem.playlists, alice, http.MethodPost, "/playlists/"+id+"/collaborators",
+		map[string]string{"userId": alice.ID})
+	expectStatus(t, rec, http.StatusBadRequest)
+}
+
+func TestSourcePlaylistIsReadOnly(t *testing.T) {
+	mem := newTestStore(t)
+	addTrack(mem, "t1", "One", "al1", "/music/a", 1)
+	if err := mem.SyncSourcePlaylist("src", "/music/a/list.m3u", "list", []string{"t1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var lists []struct {
+		ID       string
+		ReadOnly bool
+	}
+	decode(t, do(t, mem.playlists, alice, http.MethodGet, "/playlists", nil), &lists)
+	if len(lists) != 1 || !lists[0].ReadOnly {
+		t.Fatalf("playlists = %+v, want one read-only playlist", lists)
+	}
+
+	// Not even an admin may edit it
+	admin := *alice
+	admin.Role = types.RoleAdmin
+	rec := do(t, mem.playlists, &admin, http.MethodDelete, "/playlists/"+lists[0].ID+"/tracks", nil)
+	expectStatus(t, rec, http.StatusConflict)
+	rec = do(t, mem.playlists, &admin, http.MethodPatch, "/playlists/"+lists[0].ID, map[string]string{"name": "Renamed"})
+	expectStatus(t, rec, http.StatusConflict)
+}
+
+func trackIDs(p playlistResponse) string {
+	ids := ""
+	for i, t := range p.Tracks {
+		if i > 0 {
+			ids += ","
+		}
+		ids += t.ID
+	}
+	return ids
+}
+
+func TestImportPlaylist(t *testing.T) {
+	mem := newTestStore(t)
+	addTrack(mem, "t1", "One", "al1", "/music/a", 1)
+	addTrack(mem, "t2", "Two", "al1", "/music/a", 2)
+
+	r := chi.NewRouter()
+	mem.playlists(r)
+	req := httptest.NewRequest(http.MethodPost, "/playlists/import?name=Road+trip",
+		strings.NewReader("#EXTM3U\n/music/a/t2.mp3\n/music/a/missing.mp3\n/music/a/t1.mp3\n"))
+	req = req.WithContext(auth.WithUser(req.Context(), alice))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	expectStatus(t, rec, http.StatusCreated)
+
+	var imported struct {
+		Playlist       struct{ ID, Name string }
+		Total, Matched int
+	}
+	decode(t, rec, &imported)
+	if imported.Playlist.Name != "Road trip" || imported.Total != 3 || imported.Matched != 2 {
+		t.Fatalf("import = %+v", imported)
+	}
+	var p playlistResponse
+	decode(t, do(t, mem.playlists, alice, http.MethodGet, "/playlists/"+imported.Playlist.ID, nil), &p)
+	if got := trackIDs(p); got != "t2,t1" {
+		t.Errorf("tracks = %s, want t2,t1", got)
+	}
+}
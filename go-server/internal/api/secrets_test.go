@@ -0,0 +1,98 @@
+package api
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"homemusic-server/internal/types"
+)
+
+const storedSecret = "s3cret-Passw0rd"
+
+// TestSourceSecretsStayPrivate calls every source endpoint and checks the
+// stored password shows up in neither a response nor the log.
+func TestSourceSecretsStayPrivate(t *testing.T) {
+	mem := newTestStore(t)
+
+	user, password := "music", storedSecret
+	share := "Music"
+	// Nothing listens on port 1, so connection attempts fail at once
+	mem.CreateSource(types.Source{ID: "ssh1", Name: "Box", Type: types.SourceTypeSSH, Host: "127.0.0.1", Port: 1, Username: &user, Password: &password})
+	mem.CreateSource(types.Source{ID: "smb1", Name: "NAS", Type: types.SourceTypeSMB, Host: "127.0.0.1", Port: 1, Username: &user, Password: &password, Share: &share})
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	var routes []string
+	r := chi.NewRouter()
+	mem.sources(r)
+	chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routes = append(routes, method+" "+route)
+		return nil
+	})
+	// Delete last so every other route still finds the sources
+	sort.SliceStable(routes, func(i, j int) bool {
+		return !strings.HasPrefix(routes[i], "DELETE") && strings.HasPrefix(routes[j], "DELETE")
+	})
+
+	bodies := map[string]interface{}{
+		"POST /sources":              map[string]interface{}{"type": "ssh", "host": "127.0.0.1", "port": 1, "username": user, "password": storedSecret},
+		"PATCH /sources/{id}":        map[string]interface{}{"name": "Renamed", "password": ""},
+		"POST /sources/test":         map[string]interface{}{"id": "ssh1", "type": "ssh", "host": "127.0.0.1", "port": 1, "username": user},
+		"POST /smb/enumerate-shares": EnumerateRequest{Host: "127.0.0.1:1", Username: user, SourceID: "smb1"},
+	}
+	for _, id := range []string{"ssh1", "smb1"} {
+		for _, route := range routes {
+			method, pattern, _ := strings.Cut(route, " ")
+			rec := do(t, mem.sources, alice, method, strings.ReplaceAll(pattern, "{id}", id), bodies[route])
+			if strings.Contains(rec.Body.String(), storedSecret) {
+				t.Errorf("%s for %s returned the password: %s", route, id, rec.Body.String())
+			}
+			if rec.Code >= 400 && method == "GET" {
+				t.Errorf("%s for %s failed: %d %s", route, id, rec.Code, rec.Body.String())
+			}
+		}
+	}
+	// Background scans may still be logging; switching the output back
+	// first makes reading the buffer safe
+	log.SetOutput(os.Stderr)
+	if strings.Contains(logs.String(), storedSecret) {
+		t.Errorf("the password was logged:\n%s", logs.String())
+	}
+
+	// Updating without a password keeps the stored one
+	if s, _ := mem.GetSource("ssh1"); s != nil {
+		t.Errorf("ssh1 should have been deleted, got %+v", s)
+	}
+	var kept types.Source
+	mem.CreateSource(types.Source{ID: "ssh2", Name: "Box", Type: types.SourceTypeSSH, Host: "127.0.0.1", Port: 1, Username: &user, Password: &password})
+	rec := do(t, mem.sources, alice, "PATCH", "/sources/ssh2", map[string]interface{}{"name": "Renamed", "password": ""})
+	expectStatus(t, rec, http.StatusOK)
+	decode(t, rec, &kept)
+	stored, _ := mem.GetSource("ssh2")
+	if kept.Password != nil || !kept.HasPassword || stored.Password == nil || *stored.Password != storedSecret {
+		t.Errorf("response = %+v, stored password = %v", kept, stored.Password)
+	}
+}
+
+func TestRedactRequestURI(t *testing.T) {
+	tests := []struct{ uri, want string }{
+		{"/api/tracks", "/api/tracks"},
+		{"/rest/ping.view?u=alice&p=" + storedSecret + "&f=json", "/rest/ping.view?u=alice&p=REDACTED&f=json"},
+		{"/rest/stream?id=1&t=abc&s=salt", "/rest/stream?id=1&t=REDACTED&s=REDACTED"},
+		{"/api/stream/1?exp=99&sig=abc", "/api/stream/1?exp=99&sig=REDACTED"},
+		{"/rest/ping?apiKey=abc&%70=enc:00", "/rest/ping?apiKey=REDACTED&%70=REDACTED"},
+	}
+	for _, tt := range tests {
+		if got := redactRequestURI(tt.uri); got != tt.want {
+			t.Errorf("redactRequestURI(%q) = %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+}
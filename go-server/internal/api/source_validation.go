@@ -0,0 +1,193 @@
+package api
+
+import (
+	"path"
+	"strings"
+
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/types"
+)
+
+// sourceRequest is the body of POST and PATCH /sources. Fields left out
+// keep their current value, or their default for a new source. Since
+// responses never include the password, an empty one also means unchanged;
+// ClearPassword removes it.
+type sourceRequest struct {
+	ID           *string           `json:"id,omitempty"`
+	Name         *string           `json:"name,omitempty"`
+	Type         *types.SourceType `json:"type,omitempty"`
+	Host         *string           `json:"host,omitempty"`
+	Port         *int              `json:"port,omitempty"`
+	Username     *string           `json:"username,omitempty"`
+	Password     *string           `json:"password,omitempty"`
+	Domain       *string           `json:"domain,omitempty"`
+	Share        *string           `json:"share,omitempty"`
+	BasePath     *string           `json:"basePath,omitempty"`
+	Enabled      *bool             `json:"enabled,omitempty"`
+	MaxStreams   *int              `json:"maxStreams,omitempty"`
+	MaxBandwidth *int64            `json:"maxBandwidth,omitempty"`
+
+	// Removes the stored password, as for SMB guest access
+	ClearPassword bool `json:"clearPassword,omitempty"`
+}
+
+// check reports fields of the request that contradict each other.
+func (req sourceRequest) check() []apierr.FieldError {
+	if req.ClearPassword && req.Password != nil && *req.Password != "" {
+		return []apierr.FieldError{{Field: "clearPassword", Message: "cannot be combined with a password"}}
+	}
+	return nil
+}
+
+// apply copies the fields that are set onto s.
+func (req sourceRequest) apply(s *types.Source) {
+	set := func(dst *string, v *string) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	setOptional := func(dst **string, v *string) {
+		if v != nil {
+			c := *v
+			*dst = &c
+		}
+	}
+	set(&s.Name, req.Name)
+	set(&s.Host, req.Host)
+	setOptional(&s.Username, req.Username)
+	if req.ClearPassword {
+		s.Password = nil
+	} else if req.Password != nil && *req.Password != "" {
+		setOptional(&s.Password, req.Password)
+	}
+	setOptional(&s.Domain, req.Domain)
+	setOptional(&s.Share, req.Share)
+	setOptional(&s.BasePath, req.BasePath)
+	if req.Type != nil {
+		s.Type = *req.Type
+	}
+	if req.Port != nil {
+		s.Port = *req.Port
+	}
+	if req.Enabled != nil {
+		s.Enabled = *req.Enabled
+	}
+	if req.MaxStreams != nil {
+		s.MaxStreams = *req.MaxStreams
+	}
+	if req.MaxBandwidth != nil {
+		s.MaxBandwidth = *req.MaxBandwidth
+	}
+}
+
+// defaultPorts are used when a source leaves its port unset.
+var defaultPorts = map[types.SourceType]int{
+	types.SourceTypeSMB: 445,
+	types.SourceTypeSSH: 22,
+}
+
+// sourceValidators check what each source type needs to connect, after
+// the checks common to every type.
+var sourceValidators = map[types.SourceType]func(s *types.Source) []apierr.FieldError{
+	types.SourceTypeSMB: validateSMBSource,
+	types.SourceTypeSSH: validateSSHSource,
+}
+
+// normalizeSource trims s and fills in defaults: the host as name, the
+// type's standard port and a clean base path. Fields the type does not use
+// are cleared.
+func normalizeSource(s *types.Source) {
+	s.Name = strings.TrimSpace(s.Name)
+	s.Host = strings.TrimSpace(s.Host)
+	if s.Name == "" {
+		s.Name = s.Host
+	}
+	if s.Port == 0 {
+		s.Port = defaultPorts[s.Type]
+	}
+	s.Username = trimOptional(s.Username)
+	s.Domain = trimOptional(s.Domain)
+	if s.Share != nil {
+		share := strings.Trim(strings.TrimSpace(*s.Share), `/\`)
+		s.Share = &share
+	}
+	s.Share = trimOptional(s.Share)
+
+	if p := trimOptional(s.BasePath); p != nil {
+		// SMB paths are relative to the share; SSH paths may be relative
+		// to the login directory
+		clean := path.Clean(strings.ReplaceAll(*p, `\`, "/"))
+		if s.Type == types.SourceTypeSMB {
+			clean = path.Join("/", clean)
+		}
+		s.BasePath = &clean
+	} else {
+		s.BasePath = nil
+	}
+
+	if s.Type == types.SourceTypeSSH {
+		s.Domain = nil
+		s.Share = nil
+	}
+}
+
+// trimOptional trims v, turning empty strings into nil.
+func trimOptional(v *string) *string {
+	if v == nil {
+		return nil
+	}
+	t := strings.TrimSpace(*v)
+	if t == "" {
+		return nil
+	}
+	return &t
+}
+
+// validateSource lists every problem that would keep s from connecting.
+func validateSource(s *types.Source) []apierr.FieldError {
+	var errs []apierr.FieldError
+	if s.Name == "" {
+		errs = append(errs, apierr.FieldError{Field: "name", Message: "is required"})
+	}
+	if s.Host == "" {
+		errs = append(errs, apierr.FieldError{Field: "host", Message: "is required"})
+	} else if strings.ContainsAny(s.Host, " \t/\\@") || strings.Contains(s.Host, "://") {
+		errs = append(errs, apierr.FieldError{Field: "host", Message: "must be a host name or IP address"})
+	}
+	if s.Port < 1 || s.Port > 65535 {
+		errs = append(errs, apierr.FieldError{Field: "port", Message: "must be between 1 and 65535"})
+	}
+	if s.MaxStreams < 0 {
+		errs = append(errs, apierr.FieldError{Field: "maxStreams", Message: "must not be negative"})
+	}
+	if s.MaxBandwidth < 0 {
+		errs = append(errs, apierr.FieldError{Field: "maxBandwidth", Message: "must not be negative"})
+	}
+
+	validate, ok := sourceValidators[s.Type]
+	if !ok {
+		return append(errs, apierr.FieldError{Field: "type", Message: "must be smb or ssh"})
+	}
+	return append(errs, validate(s)...)
+}
+
+func validateSMBSource(s *types.Source) []apierr.FieldError {
+	var errs []apierr.FieldError
+	if s.Share == nil {
+		errs = append(errs, apierr.FieldError{Field: "share", Message: "is required for SMB sources"})
+	} else if strings.ContainsAny(*s.Share, `/\`) {
+		errs = append(errs, apierr.FieldError{Field: "share", Message: "must be a share name, not a path"})
+	}
+	return errs
+}
+
+func validateSSHSource(s *types.Source) []apierr.FieldError {
+	var errs []apierr.FieldError
+	if s.Username == nil {
+		errs = append(errs, apierr.FieldError{Field: "username", Message: "is required for SSH sources"})
+	}
+	if s.Password == nil {
+		errs = append(errs, apierr.FieldError{Field: "password", Message: "is required for SSH sources"})
+	}
+	return errs
+}
@@ -1,60 +1,122 @@
 package api
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"homemusic-server/internal/apierr"
 	"homemusic-server/internal/db"
+	"homemusic-server/internal/logging"
 	"homemusic-server/internal/scanner"
 	"homemusic-server/internal/sources"
 	"homemusic-server/internal/types"
 )
 
-func RegisterSourceRoutes(r chi.Router) {
-	r.Get("/sources", handleGetSources)
-	r.Post("/sources", handleCreateSource)
-	r.Get("/sources/{id}", handleGetSource)
-	r.Patch("/sources/{id}", handleUpdateSource)
-	r.Delete("/sources/{id}", handleDeleteSource)
-	r.Post("/sources/{id}/test", handleTestExistingSource)
-	r.Post("/sources/test", handleTestNewSource)
-	r.Post("/sources/{id}/scan", handleScanSource)
-	r.Post("/scan", handleScanAll)
-	r.Get("/sources/{id}/status", handleGetSourceStatus)
-	r.Post("/smb/enumerate-shares", handleEnumerateShares)
-	r.Get("/discover", handleDiscover)
+// SourceScanner scans sources in the background; it is a *scanner.Scanner
+// outside of tests. Scans outlive the request that starts them, so they get
+// its context without the cancellation.
+type SourceScanner interface {
+	ScanSource(ctx context.Context, sourceID string) error
+	ScanAllSources(ctx context.Context) error
 }
 
-func handleUpdateSource(w http.ResponseWriter, r *http.Request) {
+// sourceHandlers manage the sources in store and start scans of them.
+type sourceHandlers struct {
+	store *db.Store
+	scans SourceScanner
+}
+
+// RegisterSourceRoutes serves the sources in store, scanning them with
+// scans.
+func RegisterSourceRoutes(store *db.Store, scans SourceScanner) func(chi.Router) {
+	h := &sourceHandlers{store: store, scans: scans}
+	return func(r chi.Router) {
+		r.Get("/sources", h.handleGetSources)
+		r.Post("/sources", h.handleCreateSource)
+		r.Get("/sources/{id}", h.handleGetSource)
+		r.Patch("/sources/{id}", h.handleUpdateSource)
+		r.Delete("/sources/{id}", h.handleDeleteSource)
+		r.Post("/sources/{id}/test", h.handleTestExistingSource)
+		r.Post("/sources/test", h.handleTestNewSource)
+		r.Post("/sources/{id}/scan", h.handleScanSource)
+		r.Post("/scan", h.handleScanAll)
+		r.Get("/sources/{id}/status", h.handleGetSourceStatus)
+		r.Get("/sources/{id}/stats", h.handleGetSourceStats)
+		r.Post("/smb/enumerate-shares", h.handleEnumerateShares)
+		r.Get("/discover", handleDiscover)
+	}
+}
+
+type connectionTest struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+type scanStarted struct {
+	Message string `json:"message"`
+}
+
+// handleUpdateSource merges the request into the stored source, refusing
+// changes that would leave it unable to connect.
+func (h *sourceHandlers) handleUpdateSource(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	var updates map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var req sourceRequest
+	if err := readStrictJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.ID != nil && *req.ID != id {
+		writeError(w, r, invalidFields(apierr.FieldError{Field: "id", Message: "cannot be changed"}))
+		return
+	}
+	if errs := req.check(); len(errs) > 0 {
+		writeError(w, r, invalidFields(errs...))
 		return
 	}
 
-	if err := db.UpdateSource(id, updates); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	s, err := h.store.Sources.GetSource(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if s == nil {
+		writeError(w, r, apierr.NotFound("Source not found"))
+		return
+	}
+	req.apply(s)
+	normalizeSource(s)
+	if errs := validateSource(s); len(errs) > 0 {
+		writeError(w, r, invalidFields(errs...))
 		return
 	}
 
-	s, _ := db.GetSource(id)
-	json.NewEncoder(w).Encode(s)
+	if err := h.store.Sources.UpdateSource(*s); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s, err = h.store.Sources.GetSource(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(s.Redacted())
 }
 
-func handleTestExistingSource(w http.ResponseWriter, r *http.Request) {
+func (h *sourceHandlers) handleTestExistingSource(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	source, err := db.GetSource(id)
+	source, err := h.store.Sources.GetSource(id)
 	if err != nil || source == nil {
-		http.Error(w, "Source not found", http.StatusNotFound)
+		writeError(w, r, apierr.NotFound("Source not found"))
 		return
 	}
 	
@@ -65,15 +127,33 @@ func handleTestExistingSource(w http.ResponseWriter, r *http.Request) {
 		msg = err.Error()
 	}
 	
-	json.NewEncoder(w).Encode(map[string]interface{}{"success": success, "message": msg})
+	json.NewEncoder(w).Encode(connectionTest{Success: success, Message: msg})
 }
 
-func handleTestNewSource(w http.ResponseWriter, r *http.Request) {
+func (h *sourceHandlers) handleTestNewSource(w http.ResponseWriter, r *http.Request) {
 	var source types.Source
-	if err := json.NewDecoder(r.Body).Decode(&source); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := readJSON(r, &source); err != nil {
+		writeError(w, r, err)
 		return
 	}
+	// Clients editing a source don't have its password, so reuse the
+	// stored one when none is given. It only goes to the server and account
+	// it was saved for; with other details the stored source is tested.
+	if source.ID != "" && (source.Password == nil || *source.Password == "") {
+		stored, err := h.store.Sources.GetSource(source.ID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if stored != nil {
+			normalizeSource(&source)
+			if sameAccount(&source, stored) {
+				source.Password = stored.Password
+			} else {
+				source = *stored
+			}
+		}
+	}
 	
 	err := testConnection(&source)
 	success := err == nil
@@ -82,7 +162,14 @@ func handleTestNewSource(w http.ResponseWriter, r *http.Request) {
 		msg = err.Error()
 	}
 	
-	json.NewEncoder(w).Encode(map[string]interface{}{"success": success, "message": msg})
+	json.NewEncoder(w).Encode(connectionTest{Success: success, Message: msg})
+}
+
+// sameAccount reports whether a and b sign in to the same server as the
+// same user, so a password saved for one may be sent for the other.
+func sameAccount(a, b *types.Source) bool {
+	return a.Type == b.Type && a.Host == b.Host && a.Port == b.Port &&
+		getString(a.Username) == getString(b.Username) && getString(a.Domain) == getString(b.Domain)
 }
 
 func getString(s *string) string {
@@ -128,151 +215,190 @@ func testConnection(s *types.Source) error {
 	return fmt.Errorf("unsupported source type")
 }
 
-func handleScanAll(w http.ResponseWriter, r *http.Request) {
-	if err := scanner.ScanAllSources(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+func (h *sourceHandlers) handleScanAll(w http.ResponseWriter, r *http.Request) {
+	if err := h.scans.ScanAllSources(context.WithoutCancel(r.Context())); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Scan started for all enabled sources"})
+	json.NewEncoder(w).Encode(scanStarted{Message: "Scan started for all enabled sources"})
 }
 
 func handleDiscover(w http.ResponseWriter, r *http.Request) {
-	log.Printf("[API] Getting discovered services")
+	logging.Debugf("[API] Getting discovered services")
 	services := scanner.GlobalDiscoveryManager.GetServices()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(services)
 }
 
-func handleScanSource(w http.ResponseWriter, r *http.Request) {
+func (h *sourceHandlers) handleScanSource(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	
 	// Run scan in background
+	ctx := context.WithoutCancel(r.Context())
 	go func() {
-		if err := scanner.ScanSource(id); err != nil {
+		if err := h.scans.ScanSource(ctx, id); err != nil {
 			log.Printf("[API] Scan failed for source %s: %v", id, err)
 		}
 	}()
 
 	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Scan started"})
+	json.NewEncoder(w).Encode(scanStarted{Message: "Scan started"})
 }
 
-func handleGetSources(w http.ResponseWriter, r *http.Request) {
-	s, err := db.GetAllSources()
+func (h *sourceHandlers) handleGetSources(w http.ResponseWriter, r *http.Request) {
+	s, err := h.store.Sources.GetAllSources()
 	if err != nil {
-		log.Printf("[API] Failed to get sources: %v", err)
-		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	for i := range s {
+		s[i].Stats = sources.Limits.Stats(s[i].ID)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(s)
 }
 
-func handleCreateSource(w http.ResponseWriter, r *http.Request) {
-	var s types.Source
-	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+func (h *sourceHandlers) handleCreateSource(w http.ResponseWriter, r *http.Request) {
+	var req sourceRequest
+	if err := readStrictJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if errs := req.check(); len(errs) > 0 {
+		writeError(w, r, invalidFields(errs...))
 		return
 	}
 
+	s := types.Source{Enabled: true}
+	req.apply(&s)
+	if req.ID != nil {
+		s.ID = strings.TrimSpace(*req.ID)
+	}
 	if s.ID == "" {
 		s.ID = uuid.New().String()
 	}
+	normalizeSource(&s)
+	if errs := validateSource(&s); len(errs) > 0 {
+		writeError(w, r, invalidFields(errs...))
+		return
+	}
 	s.CreatedAt = time.Now()
 	s.UpdatedAt = time.Now()
 
-	if err := db.CreateSource(s); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.store.Sources.CreateSource(s); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	// Automatically start scan for the new source
+	ctx := context.WithoutCancel(r.Context())
 	go func() {
-		if err := scanner.ScanSource(s.ID); err != nil {
+		if err := h.scans.ScanSource(ctx, s.ID); err != nil {
 			log.Printf("[API] Automatic initial scan failed for source %s: %v", s.ID, err)
 		}
 	}()
 
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(s)
+	json.NewEncoder(w).Encode(s.Redacted())
 }
 
-func handleGetSource(w http.ResponseWriter, r *http.Request) {
+func (h *sourceHandlers) handleGetSource(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	s, err := db.GetSource(id)
+	s, err := h.store.Sources.GetSource(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	if s == nil {
-		http.Error(w, "Source not found", http.StatusNotFound)
+		writeError(w, r, apierr.NotFound("Source not found"))
 		return
 	}
-	json.NewEncoder(w).Encode(s)
+	json.NewEncoder(w).Encode(s.Redacted())
 }
 
-func handleDeleteSource(w http.ResponseWriter, r *http.Request) {
+func (h *sourceHandlers) handleDeleteSource(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	if err := db.DeleteSource(id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.store.Sources.DeleteSource(id); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func handleGetSourceStatus(w http.ResponseWriter, r *http.Request) {
+func (h *sourceHandlers) handleGetSourceStatus(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	s, err := db.GetSourceStatus(id)
+	s, err := h.store.Status.GetSourceStatus(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	if s == nil {
-		http.Error(w, "Status not found", http.StatusNotFound)
+		writeError(w, r, apierr.NotFound("Status not found"))
 		return
 	}
 	json.NewEncoder(w).Encode(s)
 }
 
+func (h *sourceHandlers) handleGetSourceStats(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	s, err := h.store.Sources.GetSource(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if s == nil {
+		writeError(w, r, apierr.NotFound("Source not found"))
+		return
+	}
+	json.NewEncoder(w).Encode(sources.Limits.Stats(id))
+}
+
+// EnumerateRequest names an SMB server to list. Without a password, the
+// server and account of SourceID are used instead, with its stored
+// password.
 type EnumerateRequest struct {
 	Host     string `json:"host"`
 	Username string `json:"username"`
 	Password string `json:"password"`
 	Domain   string `json:"domain"`
+	SourceID string `json:"sourceId,omitempty"`
 }
 
-func handleEnumerateShares(w http.ResponseWriter, r *http.Request) {
-	// Read body for logging
-	bodyBytes, _ := io.ReadAll(r.Body)
-	log.Printf("[SMB] Raw request body: %s", string(bodyBytes))
-	
-	// Restore body for decoder
-	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-
+func (h *sourceHandlers) handleEnumerateShares(w http.ResponseWriter, r *http.Request) {
 	var req EnumerateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, r, err)
 		return
 	}
+	if req.Password == "" && req.SourceID != "" {
+		stored, err := h.store.Sources.GetSource(req.SourceID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if stored != nil {
+			req = EnumerateRequest{
+				Host:     net.JoinHostPort(stored.Host, strconv.Itoa(stored.Port)),
+				Username: getString(stored.Username),
+				Password: getString(stored.Password),
+				Domain:   getString(stored.Domain),
+				SourceID: stored.ID,
+			}
+		}
+	}
 
-	log.Printf("[SMB] Attempting share enumeration on %s for user: %s (domain: '%s')", req.Host, req.Username, req.Domain)
+	logging.Debugf("[SMB] Attempting share enumeration on %s for user: %s (domain: '%s')", req.Host, req.Username, req.Domain)
 
 	shares, err := sources.EnumerateShares(req.Host, req.Username, req.Password, req.Domain)
 	if err != nil {
 		log.Printf("[SMB] Enumerate failed: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		
 		errMsg := err.Error()
-		status := http.StatusInternalServerError
-		
 		if strings.Contains(errMsg, "logon is invalid") || strings.Contains(errMsg, "authentication") {
-			status = http.StatusUnauthorized
-			errMsg = "Logon failure: Check your username and password. If using a Mac, try leaving the Domain field blank."
+			writeError(w, r, apierr.New(http.StatusBadGateway, apierr.CodeSourceAuthFailed, "Logon failure: Check your username and password. If using a Mac, try leaving the Domain field blank."))
+			return
 		}
-		
-		w.WriteHeader(status)
-		json.NewEncoder(w).Encode(map[string]string{"error": errMsg})
+		writeError(w, r, apierr.SourceUnreachable(err))
 		return
 	}
 
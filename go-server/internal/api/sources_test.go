@@ -0,0 +1,175 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"homemusic-server/internal/types"
+)
+
+func TestSourceRoutes(t *testing.T) {
+	mem := newTestStore(t)
+	user, password := "music", "secret"
+	if err := mem.CreateSource(types.Source{ID: "s1", Name: "NAS", Type: types.SourceTypeSSH, Host: "nas.local", Port: 22, Username: &user, Password: &password}); err != nil {
+		t.Fatal(err)
+	}
+
+	var list []struct {
+		ID     string
+		Status struct{ Status string }
+	}
+	decode(t, do(t, mem.sources, alice, http.MethodGet, "/sources", nil), &list)
+	if len(list) != 1 || list[0].ID != "s1" || list[0].Status.Status != "starting" {
+		t.Fatalf("sources = %+v", list)
+	}
+
+	rec := do(t, mem.sources, alice, http.MethodPatch, "/sources/s1", map[string]interface{}{"name": "Attic", "port": 2222})
+	expectStatus(t, rec, http.StatusOK)
+	var s types.Source
+	decode(t, rec, &s)
+	if s.Name != "Attic" || s.Port != 2222 {
+		t.Errorf("updated source = %+v", s)
+	}
+
+	rec = do(t, mem.sources, alice, http.MethodGet, "/sources/s1/status", nil)
+	expectStatus(t, rec, http.StatusOK)
+
+	rec = do(t, mem.sources, alice, http.MethodPost, "/sources/s1/scan", nil)
+	expectStatus(t, rec, http.StatusAccepted)
+	expectScan(t, mem, "s1")
+	rec = do(t, mem.sources, alice, http.MethodPost, "/scan", nil)
+	expectStatus(t, rec, http.StatusAccepted)
+	expectScan(t, mem, "*")
+
+	rec = do(t, mem.sources, alice, http.MethodDelete, "/sources/s1", nil)
+	expectStatus(t, rec, http.StatusNoContent)
+	rec = do(t, mem.sources, alice, http.MethodGet, "/sources/s1", nil)
+	expectStatus(t, rec, http.StatusNotFound)
+	rec = do(t, mem.sources, alice, http.MethodGet, "/sources/s1/status", nil)
+	expectStatus(t, rec, http.StatusNotFound)
+}
+
+func TestSourceValidation(t *testing.T) {
+	mem := newTestStore(t)
+
+	tests := []struct {
+		name   string
+		body   map[string]interface{}
+		fields []string
+	}{
+		{"unknown field", map[string]interface{}{"type": "ssh", "host": "nas", "hots": "nas"}, []string{"hots"}},
+		{"wrong type", map[string]interface{}{"type": "ssh", "host": "nas", "port": "22"}, []string{"port"}},
+		{"unknown source type", map[string]interface{}{"type": "ftp", "host": "nas"}, []string{"port", "type"}},
+		{"missing host", map[string]interface{}{"type": "ssh", "username": "u", "password": "p"}, []string{"name", "host"}},
+		{"bad port", map[string]interface{}{"type": "ssh", "host": "nas", "port": 70000, "username": "u", "password": "p"}, []string{"port"}},
+		{"url as host", map[string]interface{}{"type": "smb", "host": "smb://nas", "share": "music"}, []string{"host"}},
+		{"smb without share", map[string]interface{}{"type": "smb", "host": "nas"}, []string{"share"}},
+		{"ssh without credentials", map[string]interface{}{"type": "ssh", "host": "nas"}, []string{"username", "password"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(t, mem.sources, alice, http.MethodPost, "/sources", tt.body)
+			expectStatus(t, rec, http.StatusBadRequest)
+			var body errorBody
+			decode(t, rec, &body)
+			var fields []string
+			for _, d := range body.Error.Details.([]interface{}) {
+				fields = append(fields, d.(map[string]interface{})["field"].(string))
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("fields = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestSourceNormalization(t *testing.T) {
+	mem := newTestStore(t)
+
+	rec := do(t, mem.sources, alice, http.MethodPost, "/sources", map[string]interface{}{
+		"id": "s1", "type": "smb", "host": " nas.local ", "share": "/Music/", "basePath": `Albums\Jazz\`, "domain": "",
+	})
+	expectStatus(t, rec, http.StatusCreated)
+	var s types.Source
+	decode(t, rec, &s)
+	if s.Name != "nas.local" || s.Host != "nas.local" || s.Port != 445 || !s.Enabled {
+		t.Errorf("created source = %+v", s)
+	}
+	if *s.Share != "Music" || *s.BasePath != "/Albums/Jazz" || s.Domain != nil {
+		t.Errorf("share = %q, basePath = %q, domain = %v", *s.Share, *s.BasePath, s.Domain)
+	}
+	expectScan(t, mem, "s1")
+
+	// An update is checked against the merged source
+	rec = do(t, mem.sources, alice, http.MethodPatch, "/sources/s1", map[string]interface{}{"share": ""})
+	expectStatus(t, rec, http.StatusBadRequest)
+	rec = do(t, mem.sources, alice, http.MethodPatch, "/sources/s1", map[string]interface{}{"id": "s2"})
+	expectStatus(t, rec, http.StatusBadRequest)
+	rec = do(t, mem.sources, alice, http.MethodPatch, "/sources/s1", map[string]interface{}{"basePath": "/"})
+	expectStatus(t, rec, http.StatusOK)
+	decode(t, rec, &s)
+	if *s.Share != "Music" || *s.BasePath != "/" {
+		t.Errorf("updated source = %+v", s)
+	}
+	rec = do(t, mem.sources, alice, http.MethodPatch, "/sources/missing", map[string]interface{}{"name": "x"})
+	expectStatus(t, rec, http.StatusNotFound)
+}
+
+// expectScan waits for the handlers to start a scan of sourceID, or of
+// every source for "*".
+func expectScan(t *testing.T, mem *testStore, sourceID string) {
+	t.Helper()
+	select {
+	case id := <-mem.scans.started:
+		if id != sourceID {
+			t.Errorf("scan started for %q, want %q", id, sourceID)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("no scan started for %q", sourceID)
+	}
+}
+
+func TestSourcePasswords(t *testing.T) {
+	mem := newTestStore(t)
+	user, password, share := "music", "secret", "Music"
+	// Nothing listens on port 1, so connection attempts fail at once
+	mem.CreateSource(types.Source{ID: "ssh1", Name: "Box", Type: types.SourceTypeSSH, Host: "127.0.0.1", Port: 1, Username: &user, Password: &password})
+	mem.CreateSource(types.Source{ID: "smb1", Name: "NAS", Type: types.SourceTypeSMB, Host: "127.0.0.1", Port: 1, Username: &user, Password: &password, Share: &share})
+
+	// The stored password is not sent to another server or account; the
+	// stored source is tested instead
+	for _, body := range []map[string]interface{}{
+		{"id": "ssh1", "type": "ssh", "host": "127.0.0.2", "port": 1, "username": user},
+		{"id": "ssh1", "type": "ssh", "host": "127.0.0.1", "port": 2, "username": user},
+		{"id": "ssh1", "type": "ssh", "host": "127.0.0.1", "port": 1, "username": "root"},
+	} {
+		var res connectionTest
+		decode(t, do(t, mem.sources, alice, http.MethodPost, "/sources/test", body), &res)
+		if res.Success || !strings.Contains(res.Message, "127.0.0.1:1") {
+			t.Errorf("testing %v: %+v, want a failure connecting to 127.0.0.1:1", body, res)
+		}
+	}
+	rec := do(t, mem.sources, alice, http.MethodPost, "/smb/enumerate-shares", EnumerateRequest{Host: "127.0.0.2:1", Username: "guest", SourceID: "smb1"})
+	if !strings.Contains(rec.Body.String(), "127.0.0.1:1") {
+		t.Errorf("enumerating with another host: %s, want a failure connecting to 127.0.0.1:1", rec.Body.String())
+	}
+
+	// clearPassword removes it, where the source type allows
+	rec = do(t, mem.sources, alice, http.MethodPatch, "/sources/smb1", map[string]interface{}{"clearPassword": true, "password": "new"})
+	expectStatus(t, rec, http.StatusBadRequest)
+	rec = do(t, mem.sources, alice, http.MethodPatch, "/sources/ssh1", map[string]interface{}{"clearPassword": true})
+	expectStatus(t, rec, http.StatusBadRequest)
+	rec = do(t, mem.sources, alice, http.MethodPatch, "/sources/smb1", map[string]interface{}{"clearPassword": true})
+	expectStatus(t, rec, http.StatusOK)
+	var s types.Source
+	decode(t, rec, &s)
+	if stored, _ := mem.GetSource("smb1"); s.HasPassword || stored.Password != nil {
+		t.Errorf("response = %+v, stored password = %v", s, stored.Password)
+	}
+	if stored, _ := mem.GetSource("ssh1"); stored.Password == nil || *stored.Password != password {
+		t.Errorf("ssh1 password = %v, want it kept", stored.Password)
+	}
+}
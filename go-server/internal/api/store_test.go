@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/db/memstore"
+	"homemusic-server/internal/types"
+)
+
+var (
+	alice = &types.User{ID: "u-alice", Username: "alice", Role: types.RoleUser}
+	bob   = &types.User{ID: "u-bob", Username: "bob", Role: types.RoleUser}
+)
+
+// testStore is an empty in-memory store and the routes that serve it.
+type testStore struct {
+	*memstore.Store
+	library, playlists, sources func(chi.Router)
+	scans                       *fakeScanner
+}
+
+// fakeScanner reports the scans the handlers start instead of running them.
+type fakeScanner struct {
+	started chan string
+}
+
+func (f *fakeScanner) ScanSource(_ context.Context, sourceID string) error {
+	f.started <- sourceID
+	return nil
+}
+
+func (f *fakeScanner) ScanAllSources(context.Context) error {
+	f.started <- "*"
+	return nil
+}
+
+func newTestStore(t *testing.T) *testStore {
+	t.Helper()
+	mem := memstore.New()
+	mem.AddUser(*alice)
+	mem.AddUser(*bob)
+	store := mem.Stores()
+	// Buffered so handlers never wait on tests that ignore their scans
+	scans := &fakeScanner{started: make(chan string, 100)}
+	return &testStore{
+		Store:     mem,
+		library:   RegisterLibraryRoutes(store),
+		playlists: RegisterPlaylistRoutes(store),
+		sources:   RegisterSourceRoutes(store, scans),
+		scans:     scans,
+	}
+}
+
+// addTrack puts a track into mem, creating its album and artist.
+func addTrack(mem *testStore, id, title, albumID, folder string, number int) {
+	artistID := "ar-" + albumID
+	mem.AddArtist(types.Artist{ID: artistID, Name: "Artist " + albumID})
+	mem.AddAlbum(types.Album{ID: albumID, Name: "Album " + albumID, ArtistID: artistID})
+	mem.AddTrack(types.Track{
+		ID:          id,
+		Title:       title,
+		Artist:      "Artist " + albumID,
+		Album:       "Album " + albumID,
+		Duration:    180,
+		TrackNumber: &number,
+		Path:        folder + "/" + id + ".mp3",
+		FolderPath:  &folder,
+		SourceID:    "src",
+		AlbumID:     &albumID,
+		ArtistID:    &artistID,
+		CreatedAt:   time.Now(),
+	})
+}
+
+// do sends a request through the routes as user and returns the response.
+func do(t *testing.T, routes func(chi.Router), user *types.User, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := chi.NewRouter()
+	routes(r)
+	req := httptest.NewRequest(method, path, &buf)
+	if user != nil {
+		req = req.WithContext(auth.WithUser(req.Context(), user))
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, want, rec.Body.String())
+	}
+}
@@ -1,40 +1,173 @@
 package api
 
 import (
+	"context"
+	"crypto/sha1"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"homemusic-server/internal/apierr"
 	"homemusic-server/internal/db"
 	"homemusic-server/internal/sources"
 	"homemusic-server/internal/types"
 )
 
-func RegisterStreamRoutes(r chi.Router) {
-	r.Get("/stream/{trackId}", handleStreamTrack)
+// streamHandlers stream the tracks in store.
+type streamHandlers struct {
+	store *db.Store
 }
 
-func handleStreamTrack(w http.ResponseWriter, r *http.Request) {
-	trackID := chi.URLParam(r, "trackId")
-	track, err := db.GetTrack(trackID)
+// RegisterStreamRoutes serves the files of the tracks in store, as they
+// are or as HLS.
+func RegisterStreamRoutes(store *db.Store) func(chi.Router) {
+	h := &streamHandlers{store: store}
+	return func(r chi.Router) {
+		r.Get("/stream/{trackId}", h.handleStreamTrack)
+		// Renderers check the type and size before playing
+		r.Head("/stream/{trackId}", h.handleStreamTrack)
+		r.Get("/stream/{trackId}/playlist.m3u8", h.handleHLSMaster)
+		r.Get("/stream/{trackId}/{variant}/{file}", h.handleHLSFile)
+	}
+}
+
+func (h *streamHandlers) handleStreamTrack(w http.ResponseWriter, r *http.Request) {
+	serveTrack(w, r, h.store, chi.URLParam(r, "trackId"), writeError)
+}
+
+// serveTrack sends the original file of a track, honouring range and
+// conditional requests. Errors are reported with fail, so each API can use
+// its own error format.
+func serveTrack(w http.ResponseWriter, r *http.Request, store *db.Store, trackID string, fail func(http.ResponseWriter, *http.Request, error)) {
+	track, err := store.Library.GetTrack(trackID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		fail(w, r, err)
 		return
 	}
 	if track == nil {
-		http.Error(w, "Track not found", http.StatusNotFound)
+		fail(w, r, apierr.NotFound("Track not found"))
 		return
 	}
 
-	source, err := db.GetSource(track.SourceID)
+	source, err := store.Sources.GetSource(track.SourceID)
 	if err != nil || source == nil {
-		http.Error(w, "Source not found", http.StatusNotFound)
+		fail(w, r, apierr.NotFound("Source not found"))
+		return
+	}
+
+	reader, closeFunc, err := openTrackReader(r.Context(), source, track.Path)
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+	defer closeFunc()
+
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		fail(w, r, apierr.SourceUnreachable(err))
+		return
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		fail(w, r, apierr.SourceUnreachable(err))
 		return
 	}
 
+	modTime := track.CreatedAt
+	if track.SourceMtime != nil {
+		modTime = *track.SourceMtime
+	}
+
+	contentType := audioContentType(track.Path)
+	if contentType == "application/octet-stream" {
+		contentType = sniffContentType(reader)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", trackETag(track, modTime, size))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	// Use http.ServeContent to handle Range, If-Range and conditional requests
+	http.ServeContent(w, r, path.Base(track.Path), modTime, reader)
+}
+
+// sourceBusyRetrySeconds is the Retry-After hint sent when a source has no
+// free stream slots.
+const sourceBusyRetrySeconds = 5
+
+var audioContentTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg; codecs=opus",
+	".wav":  "audio/wav",
+	".aac":  "audio/aac",
+}
+
+// audioContentType guesses a file's type from its extension, using the
+// system's MIME table for extensions not listed above.
+func audioContentType(filename string) string {
+	ext := strings.ToLower(path.Ext(filename))
+	if ct, ok := audioContentTypes[ext]; ok {
+		return ct
+	}
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// sniffContentType looks at the start of r for files whose extension says
+// nothing, then rewinds it.
+func sniffContentType(r io.ReadSeeker) string {
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(r, buf)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(buf[:n])
+}
+
+// trackETag builds a strong validator from the file's identity, modification
+// time and size, so it changes whenever the underlying bytes may have changed.
+func trackETag(track *types.Track, modTime time.Time, size int64) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00%d", track.SourceID, track.Path, modTime.UnixNano(), size)
+	return fmt.Sprintf("\"%x\"", h.Sum(nil)[:12])
+}
+
+// trackOpener opens a track's file on its source, as openTrackReader does.
+type trackOpener func(ctx context.Context, source *types.Source, filePath string) (io.ReadSeeker, func(), error)
+
+// openTrackReader connects to the track's source and opens the remote file,
+// reserving one of the source's stream slots and applying its bandwidth
+// limit. The returned close function releases the file, the connection and
+// the slot. Failures to reach the source are reported as such, so handlers can
+// tell them apart from server errors.
+func openTrackReader(ctx context.Context, source *types.Source, filePath string) (io.ReadSeeker, func(), error) {
+	release, err := sources.Limits.Acquire(source.ID, source.MaxStreams, source.MaxBandwidth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, closeFunc, err := openRemoteFile(source, filePath)
+	if err != nil {
+		release()
+		return nil, nil, apierr.SourceUnreachable(err)
+	}
+	return sources.Limits.Reader(ctx, source.ID, reader), func() {
+		closeFunc()
+		release()
+	}, nil
+}
+
+func openRemoteFile(source *types.Source, filePath string) (io.ReadSeeker, func(), error) {
 	// Clean path for SMB
-	cleanPath := track.Path
+	cleanPath := filePath
 	if source.Type == types.SourceTypeSMB {
 		cleanPath = strings.TrimPrefix(cleanPath, "/")
 		cleanPath = strings.TrimPrefix(cleanPath, "\\")
@@ -43,9 +176,6 @@ func handleStreamTrack(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var reader io.ReadSeeker
-	var closeFunc func()
-
 	if source.Type == types.SourceTypeSMB {
 		client := sources.NewSMBClient(sources.SMBConfig{
 			Host:     source.Host,
@@ -55,22 +185,19 @@ func handleStreamTrack(w http.ResponseWriter, r *http.Request) {
 			Domain:   getString(source.Domain),
 		})
 		if err := client.Connect(); err != nil {
-			http.Error(w, "Failed to connect to SMB: "+err.Error(), http.StatusInternalServerError)
-			return
+			return nil, nil, fmt.Errorf("failed to connect to SMB: %w", err)
 		}
-		
+
 		f, err := client.Open(cleanPath)
 		if err != nil {
 			client.Close()
-			http.Error(w, "Failed to open remote file: "+err.Error(), http.StatusInternalServerError)
-			return
+			return nil, nil, fmt.Errorf("failed to open remote file: %w", err)
 		}
-		
-		reader = f
-		closeFunc = func() {
+
+		return f, func() {
 			f.Close()
 			client.Close()
-		}
+		}, nil
 	} else if source.Type == types.SourceTypeSSH {
 		client := sources.NewSSHClient(sources.SSHConfig{
 			Host:     source.Host,
@@ -79,31 +206,21 @@ func handleStreamTrack(w http.ResponseWriter, r *http.Request) {
 			Password: getString(source.Password),
 		})
 		if err := client.Connect(); err != nil {
-			http.Error(w, "Failed to connect to SSH: "+err.Error(), http.StatusInternalServerError)
-			return
+			return nil, nil, fmt.Errorf("failed to connect to SSH: %w", err)
 		}
-		
+
 		f, err := client.Open(cleanPath)
 		if err != nil {
 			client.Close()
-			http.Error(w, "Failed to open remote file: "+err.Error(), http.StatusInternalServerError)
-			return
+			return nil, nil, fmt.Errorf("failed to open remote file: %w", err)
 		}
 
 		// SFTP files implement ReadSeeker
-		reader = f
-		closeFunc = func() {
+		return f, func() {
 			f.Close()
 			client.Close()
-		}
-	}
-
-	if reader == nil {
-		http.Error(w, "Unsupported source type", http.StatusInternalServerError)
-		return
+		}, nil
 	}
-	defer closeFunc()
 
-	// Use http.ServeContent to handle Range requests automatically
-	http.ServeContent(w, r, track.Title, track.CreatedAt, reader)
+	return nil, nil, fmt.Errorf("unsupported source type")
 }
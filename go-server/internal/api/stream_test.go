@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"homemusic-server/internal/subsonic"
+)
+
+func TestAudioContentType(t *testing.T) {
+	tests := map[string]string{
+		"Music/a.MP3":  "audio/mpeg",
+		"b.opus":       "audio/ogg; codecs=opus",
+		"c.flac":       "audio/flac",
+		"d.unknownext": "application/octet-stream",
+	}
+	for name, want := range tests {
+		if got := audioContentType(name); got != want {
+			t.Errorf("audioContentType(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSniffContentType(t *testing.T) {
+	wav := append([]byte("RIFF\x24\x00\x00\x00WAVEfmt "), make([]byte, 32)...)
+	r := bytes.NewReader(wav)
+	if got := sniffContentType(r); got != "audio/wave" {
+		t.Errorf("sniffContentType(wav) = %q", got)
+	}
+	if pos, _ := r.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Errorf("reader left at %d, want 0", pos)
+	}
+}
+
+func TestStreamLookups(t *testing.T) {
+	mem := newTestStore(t)
+	addTrack(mem, "t1", "One", "al1", "/music/a", 1)
+	stream := RegisterStreamRoutes(mem.Stores())
+
+	rec := do(t, stream, alice, http.MethodGet, "/stream/missing", nil)
+	expectStatus(t, rec, http.StatusNotFound)
+	if !bytes.Contains(rec.Body.Bytes(), []byte("Track not found")) {
+		t.Errorf("missing track: %s", rec.Body.String())
+	}
+	// t1's source was never added
+	rec = do(t, stream, alice, http.MethodGet, "/stream/t1", nil)
+	expectStatus(t, rec, http.StatusNotFound)
+	if !bytes.Contains(rec.Body.Bytes(), []byte("Source not found")) {
+		t.Errorf("missing source: %s", rec.Body.String())
+	}
+}
+
+func TestSubsonicStreamErrors(t *testing.T) {
+	mem := newTestStore(t)
+	addTrack(mem, "t1", "One", "al1", "/music/a", 1)
+	h := &subsonicHandlers{store: mem.Stores()}
+
+	for _, tt := range []struct {
+		handler http.HandlerFunc
+		query   string
+		message string
+	}{
+		{h.handleSubsonicDownload, "id=missing", "Track not found"},
+		{h.handleSubsonicStream, "id=missing&format=raw", "Track not found"},
+		// t1's source was never added
+		{h.handleSubsonicDownload, "id=t1", "Source not found"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/rest/download?f=json&"+tt.query, nil)
+		req.ParseForm()
+		rec := httptest.NewRecorder()
+		tt.handler(rec, req)
+
+		// Subsonic clients expect an error document with status 200
+		expectStatus(t, rec, http.StatusOK)
+		var body struct {
+			Response subsonic.Response `json:"subsonic-response"`
+		}
+		decode(t, rec, &body)
+		if e := body.Response.Error; e == nil || e.Code != subsonic.ErrNotFound || e.Message != tt.message {
+			t.Errorf("%s: error = %+v, want %q", tt.query, e, tt.message)
+		}
+	}
+}
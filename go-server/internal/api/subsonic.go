@@ -0,0 +1,347 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/sources"
+	"homemusic-server/internal/subsonic"
+	"homemusic-server/internal/types"
+)
+
+// subsonicHandlers serve the Subsonic API from store.
+type subsonicHandlers struct {
+	store *db.Store
+}
+
+// RegisterSubsonicRoutes mounts the Subsonic REST API so existing Subsonic
+// and OpenSubsonic clients can use the library in store. Clients sign in
+// with the account's Subsonic app password or, as an API key, with an API
+// token.
+func RegisterSubsonicRoutes(store *db.Store) func(chi.Router) {
+	h := &subsonicHandlers{store: store}
+	return func(r chi.Router) {
+		// Clients probe for extensions before signing in
+		subsonicHandle(r, "getOpenSubsonicExtensions", h.handleSubsonicExtensions)
+
+		r.Group(func(r chi.Router) {
+			r.Use(subsonicAuth)
+
+			subsonicHandle(r, "ping", h.handleSubsonicPing)
+			subsonicHandle(r, "getLicense", h.handleSubsonicLicense)
+			subsonicHandle(r, "getUser", h.handleSubsonicUser)
+
+			r.Group(func(r chi.Router) {
+				r.Use(requireSubsonicScope(types.ScopeLibrary))
+				subsonicHandle(r, "getMusicFolders", h.handleSubsonicMusicFolders)
+				subsonicHandle(r, "getIndexes", h.handleSubsonicIndexes)
+				subsonicHandle(r, "getMusicDirectory", h.handleSubsonicMusicDirectory)
+				subsonicHandle(r, "getArtists", h.handleSubsonicArtists)
+				subsonicHandle(r, "getArtist", h.handleSubsonicArtist)
+				subsonicHandle(r, "getAlbum", h.handleSubsonicAlbum)
+				subsonicHandle(r, "getSong", h.handleSubsonicSong)
+				subsonicHandle(r, "getAlbumList", h.handleSubsonicAlbumList)
+				subsonicHandle(r, "getAlbumList2", h.handleSubsonicAlbumList2)
+				subsonicHandle(r, "getRandomSongs", h.handleSubsonicRandomSongs)
+				subsonicHandle(r, "search2", h.handleSubsonicSearch2)
+				subsonicHandle(r, "search3", h.handleSubsonicSearch3)
+				subsonicHandle(r, "getCoverArt", h.handleSubsonicCoverArt)
+				subsonicHandle(r, "getStarred", h.handleSubsonicStarred)
+				subsonicHandle(r, "getStarred2", h.handleSubsonicStarred2)
+				subsonicHandle(r, "star", h.handleSubsonicStar)
+				subsonicHandle(r, "unstar", h.handleSubsonicUnstar)
+				subsonicHandle(r, "scrobble", h.handleSubsonicScrobble)
+				subsonicHandle(r, "getPlaylists", h.handleSubsonicPlaylists)
+				subsonicHandle(r, "getPlaylist", h.handleSubsonicPlaylist)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(requireSubsonicScope(types.ScopePlaylists))
+				subsonicHandle(r, "createPlaylist", h.handleSubsonicCreatePlaylist)
+				subsonicHandle(r, "updatePlaylist", h.handleSubsonicUpdatePlaylist)
+				subsonicHandle(r, "deletePlaylist", h.handleSubsonicDeletePlaylist)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(requireSubsonicScope(types.ScopeStream))
+				subsonicHandle(r, "stream", h.handleSubsonicStream)
+				subsonicHandle(r, "download", h.handleSubsonicDownload)
+			})
+		})
+	}
+}
+
+// subsonicHandle registers an endpoint under its plain name and the legacy
+// ".view" suffix, for both GET and form POST requests.
+func subsonicHandle(r chi.Router, name string, h http.HandlerFunc) {
+	r.HandleFunc("/"+name, h)
+	r.HandleFunc("/"+name+".view", h)
+}
+
+// subsonicAuth accepts an API token as apiKey, or the username with the
+// Subsonic app password sent either as a salted token or in plain text.
+func subsonicAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeSubsonicFailure(w, r, apierr.Invalid("Invalid request parameters: "+err.Error()))
+			return
+		}
+		username := r.Form.Get("u")
+		password := r.Form.Get("p")
+		token, salt := r.Form.Get("t"), r.Form.Get("s")
+
+		if apiKey := r.Form.Get("apiKey"); apiKey != "" {
+			if username != "" || password != "" || token != "" {
+				writeSubsonicError(w, r, subsonic.ErrConflictingAuth, "Use either apiKey or username credentials, not both")
+				return
+			}
+			user, t, err := auth.AuthenticateAPIToken(apiKey)
+			if err != nil {
+				log.Printf("[Subsonic] Failed to look up API key: %v", err)
+				writeSubsonicError(w, r, subsonic.ErrGeneric, "Authentication failed")
+				return
+			}
+			if user == nil {
+				writeSubsonicError(w, r, subsonic.ErrInvalidAPIKey, "Invalid API key")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), user, t)))
+			return
+		}
+
+		if username == "" {
+			writeSubsonicError(w, r, subsonic.ErrMissingParameter, "Required parameter is missing: u")
+			return
+		}
+		if password == "" && (token == "" || salt == "") {
+			writeSubsonicError(w, r, subsonic.ErrMissingParameter, "Required parameter is missing: p, or t and s")
+			return
+		}
+
+		user, appPassword, err := db.GetSubsonicCredentials(username)
+		if err != nil {
+			log.Printf("[Subsonic] Failed to look up user %q: %v", username, err)
+			writeSubsonicError(w, r, subsonic.ErrGeneric, "Authentication failed")
+			return
+		}
+		valid := user != nil && appPassword != ""
+		if valid && password != "" {
+			valid = subsonic.CheckPassword(appPassword, subsonic.DecodePassword(password))
+		} else if valid {
+			valid = subsonic.CheckToken(appPassword, salt, token)
+		}
+		if !valid {
+			log.Printf("[Subsonic] Failed login for %q from %s", username, r.RemoteAddr)
+			writeSubsonicError(w, r, subsonic.ErrWrongCredentials, "Wrong username or password. Set a Subsonic password in your account settings first.")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), user, nil)))
+	})
+}
+
+func requireSubsonicScope(scope types.TokenScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !auth.HasScope(r.Context(), scope) {
+				writeSubsonicError(w, r, subsonic.ErrNotAuthorized, "API key is missing the "+string(scope)+" scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeSubsonic encodes the response in the format the client asked for
+// with f: xml (the default), json or jsonp.
+func writeSubsonic(w http.ResponseWriter, r *http.Request, resp *subsonic.Response) {
+	switch r.Form.Get("f") {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"subsonic-response": resp})
+	case "jsonp":
+		w.Header().Set("Content-Type", "application/javascript")
+		body, _ := json.Marshal(map[string]interface{}{"subsonic-response": resp})
+		fmt.Fprintf(w, "%s(%s);", jsonpCallback(r.Form.Get("callback")), body)
+	default:
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(resp)
+	}
+}
+
+// jsonpCallback keeps only identifier characters so the callback name
+// cannot inject script.
+func jsonpCallback(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '_' || r == '.' || r == '$' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, name)
+	if name == "" {
+		return "callback"
+	}
+	return name
+}
+
+// writeSubsonicError reports an error the Subsonic way: HTTP 200 with a
+// failed status and an error code in the body.
+func writeSubsonicError(w http.ResponseWriter, r *http.Request, code int, message string) {
+	writeSubsonic(w, r, subsonic.NewError(code, message))
+}
+
+// writeSubsonicFailure is writeError for the Subsonic API. Server errors
+// are logged with the request ID and reported with a generic message.
+func writeSubsonicFailure(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, sources.ErrSourceSaturated) {
+		w.Header().Set("Retry-After", strconv.Itoa(sourceBusyRetrySeconds))
+	}
+	var e *apierr.Error
+	if !errors.As(apiError(err), &e) {
+		e = apierr.Internal(err)
+	}
+	if e.Status >= http.StatusInternalServerError {
+		id := middleware.GetReqID(r.Context())
+		log.Printf("[Subsonic] %s %s failed (request %s): %v", r.Method, r.URL.Path, id, err)
+		if id != "" {
+			w.Header().Set("X-Request-Id", id)
+		}
+	}
+	code := subsonic.ErrGeneric
+	switch e.Code {
+	case apierr.CodeNotFound:
+		code = subsonic.ErrNotFound
+	case apierr.CodeForbidden:
+		code = subsonic.ErrNotAuthorized
+	}
+	writeSubsonicError(w, r, code, e.Message)
+}
+
+func (h *subsonicHandlers) handleSubsonicPing(w http.ResponseWriter, r *http.Request) {
+	writeSubsonic(w, r, subsonic.NewResponse())
+}
+
+func (h *subsonicHandlers) handleSubsonicLicense(w http.ResponseWriter, r *http.Request) {
+	resp := subsonic.NewResponse()
+	resp.License = &subsonic.License{Valid: true}
+	writeSubsonic(w, r, resp)
+}
+
+func (h *subsonicHandlers) handleSubsonicExtensions(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	resp := subsonic.NewResponse()
+	resp.OpenSubsonicExtensions = []subsonic.Extension{
+		{Name: "apiKeyAuthentication", Versions: []int{1}},
+	}
+	writeSubsonic(w, r, resp)
+}
+
+func (h *subsonicHandlers) handleSubsonicUser(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if name := r.Form.Get("username"); name != "" && !strings.EqualFold(name, user.Username) {
+		if user.Role != types.RoleAdmin {
+			writeSubsonicError(w, r, subsonic.ErrNotAuthorized, "You can only look up your own account")
+			return
+		}
+		other, err := h.store.Users.GetUserByUsername(name)
+		if err != nil {
+			writeSubsonicFailure(w, r, err)
+			return
+		}
+		if other == nil {
+			writeSubsonicError(w, r, subsonic.ErrNotFound, "User not found")
+			return
+		}
+		user = other
+	}
+
+	folders, err := h.subsonicFolders()
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	ids := make([]int, len(folders))
+	for i := range folders {
+		ids[i] = i + 1
+	}
+
+	resp := subsonic.NewResponse()
+	resp.User = &subsonic.User{
+		Username:          user.Username,
+		ScrobblingEnabled: true,
+		AdminRole:         user.Role == types.RoleAdmin,
+		DownloadRole:      true,
+		PlaylistRole:      true,
+		CoverArtRole:      true,
+		StreamRole:        true,
+		Folders:           ids,
+	}
+	writeSubsonic(w, r, resp)
+}
+
+// subsonicFolders returns the sources exposed as music folders. Folder IDs
+// are positions in creation order, so they stay put as sources are renamed.
+func (h *subsonicHandlers) subsonicFolders() ([]types.Source, error) {
+	return h.store.Sources.GetSourceList()
+}
+
+// subsonicFolderSource maps the optional musicFolderId parameter to a
+// source ID, returning "" for all folders and false for unknown ones.
+func (h *subsonicHandlers) subsonicFolderSource(r *http.Request) (string, bool) {
+	v := r.Form.Get("musicFolderId")
+	if v == "" {
+		return "", true
+	}
+	id, err := strconv.Atoi(v)
+	if err != nil {
+		return "", false
+	}
+	folders, err := h.subsonicFolders()
+	if err != nil || id < 1 || id > len(folders) {
+		return "", false
+	}
+	return folders[id-1].ID, true
+}
+
+func (h *subsonicHandlers) handleSubsonicMusicFolders(w http.ResponseWriter, r *http.Request) {
+	folders, err := h.subsonicFolders()
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	resp := subsonic.NewResponse()
+	resp.MusicFolders = &subsonic.MusicFolders{Folders: []subsonic.MusicFolder{}}
+	for i, s := range folders {
+		resp.MusicFolders.Folders = append(resp.MusicFolders.Folders, subsonic.MusicFolder{ID: i + 1, Name: s.Name})
+	}
+	writeSubsonic(w, r, resp)
+}
+
+// subsonicInt reads an integer parameter, falling back to def when it is
+// absent or malformed.
+func subsonicInt(r *http.Request, name string, def int) int {
+	if v, err := strconv.Atoi(r.Form.Get(name)); err == nil {
+		return v
+	}
+	return def
+}
+
+// subsonicRequired reads a mandatory parameter, writing the error itself
+// when it is missing.
+func subsonicRequired(w http.ResponseWriter, r *http.Request, name string) (string, bool) {
+	v := r.Form.Get(name)
+	if v == "" {
+		writeSubsonicError(w, r, subsonic.ErrMissingParameter, "Required parameter is missing: "+name)
+		return "", false
+	}
+	return v, true
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/subsonic"
+	"homemusic-server/internal/types"
+)
+
+// subsonicStarTargets collects the items named by id, albumId and artistId.
+// Plain ids may refer to a song, an album directory or an artist directory.
+func (h *subsonicHandlers) subsonicStarTargets(r *http.Request) (map[string]string, error) {
+	targets := map[string]string{}
+	for _, id := range r.Form["id"] {
+		kind, err := h.subsonicItemKind(id)
+		if err != nil {
+			return nil, err
+		}
+		if kind == "" {
+			return nil, nil
+		}
+		targets[id] = kind
+	}
+	for _, id := range r.Form["albumId"] {
+		targets[id] = db.StarAlbum
+	}
+	for _, id := range r.Form["artistId"] {
+		targets[id] = db.StarArtist
+	}
+	return targets, nil
+}
+
+func (h *subsonicHandlers) subsonicItemKind(id string) (string, error) {
+	track, err := h.store.Library.GetTrack(id)
+	if err != nil || track != nil {
+		return db.StarTrack, err
+	}
+	album, err := db.GetAlbumSummary(id)
+	if err != nil || album != nil {
+		return db.StarAlbum, err
+	}
+	artist, err := db.GetArtistSummary(id)
+	if err != nil || artist != nil {
+		return db.StarArtist, err
+	}
+	return "", nil
+}
+
+func (h *subsonicHandlers) handleSubsonicStar(w http.ResponseWriter, r *http.Request) {
+	targets, err := h.subsonicStarTargets(r)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	if targets == nil {
+		writeSubsonicError(w, r, subsonic.ErrNotFound, "Item not found")
+		return
+	}
+	user := auth.UserFromContext(r.Context())
+	for id, kind := range targets {
+		if err := db.StarItem(user.ID, id, kind); err != nil {
+			writeSubsonicFailure(w, r, err)
+			return
+		}
+	}
+	writeSubsonic(w, r, subsonic.NewResponse())
+}
+
+func (h *subsonicHandlers) handleSubsonicUnstar(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	ids := append(append(r.Form["id"], r.Form["albumId"]...), r.Form["artistId"]...)
+	for _, id := range ids {
+		if err := db.UnstarItem(user.ID, id); err != nil {
+			writeSubsonicFailure(w, r, err)
+			return
+		}
+	}
+	writeSubsonic(w, r, subsonic.NewResponse())
+}
+
+func loadSubsonicStarred(w http.ResponseWriter, r *http.Request) ([]types.ArtistSummary, []types.AlbumSummary, []types.Track, *subsonicAnnotations, bool) {
+	user := auth.UserFromContext(r.Context())
+	artists, err := db.GetStarredArtists(user.ID)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return nil, nil, nil, nil, false
+	}
+	albums, err := db.GetAlbumSummaries(db.AlbumQuery{Sort: db.AlbumsStarred, UserID: user.ID})
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return nil, nil, nil, nil, false
+	}
+	tracks, err := db.GetStarredTracks(user.ID)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return nil, nil, nil, nil, false
+	}
+	ann, err := loadSubsonicAnnotations(r)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return nil, nil, nil, nil, false
+	}
+	return artists, albums, tracks, ann, true
+}
+
+func (h *subsonicHandlers) handleSubsonicStarred(w http.ResponseWriter, r *http.Request) {
+	artists, albums, tracks, ann, ok := loadSubsonicStarred(w, r)
+	if !ok {
+		return
+	}
+	resp := subsonic.NewResponse()
+	resp.Starred = &subsonic.Starred{
+		Artists: subsonicIndexArtists(artists, ann),
+		Albums:  subsonicAlbumDirs(albums, ann),
+		Songs:   subsonicSongs(tracks, ann),
+	}
+	writeSubsonic(w, r, resp)
+}
+
+func (h *subsonicHandlers) handleSubsonicStarred2(w http.ResponseWriter, r *http.Request) {
+	artists, albums, tracks, ann, ok := loadSubsonicStarred(w, r)
+	if !ok {
+		return
+	}
+	resp := subsonic.NewResponse()
+	resp.Starred2 = &subsonic.Starred2{
+		Artists: subsonicArtists(artists, ann),
+		Albums:  subsonicAlbums(albums, ann),
+		Songs:   subsonicSongs(tracks, ann),
+	}
+	writeSubsonic(w, r, resp)
+}
+
+// handleSubsonicScrobble records finished plays. "Now playing" notifications
+// (submission=false) are accepted but not stored.
+func (h *subsonicHandlers) handleSubsonicScrobble(w http.ResponseWriter, r *http.Request) {
+	ids := r.Form["id"]
+	if len(ids) == 0 {
+		writeSubsonicError(w, r, subsonic.ErrMissingParameter, "Required parameter is missing: id")
+		return
+	}
+	if r.Form.Get("submission") == "false" {
+		writeSubsonic(w, r, subsonic.NewResponse())
+		return
+	}
+
+	user := auth.UserFromContext(r.Context())
+	times := r.Form["time"]
+	for i, id := range ids {
+		at := time.Now()
+		if i < len(times) {
+			if ms, err := strconv.ParseInt(times[i], 10, 64); err == nil {
+				at = time.UnixMilli(ms)
+			}
+		}
+		track, err := h.store.Library.GetTrack(id)
+		if err != nil {
+			writeSubsonicFailure(w, r, err)
+			return
+		}
+		if track == nil {
+			writeSubsonicError(w, r, subsonic.ErrNotFound, "Song not found")
+			return
+		}
+		if err := db.RecordPlay(user.ID, id, at); err != nil {
+			writeSubsonicFailure(w, r, err)
+			return
+		}
+	}
+	writeSubsonic(w, r, subsonic.NewResponse())
+}
@@ -0,0 +1,587 @@
+package api
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/subsonic"
+	"homemusic-server/internal/types"
+)
+
+const (
+	subsonicIgnoredArticles = "The"
+	subsonicMaxListSize     = 500
+)
+
+// subsonicAnnotations carries the signed-in user's stars and play counts
+// for decorating the entities in a response.
+type subsonicAnnotations struct {
+	starred map[string]time.Time
+	plays   map[string]types.PlayStat
+}
+
+func loadSubsonicAnnotations(r *http.Request) (*subsonicAnnotations, error) {
+	user := auth.UserFromContext(r.Context())
+	starred, err := db.GetStarredItems(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	plays, err := db.GetPlayStats(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &subsonicAnnotations{starred: starred, plays: plays}, nil
+}
+
+func (a *subsonicAnnotations) starredAt(id string) *time.Time {
+	if t, ok := a.starred[id]; ok {
+		return &t
+	}
+	return nil
+}
+
+func subsonicSong(t types.Track, ann *subsonicAnnotations) subsonic.Child {
+	c := subsonic.Child{
+		ID:          t.ID,
+		Title:       t.Title,
+		Album:       t.Album,
+		Artist:      t.Artist,
+		Duration:    int(t.Duration),
+		ContentType: audioContentType(t.Path),
+		Suffix:      strings.ToLower(strings.TrimPrefix(path.Ext(t.Path), ".")),
+		Path:        strings.TrimPrefix(strings.ReplaceAll(t.Path, "\\", "/"), "/"),
+		Type:        "music",
+		Starred:     ann.starredAt(t.ID),
+	}
+	if !t.CreatedAt.IsZero() {
+		created := t.CreatedAt
+		c.Created = &created
+	}
+	if t.ArtistsDisplay != nil && *t.ArtistsDisplay != "" {
+		c.Artist = *t.ArtistsDisplay
+	}
+	if t.TrackNumber != nil {
+		c.Track = *t.TrackNumber
+	}
+	if t.Year != nil {
+		c.Year = *t.Year
+	}
+	if t.ArtistID != nil {
+		c.ArtistID = *t.ArtistID
+	}
+	if t.AlbumID != nil {
+		c.AlbumID = *t.AlbumID
+		c.Parent = *t.AlbumID
+		c.CoverArt = *t.AlbumID
+	} else if t.ImageUrl != nil && *t.ImageUrl != "" {
+		c.CoverArt = t.ID
+	}
+	if stat, ok := ann.plays[t.ID]; ok {
+		c.PlayCount = stat.Count
+		played := stat.LastPlayed
+		c.Played = &played
+	}
+	return c
+}
+
+func subsonicSongs(tracks []types.Track, ann *subsonicAnnotations) []subsonic.Child {
+	songs := make([]subsonic.Child, 0, len(tracks))
+	for _, t := range tracks {
+		songs = append(songs, subsonicSong(t, ann))
+	}
+	return songs
+}
+
+func subsonicAlbum(a types.AlbumSummary, ann *subsonicAnnotations) subsonic.Album {
+	album := subsonic.Album{
+		ID:        a.ID,
+		Name:      a.Name,
+		Artist:    a.Artist,
+		ArtistID:  a.ArtistID,
+		SongCount: a.TrackCount,
+		Duration:  int(a.Duration),
+		Created:   a.CreatedAt,
+		Starred:   ann.starredAt(a.ID),
+	}
+	if a.ImageUrl != nil && *a.ImageUrl != "" {
+		album.CoverArt = a.ID
+	}
+	if a.Year != nil {
+		album.Year = *a.Year
+	}
+	return album
+}
+
+func subsonicAlbums(albums []types.AlbumSummary, ann *subsonicAnnotations) []subsonic.Album {
+	result := make([]subsonic.Album, 0, len(albums))
+	for _, a := range albums {
+		result = append(result, subsonicAlbum(a, ann))
+	}
+	return result
+}
+
+// subsonicAlbumDirs presents albums as directories for the folder-based
+// endpoints.
+func subsonicAlbumDirs(albums []types.AlbumSummary, ann *subsonicAnnotations) []subsonic.Child {
+	dirs := make([]subsonic.Child, 0, len(albums))
+	for _, a := range albums {
+		album := subsonicAlbum(a, ann)
+		created := a.CreatedAt
+		dirs = append(dirs, subsonic.Child{
+			ID:       a.ID,
+			Parent:   a.ArtistID,
+			IsDir:    true,
+			Title:    a.Name,
+			Album:    a.Name,
+			Artist:   a.Artist,
+			Year:     album.Year,
+			CoverArt: album.CoverArt,
+			Created:  &created,
+			Starred:  album.Starred,
+			AlbumID:  a.ID,
+			ArtistID: a.ArtistID,
+		})
+	}
+	return dirs
+}
+
+func subsonicArtist(a types.ArtistSummary, ann *subsonicAnnotations) subsonic.Artist {
+	artist := subsonic.Artist{
+		ID:         a.ID,
+		Name:       a.Name,
+		AlbumCount: a.AlbumCount,
+		Starred:    ann.starredAt(a.ID),
+	}
+	if a.ImageUrl != nil && *a.ImageUrl != "" {
+		artist.CoverArt = a.ID
+	}
+	return artist
+}
+
+func subsonicArtists(artists []types.ArtistSummary, ann *subsonicAnnotations) []subsonic.Artist {
+	result := make([]subsonic.Artist, 0, len(artists))
+	for _, a := range artists {
+		result = append(result, subsonicArtist(a, ann))
+	}
+	return result
+}
+
+func subsonicIndexArtists(artists []types.ArtistSummary, ann *subsonicAnnotations) []subsonic.IndexArtist {
+	result := make([]subsonic.IndexArtist, 0, len(artists))
+	for _, a := range artists {
+		result = append(result, subsonic.IndexArtist{ID: a.ID, Name: a.Name, Starred: ann.starredAt(a.ID)})
+	}
+	return result
+}
+
+// subsonicIndexName is the index letter an artist is filed under, skipping
+// a leading "The" and grouping everything non-alphabetic under "#".
+func subsonicIndexName(name string) string {
+	name = strings.TrimSpace(name)
+	for _, article := range strings.Fields(subsonicIgnoredArticles) {
+		if len(name) > len(article)+1 && strings.EqualFold(name[:len(article)+1], article+" ") {
+			name = strings.TrimSpace(name[len(article)+1:])
+			break
+		}
+	}
+	for _, r := range name {
+		if unicode.IsLetter(r) {
+			return strings.ToUpper(string(r))
+		}
+		break
+	}
+	return "#"
+}
+
+func (h *subsonicHandlers) loadSubsonicArtistIndex(w http.ResponseWriter, r *http.Request) ([]types.ArtistSummary, *subsonicAnnotations, bool) {
+	sourceID, ok := h.subsonicFolderSource(r)
+	if !ok {
+		writeSubsonicError(w, r, subsonic.ErrNotFound, "Music folder not found")
+		return nil, nil, false
+	}
+	artists, err := db.GetArtistSummaries(sourceID)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return nil, nil, false
+	}
+	ann, err := loadSubsonicAnnotations(r)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return nil, nil, false
+	}
+	return artists, ann, true
+}
+
+func (h *subsonicHandlers) handleSubsonicIndexes(w http.ResponseWriter, r *http.Request) {
+	artists, ann, ok := h.loadSubsonicArtistIndex(w, r)
+	if !ok {
+		return
+	}
+	indexes := &subsonic.Indexes{
+		LastModified:    time.Now().UnixMilli(),
+		IgnoredArticles: subsonicIgnoredArticles,
+		Index:           []subsonic.Index{},
+	}
+	for _, a := range artists {
+		name := subsonicIndexName(a.Name)
+		if n := len(indexes.Index); n == 0 || indexes.Index[n-1].Name != name {
+			indexes.Index = append(indexes.Index, subsonic.Index{Name: name})
+		}
+		last := &indexes.Index[len(indexes.Index)-1]
+		last.Artists = append(last.Artists, subsonicIndexArtists([]types.ArtistSummary{a}, ann)...)
+	}
+
+	resp := subsonic.NewResponse()
+	resp.Indexes = indexes
+	writeSubsonic(w, r, resp)
+}
+
+func (h *subsonicHandlers) handleSubsonicArtists(w http.ResponseWriter, r *http.Request) {
+	artists, ann, ok := h.loadSubsonicArtistIndex(w, r)
+	if !ok {
+		return
+	}
+	index := &subsonic.Artists{IgnoredArticles: subsonicIgnoredArticles, Index: []subsonic.ArtistsIdx{}}
+	for _, a := range artists {
+		name := subsonicIndexName(a.Name)
+		if n := len(index.Index); n == 0 || index.Index[n-1].Name != name {
+			index.Index = append(index.Index, subsonic.ArtistsIdx{Name: name})
+		}
+		last := &index.Index[len(index.Index)-1]
+		last.Artists = append(last.Artists, subsonicArtist(a, ann))
+	}
+
+	resp := subsonic.NewResponse()
+	resp.Artists = index
+	writeSubsonic(w, r, resp)
+}
+
+// handleSubsonicMusicDirectory serves the folder-based hierarchy, in which
+// artists contain albums and albums contain songs.
+func (h *subsonicHandlers) handleSubsonicMusicDirectory(w http.ResponseWriter, r *http.Request) {
+	id, ok := subsonicRequired(w, r, "id")
+	if !ok {
+		return
+	}
+	ann, err := loadSubsonicAnnotations(r)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+
+	artist, err := db.GetArtistSummary(id)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	if artist != nil {
+		albums, err := db.GetAlbumSummaries(db.AlbumQuery{Sort: db.AlbumsByArtist, ArtistID: id})
+		if err != nil {
+			writeSubsonicFailure(w, r, err)
+			return
+		}
+		resp := subsonic.NewResponse()
+		resp.Directory = &subsonic.Directory{
+			ID:       artist.ID,
+			Name:     artist.Name,
+			Starred:  ann.starredAt(artist.ID),
+			Children: subsonicAlbumDirs(albums, ann),
+		}
+		writeSubsonic(w, r, resp)
+		return
+	}
+
+	album, err := db.GetAlbumSummary(id)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	if album == nil {
+		writeSubsonicError(w, r, subsonic.ErrNotFound, "Directory not found")
+		return
+	}
+	tracks, err := h.store.Library.GetTracksByAlbum(id)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	resp := subsonic.NewResponse()
+	resp.Directory = &subsonic.Directory{
+		ID:       album.ID,
+		Parent:   album.ArtistID,
+		Name:     album.Name,
+		Starred:  ann.starredAt(album.ID),
+		Children: subsonicSongs(tracks, ann),
+	}
+	writeSubsonic(w, r, resp)
+}
+
+func (h *subsonicHandlers) handleSubsonicArtist(w http.ResponseWriter, r *http.Request) {
+	id, ok := subsonicRequired(w, r, "id")
+	if !ok {
+		return
+	}
+	artist, err := db.GetArtistSummary(id)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	if artist == nil {
+		writeSubsonicError(w, r, subsonic.ErrNotFound, "Artist not found")
+		return
+	}
+	albums, err := db.GetAlbumSummaries(db.AlbumQuery{Sort: db.AlbumsByArtist, ArtistID: id})
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	ann, err := loadSubsonicAnnotations(r)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+
+	resp := subsonic.NewResponse()
+	resp.Artist = &subsonic.ArtistWithAlbums{
+		Artist: subsonicArtist(*artist, ann),
+		Albums: subsonicAlbums(albums, ann),
+	}
+	writeSubsonic(w, r, resp)
+}
+
+func (h *subsonicHandlers) handleSubsonicAlbum(w http.ResponseWriter, r *http.Request) {
+	id, ok := subsonicRequired(w, r, "id")
+	if !ok {
+		return
+	}
+	album, err := db.GetAlbumSummary(id)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	if album == nil {
+		writeSubsonicError(w, r, subsonic.ErrNotFound, "Album not found")
+		return
+	}
+	tracks, err := h.store.Library.GetTracksByAlbum(id)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	ann, err := loadSubsonicAnnotations(r)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+
+	resp := subsonic.NewResponse()
+	resp.Album = &subsonic.AlbumWithSongs{
+		Album: subsonicAlbum(*album, ann),
+		Songs: subsonicSongs(tracks, ann),
+	}
+	writeSubsonic(w, r, resp)
+}
+
+func (h *subsonicHandlers) handleSubsonicSong(w http.ResponseWriter, r *http.Request) {
+	id, ok := subsonicRequired(w, r, "id")
+	if !ok {
+		return
+	}
+	track, err := h.store.Library.GetTrack(id)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	if track == nil {
+		writeSubsonicError(w, r, subsonic.ErrNotFound, "Song not found")
+		return
+	}
+	ann, err := loadSubsonicAnnotations(r)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+
+	resp := subsonic.NewResponse()
+	song := subsonicSong(*track, ann)
+	resp.Song = &song
+	writeSubsonic(w, r, resp)
+}
+
+var subsonicAlbumListTypes = map[string]db.AlbumSort{
+	"random":               db.AlbumsRandom,
+	"newest":               db.AlbumsNewest,
+	"frequent":             db.AlbumsMostPlayed,
+	"recent":               db.AlbumsRecentlyPlayed,
+	"starred":              db.AlbumsStarred,
+	"alphabeticalByName":   db.AlbumsByName,
+	"alphabeticalByArtist": db.AlbumsByArtist,
+	"byYear":               db.AlbumsByYear,
+}
+
+// querySubsonicAlbumList runs getAlbumList(2). List types we have no data
+// for, such as byGenre and highest, return an empty list.
+func (h *subsonicHandlers) querySubsonicAlbumList(w http.ResponseWriter, r *http.Request) ([]types.AlbumSummary, *subsonicAnnotations, bool) {
+	listType, ok := subsonicRequired(w, r, "type")
+	if !ok {
+		return nil, nil, false
+	}
+	sourceID, ok := h.subsonicFolderSource(r)
+	if !ok {
+		writeSubsonicError(w, r, subsonic.ErrNotFound, "Music folder not found")
+		return nil, nil, false
+	}
+	ann, err := loadSubsonicAnnotations(r)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return nil, nil, false
+	}
+
+	sort, known := subsonicAlbumListTypes[listType]
+	if !known {
+		if listType == "byGenre" || listType == "highest" {
+			return []types.AlbumSummary{}, ann, true
+		}
+		writeSubsonicError(w, r, subsonic.ErrGeneric, "Unknown album list type: "+listType)
+		return nil, nil, false
+	}
+	if sort == db.AlbumsByYear && (r.Form.Get("fromYear") == "" || r.Form.Get("toYear") == "") {
+		writeSubsonicError(w, r, subsonic.ErrMissingParameter, "Required parameter is missing: fromYear and toYear")
+		return nil, nil, false
+	}
+
+	albums, err := db.GetAlbumSummaries(db.AlbumQuery{
+		Sort:     sort,
+		SourceID: sourceID,
+		FromYear: subsonicInt(r, "fromYear", 0),
+		ToYear:   subsonicInt(r, "toYear", 0),
+		UserID:   auth.UserFromContext(r.Context()).ID,
+		Limit:    min(max(subsonicInt(r, "size", 10), 1), subsonicMaxListSize),
+		Offset:   max(subsonicInt(r, "offset", 0), 0),
+	})
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return nil, nil, false
+	}
+	return albums, ann, true
+}
+
+func (h *subsonicHandlers) handleSubsonicAlbumList(w http.ResponseWriter, r *http.Request) {
+	albums, ann, ok := h.querySubsonicAlbumList(w, r)
+	if !ok {
+		return
+	}
+	resp := subsonic.NewResponse()
+	resp.AlbumList = &subsonic.AlbumList{Albums: subsonicAlbumDirs(albums, ann)}
+	writeSubsonic(w, r, resp)
+}
+
+func (h *subsonicHandlers) handleSubsonicAlbumList2(w http.ResponseWriter, r *http.Request) {
+	albums, ann, ok := h.querySubsonicAlbumList(w, r)
+	if !ok {
+		return
+	}
+	resp := subsonic.NewResponse()
+	resp.AlbumList2 = &subsonic.AlbumList2{Albums: subsonicAlbums(albums, ann)}
+	writeSubsonic(w, r, resp)
+}
+
+func (h *subsonicHandlers) handleSubsonicRandomSongs(w http.ResponseWriter, r *http.Request) {
+	sourceID, ok := h.subsonicFolderSource(r)
+	if !ok {
+		writeSubsonicError(w, r, subsonic.ErrNotFound, "Music folder not found")
+		return
+	}
+	size := min(max(subsonicInt(r, "size", 10), 1), subsonicMaxListSize)
+	tracks, err := db.GetRandomTracks(size, sourceID, subsonicInt(r, "fromYear", 0), subsonicInt(r, "toYear", 0))
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	ann, err := loadSubsonicAnnotations(r)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+
+	resp := subsonic.NewResponse()
+	resp.RandomSongs = &subsonic.Songs{Songs: subsonicSongs(tracks, ann)}
+	writeSubsonic(w, r, resp)
+}
+
+type subsonicSearchResults struct {
+	artists []types.ArtistSummary
+	albums  []types.AlbumSummary
+	tracks  []types.Track
+	ann     *subsonicAnnotations
+}
+
+// runSubsonicSearch serves search2 and search3. An empty query matches
+// everything, which clients use to sync the whole library page by page.
+func (h *subsonicHandlers) runSubsonicSearch(w http.ResponseWriter, r *http.Request) (*subsonicSearchResults, bool) {
+	query := strings.Trim(strings.TrimSpace(r.Form.Get("query")), `"`)
+	sourceID, ok := h.subsonicFolderSource(r)
+	if !ok {
+		writeSubsonicError(w, r, subsonic.ErrNotFound, "Music folder not found")
+		return nil, false
+	}
+
+	res := &subsonicSearchResults{}
+	var err error
+	if n := min(subsonicInt(r, "artistCount", 20), subsonicMaxListSize); n > 0 {
+		if res.artists, err = db.SearchArtists(query, sourceID, n, max(subsonicInt(r, "artistOffset", 0), 0)); err != nil {
+			writeSubsonicFailure(w, r, err)
+			return nil, false
+		}
+	}
+	if n := min(subsonicInt(r, "albumCount", 20), subsonicMaxListSize); n > 0 {
+		if res.albums, err = db.SearchAlbums(query, sourceID, n, max(subsonicInt(r, "albumOffset", 0), 0)); err != nil {
+			writeSubsonicFailure(w, r, err)
+			return nil, false
+		}
+	}
+	if n := min(subsonicInt(r, "songCount", 20), subsonicMaxListSize); n > 0 {
+		if res.tracks, err = db.SearchTracks(query, sourceID, n, max(subsonicInt(r, "songOffset", 0), 0)); err != nil {
+			writeSubsonicFailure(w, r, err)
+			return nil, false
+		}
+	}
+	if res.ann, err = loadSubsonicAnnotations(r); err != nil {
+		writeSubsonicFailure(w, r, err)
+		return nil, false
+	}
+	return res, true
+}
+
+func (h *subsonicHandlers) handleSubsonicSearch2(w http.ResponseWriter, r *http.Request) {
+	res, ok := h.runSubsonicSearch(w, r)
+	if !ok {
+		return
+	}
+	resp := subsonic.NewResponse()
+	resp.SearchResult2 = &subsonic.SearchResult2{
+		Artists: subsonicIndexArtists(res.artists, res.ann),
+		Albums:  subsonicAlbumDirs(res.albums, res.ann),
+		Songs:   subsonicSongs(res.tracks, res.ann),
+	}
+	writeSubsonic(w, r, resp)
+}
+
+func (h *subsonicHandlers) handleSubsonicSearch3(w http.ResponseWriter, r *http.Request) {
+	res, ok := h.runSubsonicSearch(w, r)
+	if !ok {
+		return
+	}
+	resp := subsonic.NewResponse()
+	resp.SearchResult3 = &subsonic.SearchResult3{
+		Artists: subsonicArtists(res.artists, res.ann),
+		Albums:  subsonicAlbums(res.albums, res.ann),
+		Songs:   subsonicSongs(res.tracks, res.ann),
+	}
+	writeSubsonic(w, r, resp)
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/scanner"
+	"homemusic-server/internal/subsonic"
+	"homemusic-server/internal/transcode"
+)
+
+var subsonicFormatExts = map[string]string{
+	"mp3":  ".mp3",
+	"aac":  ".aac",
+	"opus": ".opus",
+}
+
+// subsonicProfile picks the transcoding profile for a stream request. The
+// original file is sent for format=raw, when neither a format nor a bit
+// rate limit is given, or when ffmpeg is missing.
+func subsonicProfile(format string, maxBitRate int) (transcode.Profile, bool) {
+	if format == "raw" || (format == "" && maxBitRate <= 0) || !transcode.Available() {
+		return transcode.Profile{}, false
+	}
+	if format == "" {
+		format = "mp3"
+	}
+	ext, ok := subsonicFormatExts[format]
+	if !ok {
+		return transcode.Profile{}, false
+	}
+
+	// Highest bit rate within the limit, else the lowest available
+	var best, lowest *transcode.Profile
+	for i := range transcode.Profiles {
+		p := &transcode.Profiles[i]
+		if p.Ext != ext {
+			continue
+		}
+		if lowest == nil || p.Bitrate < lowest.Bitrate {
+			lowest = p
+		}
+		if (maxBitRate <= 0 || p.Bitrate <= maxBitRate*1000) && (best == nil || p.Bitrate > best.Bitrate) {
+			best = p
+		}
+	}
+	if best == nil {
+		best = lowest
+	}
+	if best == nil {
+		return transcode.Profile{}, false
+	}
+	return *best, true
+}
+
+func (h *subsonicHandlers) handleSubsonicStream(w http.ResponseWriter, r *http.Request) {
+	id, ok := subsonicRequired(w, r, "id")
+	if !ok {
+		return
+	}
+	profile, ok := subsonicProfile(r.Form.Get("format"), subsonicInt(r, "maxBitRate", 0))
+	if !ok {
+		serveTrack(w, r, h.store, id, writeSubsonicFailure)
+		return
+	}
+
+	track, err := h.store.Library.GetTrack(id)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	if track == nil {
+		writeSubsonicFailure(w, r, apierr.NotFound("Track not found"))
+		return
+	}
+	source, err := h.store.Sources.GetSource(track.SourceID)
+	if err != nil || source == nil {
+		writeSubsonicFailure(w, r, apierr.NotFound("Source not found"))
+		return
+	}
+	reader, closeFunc, err := openTrackReader(r.Context(), source, track.Path)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	defer closeFunc()
+
+	w.Header().Set("Content-Type", profile.ContentType)
+	if err := transcode.Stream(r.Context(), reader, w, profile); err != nil && r.Context().Err() == nil {
+		log.Printf("[Subsonic] Failed to transcode %s to %s: %v", track.Path, profile.Name, err)
+	}
+}
+
+func (h *subsonicHandlers) handleSubsonicDownload(w http.ResponseWriter, r *http.Request) {
+	id, ok := subsonicRequired(w, r, "id")
+	if !ok {
+		return
+	}
+	serveTrack(w, r, h.store, id, writeSubsonicFailure)
+}
+
+// handleSubsonicCoverArt serves the artwork for an album, track or artist
+// ID. Images are sent at their stored size; the size parameter is ignored.
+func (h *subsonicHandlers) handleSubsonicCoverArt(w http.ResponseWriter, r *http.Request) {
+	id, ok := subsonicRequired(w, r, "id")
+	if !ok {
+		return
+	}
+
+	imageURL, err := h.subsonicCoverArtURL(id)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	if imageURL == "" {
+		writeSubsonicError(w, r, subsonic.ErrNotFound, "Cover art not found")
+		return
+	}
+	file := filepath.Join(scanner.ArtDir, path.Base(strings.TrimPrefix(imageURL, "/api/art/")))
+	if _, err := os.Stat(file); err != nil {
+		writeSubsonicError(w, r, subsonic.ErrNotFound, "Cover art not found")
+		return
+	}
+	http.ServeFile(w, r, file)
+}
+
+func (h *subsonicHandlers) subsonicCoverArtURL(id string) (string, error) {
+	album, err := db.GetAlbumSummary(id)
+	if err != nil {
+		return "", err
+	}
+	if album != nil && album.ImageUrl != nil {
+		return *album.ImageUrl, nil
+	}
+
+	track, err := h.store.Library.GetTrack(id)
+	if err != nil {
+		return "", err
+	}
+	if track != nil {
+		if track.ImageUrl != nil && *track.ImageUrl != "" {
+			return *track.ImageUrl, nil
+		}
+		if track.AlbumID != nil {
+			return h.subsonicCoverArtURL(*track.AlbumID)
+		}
+		return "", nil
+	}
+
+	artist, err := db.GetArtistSummary(id)
+	if err != nil {
+		return "", err
+	}
+	if artist != nil && artist.ImageUrl != nil {
+		return *artist.ImageUrl, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/subsonic"
+	"homemusic-server/internal/types"
+)
+
+func subsonicPlaylist(p types.PlaylistSummary) subsonic.Playlist {
+	pl := subsonic.Playlist{
+		ID:        p.ID,
+		Name:      p.Name,
+		Public:    p.Visibility == types.VisibilityPublic,
+		Readonly:  p.ReadOnly,
+		Created:   p.CreatedAt,
+		Changed:   p.UpdatedAt,
+		SongCount: p.TrackCount,
+		Duration:  int(p.Duration),
+	}
+	if p.Owner != nil {
+		pl.Owner = *p.Owner
+	}
+	return pl
+}
+
+// authorizeSubsonicPlaylist is authorizePlaylist with Subsonic errors.
+func (h *subsonicHandlers) authorizeSubsonicPlaylist(w http.ResponseWriter, r *http.Request, id string, need db.PlaylistAccess) bool {
+	access, err := h.store.Playlists.GetPlaylistAccess(id, auth.UserFromContext(r.Context()))
+	if errors.Is(err, db.ErrNotFound) || (err == nil && access == db.AccessNone) {
+		writeSubsonicError(w, r, subsonic.ErrNotFound, "Playlist not found")
+		return false
+	}
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return false
+	}
+	if access < need {
+		writeSubsonicError(w, r, subsonic.ErrNotAuthorized, "You do not have permission to change this playlist")
+		return false
+	}
+	return true
+}
+
+func writeSubsonicPlaylistError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeSubsonicError(w, r, subsonic.ErrNotFound, "Playlist not found")
+	case errors.Is(err, db.ErrPlaylistReadOnly):
+		writeSubsonicError(w, r, subsonic.ErrNotAuthorized, "This playlist is read-only")
+	default:
+		writeSubsonicFailure(w, r, err)
+	}
+}
+
+func (h *subsonicHandlers) handleSubsonicPlaylists(w http.ResponseWriter, r *http.Request) {
+	playlists, err := h.store.Playlists.GetAllPlaylists(auth.UserFromContext(r.Context()))
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	resp := subsonic.NewResponse()
+	resp.Playlists = &subsonic.Playlists{Playlists: []subsonic.Playlist{}}
+	for _, p := range playlists {
+		resp.Playlists.Playlists = append(resp.Playlists.Playlists, subsonicPlaylist(p))
+	}
+	writeSubsonic(w, r, resp)
+}
+
+func (h *subsonicHandlers) handleSubsonicPlaylist(w http.ResponseWriter, r *http.Request) {
+	id, ok := subsonicRequired(w, r, "id")
+	if !ok {
+		return
+	}
+	if !h.authorizeSubsonicPlaylist(w, r, id, db.AccessView) {
+		return
+	}
+	h.writeSubsonicPlaylistDetail(w, r, id)
+}
+
+func (h *subsonicHandlers) writeSubsonicPlaylistDetail(w http.ResponseWriter, r *http.Request, id string) {
+	playlist, err := h.store.Playlists.GetPlaylist(id)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	if playlist == nil {
+		writeSubsonicError(w, r, subsonic.ErrNotFound, "Playlist not found")
+		return
+	}
+	ann, err := loadSubsonicAnnotations(r)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+
+	pl := subsonicPlaylist(playlist.PlaylistSummary)
+	// Sum whole seconds per track as the Subsonic API reports durations
+	pl.Duration = 0
+	for _, t := range playlist.Tracks {
+		pl.Duration += int(t.Duration)
+	}
+
+	resp := subsonic.NewResponse()
+	resp.Playlist = &subsonic.PlaylistWithSongs{Playlist: pl, Entries: subsonicSongs(playlist.Tracks, ann)}
+	writeSubsonic(w, r, resp)
+}
+
+// handleSubsonicCreatePlaylist creates a playlist from songId values or,
+// given playlistId, replaces the songs of an existing one.
+func (h *subsonicHandlers) handleSubsonicCreatePlaylist(w http.ResponseWriter, r *http.Request) {
+	songIDs := r.Form["songId"]
+	id := r.Form.Get("playlistId")
+	if id != "" {
+		if !h.authorizeSubsonicPlaylist(w, r, id, db.AccessEdit) {
+			return
+		}
+		if _, err := h.store.Playlists.ReplacePlaylistTracks(id, songIDs); err != nil {
+			writeSubsonicPlaylistError(w, r, err)
+			return
+		}
+		h.writeSubsonicPlaylistDetail(w, r, id)
+		return
+	}
+
+	name := strings.TrimSpace(r.Form.Get("name"))
+	if name == "" {
+		writeSubsonicError(w, r, subsonic.ErrMissingParameter, "Required parameter is missing: name or playlistId")
+		return
+	}
+	p, err := h.store.Playlists.CreatePlaylist(name, auth.UserFromContext(r.Context()).ID)
+	if err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	if len(songIDs) > 0 {
+		if _, err := h.store.Playlists.AddTracksToPlaylist(p.ID, songIDs); err != nil {
+			writeSubsonicPlaylistError(w, r, err)
+			return
+		}
+	}
+	h.writeSubsonicPlaylistDetail(w, r, p.ID)
+}
+
+func (h *subsonicHandlers) handleSubsonicUpdatePlaylist(w http.ResponseWriter, r *http.Request) {
+	id, ok := subsonicRequired(w, r, "playlistId")
+	if !ok {
+		return
+	}
+	public := r.Form.Get("public")
+	need := db.AccessEdit
+	if public != "" {
+		need = db.AccessOwner
+	}
+	if !h.authorizeSubsonicPlaylist(w, r, id, need) {
+		return
+	}
+
+	if name := strings.TrimSpace(r.Form.Get("name")); name != "" {
+		if err := h.store.Playlists.RenamePlaylist(id, name); err != nil {
+			writeSubsonicPlaylistError(w, r, err)
+			return
+		}
+	}
+	if public != "" {
+		visibility := types.VisibilityPrivate
+		if public == "true" {
+			visibility = types.VisibilityPublic
+		}
+		if err := h.store.Playlists.SetPlaylistVisibility(id, visibility); err != nil {
+			writeSubsonicPlaylistError(w, r, err)
+			return
+		}
+	}
+
+	if remove := r.Form["songIndexToRemove"]; len(remove) > 0 {
+		playlist, err := h.store.Playlists.GetPlaylist(id)
+		if err != nil {
+			writeSubsonicFailure(w, r, err)
+			return
+		}
+		items := playlist.Items
+		// Remove from the end so earlier indexes stay valid
+		var indexes []int
+		for _, v := range remove {
+			i, err := strconv.Atoi(v)
+			if err != nil || i < 0 || i >= len(items) {
+				writeSubsonicError(w, r, subsonic.ErrGeneric, "Invalid songIndexToRemove: "+v)
+				return
+			}
+			indexes = append(indexes, i)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(indexes)))
+		for n, i := range indexes {
+			if n > 0 && indexes[n-1] == i {
+				continue
+			}
+			if err := h.store.Playlists.RemovePlaylistItem(id, items[i].ID); err != nil {
+				writeSubsonicPlaylistError(w, r, err)
+				return
+			}
+		}
+	}
+	if add := r.Form["songIdToAdd"]; len(add) > 0 {
+		if _, err := h.store.Playlists.AddTracksToPlaylist(id, add); err != nil {
+			writeSubsonicPlaylistError(w, r, err)
+			return
+		}
+	}
+	writeSubsonic(w, r, subsonic.NewResponse())
+}
+
+func (h *subsonicHandlers) handleSubsonicDeletePlaylist(w http.ResponseWriter, r *http.Request) {
+	id, ok := subsonicRequired(w, r, "id")
+	if !ok {
+		return
+	}
+	if !h.authorizeSubsonicPlaylist(w, r, id, db.AccessOwner) {
+		return
+	}
+	if err := h.store.Playlists.DeletePlaylist(id); err != nil {
+		writeSubsonicFailure(w, r, err)
+		return
+	}
+	writeSubsonic(w, r, subsonic.NewResponse())
+}
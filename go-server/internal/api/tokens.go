@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/types"
+)
+
+func handleGetAPITokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := db.GetAPITokens(auth.UserFromContext(r.Context()).ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// handleCreateAPIToken issues a token. The secret is only returned here;
+// afterwards just its hash is kept.
+func handleCreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name          string             `json:"name"`
+		Scopes        []types.TokenScope `json:"scopes"`
+		ExpiresInDays int                `json:"expiresInDays"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		writeError(w, r, apierr.Invalid("Name is required"))
+		return
+	}
+	if len(req.Scopes) == 0 {
+		writeError(w, r, apierr.Invalid("At least one scope is required"))
+		return
+	}
+	user := auth.UserFromContext(r.Context())
+	scopes := []types.TokenScope{}
+	for _, s := range req.Scopes {
+		if !slices.Contains(auth.AllScopes, s) {
+			writeError(w, r, apierr.Invalid("Unknown scope: "+string(s)))
+			return
+		}
+		if (s == types.ScopeSources || s == types.ScopeAdmin) && user.Role != types.RoleAdmin {
+			writeError(w, r, apierr.Forbidden("Only admins can create tokens with the "+string(s)+" scope"))
+			return
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		writeError(w, r, apierr.Invalid("expiresInDays must not be negative"))
+		return
+	}
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	secret, hash, err := auth.NewAPIToken()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	token, err := db.CreateAPIToken(user.ID, name, hash, scopes, expiresAt)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"token": token, "secret": secret})
+}
+
+func handleDeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := db.DeleteAPIToken(auth.UserFromContext(r.Context()).ID, id)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, r, apierr.NotFound("Token not found"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/types"
+)
+
+// RegisterUserRoutes mounts account management; callers must require admin.
+func RegisterUserRoutes(r chi.Router) {
+	r.Get("/users", handleGetUsers)
+	r.Post("/users", handleCreateUser)
+	r.Patch("/users/{id}", handleUpdateUser)
+	r.Delete("/users/{id}", handleDeleteUser)
+}
+
+func handleGetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := db.GetAllUsers()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(users)
+}
+
+func handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		credentials
+		Role types.UserRole `json:"role"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Role == "" {
+		req.Role = types.RoleUser
+	}
+	if req.Role != types.RoleUser && req.Role != types.RoleAdmin {
+		writeError(w, r, apierr.Invalid("Role must be admin or user"))
+		return
+	}
+
+	user, ok := createUser(w, r, req.credentials, func(username, passwordHash string) (*types.User, error) {
+		return db.CreateUser(username, passwordHash, req.Role)
+	})
+	if !ok {
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+func handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req struct {
+		Password *string         `json:"password"`
+		Role     *types.UserRole `json:"role"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if req.Role != nil {
+		if *req.Role != types.RoleUser && *req.Role != types.RoleAdmin {
+			writeError(w, r, apierr.Invalid("Role must be admin or user"))
+			return
+		}
+		if err := db.UpdateUserRole(id, *req.Role); err != nil {
+			writeUserError(w, r, err)
+			return
+		}
+	}
+	if req.Password != nil {
+		hash, err := auth.HashPassword(*req.Password)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if err := db.UpdateUserPassword(id, hash); err != nil {
+			writeUserError(w, r, err)
+			return
+		}
+	}
+
+	user, err := db.GetUser(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if user == nil {
+		writeError(w, r, apierr.NotFound("User not found"))
+		return
+	}
+	json.NewEncoder(w).Encode(user)
+}
+
+func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := db.DeleteUser(id); err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeUserError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, db.ErrNotFound) {
+		err = apierr.NotFound("User not found")
+	}
+	writeError(w, r, err)
+}
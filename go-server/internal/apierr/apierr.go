@@ -0,0 +1,142 @@
+// Package apierr defines the error responses of the JSON API. Every failure
+// is sent as {"error": {"code", "message", "details", "requestId"}} so
+// clients can branch on a stable code and show the message as is.
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Codes clients can rely on. Messages may change; codes don't.
+const (
+	CodeInvalid           = "invalid_request"
+	CodeUnauthorized      = "unauthorized"
+	CodeForbidden         = "forbidden"
+	CodeNotFound          = "not_found"
+	CodeMethodNotAllowed  = "method_not_allowed"
+	CodeConflict          = "conflict"
+	CodeTooLarge          = "too_large"
+	CodeSourceUnreachable = "source_unreachable"
+	CodeSourceAuthFailed  = "source_auth_failed"
+	CodeUnavailable       = "unavailable"
+	CodeInternal          = "internal"
+)
+
+// Error is an API failure. Cause is logged for server errors but never
+// sent, so database and driver messages stay out of responses.
+type Error struct {
+	Status    int         `json:"-"`
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"requestId,omitempty"`
+	Cause     error       `json:"-"`
+}
+
+// FieldError is a problem with one field of a request body, named as it
+// appears in the JSON. Validation failures carry a list of them as details.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Message + ": " + e.Cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// WithDetails returns a copy of e carrying details, such as the fields
+// that failed validation.
+func (e *Error) WithDetails(details interface{}) *Error {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func Invalid(message string) *Error {
+	return New(http.StatusBadRequest, CodeInvalid, message)
+}
+
+func Unauthorized(message string) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func Forbidden(message string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, message)
+}
+
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, message)
+}
+
+func Conflict(message string) *Error {
+	return New(http.StatusConflict, CodeConflict, message)
+}
+
+func Unavailable(message string) *Error {
+	return New(http.StatusServiceUnavailable, CodeUnavailable, message)
+}
+
+// SourceUnreachable reports that a music source could not be connected to
+// or read from. Its message includes the cause, since that names the
+// network or file problem the user has to fix on their side.
+func SourceUnreachable(cause error) *Error {
+	return New(http.StatusBadGateway, CodeSourceUnreachable, "Could not reach the music source: "+cause.Error())
+}
+
+// Internal hides cause behind a generic message.
+func Internal(cause error) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Internal server error", Cause: cause}
+}
+
+// Write sends err as an error response. Errors that are not an *Error are
+// treated as internal.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = Internal(err)
+	}
+	resp := *e
+	resp.RequestID = middleware.GetReqID(r.Context())
+	if resp.Status >= http.StatusInternalServerError {
+		log.Printf("[API] %s %s failed (request %s): %v", r.Method, r.URL.Path, resp.RequestID, err)
+	}
+
+	h := w.Header()
+	h.Del("Content-Disposition")
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	if resp.RequestID != "" {
+		h.Set("X-Request-Id", resp.RequestID)
+	}
+	w.WriteHeader(resp.Status)
+	json.NewEncoder(w).Encode(struct {
+		Error *Error `json:"error"`
+	}{&resp})
+}
+
+// NotFoundHandler and MethodNotAllowedHandler replace the router's plain
+// text defaults.
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, NotFound("No such endpoint"))
+}
+
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, New(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/types"
+)
+
+const (
+	SessionCookieName = "homemusic_session"
+	SessionDuration   = 30 * 24 * time.Hour
+
+	MinPasswordLength = 8
+	// bcrypt ignores everything past 72 bytes, so refuse longer passwords
+	MaxPasswordLength = 72
+)
+
+var ErrInvalidPassword = errors.New("password must be between 8 and 72 bytes")
+
+// dummyHash is compared against when a username does not exist so failed
+// logins take the same time either way.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("homemusic-dummy-password"), bcrypt.DefaultCost)
+
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return "", ErrInvalidPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Authenticate checks a username and password, returning nil for any
+// mismatch.
+func Authenticate(username, password string) (*types.User, error) {
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// NewToken returns a random bearer token and the hash stored in its place.
+func NewToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartSession creates a session for the user and sets its cookie. The
+// token is also returned for clients that prefer an Authorization header.
+func StartSession(w http.ResponseWriter, r *http.Request, user *types.User) (string, error) {
+	token, _, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+	token = SessionTokenPrefix + token
+	expires := time.Now().Add(SessionDuration)
+	if err := db.CreateSession(HashToken(token), user.ID, expires); err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, nil
+}
+
+// EndSession deletes the request's session and clears its cookie.
+func EndSession(w http.ResponseWriter, r *http.Request) error {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if token := requestToken(r); token != "" {
+		return db.DeleteSession(HashToken(token))
+	}
+	return nil
+}
+
+// requestToken reads the bearer token, falling back to the session cookie.
+func requestToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if token, ok := strings.CutPrefix(h, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	if c, err := r.Cookie(SessionCookieName); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+type contextKey struct{}
+
+func UserFromContext(ctx context.Context) *types.User {
+	u, _ := ctx.Value(contextKey{}).(*types.User)
+	return u
+}
+
+// WithUser returns ctx signed in as u.
+func WithUser(ctx context.Context, u *types.User) context.Context {
+	return context.WithValue(ctx, contextKey{}, u)
+}
+
+// RequireUser rejects requests without a valid session, API token or URL
+// signature.
+func RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := requestToken(r)
+		if token == "" && validSignature(r) {
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), nil, signedURLToken)))
+			return
+		}
+		if token == "" {
+			apierr.Write(w, r, apierr.Unauthorized("Authentication required"))
+			return
+		}
+		if strings.HasPrefix(token, APITokenPrefix) {
+			serveWithAPIToken(w, r, next, token)
+			return
+		}
+		user, err := db.GetSessionUser(HashToken(token))
+		if err != nil {
+			apierr.Write(w, r, fmt.Errorf("looking up session: %w", err))
+			return
+		}
+		if user == nil {
+			apierr.Write(w, r, apierr.Unauthorized("Invalid or expired session"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
+	})
+}
+
+// RequireAdmin must run after RequireUser.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := UserFromContext(r.Context())
+		if user == nil || user.Role != types.RoleAdmin {
+			apierr.Write(w, r, apierr.Forbidden("Admin access required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"homemusic-server/internal/types"
+)
+
+// SignatureParam is the query parameter carrying a signed URL's signature
+// and ExpiryParam the Unix time it stops working, which the signature
+// covers as well.
+const (
+	SignatureParam = "sig"
+	ExpiryParam    = "exp"
+)
+
+// SignedURLLifetime is how long a signed URL works. Renderers fetch what
+// they were handed well within it, but a URL that leaks from a playlist or
+// log stops being useful.
+var SignedURLLifetime = 24 * time.Hour
+
+// signingKey is generated at startup, so signed URLs stop working when the
+// server restarts and devices have to browse again.
+var signingKey = func() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}()
+
+// signedURLToken is the identity of a signed request. The signature is
+// bound to one path, so read-only scopes cannot reach anything else.
+var signedURLToken = &types.APIToken{
+	Name:   "signed URL",
+	Scopes: []types.TokenScope{types.ScopeLibrary, types.ScopeStream},
+}
+
+// SignedURL returns path with a signature that lets devices which cannot
+// sign in, such as UPnP renderers, GET that one path until it expires.
+func SignedURL(path string) string {
+	exp := strconv.FormatInt(time.Now().Add(SignedURLLifetime).Unix(), 10)
+	return path + "?" + ExpiryParam + "=" + exp + "&" + SignatureParam + "=" + signPath(path, exp)
+}
+
+func signPath(path, exp string) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(exp + "\n" + path))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func validSignature(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	q := r.URL.Query()
+	sig, exp := q.Get(SignatureParam), q.Get(ExpiryParam)
+	if sig == "" || exp == "" {
+		return false
+	}
+	if !hmac.Equal([]byte(sig), []byte(signPath(r.URL.Path, exp))) {
+		return false
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	return err == nil && time.Now().Unix() < expires
+}
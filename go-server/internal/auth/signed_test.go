@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedURL(t *testing.T) {
+	signed := SignedURL("/api/stream/1")
+	if !strings.HasPrefix(signed, "/api/stream/1?exp=") {
+		t.Fatalf("SignedURL = %q", signed)
+	}
+	expired := "/api/stream/1?exp=" + strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	expired += "&sig=" + signPath("/api/stream/1", expired[len("/api/stream/1?exp="):])
+	later := strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   bool
+	}{
+		{"valid", "GET", signed, true},
+		{"head", "HEAD", signed, true},
+		{"post", "POST", signed, false},
+		{"other path", "GET", strings.Replace(signed, "/1?", "/2?", 1), false},
+		{"expired", "GET", expired, false},
+		{"extended expiry", "GET", strings.Replace(signed, "exp=", "exp="+later+"&old=", 1), false},
+		{"no expiry", "GET", "/api/stream/1?sig=" + signPath("/api/stream/1", ""), false},
+		{"no signature", "GET", "/api/stream/1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validSignature(httptest.NewRequest(tt.method, tt.target, nil)); got != tt.want {
+				t.Errorf("validSignature(%s %s) = %v, want %v", tt.method, tt.target, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"homemusic-server/internal/apierr"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/types"
+)
+
+// APITokenPrefix marks API tokens so they can be told apart from session
+// tokens without a lookup. Session tokens carry SessionTokenPrefix, since
+// their random part may itself start with "hm_".
+const (
+	APITokenPrefix     = "hm_"
+	SessionTokenPrefix = "hs_"
+)
+
+// lastUsedResolution limits how often a busy token's last-used time is
+// written back.
+const lastUsedResolution = time.Minute
+
+var AllScopes = []types.TokenScope{
+	types.ScopeLibrary,
+	types.ScopeStream,
+	types.ScopePlaylists,
+	types.ScopeSources,
+	types.ScopeAdmin,
+}
+
+// NewAPIToken returns a fresh API token secret and its hash.
+func NewAPIToken() (token, hash string, err error) {
+	token, _, err = NewToken()
+	if err != nil {
+		return "", "", err
+	}
+	token = APITokenPrefix + token
+	return token, HashToken(token), nil
+}
+
+type tokenContextKey struct{}
+
+// APITokenFromContext returns the token a request authenticated with, or
+// nil for an interactive session.
+func APITokenFromContext(ctx context.Context) *types.APIToken {
+	t, _ := ctx.Value(tokenContextKey{}).(*types.APIToken)
+	return t
+}
+
+// HasScope reports whether the request may use scope. Sessions have every
+// scope; tokens only those they were created with, with admin implying all.
+func HasScope(ctx context.Context, scope types.TokenScope) bool {
+	t := APITokenFromContext(ctx)
+	if t == nil {
+		return true
+	}
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, types.ScopeAdmin)
+}
+
+// AuthenticateAPIToken resolves an API token secret to its token and
+// owner, returning nils for unknown, expired or orphaned tokens. It also
+// records the use.
+func AuthenticateAPIToken(secret string) (*types.User, *types.APIToken, error) {
+	t, err := db.GetAPITokenByHash(HashToken(secret))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	if t == nil || (t.ExpiresAt != nil && now.After(*t.ExpiresAt)) {
+		return nil, nil, nil
+	}
+	user, err := db.GetUser(t.UserID)
+	if err != nil || user == nil {
+		return nil, nil, err
+	}
+
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) > lastUsedResolution {
+		if err := db.TouchAPIToken(t.ID, now); err != nil {
+			log.Printf("[Auth] Failed to record API token use: %v", err)
+		}
+		t.LastUsedAt = &now
+	}
+	return user, t, nil
+}
+
+// WithIdentity stores the authenticated user, and the API token used if
+// any, for UserFromContext and HasScope.
+func WithIdentity(ctx context.Context, user *types.User, token *types.APIToken) context.Context {
+	ctx = WithUser(ctx, user)
+	if token != nil {
+		ctx = context.WithValue(ctx, tokenContextKey{}, token)
+	}
+	return ctx
+}
+
+func serveWithAPIToken(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	user, t, err := AuthenticateAPIToken(token)
+	if err != nil {
+		apierr.Write(w, r, fmt.Errorf("looking up API token: %w", err))
+		return
+	}
+	if user == nil {
+		apierr.Write(w, r, apierr.Unauthorized("Invalid or expired API token"))
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), user, t)))
+}
+
+// RequireScope rejects API tokens lacking scope. It must run after
+// RequireUser.
+func RequireScope(scope types.TokenScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasScope(r.Context(), scope) {
+				apierr.Write(w, r, apierr.Forbidden("API token is missing the "+string(scope)+" scope"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireWriteScope is RequireScope for requests other than GET and HEAD.
+func RequireWriteScope(scope types.TokenScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		scoped := RequireScope(scope)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			scoped.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession rejects API tokens outright, for account changes that
+// should only be made interactively.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if APITokenFromContext(r.Context()) != nil {
+			apierr.Write(w, r, apierr.Forbidden("This endpoint cannot be used with an API token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
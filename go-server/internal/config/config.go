@@ -0,0 +1,406 @@
+// Package config holds the server settings. Each setting has a default and
+// can be changed in a YAML file, then by an environment variable, then by a
+// command line flag, each overriding the one before.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"homemusic-server/internal/logging"
+)
+
+// Config is the effective server configuration. Relative paths are
+// relative to the working directory.
+type Config struct {
+	// Listen is the address the HTTP server binds, such as ":3001".
+	Listen string `yaml:"listen"`
+	// DataDir holds the database, artwork and cache unless they are set
+	// separately.
+	DataDir string `yaml:"data_dir"`
+	// Database is the SQLite file; defaults to music.db in DataDir.
+	Database string `yaml:"database"`
+	// ArtDir holds artwork extracted from tags; defaults to public/art in
+	// DataDir.
+	ArtDir string `yaml:"art_dir"`
+	// CacheDir holds files the server can regenerate, such as HLS
+	// segments; defaults to cache in DataDir.
+	CacheDir string `yaml:"cache_dir"`
+	// FrontendDir is the built web client. Nothing is served when it is
+	// empty or missing.
+	FrontendDir string `yaml:"frontend_dir"`
+	// LogLevel is debug, info or warn. Requests are logged at info.
+	LogLevel string `yaml:"log_level"`
+	// Discovery browses the local network for SMB and SSH servers and,
+	// unless MDNS is disabled, announces this server to it.
+	Discovery bool `yaml:"discovery"`
+	// ScanConcurrency is how many sources are scanned at the same time.
+	ScanConcurrency int       `yaml:"scan_concurrency"`
+	TLS             TLSConfig `yaml:"tls"`
+	// CORSAllowedOrigins may make credentialed cross-site requests, such
+	// as the web client's development server.
+	CORSAllowedOrigins []string     `yaml:"cors_allowed_origins"`
+	Backup             BackupConfig `yaml:"backup"`
+	MDNS               MDNSConfig   `yaml:"mdns"`
+	DLNA               DLNAConfig   `yaml:"dlna"`
+	MPD                MPDConfig    `yaml:"mpd"`
+}
+
+// TLSConfig serves HTTPS when both files are set.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// BackupConfig keeps rotated database backups when Dir is set.
+type BackupConfig struct {
+	Dir string `yaml:"dir"`
+	// Interval is a Go duration such as "24h".
+	Interval string `yaml:"interval"`
+	// Keep is how many backups are kept; older ones are deleted.
+	Keep int `yaml:"keep"`
+}
+
+// MDNSConfig announces the server over mDNS so apps can find it by name.
+type MDNSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Name defaults to "HomeMusic on <hostname>".
+	Name string `yaml:"name"`
+}
+
+// DLNAConfig serves the library to UPnP players. UPnP has no
+// authentication, so it is off by default.
+type DLNAConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Name    string `yaml:"name"`
+}
+
+// MPDConfig serves the MPD protocol when Listen is set.
+type MPDConfig struct {
+	Listen string `yaml:"listen"`
+}
+
+// Default returns the settings used when nothing is configured, which
+// match a server started from the go-server directory.
+func Default() *Config {
+	return &Config{
+		Listen:             ":3001",
+		DataDir:            ".",
+		FrontendDir:        filepath.Join("..", "client", "dist"),
+		LogLevel:           "info",
+		Discovery:          true,
+		ScanConcurrency:    2,
+		CORSAllowedOrigins: []string{"http://localhost:5173"},
+		Backup:             BackupConfig{Interval: "24h", Keep: 7},
+		MDNS:               MDNSConfig{Enabled: true},
+		DLNA:               DLNAConfig{Name: "HomeMusic"},
+	}
+}
+
+// Flags are the command line flags for the settings, registered on a flag
+// set by RegisterFlags.
+type Flags struct {
+	fs   *flag.FlagSet
+	file string
+	set  Config
+	// Print asks for the configuration to be printed instead of serving.
+	Print bool
+}
+
+// flagSettings copy a flag's value from the flag targets onto a Config,
+// keyed by flag name.
+var flagSettings = map[string]func(c, f *Config){
+	"listen":           func(c, f *Config) { c.Listen = f.Listen },
+	"data-dir":         func(c, f *Config) { c.DataDir = f.DataDir },
+	"database":         func(c, f *Config) { c.Database = f.Database },
+	"art-dir":          func(c, f *Config) { c.ArtDir = f.ArtDir },
+	"cache-dir":        func(c, f *Config) { c.CacheDir = f.CacheDir },
+	"frontend-dir":     func(c, f *Config) { c.FrontendDir = f.FrontendDir },
+	"log-level":        func(c, f *Config) { c.LogLevel = f.LogLevel },
+	"discovery":        func(c, f *Config) { c.Discovery = f.Discovery },
+	"scan-concurrency": func(c, f *Config) { c.ScanConcurrency = f.ScanConcurrency },
+	"tls-cert":         func(c, f *Config) { c.TLS.CertFile = f.TLS.CertFile },
+	"tls-key":          func(c, f *Config) { c.TLS.KeyFile = f.TLS.KeyFile },
+	"cors-origins":     func(c, f *Config) { c.CORSAllowedOrigins = f.CORSAllowedOrigins },
+	"backup-dir":       func(c, f *Config) { c.Backup.Dir = f.Backup.Dir },
+	"backup-interval":  func(c, f *Config) { c.Backup.Interval = f.Backup.Interval },
+	"backup-keep":      func(c, f *Config) { c.Backup.Keep = f.Backup.Keep },
+	"mdns":             func(c, f *Config) { c.MDNS.Enabled = f.MDNS.Enabled },
+	"mdns-name":        func(c, f *Config) { c.MDNS.Name = f.MDNS.Name },
+	"dlna":             func(c, f *Config) { c.DLNA.Enabled = f.DLNA.Enabled },
+	"dlna-name":        func(c, f *Config) { c.DLNA.Name = f.DLNA.Name },
+	"mpd-listen":       func(c, f *Config) { c.MPD.Listen = f.MPD.Listen },
+}
+
+// RegisterFlags adds the settings flags to fs; call Load after parsing.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{fs: fs}
+	def := Default()
+	fs.StringVar(&f.file, "config", "", "read settings from the YAML `file` (env CONFIG_FILE)")
+	fs.BoolVar(&f.Print, "print-config", false, "print the effective configuration as YAML and exit")
+	fs.StringVar(&f.set.Listen, "listen", def.Listen, "listen on `address` (env LISTEN_ADDR, or PORT for the port alone)")
+	fs.StringVar(&f.set.DataDir, "data-dir", def.DataDir, "keep the database, artwork and cache in `dir` (env DATA_DIR)")
+	fs.StringVar(&f.set.Database, "database", "", "SQLite database `file` (env DATABASE_URL; default music.db in the data dir)")
+	fs.StringVar(&f.set.ArtDir, "art-dir", "", "save artwork in `dir` (env ART_DIR; default public/art in the data dir)")
+	fs.StringVar(&f.set.CacheDir, "cache-dir", "", "keep regenerable files in `dir` (env CACHE_DIR; default cache in the data dir)")
+	fs.StringVar(&f.set.FrontendDir, "frontend-dir", def.FrontendDir, "serve the web client from `dir`, or nothing if empty (env FRONTEND_DIR)")
+	fs.StringVar(&f.set.LogLevel, "log-level", def.LogLevel, "log at `level` debug, info or warn (env LOG_LEVEL)")
+	fs.BoolVar(&f.set.Discovery, "discovery", def.Discovery, "browse the network for music sources (env DISCOVERY_ENABLED)")
+	fs.IntVar(&f.set.ScanConcurrency, "scan-concurrency", def.ScanConcurrency, "scan at most `n` sources at once (env SCAN_CONCURRENCY)")
+	fs.StringVar(&f.set.TLS.CertFile, "tls-cert", "", "serve HTTPS with the certificate in `file` (env TLS_CERT_FILE)")
+	fs.StringVar(&f.set.TLS.KeyFile, "tls-key", "", "serve HTTPS with the private key in `file` (env TLS_KEY_FILE)")
+	fs.Func("cors-origins", "allow credentialed requests from the comma-separated `origins` (env CORS_ALLOWED_ORIGINS; default "+strings.Join(def.CORSAllowedOrigins, ",")+")", func(v string) error {
+		f.set.CORSAllowedOrigins = splitList(v)
+		return nil
+	})
+	fs.StringVar(&f.set.Backup.Dir, "backup-dir", "", "keep rotated database backups in `dir` (env BACKUP_DIR)")
+	fs.StringVar(&f.set.Backup.Interval, "backup-interval", def.Backup.Interval, "back up every `duration` (env BACKUP_INTERVAL)")
+	fs.IntVar(&f.set.Backup.Keep, "backup-keep", def.Backup.Keep, "keep the last `n` backups (env BACKUP_KEEP)")
+	fs.BoolVar(&f.set.MDNS.Enabled, "mdns", def.MDNS.Enabled, "announce the server over mDNS when discovery is on (env MDNS_ENABLED)")
+	fs.StringVar(&f.set.MDNS.Name, "mdns-name", "", "announce the server as `name` (env MDNS_NAME; default HomeMusic on the hostname)")
+	fs.BoolVar(&f.set.DLNA.Enabled, "dlna", def.DLNA.Enabled, "serve the library to DLNA players, without authentication (env DLNA_ENABLED)")
+	fs.StringVar(&f.set.DLNA.Name, "dlna-name", def.DLNA.Name, "show the DLNA server as `name` (env DLNA_NAME)")
+	fs.StringVar(&f.set.MPD.Listen, "mpd-listen", "", "serve the MPD protocol on `address` (env MPD_ADDR)")
+	return f
+}
+
+// Load builds the configuration from the defaults, the config file, the
+// environment read with getenv and the flags that were set, then
+// validates it.
+func (f *Flags) Load(getenv func(string) string) (*Config, error) {
+	c := Default()
+	file := f.file
+	if file == "" {
+		file = getenv("CONFIG_FILE")
+	}
+	if file != "" {
+		if err := c.readFile(file); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.readEnv(getenv); err != nil {
+		return nil, err
+	}
+	f.fs.Visit(func(fl *flag.Flag) {
+		if apply, ok := flagSettings[fl.Name]; ok {
+			apply(c, &f.set)
+		}
+	})
+	c.fillPaths()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readFile applies the settings in a YAML file. Unknown keys are errors so
+// typos do not go unnoticed.
+func (c *Config) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer file.Close()
+	dec := yaml.NewDecoder(file)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// readEnv applies the environment variables that are set.
+func (c *Config) readEnv(getenv func(string) string) error {
+	// PORT predates LISTEN_ADDR and sets only the port
+	if port := getenv("PORT"); port != "" {
+		c.Listen = ":" + port
+	}
+	values := map[string]*string{
+		"LISTEN_ADDR":     &c.Listen,
+		"DATA_DIR":        &c.DataDir,
+		"DATABASE_URL":    &c.Database,
+		"ART_DIR":         &c.ArtDir,
+		"CACHE_DIR":       &c.CacheDir,
+		"FRONTEND_DIR":    &c.FrontendDir,
+		"LOG_LEVEL":       &c.LogLevel,
+		"TLS_CERT_FILE":   &c.TLS.CertFile,
+		"TLS_KEY_FILE":    &c.TLS.KeyFile,
+		"BACKUP_DIR":      &c.Backup.Dir,
+		"BACKUP_INTERVAL": &c.Backup.Interval,
+		"MDNS_NAME":       &c.MDNS.Name,
+		"DLNA_NAME":       &c.DLNA.Name,
+		"MPD_ADDR":        &c.MPD.Listen,
+	}
+	for name, dst := range values {
+		if v := getenv(name); v != "" {
+			*dst = v
+		}
+	}
+	if v := getenv("CORS_ALLOWED_ORIGINS"); v != "" {
+		c.CORSAllowedOrigins = splitList(v)
+	}
+	bools := map[string]*bool{
+		"DISCOVERY_ENABLED": &c.Discovery,
+		"MDNS_ENABLED":      &c.MDNS.Enabled,
+		"DLNA_ENABLED":      &c.DLNA.Enabled,
+	}
+	for name, dst := range bools {
+		if v := getenv(name); v != "" {
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s must be true or false, got %q", name, v)
+			}
+			*dst = enabled
+		}
+	}
+	ints := map[string]*int{
+		"SCAN_CONCURRENCY": &c.ScanConcurrency,
+		"BACKUP_KEEP":      &c.Backup.Keep,
+	}
+	for name, dst := range ints {
+		if v := getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s must be a whole number, got %q", name, v)
+			}
+			*dst = n
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// fillPaths places the paths left unset in the data dir.
+func (c *Config) fillPaths() {
+	if c.Database == "" {
+		c.Database = filepath.Join(c.DataDir, "music.db")
+	}
+	if c.ArtDir == "" {
+		c.ArtDir = filepath.Join(c.DataDir, "public", "art")
+	}
+	if c.CacheDir == "" {
+		c.CacheDir = filepath.Join(c.DataDir, "cache")
+	}
+}
+
+// Validate lists every setting that keeps the server from starting.
+func (c *Config) Validate() error {
+	var errs []error
+	if _, port, err := net.SplitHostPort(c.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("listen: %q is not a host:port address", c.Listen))
+	} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		errs = append(errs, fmt.Errorf("listen: port must be between 1 and 65535, got %q", port))
+	}
+	if c.DataDir == "" {
+		errs = append(errs, errors.New("data_dir: is required"))
+	}
+	for _, dir := range []struct{ key, path string }{{"data_dir", c.DataDir}, {"art_dir", c.ArtDir}, {"cache_dir", c.CacheDir}} {
+		if info, err := os.Stat(dir.path); err == nil && !info.IsDir() {
+			errs = append(errs, fmt.Errorf("%s: %s is not a directory", dir.key, dir.path))
+		}
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+	if c.ScanConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("scan_concurrency: must be at least 1, got %d", c.ScanConcurrency))
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls: cert_file and key_file must be set together"))
+	}
+	for _, file := range []struct{ key, path string }{{"tls.cert_file", c.TLS.CertFile}, {"tls.key_file", c.TLS.KeyFile}} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file.key, err))
+		}
+	}
+	for _, origin := range c.CORSAllowedOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			errs = append(errs, fmt.Errorf("cors_allowed_origins: %q is not an http:// or https:// origin", origin))
+		}
+	}
+	if d, err := time.ParseDuration(c.Backup.Interval); err != nil || d <= 0 {
+		errs = append(errs, fmt.Errorf("backup.interval: must be a positive duration such as 24h, got %q", c.Backup.Interval))
+	}
+	if c.Backup.Keep < 1 {
+		errs = append(errs, fmt.Errorf("backup.keep: must be at least 1, got %d", c.Backup.Keep))
+	}
+	if c.DLNA.Enabled && c.DLNA.Name == "" {
+		errs = append(errs, errors.New("dlna.name: is required when DLNA is enabled"))
+	}
+	if c.MPD.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.MPD.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("mpd.listen: %q is not a host:port address", c.MPD.Listen))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Port is the port from Listen, announced over mDNS and SSDP.
+func (c *Config) Port() int {
+	_, port, _ := net.SplitHostPort(c.Listen)
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// TLSEnabled reports whether the server speaks HTTPS.
+func (c *Config) TLSEnabled() bool {
+	return c.TLS.CertFile != ""
+}
+
+// Scheme is the URL scheme the server is reached with.
+func (c *Config) Scheme() string {
+	if c.TLSEnabled() {
+		return "https"
+	}
+	return "http"
+}
+
+// BackupInterval is the parsed Backup.Interval.
+func (c *Config) BackupInterval() time.Duration {
+	d, _ := time.ParseDuration(c.Backup.Interval)
+	return d
+}
+
+// AdvertiseMDNS reports whether the server announces itself over mDNS,
+// which needs discovery on as well.
+func (c *Config) AdvertiseMDNS() bool {
+	return c.Discovery && c.MDNS.Enabled
+}
+
+// Level is the parsed LogLevel.
+func (c *Config) Level() logging.Level {
+	l, _ := logging.ParseLevel(c.LogLevel)
+	return l
+}
+
+// WriteYAML writes c in the config file format.
+func (c *Config) WriteYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func load(t *testing.T, args []string, env map[string]string) (*Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	settings := RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return settings.Load(func(name string) string { return env[name] })
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultsMatchWorkingDirectoryLayout(t *testing.T) {
+	cfg, err := load(t, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Config{
+		Listen:             ":3001",
+		DataDir:            ".",
+		Database:           "music.db",
+		ArtDir:             filepath.Join("public", "art"),
+		CacheDir:           "cache",
+		FrontendDir:        filepath.Join("..", "client", "dist"),
+		LogLevel:           "info",
+		Discovery:          true,
+		ScanConcurrency:    2,
+		CORSAllowedOrigins: []string{"http://localhost:5173"},
+		Backup:             BackupConfig{Interval: "24h", Keep: 7},
+		MDNS:               MDNSConfig{Enabled: true},
+		DLNA:               DLNAConfig{Name: "HomeMusic"},
+	}
+	if !reflect.DeepEqual(*cfg, want) {
+		t.Errorf("defaults = %+v, want %+v", *cfg, want)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	file := writeFile(t, "homemusic.yaml", `
+listen: ":4000"
+data_dir: /srv/music
+log_level: debug
+discovery: false
+scan_concurrency: 4
+cors_allowed_origins: [https://music.example.com]
+backup:
+  dir: /srv/backups
+  keep: 3
+dlna:
+  enabled: true
+mpd:
+  listen: ":6600"
+`)
+	tests := []struct {
+		name  string
+		args  []string
+		env   map[string]string
+		check func(*Config) bool
+	}{
+		{"file", []string{"-config", file}, nil, func(c *Config) bool {
+			return c.Listen == ":4000" && c.LogLevel == "debug" && !c.Discovery && c.ScanConcurrency == 4 &&
+				c.Database == filepath.Join("/srv/music", "music.db") && c.ArtDir == filepath.Join("/srv/music", "public", "art")
+		}},
+		{"services from file", []string{"-config", file}, nil, func(c *Config) bool {
+			return reflect.DeepEqual(c.CORSAllowedOrigins, []string{"https://music.example.com"}) &&
+				c.Backup == BackupConfig{Dir: "/srv/backups", Interval: "24h", Keep: 3} &&
+				c.DLNA == DLNAConfig{Enabled: true, Name: "HomeMusic"} && c.MPD.Listen == ":6600" &&
+				c.MDNS.Enabled && !c.AdvertiseMDNS()
+		}},
+		{"services from env", []string{"-config", file}, map[string]string{
+			"CORS_ALLOWED_ORIGINS": "http://a.example, http://b.example", "BACKUP_INTERVAL": "1h", "BACKUP_KEEP": "10",
+			"DLNA_ENABLED": "false", "MPD_ADDR": "127.0.0.1:6601", "DISCOVERY_ENABLED": "true", "MDNS_NAME": "Den",
+		}, func(c *Config) bool {
+			return reflect.DeepEqual(c.CORSAllowedOrigins, []string{"http://a.example", "http://b.example"}) &&
+				c.BackupInterval() == time.Hour && c.Backup.Keep == 10 && c.Backup.Dir == "/srv/backups" &&
+				!c.DLNA.Enabled && c.MPD.Listen == "127.0.0.1:6601" && c.AdvertiseMDNS() && c.MDNS.Name == "Den"
+		}},
+		{"services from flags", []string{"-config", file, "-cors-origins", "*", "-backup-keep", "2", "-dlna-name", "Attic", "-mpd-listen", ":6700", "-discovery", "-mdns=false"},
+			map[string]string{"BACKUP_KEEP": "10", "DLNA_NAME": "Den"}, func(c *Config) bool {
+				return reflect.DeepEqual(c.CORSAllowedOrigins, []string{"*"}) && c.Backup.Keep == 2 &&
+					c.DLNA.Name == "Attic" && c.MPD.Listen == ":6700" && c.Discovery && !c.AdvertiseMDNS()
+			}},
+		{"file from env", nil, map[string]string{"CONFIG_FILE": file}, func(c *Config) bool {
+			return c.Listen == ":4000"
+		}},
+		{"env over file", []string{"-config", file}, map[string]string{"PORT": "5000", "DISCOVERY_ENABLED": "true", "CACHE_DIR": "/var/cache/homemusic"}, func(c *Config) bool {
+			return c.Listen == ":5000" && c.Discovery && c.CacheDir == "/var/cache/homemusic" && c.ScanConcurrency == 4
+		}},
+		{"listen address over port", nil, map[string]string{"PORT": "5000", "LISTEN_ADDR": "127.0.0.1:6000"}, func(c *Config) bool {
+			return c.Listen == "127.0.0.1:6000" && c.Port() == 6000
+		}},
+		{"flags over env", []string{"-config", file, "-listen", ":7000", "-discovery", "-data-dir", "/data"}, map[string]string{"PORT": "5000"}, func(c *Config) bool {
+			return c.Listen == ":7000" && c.Discovery && c.Database == filepath.Join("/data", "music.db")
+		}},
+		{"unset flags keep file", []string{"-config", file, "-log-level", "warn"}, nil, func(c *Config) bool {
+			return c.Listen == ":4000" && c.LogLevel == "warn"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := load(t, tt.args, tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("got %+v", *cfg)
+			}
+		})
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	notDir := writeFile(t, "art", "")
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want []string
+	}{
+		{"unknown key", []string{"-config", writeFile(t, "c.yaml", "listen: ':1'\nlsten: ':2'\n")}, nil, []string{"field lsten not found"}},
+		{"missing file", []string{"-config", "/nonexistent/homemusic.yaml"}, nil, []string{"config file"}},
+		{"bad env", nil, map[string]string{"SCAN_CONCURRENCY": "many"}, []string{"SCAN_CONCURRENCY"}},
+		{"bad bool env", nil, map[string]string{"MDNS_ENABLED": "sometimes"}, []string{"MDNS_ENABLED"}},
+		{"bad services", []string{"-cors-origins", "localhost:5173", "-backup-interval", "daily", "-backup-keep", "0", "-dlna", "-dlna-name", "", "-mpd-listen", "6600"}, nil,
+			[]string{"cors_allowed_origins:", "backup.interval:", "backup.keep:", "dlna.name:", "mpd.listen:"}},
+		{"every problem", []string{"-listen", "3001", "-log-level", "loud", "-scan-concurrency", "0", "-tls-cert", "/nonexistent/cert.pem", "-art-dir", notDir}, nil,
+			[]string{"listen:", "log_level:", "scan_concurrency:", "tls: cert_file and key_file", "tls.cert_file:", "art_dir:"}},
+		{"port out of range", []string{"-listen", ":70000"}, nil, []string{"between 1 and 65535"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(t, tt.args, tt.env)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPrintedConfigLoadsBack(t *testing.T) {
+	cfg, err := load(t, []string{"-data-dir", "/srv/music", "-log-level", "debug", "-cors-origins", "http://a.example,http://b.example", "-backup-dir", "/srv/backups", "-dlna"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := cfg.WriteYAML(&out); err != nil {
+		t.Fatal(err)
+	}
+	again, err := load(t, []string{"-config", writeFile(t, "printed.yaml", out.String())}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, cfg) {
+		t.Errorf("printed config loaded as %+v, want %+v\n%s", *again, *cfg, out.String())
+	}
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"time"
+
+	"homemusic-server/internal/types"
+)
+
+// Kinds of item a user can star.
+const (
+	StarTrack  = "track"
+	StarAlbum  = "album"
+	StarArtist = "artist"
+)
+
+func StarItem(userID, itemID, kind string) error {
+	_, err := DB.Exec("INSERT OR IGNORE INTO stars (user_id, item_id, item_type, created_at) VALUES (?, ?, ?, ?)",
+		userID, itemID, kind, time.Now())
+	return err
+}
+
+func UnstarItem(userID, itemID string) error {
+	_, err := DB.Exec("DELETE FROM stars WHERE user_id = ? AND item_id = ?", userID, itemID)
+	return err
+}
+
+// GetStarredItems returns when the user starred each item, keyed by ID.
+func GetStarredItems(userID string) (map[string]time.Time, error) {
+	rows, err := DB.Query("SELECT item_id, created_at FROM stars WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	starred := map[string]time.Time{}
+	for rows.Next() {
+		var id string
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, err
+		}
+		starred[id] = at
+	}
+	return starred, rows.Err()
+}
+
+func GetStarredTracks(userID string) ([]types.Track, error) {
+	rows, err := DB.Query(`
+		SELECT `+trackColumns+` FROM tracks t
+		JOIN stars s ON s.item_id = t.id AND s.user_id = ?
+		ORDER BY s.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanTrackRows(rows)
+}
+
+func GetStarredArtists(userID string) ([]types.ArtistSummary, error) {
+	artists, err := GetArtistSummaries("")
+	if err != nil {
+		return nil, err
+	}
+	starred, err := GetStarredItems(userID)
+	if err != nil {
+		return nil, err
+	}
+	result := []types.ArtistSummary{}
+	for _, a := range artists {
+		if _, ok := starred[a.ID]; ok {
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
+
+// RecordPlay counts one complete play of a track by the user.
+func RecordPlay(userID, trackID string, at time.Time) error {
+	_, err := DB.Exec(`
+		INSERT INTO plays (user_id, track_id, play_count, last_played) VALUES (?, ?, 1, ?)
+		ON CONFLICT(user_id, track_id) DO UPDATE SET play_count = play_count + 1, last_played = excluded.last_played`,
+		userID, trackID, at)
+	return err
+}
+
+func GetPlayStats(userID string) (map[string]types.PlayStat, error) {
+	rows, err := DB.Query("SELECT track_id, play_count, last_played FROM plays WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := map[string]types.PlayStat{}
+	for rows.Next() {
+		var id string
+		var s types.PlayStat
+		if err := rows.Scan(&id, &s.Count, &s.LastPlayed); err != nil {
+			return nil, err
+		}
+		stats[id] = s
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"homemusic-server/internal/logging"
+)
+
+// backupPrefix names rotated backups so they sort by age.
+const backupPrefix = "music-"
+
+// Backup writes a consistent copy of the live database to path, which must
+// not exist yet. The server keeps running while it is taken.
+func Backup(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup %s already exists", path)
+	}
+	_, err := DB.Exec("VACUUM INTO ?", path)
+	return err
+}
+
+// RotateBackup takes a timestamped backup in dir and deletes all but the
+// newest keep backups there. It returns the new backup's path.
+func RotateBackup(dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, BackupName(time.Now()))
+	if err := Backup(path); err != nil {
+		return "", err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, backupPrefix+"*.db"))
+	if err != nil {
+		return path, err
+	}
+	sort.Strings(matches)
+	for keep > 0 && len(matches) > keep {
+		if err := os.Remove(matches[0]); err != nil {
+			return path, err
+		}
+		logging.Infof("[Backup] Removed old backup %s", matches[0])
+		matches = matches[1:]
+	}
+	return path, nil
+}
+
+// ScheduleBackups takes a rotated backup every interval until ctx is done.
+func ScheduleBackups(ctx context.Context, dir string, interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			path, err := RotateBackup(dir, keep)
+			if err != nil {
+				log.Printf("[Backup] Scheduled backup failed: %v", err)
+				continue
+			}
+			logging.Infof("[Backup] Saved %s", path)
+		}
+	}
+}
+
+// Restore replaces the database at dbPath with a backup. The server must
+// not be running. The database being replaced is kept beside it with a
+// .pre-restore suffix, and pending migrations run on the next start.
+func Restore(backupPath, dbPath string) error {
+	if err := checkBackup(backupPath); err != nil {
+		return fmt.Errorf("%s is not a usable backup: %w", backupPath, err)
+	}
+
+	tmp := dbPath + ".restoring"
+	if err := copyFile(backupPath, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		if err := os.Rename(dbPath, dbPath+".pre-restore"); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	// Writes not yet checkpointed live in the write-ahead log. It moves
+	// with the old database, where SQLite looks for it when that file is
+	// opened, and must not be replayed into the restored one. A log left
+	// by an earlier restore would corrupt the copy, so it goes first.
+	for _, suffix := range []string{"-wal", "-shm"} {
+		kept := dbPath + ".pre-restore" + suffix
+		if err := os.Remove(kept); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(dbPath+suffix, kept); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	return os.Rename(tmp, dbPath)
+}
+
+func checkBackup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	backup, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer backup.Close()
+
+	var result string
+	if err := backup.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	var tables int
+	err = backup.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('sources', 'tracks', 'playlists')").Scan(&tables)
+	if err != nil {
+		return err
+	}
+	if tables != 3 {
+		return fmt.Errorf("not a HomeMusic database")
+	}
+	return nil
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// BackupName is the file name for a backup taken at t.
+func BackupName(t time.Time) string {
+	return backupPrefix + t.Format("20060102-150405") + ".db"
+}
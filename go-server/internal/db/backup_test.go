@@ -0,0 +1,65 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"homemusic-server/internal/types"
+)
+
+func countSources(t *testing.T, path string) int {
+	t.Helper()
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var n int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM sources").Scan(&n); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return n
+}
+
+func TestRestoreKeepsWriteAheadLogWithOldDatabase(t *testing.T) {
+	live := openTestDB(t)
+	backup := filepath.Join(t.TempDir(), "backup.db")
+	if err := Backup(backup); err != nil {
+		t.Fatal(err)
+	}
+	// Not yet checkpointed, so this row only exists in the -wal file
+	if err := CreateSource(types.Source{ID: "src", Name: "NAS", Type: types.SourceTypeSMB, Host: "nas", Port: 445}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copy the files as a stopped server would have left them
+	dbPath := filepath.Join(t.TempDir(), "music.db")
+	for _, suffix := range []string{"", "-wal"} {
+		data, err := os.ReadFile(live + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dbPath+suffix, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A log left over from an earlier restore must not be replayed
+	if err := os.WriteFile(dbPath+".pre-restore-shm", []byte("stale"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(backup, dbPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dbPath + "-wal"); !os.IsNotExist(err) {
+		t.Errorf("restored database still has a -wal file (err %v)", err)
+	}
+	if n := countSources(t, dbPath); n != 0 {
+		t.Errorf("restored database has %d sources, want the backup's 0", n)
+	}
+	if n := countSources(t, dbPath+".pre-restore"); n != 1 {
+		t.Errorf("pre-restore database has %d sources, want 1 including the unsaved write", n)
+	}
+}
@@ -0,0 +1,280 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"homemusic-server/internal/types"
+)
+
+// AlbumSort selects the ordering of GetAlbumSummaries.
+type AlbumSort string
+
+const (
+	AlbumsNewest         AlbumSort = "newest"
+	AlbumsByName         AlbumSort = "name"
+	AlbumsByArtist       AlbumSort = "artist"
+	AlbumsByYear         AlbumSort = "year"
+	AlbumsRandom         AlbumSort = "random"
+	AlbumsRecentlyPlayed AlbumSort = "recent"
+	AlbumsMostPlayed     AlbumSort = "frequent"
+	AlbumsStarred        AlbumSort = "starred"
+)
+
+// AlbumQuery filters and orders album listings. UserID is required for the
+// play- and star-based sorts; zero Limit means no limit.
+type AlbumQuery struct {
+	Sort     AlbumSort
+	ArtistID string
+	SourceID string
+	FromYear int
+	ToYear   int
+	UserID   string
+	Limit    int
+	Offset   int
+}
+
+const trackColumns = "t.id, t.title, t.artist, t.album, t.duration, t.track_number, t.year, t.path, t.folder_path, t.image_url, t.source_mtime, t.artists_display, t.source_id, t.album_id, t.artist_id, t.created_at"
+
+func scanTrackRows(rows *sql.Rows) ([]types.Track, error) {
+	defer rows.Close()
+	tracks := []types.Track{}
+	for rows.Next() {
+		var t types.Track
+		err := rows.Scan(&t.ID, &t.Title, &t.Artist, &t.Album, &t.Duration, &t.TrackNumber, &t.Year, &t.Path, &t.FolderPath, &t.ImageUrl, &t.SourceMtime, &t.ArtistsDisplay, &t.SourceID, &t.AlbumID, &t.ArtistID, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, rows.Err()
+}
+
+// GetArtistSummaries lists artists that have tracks, optionally only
+// counting tracks on one source.
+func GetArtistSummaries(sourceID string) ([]types.ArtistSummary, error) {
+	return queryArtistSummaries("", sourceID, "", 0, 0)
+}
+
+func SearchArtists(query, sourceID string, limit, offset int) ([]types.ArtistSummary, error) {
+	return queryArtistSummaries("ar.name LIKE ?", sourceID, "%"+query+"%", limit, offset)
+}
+
+func GetArtistSummary(id string) (*types.ArtistSummary, error) {
+	artists, err := queryArtistSummaries("ar.id = ?", "", id, 0, 0)
+	if err != nil || len(artists) == 0 {
+		return nil, err
+	}
+	return &artists[0], nil
+}
+
+func queryArtistSummaries(cond, sourceID string, arg interface{}, limit, offset int) ([]types.ArtistSummary, error) {
+	var where []string
+	var args []interface{}
+	if cond != "" {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if sourceID != "" {
+		where = append(where, "t.source_id = ?")
+		args = append(args, sourceID)
+	}
+	query := `
+		SELECT ar.id, ar.name, COUNT(DISTINCT t.album_id),
+			(SELECT al.image_url FROM albums al WHERE al.artist_id = ar.id AND al.image_url <> '' LIMIT 1)
+		FROM artists ar
+		JOIN tracks t ON t.artist_id = ar.id
+	`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " GROUP BY ar.id ORDER BY ar.name COLLATE NOCASE ASC"
+	query += limitClause(limit, offset)
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	artists := []types.ArtistSummary{}
+	for rows.Next() {
+		var a types.ArtistSummary
+		if err := rows.Scan(&a.ID, &a.Name, &a.AlbumCount, &a.ImageUrl); err != nil {
+			return nil, err
+		}
+		artists = append(artists, a)
+	}
+	return artists, rows.Err()
+}
+
+func GetAlbumSummaries(q AlbumQuery) ([]types.AlbumSummary, error) {
+	return queryAlbumSummaries(q, "", nil)
+}
+
+func SearchAlbums(query, sourceID string, limit, offset int) ([]types.AlbumSummary, error) {
+	return queryAlbumSummaries(AlbumQuery{Sort: AlbumsByName, SourceID: sourceID, Limit: limit, Offset: offset},
+		"(a.name LIKE ? OR ar.name LIKE ?)", []interface{}{"%" + query + "%", "%" + query + "%"})
+}
+
+func GetAlbumSummary(id string) (*types.AlbumSummary, error) {
+	albums, err := queryAlbumSummaries(AlbumQuery{}, "a.id = ?", []interface{}{id})
+	if err != nil || len(albums) == 0 {
+		return nil, err
+	}
+	return &albums[0], nil
+}
+
+func queryAlbumSummaries(q AlbumQuery, cond string, condArgs []interface{}) ([]types.AlbumSummary, error) {
+	var joins, where []string
+	var args []interface{}
+	switch q.Sort {
+	case AlbumsRecentlyPlayed, AlbumsMostPlayed:
+		// Left join so the counts still cover the album's unplayed tracks
+		joins = append(joins, "LEFT JOIN plays p ON p.track_id = t.id AND p.user_id = ?")
+		where = append(where, "a.id IN (SELECT pt.album_id FROM plays pp JOIN tracks pt ON pt.id = pp.track_id WHERE pp.user_id = ?)")
+		args = append(args, q.UserID, q.UserID)
+	case AlbumsStarred:
+		joins = append(joins, "JOIN stars s ON s.item_id = a.id AND s.user_id = ?")
+		args = append(args, q.UserID)
+	}
+	if cond != "" {
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+	if q.ArtistID != "" {
+		where = append(where, "a.artist_id = ?")
+		args = append(args, q.ArtistID)
+	}
+	if q.SourceID != "" {
+		where = append(where, "t.source_id = ?")
+		args = append(args, q.SourceID)
+	}
+	if q.Sort == AlbumsByYear {
+		lo, hi := q.FromYear, q.ToYear
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		where = append(where, "t.year BETWEEN ? AND ?")
+		args = append(args, lo, hi)
+	}
+
+	query := `
+		SELECT a.id, a.name, a.artist_id, COALESCE(ar.name, ''), a.image_url, COUNT(DISTINCT t.id),
+			COALESCE(SUM(t.duration), 0), MAX(t.year), a.created_at
+		FROM albums a
+		LEFT JOIN artists ar ON ar.id = a.artist_id
+		JOIN tracks t ON t.album_id = a.id
+	` + strings.Join(joins, " ")
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " GROUP BY a.id ORDER BY " + albumOrder(q) + limitClause(q.Limit, q.Offset)
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := []types.AlbumSummary{}
+	for rows.Next() {
+		var a types.AlbumSummary
+		if err := rows.Scan(&a.ID, &a.Name, &a.ArtistID, &a.Artist, &a.ImageUrl, &a.TrackCount, &a.Duration, &a.Year, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		albums = append(albums, a)
+	}
+	return albums, rows.Err()
+}
+
+func albumOrder(q AlbumQuery) string {
+	switch q.Sort {
+	case AlbumsNewest:
+		return "a.created_at DESC, a.name COLLATE NOCASE ASC"
+	case AlbumsByArtist:
+		return "ar.name COLLATE NOCASE ASC, MAX(t.year) ASC, a.name COLLATE NOCASE ASC"
+	case AlbumsByYear:
+		if q.FromYear > q.ToYear {
+			return "MAX(t.year) DESC, a.name COLLATE NOCASE ASC"
+		}
+		return "MAX(t.year) ASC, a.name COLLATE NOCASE ASC"
+	case AlbumsRandom:
+		return "RANDOM()"
+	case AlbumsRecentlyPlayed:
+		return "MAX(p.last_played) DESC"
+	case AlbumsMostPlayed:
+		return "SUM(p.play_count) DESC, a.name COLLATE NOCASE ASC"
+	case AlbumsStarred:
+		return "MAX(s.created_at) DESC"
+	default:
+		return "a.name COLLATE NOCASE ASC"
+	}
+}
+
+func limitClause(limit, offset int) string {
+	if limit <= 0 {
+		if offset > 0 {
+			return fmt.Sprintf(" LIMIT -1 OFFSET %d", offset)
+		}
+		return ""
+	}
+	return fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+}
+
+// SearchTracks matches the query against title, artist and album.
+func SearchTracks(query, sourceID string, limit, offset int) ([]types.Track, error) {
+	like := "%" + query + "%"
+	q := "SELECT " + trackColumns + " FROM tracks t WHERE (t.title LIKE ? OR t.artist LIKE ? OR t.album LIKE ?)"
+	args := []interface{}{like, like, like}
+	if sourceID != "" {
+		q += " AND t.source_id = ?"
+		args = append(args, sourceID)
+	}
+	q += " ORDER BY t.title COLLATE NOCASE ASC" + limitClause(limit, offset)
+	rows, err := DB.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanTrackRows(rows)
+}
+
+// GetRandomTracks picks up to limit tracks, optionally within a year range
+// (zero bounds are open) and on one source.
+func GetRandomTracks(limit int, sourceID string, fromYear, toYear int) ([]types.Track, error) {
+	query := "SELECT " + trackColumns + " FROM tracks t WHERE 1 = 1"
+	var args []interface{}
+	if sourceID != "" {
+		query += " AND t.source_id = ?"
+		args = append(args, sourceID)
+	}
+	if fromYear > 0 {
+		query += " AND t.year >= ?"
+		args = append(args, fromYear)
+	}
+	if toYear > 0 {
+		query += " AND t.year <= ?"
+		args = append(args, toYear)
+	}
+	query += " ORDER BY RANDOM()" + limitClause(limit, 0)
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanTrackRows(rows)
+}
+
+// GetTrackBySourcePath finds a track from its path on a source, given with
+// forward slashes and without the leading one.
+func GetTrackBySourcePath(sourceID, relPath string) (*types.Track, error) {
+	rows, err := DB.Query("SELECT "+trackColumns+" FROM tracks t WHERE t.source_id = ? AND LTRIM(REPLACE(t.path, '\\', '/'), '/') = ? LIMIT 1", sourceID, relPath)
+	if err != nil {
+		return nil, err
+	}
+	tracks, err := scanTrackRows(rows)
+	if err != nil || len(tracks) == 0 {
+		return nil, err
+	}
+	return &tracks[0], nil
+}
@@ -0,0 +1,22 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB points DB at a new, migrated database file for the rest of
+// the test.
+func openTestDB(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "music.db")
+	prev := DB
+	if err := InitDB(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		DB.Close()
+		DB = prev
+	})
+	return path
+}
@@ -0,0 +1,408 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"homemusic-server/internal/types"
+)
+
+// ExportVersion is the format version of LibraryExport documents.
+const ExportVersion = 1
+
+// ErrInvalidExport is returned when an import document cannot be applied.
+var ErrInvalidExport = errors.New("invalid export")
+
+// LibraryExport is a portable copy of what users create, as opposed to
+// what scanning rebuilds. Tracks are referenced by source and path rather
+// than ID so the document still applies after a full rescan.
+type LibraryExport struct {
+	Version    int                `json:"version"`
+	ExportedAt time.Time          `json:"exportedAt"`
+	Sources    []types.Source     `json:"sources"`
+	Playlists  []ExportedPlaylist `json:"playlists"`
+	Favorites  []ExportedFavorite `json:"favorites"`
+}
+
+type TrackRef struct {
+	SourceID string `json:"sourceId"`
+	Path     string `json:"path"`
+	// Used to find the track if its file has moved
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Album  string `json:"album"`
+}
+
+type ExportedPlaylist struct {
+	Name       string                   `json:"name"`
+	Owner      string                   `json:"owner,omitempty"` // username
+	Visibility types.PlaylistVisibility `json:"visibility"`
+	Rules      *types.SmartRules        `json:"rules,omitempty"`
+	Tracks     []TrackRef               `json:"tracks,omitempty"`
+	CreatedAt  time.Time                `json:"createdAt"`
+}
+
+type ExportedFavorite struct {
+	User      string    `json:"user"` // username
+	Type      string    `json:"type"`
+	Track     *TrackRef `json:"track,omitempty"`
+	Artist    string    `json:"artist,omitempty"`
+	Album     string    `json:"album,omitempty"`
+	StarredAt time.Time `json:"starredAt"`
+}
+
+type ImportResult struct {
+	Sources   int `json:"sources"`
+	Playlists int `json:"playlists"`
+	Favorites int `json:"favorites"`
+	// Items already present, which import leaves alone
+	Skipped int `json:"skipped"`
+	// Playlist entries and favorites not found in the library
+	Missing int `json:"missing"`
+}
+
+// ExportLibrary builds an export of every source, user playlist and
+// favorite. Source passwords are left out unless credentials is set.
+func ExportLibrary(credentials bool) (*LibraryExport, error) {
+	doc := &LibraryExport{
+		Version:    ExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Sources:    []types.Source{},
+		Playlists:  []ExportedPlaylist{},
+		Favorites:  []ExportedFavorite{},
+	}
+
+	rows, err := DB.Query("SELECT id FROM sources ORDER BY created_at ASC, id ASC")
+	if err != nil {
+		return nil, err
+	}
+	var sourceIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sourceIDs = append(sourceIDs, id)
+	}
+	rows.Close()
+	for _, id := range sourceIDs {
+		s, err := GetSource(id)
+		if err != nil {
+			return nil, err
+		}
+		if !credentials {
+			s.Password = nil
+		}
+		doc.Sources = append(doc.Sources, *s)
+	}
+
+	if err := exportPlaylists(doc); err != nil {
+		return nil, err
+	}
+	if err := exportFavorites(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// exportPlaylists skips playlists read from files on a source, which the
+// next scan recreates.
+func exportPlaylists(doc *LibraryExport) error {
+	rows, err := DB.Query(`
+		SELECT p.id, p.name, u.username, p.visibility, p.smart, p.rules, p.created_at
+		FROM playlists p
+		LEFT JOIN users u ON u.id = p.owner_id
+		WHERE p.source_id IS NULL
+		ORDER BY p.created_at ASC, p.id ASC`)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		var owner *string
+		var smart bool
+		var rulesJSON sql.NullString
+		p := ExportedPlaylist{}
+		if err := rows.Scan(&id, &p.Name, &owner, &p.Visibility, &smart, &rulesJSON, &p.CreatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		if owner != nil {
+			p.Owner = *owner
+		}
+		if smart {
+			if p.Rules, err = decodeSmartRules(rulesJSON); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		ids = append(ids, id)
+		doc.Playlists = append(doc.Playlists, p)
+	}
+	rows.Close()
+
+	for i, id := range ids {
+		rows, err := DB.Query(`
+			SELECT t.source_id, t.path, t.title, t.artist, t.album
+			FROM playlist_items pi JOIN tracks t ON t.id = pi.track_id
+			WHERE pi.playlist_id = ?
+			ORDER BY pi."order" ASC`, id)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var ref TrackRef
+			if err := rows.Scan(&ref.SourceID, &ref.Path, &ref.Title, &ref.Artist, &ref.Album); err != nil {
+				rows.Close()
+				return err
+			}
+			doc.Playlists[i].Tracks = append(doc.Playlists[i].Tracks, ref)
+		}
+		rows.Close()
+	}
+	return nil
+}
+
+func exportFavorites(doc *LibraryExport) error {
+	rows, err := DB.Query(`
+		SELECT u.username, s.item_type, s.created_at,
+			t.source_id, t.path, t.title, t.artist, t.album,
+			COALESCE(al.name, ''), COALESCE(alar.name, ar.name, '')
+		FROM stars s
+		JOIN users u ON u.id = s.user_id
+		LEFT JOIN tracks t ON s.item_type = 'track' AND t.id = s.item_id
+		LEFT JOIN albums al ON s.item_type = 'album' AND al.id = s.item_id
+		LEFT JOIN artists alar ON alar.id = al.artist_id
+		LEFT JOIN artists ar ON s.item_type = 'artist' AND ar.id = s.item_id
+		ORDER BY s.created_at ASC`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f ExportedFavorite
+		var sourceID, path, title, artist, album *string
+		if err := rows.Scan(&f.User, &f.Type, &f.StarredAt, &sourceID, &path, &title, &artist, &album, &f.Album, &f.Artist); err != nil {
+			return err
+		}
+		switch f.Type {
+		case StarTrack:
+			if sourceID == nil {
+				continue
+			}
+			f.Track = &TrackRef{SourceID: *sourceID, Path: *path, Title: *title, Artist: *artist, Album: *album}
+			f.Album, f.Artist = "", ""
+		case StarAlbum, StarArtist:
+			if f.Artist == "" {
+				continue
+			}
+		}
+		doc.Favorites = append(doc.Favorites, f)
+	}
+	return rows.Err()
+}
+
+// ImportLibrary adds an export's sources, playlists and favorites in one
+// transaction. Sources that already exist, playlists with the same name and
+// owner, and existing favorites are skipped, so importing twice is safe.
+// Playlists whose owner has no account go to importer.
+func ImportLibrary(doc *LibraryExport, importer *types.User) (*ImportResult, error) {
+	if doc.Version != ExportVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidExport, doc.Version)
+	}
+	result := &ImportResult{}
+	err := withTx(func(tx *sql.Tx) error {
+		users := map[string]*string{}
+		userID := func(username string) (*string, error) {
+			if id, ok := users[username]; ok {
+				return id, nil
+			}
+			var id *string
+			err := tx.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&id)
+			if err != nil && err != sql.ErrNoRows {
+				return nil, err
+			}
+			users[username] = id
+			return id, nil
+		}
+
+		for _, s := range doc.Sources {
+			if s.ID == "" {
+				s.ID = uuid.New().String()
+			}
+			var exists int
+			if err := tx.QueryRow("SELECT COUNT(*) FROM sources WHERE id = ?", s.ID).Scan(&exists); err != nil {
+				return err
+			}
+			if exists > 0 {
+				result.Skipped++
+				continue
+			}
+			_, err := tx.Exec(`INSERT INTO sources (id, name, type, host, port, username, password, domain, share, base_path, enabled, max_streams, max_bandwidth)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				s.ID, s.Name, s.Type, s.Host, s.Port, s.Username, s.Password, s.Domain, s.Share, s.BasePath, s.Enabled, s.MaxStreams, s.MaxBandwidth)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec("INSERT INTO source_status (source_id, status) VALUES (?, ?)", s.ID, "starting"); err != nil {
+				return err
+			}
+			result.Sources++
+		}
+
+		for _, p := range doc.Playlists {
+			var ownerID *string
+			if p.Owner != "" {
+				id, err := userID(p.Owner)
+				if err != nil {
+					return err
+				}
+				ownerID = id
+				if ownerID == nil {
+					ownerID = &importer.ID
+				}
+			}
+			var exists int
+			err := tx.QueryRow("SELECT COUNT(*) FROM playlists WHERE name = ? AND owner_id IS ? AND source_id IS NULL", p.Name, ownerID).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if exists > 0 {
+				result.Skipped++
+				continue
+			}
+			if err := importPlaylist(tx, p, ownerID, result); err != nil {
+				return fmt.Errorf("playlist %q: %w", p.Name, err)
+			}
+			result.Playlists++
+		}
+
+		for _, f := range doc.Favorites {
+			id, err := userID(f.User)
+			if err != nil {
+				return err
+			}
+			if id == nil {
+				result.Skipped++
+				continue
+			}
+			itemID, err := resolveFavorite(tx, f)
+			if err != nil {
+				return err
+			}
+			if itemID == "" {
+				result.Missing++
+				continue
+			}
+			res, err := tx.Exec("INSERT OR IGNORE INTO stars (user_id, item_id, item_type, created_at) VALUES (?, ?, ?, ?)",
+				*id, itemID, f.Type, f.StarredAt)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				result.Skipped++
+			} else {
+				result.Favorites++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func importPlaylist(tx *sql.Tx, p ExportedPlaylist, ownerID *string, result *ImportResult) error {
+	switch p.Visibility {
+	case types.VisibilityPrivate, types.VisibilityShared, types.VisibilityPublic:
+	default:
+		p.Visibility = types.VisibilityPrivate
+	}
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = time.Now()
+	}
+	id := uuid.New().String()
+
+	if p.Rules != nil {
+		if err := ValidateSmartRules(p.Rules); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidExport, err)
+		}
+		rulesJSON, err := json.Marshal(p.Rules)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO playlists (id, name, smart, rules, owner_id, visibility, created_at, updated_at) VALUES (?, ?, 1, ?, ?, ?, ?, ?)",
+			id, p.Name, string(rulesJSON), ownerID, p.Visibility, p.CreatedAt, time.Now())
+		return err
+	}
+
+	_, err := tx.Exec("INSERT INTO playlists (id, name, owner_id, visibility, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		id, p.Name, ownerID, p.Visibility, p.CreatedAt, time.Now())
+	if err != nil {
+		return err
+	}
+	order := 0
+	for _, ref := range p.Tracks {
+		trackID, err := resolveTrack(tx, ref)
+		if err != nil {
+			return err
+		}
+		if trackID == "" {
+			result.Missing++
+			continue
+		}
+		_, err = tx.Exec(`INSERT INTO playlist_items (id, playlist_id, track_id, "order", created_at) VALUES (?, ?, ?, ?, ?)`,
+			uuid.New().String(), id, trackID, order, time.Now())
+		if err != nil {
+			return err
+		}
+		order++
+	}
+	return nil
+}
+
+// resolveTrack finds a referenced track by its file, then by its tags.
+// It returns "" when the library has no such track.
+func resolveTrack(tx *sql.Tx, ref TrackRef) (string, error) {
+	var id string
+	err := tx.QueryRow("SELECT id FROM tracks WHERE source_id = ? AND path = ?", ref.SourceID, ref.Path).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(`SELECT id FROM tracks
+			WHERE title = ? COLLATE NOCASE AND artist = ? COLLATE NOCASE AND album = ? COLLATE NOCASE
+			ORDER BY source_id = ? DESC LIMIT 1`, ref.Title, ref.Artist, ref.Album, ref.SourceID).Scan(&id)
+	}
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+func resolveFavorite(tx *sql.Tx, f ExportedFavorite) (string, error) {
+	var id string
+	var err error
+	switch f.Type {
+	case StarTrack:
+		if f.Track == nil {
+			return "", nil
+		}
+		return resolveTrack(tx, *f.Track)
+	case StarAlbum:
+		err = tx.QueryRow(`SELECT a.id FROM albums a JOIN artists ar ON ar.id = a.artist_id
+			WHERE a.name = ? AND ar.name = ?`, f.Album, f.Artist).Scan(&id)
+	case StarArtist:
+		err = tx.QueryRow("SELECT id FROM artists WHERE name = ?", f.Artist).Scan(&id)
+	default:
+		return "", fmt.Errorf("%w: unknown favorite type %q", ErrInvalidExport, f.Type)
+	}
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
@@ -43,9 +43,10 @@ func GetArtist(id string) (*types.Artist, error) {
 	return &a, err
 }
 
-func GetAllAlbums() ([]map[string]interface{}, error) {
+func GetAllAlbums() ([]types.AlbumSummary, error) {
 	query := `
-		SELECT a.id, a.name, ar.name as artist_name, a.image_url, COUNT(t.id) as track_count
+		SELECT a.id, a.name, a.artist_id, COALESCE(ar.name, ''), a.image_url, COUNT(t.id),
+			COALESCE(SUM(t.duration), 0), MAX(t.year), a.created_at
 		FROM albums a
 		LEFT JOIN artists ar ON a.artist_id = ar.id
 		LEFT JOIN tracks t ON a.id = t.album_id
@@ -58,38 +59,27 @@ func GetAllAlbums() ([]map[string]interface{}, error) {
 	}
 	defer rows.Close()
 
-	albums := []map[string]interface{}{}
+	albums := []types.AlbumSummary{}
 	for rows.Next() {
-		var id, name, artistName string
-		var imageUrl sql.NullString
-		var trackCount int
-		err := rows.Scan(&id, &name, &artistName, &imageUrl, &trackCount)
+		var a types.AlbumSummary
+		err := rows.Scan(&a.ID, &a.Name, &a.ArtistID, &a.Artist, &a.ImageUrl, &a.TrackCount, &a.Duration, &a.Year, &a.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
-		
-		album := map[string]interface{}{
-			"id":         id,
-			"name":       name,
-			"artist":     artistName,
-			"trackCount": trackCount,
-		}
-		if imageUrl.Valid {
-			album["imageUrl"] = imageUrl.String
-		}
-		albums = append(albums, album)
+		albums = append(albums, a)
 	}
 	return albums, nil
 }
 
-func GetAlbum(id string) (map[string]interface{}, error) {
-	var albumName, artistName string
-	var imageUrl sql.NullString
+func GetAlbum(id string) (*types.AlbumDetail, error) {
+	album := &types.AlbumDetail{}
+	a := &album.AlbumSummary
+	a.ID = id
 	err := DB.QueryRow(`
-		SELECT a.name, ar.name as artist_name, a.image_url
+		SELECT a.name, a.artist_id, ar.name as artist_name, a.image_url, a.created_at
 		FROM albums a
 		JOIN artists ar ON a.artist_id = ar.id
-		WHERE a.id = ?`, id).Scan(&albumName, &artistName, &imageUrl)
+		WHERE a.id = ?`, id).Scan(&a.Name, &a.ArtistID, &a.Artist, &a.ImageUrl, &a.CreatedAt)
 	
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -98,22 +88,18 @@ func GetAlbum(id string) (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	tracks, err := GetTracksByAlbum(id)
+	album.Tracks, err = GetTracksByAlbum(id)
 	if err != nil {
 		return nil, err
 	}
-
-	result := map[string]interface{}{
-		"id":     id,
-		"name":   albumName,
-		"artist": map[string]string{"name": artistName},
-		"tracks": tracks,
-	}
-	if imageUrl.Valid {
-		result["imageUrl"] = imageUrl.String
+	a.TrackCount = len(album.Tracks)
+	for _, t := range album.Tracks {
+		a.Duration += t.Duration
+		if t.Year != nil && (a.Year == nil || *t.Year > *a.Year) {
+			a.Year = t.Year
+		}
 	}
-	
-	return result, nil
+	return album, nil
 }
 
 func GetAllTracks() ([]types.Track, error) {
@@ -154,7 +140,7 @@ func GetTracksByAlbum(albumID string) ([]types.Track, error) {
 	return tracks, nil
 }
 
-func GetFolders() ([]map[string]interface{}, error) {
+func GetFolders() ([]types.Folder, error) {
 	query := `
 		SELECT folder_path, COUNT(id) as track_count
 		FROM tracks
@@ -168,29 +154,23 @@ func GetFolders() ([]map[string]interface{}, error) {
 	}
 	defer rows.Close()
 
-	folders := []map[string]interface{}{}
+	folders := []types.Folder{}
 	for rows.Next() {
-		var path string
-		var count int
-		if err := rows.Scan(&path, &count); err != nil {
+		var f types.Folder
+		if err := rows.Scan(&f.ID, &f.TrackCount); err != nil {
 			return nil, err
 		}
+		f.Name = filepath.Base(f.ID)
 		
 		// Find a sample track from this folder to get an image
-		var imageUrl sql.NullString
 		DB.QueryRow(`
 			SELECT COALESCE(t.image_url, a.image_url) 
 			FROM tracks t 
 			LEFT JOIN albums a ON t.album_id = a.id 
 			WHERE t.folder_path = ? AND (t.image_url IS NOT NULL OR a.image_url IS NOT NULL) 
-			LIMIT 1`, path).Scan(&imageUrl)
+			LIMIT 1`, f.ID).Scan(&f.ImageUrl)
 
-		folders = append(folders, map[string]interface{}{
-			"id":         path,
-			"name":       filepath.Base(path),
-			"trackCount": count,
-			"imageUrl":   imageUrl.String,
-		})
+		folders = append(folders, f)
 	}
 	return folders, nil
 }
@@ -213,3 +193,22 @@ func GetTracksByFolder(path string) ([]types.Track, error) {
 	}
 	return tracks, nil
 }
+
+func GetTracksBySource(sourceID string) ([]types.Track, error) {
+	rows, err := DB.Query("SELECT id, title, artist, album, duration, track_number, year, path, folder_path, image_url, source_mtime, artists_display, source_id, album_id, artist_id, created_at FROM tracks WHERE source_id = ?", sourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := []types.Track{}
+	for rows.Next() {
+		var t types.Track
+		err := rows.Scan(&t.ID, &t.Title, &t.Artist, &t.Album, &t.Duration, &t.TrackNumber, &t.Year, &t.Path, &t.FolderPath, &t.ImageUrl, &t.SourceMtime, &t.ArtistsDisplay, &t.SourceID, &t.AlbumID, &t.ArtistID, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, nil
+}
@@ -0,0 +1,398 @@
+// Package memstore keeps sources, the library and playlists in memory. It
+// implements the db store interfaces so handlers and the scanner can be
+// tested without a database file.
+package memstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/types"
+)
+
+// Store is safe for concurrent use. Smart playlists are kept but match no
+// tracks, since their rules are evaluated in SQL.
+type Store struct {
+	mu        sync.Mutex
+	sources   map[string]types.Source
+	status    map[string]types.SourceStatus
+	users     map[string]types.User
+	artists   map[string]types.Artist
+	albums    map[string]types.Album
+	tracks    []types.Track
+	playlists map[string]*playlist
+	follows   map[follow]bool
+
+	// Files staged by running scans, by source
+	staged map[string][]db.ScannedTrack
+	// Audio checksums of scanned tracks, by track ID
+	hashes map[string]string
+}
+
+type playlist struct {
+	types.Playlist
+	items         []types.PlaylistItem
+	collaborators map[string]types.PlaylistCollaborator
+}
+
+type follow struct {
+	userID     string
+	playlistID string
+}
+
+func New() *Store {
+	return &Store{
+		sources:   make(map[string]types.Source),
+		status:    make(map[string]types.SourceStatus),
+		users:     make(map[string]types.User),
+		artists:   make(map[string]types.Artist),
+		albums:    make(map[string]types.Album),
+		playlists: make(map[string]*playlist),
+		follows:   make(map[follow]bool),
+		staged:    make(map[string][]db.ScannedTrack),
+		hashes:    make(map[string]string),
+	}
+}
+
+// Stores returns s as every store the server uses.
+func (s *Store) Stores() *db.Store {
+	return &db.Store{Sources: s, Status: s, Library: s, Playlists: s, Users: s, Scans: s}
+}
+
+// AddUser makes u known as a playlist owner and collaborator.
+func (s *Store) AddUser(u types.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.ID] = u
+}
+
+func (s *Store) GetUserByUsername(username string) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == username {
+			return &u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *Store) AddArtist(a types.Artist) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.artists[a.ID] = a
+}
+
+func (s *Store) AddAlbum(a types.Album) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.albums[a.ID] = a
+}
+
+// AddTrack adds t to the library as a scan would.
+func (s *Store) AddTrack(t types.Track) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
+	}
+	s.tracks = append(s.tracks, t)
+}
+
+func (s *Store) GetAllSources() ([]types.SourceSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sources := []types.SourceSummary{}
+	for _, src := range s.sources {
+		sources = append(sources, types.SourceSummary{Source: src.Redacted(), Status: s.status[src.ID]})
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+	return sources, nil
+}
+
+// GetSourceList lists the sources without credentials, oldest first.
+func (s *Store) GetSourceList() ([]types.Source, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sources := []types.Source{}
+	for _, src := range s.sources {
+		sources = append(sources, types.Source{ID: src.ID, Name: src.Name, Type: src.Type, Enabled: src.Enabled, CreatedAt: src.CreatedAt, UpdatedAt: src.UpdatedAt})
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if !sources[i].CreatedAt.Equal(sources[j].CreatedAt) {
+			return sources[i].CreatedAt.Before(sources[j].CreatedAt)
+		}
+		return sources[i].ID < sources[j].ID
+	})
+	return sources, nil
+}
+
+func (s *Store) GetSource(id string) (*types.Source, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, ok := s.sources[id]
+	if !ok {
+		return nil, nil
+	}
+	return &src, nil
+}
+
+func (s *Store) CreateSource(src types.Source) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sources[src.ID]; ok {
+		return fmt.Errorf("source %s already exists", src.ID)
+	}
+	s.sources[src.ID] = src
+	s.status[src.ID] = types.SourceStatus{SourceID: src.ID, Status: "starting"}
+	return nil
+}
+
+func (s *Store) UpdateSource(src types.Source) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.sources[src.ID]
+	if !ok {
+		return nil
+	}
+	src.CreatedAt = old.CreatedAt
+	src.UpdatedAt = time.Now()
+	s.sources[src.ID] = src
+	return nil
+}
+
+func (s *Store) DeleteSource(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sources, id)
+	delete(s.status, id)
+	return nil
+}
+
+func (s *Store) GetSourceStatus(sourceID string) (*types.SourceStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.status[sourceID]
+	if !ok {
+		return nil, nil
+	}
+	return &st, nil
+}
+
+func (s *Store) UpdateSourceStatus(st types.SourceStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.status[st.SourceID]
+	if !ok {
+		return nil
+	}
+	st.LastScan = old.LastScan
+	s.status[st.SourceID] = st
+	return nil
+}
+
+func (s *Store) GetTrack(id string) (*types.Track, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.track(id)
+	if !ok {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+// GetTrackBySourcePath matches relPath against the track paths with
+// forward slashes and no leading slash, as the SQLite store does.
+func (s *Store) GetTrackBySourcePath(sourceID, relPath string) (*types.Track, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tracks {
+		if t.SourceID == sourceID && strings.TrimLeft(strings.ReplaceAll(t.Path, "\\", "/"), "/") == relPath {
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *Store) GetAllTracks() ([]types.Track, error) {
+	tracks := s.filterTracks(func(types.Track) bool { return true })
+	sort.SliceStable(tracks, func(i, j int) bool { return tracks[i].CreatedAt.After(tracks[j].CreatedAt) })
+	return tracks, nil
+}
+
+func (s *Store) GetTracksByAlbum(albumID string) ([]types.Track, error) {
+	tracks := s.filterTracks(func(t types.Track) bool { return t.AlbumID != nil && *t.AlbumID == albumID })
+	sort.SliceStable(tracks, func(i, j int) bool { return trackNumber(tracks[i]) < trackNumber(tracks[j]) })
+	return tracks, nil
+}
+
+func (s *Store) GetTracksByFolder(path string) ([]types.Track, error) {
+	tracks := s.filterTracks(func(t types.Track) bool { return t.FolderPath != nil && *t.FolderPath == path })
+	sort.SliceStable(tracks, func(i, j int) bool {
+		if a, b := trackNumber(tracks[i]), trackNumber(tracks[j]); a != b {
+			return a < b
+		}
+		return tracks[i].Title < tracks[j].Title
+	})
+	return tracks, nil
+}
+
+func (s *Store) GetTracksBySource(sourceID string) ([]types.Track, error) {
+	return s.filterTracks(func(t types.Track) bool { return t.SourceID == sourceID }), nil
+}
+
+func (s *Store) GetAllAlbums() ([]types.AlbumSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	albums := []types.AlbumSummary{}
+	for _, a := range s.albums {
+		albums = append(albums, s.albumSummary(a))
+	}
+	sort.Slice(albums, func(i, j int) bool { return albums[i].Name < albums[j].Name })
+	return albums, nil
+}
+
+func (s *Store) GetAlbum(id string) (*types.AlbumDetail, error) {
+	s.mu.Lock()
+	a, ok := s.albums[id]
+	_, hasArtist := s.artists[a.ArtistID]
+	var summary types.AlbumSummary
+	if ok {
+		summary = s.albumSummary(a)
+	}
+	s.mu.Unlock()
+	if !ok || !hasArtist {
+		return nil, nil
+	}
+
+	tracks, err := s.GetTracksByAlbum(id)
+	if err != nil {
+		return nil, err
+	}
+	return &types.AlbumDetail{AlbumSummary: summary, Tracks: tracks}, nil
+}
+
+func (s *Store) albumSummary(a types.Album) types.AlbumSummary {
+	summary := types.AlbumSummary{
+		ID:        a.ID,
+		Name:      a.Name,
+		ArtistID:  a.ArtistID,
+		Artist:    s.artists[a.ArtistID].Name,
+		ImageUrl:  a.ImageUrl,
+		CreatedAt: a.CreatedAt,
+	}
+	for _, t := range s.tracks {
+		if t.AlbumID == nil || *t.AlbumID != a.ID {
+			continue
+		}
+		summary.TrackCount++
+		summary.Duration += t.Duration
+		if t.Year != nil && (summary.Year == nil || *t.Year > *summary.Year) {
+			summary.Year = t.Year
+		}
+	}
+	return summary
+}
+
+// GetAllArtists lists artists with tracks, one per name.
+func (s *Store) GetAllArtists() ([]types.Artist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := map[string]bool{}
+	artists := []types.Artist{}
+	for _, t := range s.tracks {
+		if t.ArtistID == nil {
+			continue
+		}
+		a, ok := s.artists[*t.ArtistID]
+		key := strings.ToUpper(strings.TrimSpace(a.Name))
+		if !ok || names[key] {
+			continue
+		}
+		names[key] = true
+		artists = append(artists, a)
+	}
+	sort.Slice(artists, func(i, j int) bool { return artists[i].Name < artists[j].Name })
+	return artists, nil
+}
+
+func (s *Store) GetArtist(id string) (*types.Artist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.artists[id]
+	if !ok {
+		return nil, nil
+	}
+	return &a, nil
+}
+
+func (s *Store) GetFolders() ([]types.Folder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byPath := map[string]*types.Folder{}
+	for _, t := range s.tracks {
+		if t.FolderPath == nil {
+			continue
+		}
+		path := *t.FolderPath
+		f, ok := byPath[path]
+		if !ok {
+			f = &types.Folder{ID: path, Name: filepath.Base(path)}
+			byPath[path] = f
+		}
+		f.TrackCount++
+		if f.ImageUrl != nil {
+			continue
+		}
+		if t.ImageUrl != nil {
+			f.ImageUrl = t.ImageUrl
+		} else if t.AlbumID != nil {
+			f.ImageUrl = s.albums[*t.AlbumID].ImageUrl
+		}
+	}
+
+	folders := make([]types.Folder, 0, len(byPath))
+	for _, f := range byPath {
+		folders = append(folders, *f)
+	}
+	sort.Slice(folders, func(i, j int) bool { return folders[i].ID < folders[j].ID })
+	return folders, nil
+}
+
+func (s *Store) filterTracks(keep func(types.Track) bool) []types.Track {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tracks := []types.Track{}
+	for _, t := range s.tracks {
+		if keep(t) {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks
+}
+
+func (s *Store) track(id string) (types.Track, bool) {
+	for _, t := range s.tracks {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return types.Track{}, false
+}
+
+func trackNumber(t types.Track) int {
+	if t.TrackNumber == nil {
+		return -1
+	}
+	return *t.TrackNumber
+}
@@ -0,0 +1,476 @@
+package memstore
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/types"
+)
+
+func (s *Store) GetAllPlaylists(user *types.User) ([]types.PlaylistSummary, error) {
+	return s.listPlaylists(user, func(p *playlist, following bool) bool {
+		_, collaborator := p.collaborators[user.ID]
+		return p.OwnerID == nil || *p.OwnerID == user.ID || collaborator || following
+	})
+}
+
+func (s *Store) GetFollowedPlaylists(user *types.User) ([]types.PlaylistSummary, error) {
+	return s.listPlaylists(user, func(p *playlist, following bool) bool {
+		return following
+	})
+}
+
+func (s *Store) GetPublicPlaylists(user *types.User) ([]types.PlaylistSummary, error) {
+	return s.listPlaylists(user, func(p *playlist, following bool) bool {
+		return p.OwnerID != nil && p.Visibility == types.VisibilityPublic
+	})
+}
+
+func (s *Store) listPlaylists(user *types.User, include func(p *playlist, following bool) bool) ([]types.PlaylistSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matched []*playlist
+	for _, p := range s.playlists {
+		if include(p, s.follows[follow{user.ID, p.ID}]) {
+			matched = append(matched, p)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Name < matched[j].Name })
+
+	playlists := []types.PlaylistSummary{}
+	for _, p := range matched {
+		summary := s.summary(p)
+		summary.Access = s.access(p, user)
+		if summary.Access == db.AccessNone {
+			continue
+		}
+		summary.Following = s.follows[follow{user.ID, p.ID}]
+		summary.TrackCount = len(p.items)
+		for _, item := range p.items {
+			if t, ok := s.track(item.TrackID); ok {
+				summary.Duration += t.Duration
+			}
+		}
+		playlists = append(playlists, summary)
+	}
+	return playlists, nil
+}
+
+func (s *Store) GetPlaylist(id string) (*types.PlaylistDetail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.playlists[id]
+	if !ok {
+		return nil, nil
+	}
+
+	detail := &types.PlaylistDetail{
+		PlaylistSummary: s.summary(p),
+		Tracks:          []types.Track{},
+		Items:           []types.PlaylistItem{},
+	}
+	if !p.Smart {
+		for _, item := range p.items {
+			t, ok := s.track(item.TrackID)
+			if !ok {
+				continue
+			}
+			detail.Tracks = append(detail.Tracks, t)
+			detail.Items = append(detail.Items, item)
+		}
+	}
+	detail.SummarizeTracks()
+	return detail, nil
+}
+
+func (s *Store) summary(p *playlist) types.PlaylistSummary {
+	return types.PlaylistSummary{
+		Playlist: p.Playlist,
+		ReadOnly: p.Smart || p.SourceID != nil,
+		Owner:    s.ownerName(p),
+	}
+}
+
+func (s *Store) CreatePlaylist(name, ownerID string) (*types.Playlist, error) {
+	return s.create(types.Playlist{Name: name, OwnerID: &ownerID}), nil
+}
+
+func (s *Store) CreateSmartPlaylist(name, ownerID string, rules *types.SmartRules) (*types.Playlist, error) {
+	if err := db.ValidateSmartRules(rules); err != nil {
+		return nil, err
+	}
+	return s.create(types.Playlist{Name: name, OwnerID: &ownerID, Smart: true, Rules: rules}), nil
+}
+
+func (s *Store) create(p types.Playlist) *types.Playlist {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.ID = uuid.New().String()
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = p.CreatedAt
+	p.Visibility = types.VisibilityPrivate
+	s.playlists[p.ID] = &playlist{Playlist: p, collaborators: map[string]types.PlaylistCollaborator{}}
+	return &p
+}
+
+func (s *Store) UpdateSmartPlaylistRules(id string, rules *types.SmartRules) error {
+	if err := db.ValidateSmartRules(rules); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.playlists[id]
+	if !ok {
+		return db.ErrNotFound
+	}
+	if !p.Smart {
+		return fmt.Errorf("%w: not a smart playlist", db.ErrInvalidSmartRules)
+	}
+	p.Rules = rules
+	p.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *Store) RenamePlaylist(id, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.playlists[id]
+	if !ok {
+		return db.ErrNotFound
+	}
+	if p.SourceID != nil {
+		return db.ErrPlaylistReadOnly
+	}
+	p.Name = name
+	p.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *Store) DeletePlaylist(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.playlists, id)
+	for f := range s.follows {
+		if f.playlistID == id {
+			delete(s.follows, f)
+		}
+	}
+	return nil
+}
+
+func (s *Store) AddTracksToPlaylist(playlistID string, trackIDs []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.editable(playlistID)
+	if err != nil {
+		return 0, err
+	}
+	return s.appendTracks(p, trackIDs), nil
+}
+
+func (s *Store) ReplacePlaylistTracks(playlistID string, trackIDs []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.editable(playlistID)
+	if err != nil {
+		return 0, err
+	}
+	p.items = nil
+	return s.appendTracks(p, trackIDs), nil
+}
+
+// appendTracks adds the tracks that exist to the end of p.
+func (s *Store) appendTracks(p *playlist, trackIDs []string) int {
+	added := 0
+	for _, trackID := range trackIDs {
+		if _, ok := s.track(trackID); !ok {
+			continue
+		}
+		p.items = append(p.items, types.PlaylistItem{
+			ID:         uuid.New().String(),
+			PlaylistID: p.ID,
+			TrackID:    trackID,
+			Order:      len(p.items),
+			CreatedAt:  time.Now(),
+		})
+		added++
+	}
+	return added
+}
+
+func (s *Store) RemoveTrackFromPlaylist(playlistID, trackID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.editable(playlistID)
+	if err != nil {
+		return err
+	}
+	kept := p.items[:0]
+	for _, item := range p.items {
+		if item.TrackID != trackID {
+			kept = append(kept, item)
+		}
+	}
+	p.setItems(kept)
+	return nil
+}
+
+func (s *Store) RemovePlaylistItem(playlistID, itemID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.editable(playlistID)
+	if err != nil {
+		return err
+	}
+	i := p.itemIndex(itemID)
+	if i == -1 {
+		return db.ErrNotFound
+	}
+	p.setItems(append(p.items[:i], p.items[i+1:]...))
+	return nil
+}
+
+func (s *Store) MovePlaylistItem(playlistID, itemID string, position int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.editable(playlistID)
+	if err != nil {
+		return err
+	}
+	from := p.itemIndex(itemID)
+	if from == -1 {
+		return db.ErrNotFound
+	}
+	if position < 0 {
+		position = 0
+	}
+	if position >= len(p.items) {
+		position = len(p.items) - 1
+	}
+	item := p.items[from]
+	items := append(p.items[:from:from], p.items[from+1:]...)
+	items = append(items[:position], append([]types.PlaylistItem{item}, items[position:]...)...)
+	p.setItems(items)
+	return nil
+}
+
+func (s *Store) ClearPlaylist(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.editable(id)
+	if err != nil {
+		return err
+	}
+	p.items = nil
+	return nil
+}
+
+func (s *Store) GetPlaylistAccess(playlistID string, user *types.User) (db.PlaylistAccess, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.playlists[playlistID]
+	if !ok {
+		return db.AccessNone, db.ErrNotFound
+	}
+	return s.access(p, user), nil
+}
+
+func (s *Store) SetPlaylistVisibility(playlistID string, visibility types.PlaylistVisibility) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.playlists[playlistID]
+	if !ok {
+		return db.ErrNotFound
+	}
+	p.Visibility = visibility
+	p.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *Store) GetPlaylistCollaborators(playlistID string) ([]types.PlaylistCollaborator, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	collaborators := []types.PlaylistCollaborator{}
+	if p, ok := s.playlists[playlistID]; ok {
+		for _, c := range p.collaborators {
+			if u, ok := s.users[c.UserID]; ok {
+				c.Username = u.Username
+				collaborators = append(collaborators, c)
+			}
+		}
+	}
+	sort.Slice(collaborators, func(i, j int) bool { return collaborators[i].Username < collaborators[j].Username })
+	return collaborators, nil
+}
+
+func (s *Store) SetPlaylistCollaborator(playlistID, userID string, permission types.CollaboratorPermission) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.playlists[playlistID]
+	if !ok {
+		return db.ErrNotFound
+	}
+	if _, known := s.users[userID]; !known || (p.OwnerID != nil && *p.OwnerID == userID) {
+		return db.ErrInvalidCollaborator
+	}
+	c, ok := p.collaborators[userID]
+	if !ok {
+		c = types.PlaylistCollaborator{UserID: userID, CreatedAt: time.Now()}
+	}
+	c.Permission = permission
+	p.collaborators[userID] = c
+	return nil
+}
+
+func (s *Store) RemovePlaylistCollaborator(playlistID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.playlists[playlistID]
+	if !ok {
+		return db.ErrNotFound
+	}
+	if _, ok := p.collaborators[userID]; !ok {
+		return db.ErrNotFound
+	}
+	delete(p.collaborators, userID)
+	return nil
+}
+
+func (s *Store) FollowPlaylist(userID, playlistID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.follows[follow{userID, playlistID}] = true
+	return nil
+}
+
+func (s *Store) UnfollowPlaylist(userID, playlistID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.follows, follow{userID, playlistID})
+	return nil
+}
+
+func (s *Store) IsFollowingPlaylist(userID, playlistID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.follows[follow{userID, playlistID}], nil
+}
+
+func (s *Store) SyncSourcePlaylist(sourceID, sourcePath, name string, trackIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var p *playlist
+	for _, existing := range s.playlists {
+		if existing.SourceID != nil && *existing.SourceID == sourceID && *existing.SourcePath == sourcePath {
+			p = existing
+			break
+		}
+	}
+	now := time.Now()
+	if p == nil {
+		p = &playlist{
+			Playlist: types.Playlist{
+				ID:         uuid.New().String(),
+				CreatedAt:  now,
+				SourceID:   &sourceID,
+				SourcePath: &sourcePath,
+				Visibility: types.VisibilityShared,
+			},
+			collaborators: map[string]types.PlaylistCollaborator{},
+		}
+		s.playlists[p.ID] = p
+	}
+	p.Name = name
+	p.UpdatedAt = now
+
+	p.items = nil
+	for i, trackID := range trackIDs {
+		p.items = append(p.items, types.PlaylistItem{
+			ID:         uuid.New().String(),
+			PlaylistID: p.ID,
+			TrackID:    trackID,
+			Order:      i,
+			CreatedAt:  now,
+		})
+	}
+	return nil
+}
+
+func (s *Store) DeleteStaleSourcePlaylists(sourceID string, seenPaths []string) error {
+	seen := map[string]bool{}
+	for _, path := range seenPaths {
+		seen[path] = true
+	}
+	s.mu.Lock()
+	var stale []string
+	for id, p := range s.playlists {
+		if p.SourceID != nil && *p.SourceID == sourceID && !seen[*p.SourcePath] {
+			stale = append(stale, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, id := range stale {
+		if err := s.DeletePlaylist(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// editable returns a playlist whose items may be changed, as lockPlaylist
+// does for SQLite.
+func (s *Store) editable(id string) (*playlist, error) {
+	p, ok := s.playlists[id]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	if p.Smart || p.SourceID != nil {
+		return nil, db.ErrPlaylistReadOnly
+	}
+	p.UpdatedAt = time.Now()
+	return p, nil
+}
+
+func (s *Store) access(p *playlist, user *types.User) db.PlaylistAccess {
+	var permission *string
+	if user != nil {
+		if c, ok := p.collaborators[user.ID]; ok {
+			perm := string(c.Permission)
+			permission = &perm
+		}
+	}
+	return db.PlaylistAccessFor(user, p.OwnerID, string(p.Visibility), permission)
+}
+
+func (s *Store) ownerName(p *playlist) *string {
+	if p.OwnerID == nil {
+		return nil
+	}
+	u, ok := s.users[*p.OwnerID]
+	if !ok {
+		return nil
+	}
+	return &u.Username
+}
+
+func (p *playlist) itemIndex(itemID string) int {
+	for i, item := range p.items {
+		if item.ID == itemID {
+			return i
+		}
+	}
+	return -1
+}
+
+// setItems replaces the items, numbering them 0..n-1.
+func (p *playlist) setItems(items []types.PlaylistItem) {
+	for i := range items {
+		items[i].Order = i
+	}
+	p.items = items
+}
@@ -0,0 +1,182 @@
+package memstore
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/types"
+)
+
+func (s *Store) KnownFiles(sourceID string) (map[string]db.KnownFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	known := map[string]db.KnownFile{}
+	for _, t := range s.tracks {
+		hash := s.hashes[t.ID]
+		if t.SourceID != sourceID || hash == "" {
+			continue
+		}
+		k := db.KnownFile{ContentHash: hash}
+		if t.SourceMtime != nil {
+			k.Mtime = *t.SourceMtime
+		}
+		known[t.Path] = k
+	}
+	return known, nil
+}
+
+func (s *Store) ClearStagedTracks(sourceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.staged, sourceID)
+	return nil
+}
+
+// StageTracks replaces staged files with the same path, as the SQLite
+// store does.
+func (s *Store) StageTracks(sourceID string, tracks []db.ScannedTrack) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	staged := s.staged[sourceID]
+	for _, t := range tracks {
+		i := sort.Search(len(staged), func(i int) bool { return staged[i].Path >= t.Path })
+		if i < len(staged) && staged[i].Path == t.Path {
+			staged[i] = t
+			continue
+		}
+		staged = append(staged, db.ScannedTrack{})
+		copy(staged[i+1:], staged[i:])
+		staged[i] = t
+	}
+	s.staged[sourceID] = staged
+	return nil
+}
+
+func (s *Store) CommitScan(sourceID string, seen map[string]bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.staged[sourceID] {
+		artistID := s.artistNamed(t.Artist)
+		albumID := s.albumNamed(t.Album, artistID)
+		if album := s.albums[albumID]; t.ImageURL != nil && *t.ImageURL != "" && (album.ImageUrl == nil || *album.ImageUrl == "") {
+			album.ImageUrl = t.ImageURL
+			s.albums[albumID] = album
+		}
+
+		mtime := t.Mtime
+		folderPath, artistsDisplay := t.FolderPath, t.ArtistsDisplay
+		track := types.Track{
+			Title:          t.Title,
+			Artist:         t.Artist,
+			Album:          t.Album,
+			Duration:       t.Duration,
+			TrackNumber:    t.TrackNumber,
+			Year:           t.Year,
+			Path:           t.Path,
+			FolderPath:     &folderPath,
+			ImageUrl:       t.ImageURL,
+			SourceMtime:    &mtime,
+			ArtistsDisplay: &artistsDisplay,
+			SourceID:       sourceID,
+			AlbumID:        &albumID,
+			ArtistID:       &artistID,
+		}
+		if i := s.scannedTrack(sourceID, t, seen); i >= 0 {
+			track.ID, track.CreatedAt = s.tracks[i].ID, s.tracks[i].CreatedAt
+			s.tracks[i] = track
+		} else {
+			track.ID, track.CreatedAt = uuid.New().String(), time.Now()
+			s.tracks = append(s.tracks, track)
+		}
+		if t.ContentHash != "" {
+			s.hashes[track.ID] = t.ContentHash
+		} else {
+			delete(s.hashes, track.ID)
+		}
+	}
+	delete(s.staged, sourceID)
+
+	// Tracks whose files have gone go, with their playlist items
+	kept := s.tracks[:0]
+	for _, t := range s.tracks {
+		if t.SourceID != sourceID || seen[t.Path] {
+			kept = append(kept, t)
+			continue
+		}
+		delete(s.hashes, t.ID)
+		for _, p := range s.playlists {
+			items := p.items[:0]
+			for _, item := range p.items {
+				if item.TrackID != t.ID {
+					items = append(items, item)
+				}
+			}
+			p.setItems(items)
+		}
+	}
+	s.tracks = kept
+
+	if st, ok := s.status[sourceID]; ok {
+		now := time.Now()
+		st.LastScan = &now
+		s.status[sourceID] = st
+	}
+	return nil
+}
+
+// scannedTrack returns the index of the track t already is: the one at the
+// same path or, for a moved file, one whose path was not seen in the scan
+// and whose audio or tags match. It returns -1 for a new file.
+func (s *Store) scannedTrack(sourceID string, t db.ScannedTrack, seen map[string]bool) int {
+	for i, old := range s.tracks {
+		if old.SourceID == sourceID && old.Path == t.Path {
+			return i
+		}
+	}
+	moved := func(match func(types.Track) bool) int {
+		for i, old := range s.tracks {
+			if old.SourceID == sourceID && !seen[old.Path] && match(old) {
+				return i
+			}
+		}
+		return -1
+	}
+	if t.ContentHash != "" {
+		if i := moved(func(old types.Track) bool { return s.hashes[old.ID] == t.ContentHash }); i >= 0 {
+			return i
+		}
+	}
+	if !t.Tagged {
+		return -1
+	}
+	return moved(func(old types.Track) bool {
+		return old.Title == t.Title && old.Artist == t.Artist && old.Album == t.Album &&
+			math.Abs(old.Duration-t.Duration) < db.FingerprintTolerance
+	})
+}
+
+func (s *Store) artistNamed(name string) string {
+	for _, a := range s.artists {
+		if a.Name == name {
+			return a.ID
+		}
+	}
+	a := types.Artist{ID: uuid.New().String(), Name: name, CreatedAt: time.Now()}
+	s.artists[a.ID] = a
+	return a.ID
+}
+
+func (s *Store) albumNamed(name, artistID string) string {
+	for _, a := range s.albums {
+		if a.Name == name && a.ArtistID == artistID {
+			return a.ID
+		}
+	}
+	a := types.Album{ID: uuid.New().String(), Name: name, ArtistID: artistID, CreatedAt: time.Now()}
+	s.albums[a.ID] = a
+	return a.ID
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	"homemusic-server/internal/logging"
+)
+
+// Schema changes live in migrations/ as NNNN_description.sql. Each runs once,
+// in version order, inside a transaction that also records it in
+// schema_migrations. Applied migrations must never be edited; add a new one.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+func loadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	seen := map[int]string{}
+	for _, e := range entries {
+		base := strings.TrimSuffix(e.Name(), ".sql")
+		num, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s is not named NNNN_description.sql", e.Name())
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, e.Name(), version)
+		}
+		seen[version] = e.Name()
+		content, err := migrationFiles.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(content)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// appliedVersions reads schema_migrations, which is empty for a new or
+// pre-migration database.
+func appliedVersions() (map[int]bool, error) {
+	applied := map[int]bool{}
+	exists, err := tableExists("schema_migrations")
+	if err != nil || !exists {
+		return applied, err
+	}
+	rows, err := DB.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+func tableExists(name string) (bool, error) {
+	var n int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n)
+	return n > 0, err
+}
+
+// PendingMigrations lists the migrations not yet applied, without changing
+// the database.
+func PendingMigrations() ([]Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+	known := map[int]bool{}
+	var pending []Migration
+	for _, m := range migrations {
+		known[m.Version] = true
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	// An older binary must not run against a schema it does not know
+	for v := range applied {
+		if !known[v] {
+			return nil, fmt.Errorf("database has migration %04d, which this build does not know; upgrade the server", v)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies pending migrations in order, stopping at the first that
+// fails so the schema is never left half-changed.
+func Migrate() error {
+	pending, err := PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return err
+	}
+	if pending[0].Version == 1 {
+		if err := upgradeLegacySchema(); err != nil {
+			return fmt.Errorf("upgrading pre-migration schema: %w", err)
+		}
+	}
+
+	for _, m := range pending {
+		err := withTx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(m.SQL); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", m, err)
+		}
+		logging.Infof("🗄️  Applied migration %s", m)
+	}
+	return nil
+}
+
+// legacyColumns were added to existing databases by ALTER TABLE before
+// migrations were versioned.
+var legacyColumns = []struct{ table, column, definition string }{
+	{"tracks", "folder_path", "TEXT"},
+	{"tracks", "image_url", "TEXT"},
+	{"tracks", "source_mtime", "DATETIME"},
+	{"tracks", "artists_display", "TEXT"},
+	{"sources", "max_streams", "INTEGER DEFAULT 0"},
+	{"sources", "max_bandwidth", "INTEGER DEFAULT 0"},
+	{"playlists", "smart", "INTEGER DEFAULT 0"},
+	{"playlists", "rules", "TEXT"},
+	{"playlists", "source_id", "TEXT REFERENCES sources(id) ON DELETE CASCADE"},
+	{"playlists", "source_path", "TEXT"},
+	{"playlists", "owner_id", "TEXT REFERENCES users(id) ON DELETE CASCADE"},
+	{"playlists", "visibility", "TEXT NOT NULL DEFAULT 'private'"},
+	{"users", "subsonic_password", "TEXT"},
+}
+
+// upgradeLegacySchema brings a database from before versioned migrations up
+// to the baseline, adding whichever columns it is missing. Tables it lacks
+// are created by the baseline migration itself.
+func upgradeLegacySchema() error {
+	for _, c := range legacyColumns {
+		exists, err := tableExists(c.table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		has, err := hasColumn(c.table, c.column)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if _, err := DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)); err != nil {
+			return err
+		}
+		logging.Infof("🗄️  Added %s.%s to pre-migration database", c.table, c.column)
+	}
+	return nil
+}
+
+func hasColumn(table, column string) (bool, error) {
+	rows, err := DB.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
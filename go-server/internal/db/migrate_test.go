@@ -0,0 +1,135 @@
+package db
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openEmptyDB points DB at a new database file without migrating it.
+func openEmptyDB(t *testing.T) {
+	t.Helper()
+	prev := DB
+	if err := Open(filepath.Join(t.TempDir(), "music.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		DB.Close()
+		DB = prev
+	})
+}
+
+func appliedCount(t *testing.T) int {
+	t.Helper()
+	var n int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func checkFullyMigrated(t *testing.T) {
+	t.Helper()
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := appliedCount(t); n != len(migrations) {
+		t.Errorf("%d migrations recorded, want %d", n, len(migrations))
+	}
+	pending, err := PendingMigrations()
+	if err != nil || len(pending) != 0 {
+		t.Errorf("PendingMigrations() = %v, %v, want none", pending, err)
+	}
+	for _, c := range legacyColumns {
+		if has, err := hasColumn(c.table, c.column); err != nil || !has {
+			t.Errorf("%s.%s missing (err %v)", c.table, c.column, err)
+		}
+	}
+	for _, table := range []string{"users", "scan_tracks"} {
+		if exists, err := tableExists(table); err != nil || !exists {
+			t.Errorf("table %s missing (err %v)", table, err)
+		}
+	}
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	openEmptyDB(t)
+	if err := Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	checkFullyMigrated(t)
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	openEmptyDB(t)
+	// The schema before optional columns were added with ALTER TABLE
+	_, err := DB.Exec(`
+	CREATE TABLE sources (
+		id TEXT PRIMARY KEY, name TEXT NOT NULL, type TEXT NOT NULL, host TEXT NOT NULL, port INTEGER NOT NULL,
+		username TEXT, password TEXT, domain TEXT, share TEXT, base_path TEXT, enabled INTEGER DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP, updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE tracks (
+		id TEXT PRIMARY KEY, title TEXT NOT NULL, artist TEXT NOT NULL, album TEXT NOT NULL, duration REAL NOT NULL,
+		track_number INTEGER, year INTEGER, path TEXT NOT NULL, source_id TEXT NOT NULL, album_id TEXT, artist_id TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP, UNIQUE(path, source_id)
+	);
+	CREATE TABLE playlists (
+		id TEXT PRIMARY KEY, name TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP, updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	INSERT INTO sources (id, name, type, host, port) VALUES ('src', 'NAS', 'smb', 'nas', 445);
+	INSERT INTO tracks (id, title, artist, album, duration, path, source_id) VALUES ('t1', 'Song', 'A', 'B', 60, 'a.mp3', 'src');
+	INSERT INTO playlists (id, name) VALUES ('p1', 'Old mix');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	checkFullyMigrated(t)
+
+	var title, visibility string
+	var smart bool
+	if err := DB.QueryRow("SELECT title FROM tracks WHERE id = 't1'").Scan(&title); err != nil || title != "Song" {
+		t.Errorf("track after upgrade: %q, %v", title, err)
+	}
+	if err := DB.QueryRow("SELECT smart, visibility FROM playlists WHERE id = 'p1'").Scan(&smart, &visibility); err != nil {
+		t.Fatal(err)
+	}
+	if smart || visibility != "private" {
+		t.Errorf("legacy playlist got smart = %v, visibility = %q", smart, visibility)
+	}
+}
+
+func TestMigrateRerunDoesNothing(t *testing.T) {
+	openEmptyDB(t)
+	if err := Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	before := appliedCount(t)
+	if err := Migrate(); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if after := appliedCount(t); after != before {
+		t.Errorf("second run recorded %d migrations, want %d", after, before)
+	}
+	checkFullyMigrated(t)
+}
+
+func TestMigrateRejectsUnknownVersion(t *testing.T) {
+	openEmptyDB(t)
+	if err := Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DB.Exec("INSERT INTO schema_migrations (version, name) VALUES (9999, 'from_the_future')"); err != nil {
+		t.Fatal(err)
+	}
+	err := Migrate()
+	if err == nil || !strings.Contains(err.Error(), "9999") || !strings.Contains(err.Error(), "upgrade the server") {
+		t.Errorf("Migrate() = %v, want an unknown version error", err)
+	}
+}
@@ -0,0 +1,163 @@
+-- Baseline schema. Databases created before versioned migrations already
+-- have some of these tables, so everything here is IF NOT EXISTS.
+
+CREATE TABLE IF NOT EXISTS sources (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	type TEXT NOT NULL,
+	host TEXT NOT NULL,
+	port INTEGER NOT NULL,
+	username TEXT,
+	password TEXT,
+	domain TEXT,
+	share TEXT,
+	base_path TEXT,
+	enabled INTEGER DEFAULT 1,
+	max_streams INTEGER DEFAULT 0,
+	max_bandwidth INTEGER DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS source_status (
+	source_id TEXT PRIMARY KEY,
+	status TEXT NOT NULL,
+	progress REAL DEFAULT 0,
+	total_files INTEGER DEFAULT 0,
+	scanned_files INTEGER DEFAULT 0,
+	last_error TEXT,
+	last_scan DATETIME,
+	FOREIGN KEY(source_id) REFERENCES sources(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS artists (
+	id TEXT PRIMARY KEY,
+	name TEXT UNIQUE NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS albums (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	artist_id TEXT NOT NULL,
+	image_url TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(name, artist_id),
+	FOREIGN KEY(artist_id) REFERENCES artists(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS tracks (
+	id TEXT PRIMARY KEY,
+	title TEXT NOT NULL,
+	artist TEXT NOT NULL,
+	album TEXT NOT NULL,
+	duration REAL NOT NULL,
+	track_number INTEGER,
+	year INTEGER,
+	path TEXT NOT NULL,
+	folder_path TEXT,
+	image_url TEXT,
+	source_mtime DATETIME,
+	artists_display TEXT,
+	source_id TEXT NOT NULL,
+	album_id TEXT,
+	artist_id TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(path, source_id),
+	FOREIGN KEY(source_id) REFERENCES sources(id) ON DELETE CASCADE,
+	FOREIGN KEY(album_id) REFERENCES albums(id) ON DELETE SET NULL,
+	FOREIGN KEY(artist_id) REFERENCES artists(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS playlists (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	smart INTEGER DEFAULT 0,
+	rules TEXT,
+	source_id TEXT REFERENCES sources(id) ON DELETE CASCADE,
+	source_path TEXT,
+	owner_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+	visibility TEXT NOT NULL DEFAULT 'private',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS playlist_items (
+	id TEXT PRIMARY KEY,
+	playlist_id TEXT NOT NULL,
+	track_id TEXT NOT NULL,
+	"order" INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(playlist_id) REFERENCES playlists(id) ON DELETE CASCADE,
+	FOREIGN KEY(track_id) REFERENCES tracks(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	username TEXT UNIQUE NOT NULL COLLATE NOCASE,
+	password_hash TEXT NOT NULL,
+	role TEXT NOT NULL DEFAULT 'user',
+	subsonic_password TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	token_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	token_hash TEXT UNIQUE NOT NULL,
+	scopes TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_used_at DATETIME,
+	expires_at DATETIME,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS stars (
+	user_id TEXT NOT NULL,
+	item_id TEXT NOT NULL,
+	item_type TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(user_id, item_id),
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS plays (
+	user_id TEXT NOT NULL,
+	track_id TEXT NOT NULL,
+	play_count INTEGER NOT NULL DEFAULT 0,
+	last_played DATETIME,
+	PRIMARY KEY(user_id, track_id),
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY(track_id) REFERENCES tracks(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS playlist_collaborators (
+	playlist_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	permission TEXT NOT NULL DEFAULT 'view',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(playlist_id, user_id),
+	FOREIGN KEY(playlist_id) REFERENCES playlists(id) ON DELETE CASCADE,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS playlist_follows (
+	user_id TEXT NOT NULL,
+	playlist_id TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(user_id, playlist_id),
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY(playlist_id) REFERENCES playlists(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_playlists_source_path ON playlists(source_id, source_path);
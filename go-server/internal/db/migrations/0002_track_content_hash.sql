@@ -0,0 +1,5 @@
+-- Checksum of a file's audio, ignoring its tags, so a scan can recognise a
+-- track whose file was moved or renamed.
+ALTER TABLE tracks ADD COLUMN content_hash TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_tracks_content_hash ON tracks(source_id, content_hash);
@@ -0,0 +1,22 @@
+-- Files found by a scan in progress. The scanner merges them into tracks
+-- in one transaction when the scan finishes.
+CREATE TABLE scan_tracks (
+	source_id TEXT NOT NULL,
+	path TEXT NOT NULL,
+	folder_path TEXT NOT NULL,
+	source_mtime DATETIME,
+	title TEXT NOT NULL,
+	artist TEXT NOT NULL,
+	album TEXT NOT NULL,
+	artists_display TEXT NOT NULL,
+	track_number INTEGER,
+	year INTEGER,
+	duration REAL NOT NULL,
+	image_url TEXT,
+	album_id TEXT NOT NULL,
+	artist_id TEXT NOT NULL,
+	content_hash TEXT,
+	tagged INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY(source_id, path),
+	FOREIGN KEY(source_id) REFERENCES sources(id) ON DELETE CASCADE
+);
@@ -0,0 +1,23 @@
+-- Scans now create artists and albums only when they commit, so staged
+-- files carry names rather than IDs. Rows left here belong to interrupted
+-- scans, which the next scan of their source clears anyway.
+DROP TABLE scan_tracks;
+
+CREATE TABLE scan_tracks (
+	source_id TEXT NOT NULL,
+	path TEXT NOT NULL,
+	folder_path TEXT NOT NULL,
+	source_mtime DATETIME,
+	title TEXT NOT NULL,
+	artist TEXT NOT NULL,
+	album TEXT NOT NULL,
+	artists_display TEXT NOT NULL,
+	track_number INTEGER,
+	year INTEGER,
+	duration REAL NOT NULL,
+	image_url TEXT,
+	content_hash TEXT,
+	tagged INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY(source_id, path),
+	FOREIGN KEY(source_id) REFERENCES sources(id) ON DELETE CASCADE
+);
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"homemusic-server/internal/types"
+)
+
+// ErrInvalidCollaborator is returned when adding a playlist's owner, or an
+// unknown user, as a collaborator.
+var ErrInvalidCollaborator = errors.New("invalid collaborator")
+
+// PlaylistAccess is what a user may do with a playlist.
+type PlaylistAccess = types.PlaylistAccess
+
+const (
+	AccessNone  = types.AccessNone
+	AccessView  = types.AccessView
+	AccessEdit  = types.AccessEdit
+	AccessOwner = types.AccessOwner
+)
+
+// PlaylistAccessFor applies the sharing rules: admins and owners have full
+// control, collaborators get their granted permission, and everyone else
+// can view ownerless playlists and anything not private.
+func PlaylistAccessFor(user *types.User, ownerID *string, visibility string, permission *string) PlaylistAccess {
+	if user == nil {
+		return AccessNone
+	}
+	if user.Role == types.RoleAdmin || (ownerID != nil && *ownerID == user.ID) {
+		return AccessOwner
+	}
+	if permission != nil && types.CollaboratorPermission(*permission) == types.PermissionEdit {
+		return AccessEdit
+	}
+	if permission != nil || ownerID == nil || types.PlaylistVisibility(visibility) != types.VisibilityPrivate {
+		return AccessView
+	}
+	return AccessNone
+}
+
+// GetPlaylistAccess returns the user's access to a playlist, or ErrNotFound
+// if it does not exist.
+func GetPlaylistAccess(playlistID string, user *types.User) (PlaylistAccess, error) {
+	var ownerID, permission *string
+	var visibility string
+	err := DB.QueryRow(`
+		SELECT p.owner_id, p.visibility, c.permission
+		FROM playlists p
+		LEFT JOIN playlist_collaborators c ON c.playlist_id = p.id AND c.user_id = ?
+		WHERE p.id = ?`, user.ID, playlistID).Scan(&ownerID, &visibility, &permission)
+	if err == sql.ErrNoRows {
+		return AccessNone, ErrNotFound
+	}
+	if err != nil {
+		return AccessNone, err
+	}
+	return PlaylistAccessFor(user, ownerID, visibility, permission), nil
+}
+
+func SetPlaylistVisibility(playlistID string, visibility types.PlaylistVisibility) error {
+	res, err := DB.Exec("UPDATE playlists SET visibility = ?, updated_at = ? WHERE id = ?", visibility, time.Now(), playlistID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func GetPlaylistCollaborators(playlistID string) ([]types.PlaylistCollaborator, error) {
+	rows, err := DB.Query(`
+		SELECT c.user_id, u.username, c.permission, c.created_at
+		FROM playlist_collaborators c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.playlist_id = ?
+		ORDER BY u.username ASC`, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collaborators := []types.PlaylistCollaborator{}
+	for rows.Next() {
+		var c types.PlaylistCollaborator
+		if err := rows.Scan(&c.UserID, &c.Username, &c.Permission, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		collaborators = append(collaborators, c)
+	}
+	return collaborators, rows.Err()
+}
+
+// SetPlaylistCollaborator adds a collaborator or changes their permission.
+func SetPlaylistCollaborator(playlistID, userID string, permission types.CollaboratorPermission) error {
+	return withTx(func(tx *sql.Tx) error {
+		var ownerID *string
+		err := tx.QueryRow("SELECT owner_id FROM playlists WHERE id = ?", playlistID).Scan(&ownerID)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if ownerID != nil && *ownerID == userID {
+			return ErrInvalidCollaborator
+		}
+
+		var exists int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?", userID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists == 0 {
+			return ErrInvalidCollaborator
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO playlist_collaborators (playlist_id, user_id, permission, created_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT(playlist_id, user_id) DO UPDATE SET permission = excluded.permission`,
+			playlistID, userID, permission, time.Now())
+		return err
+	})
+}
+
+func RemovePlaylistCollaborator(playlistID, userID string) error {
+	res, err := DB.Exec("DELETE FROM playlist_collaborators WHERE playlist_id = ? AND user_id = ?", playlistID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func FollowPlaylist(userID, playlistID string) error {
+	_, err := DB.Exec("INSERT OR IGNORE INTO playlist_follows (user_id, playlist_id, created_at) VALUES (?, ?, ?)",
+		userID, playlistID, time.Now())
+	return err
+}
+
+func UnfollowPlaylist(userID, playlistID string) error {
+	_, err := DB.Exec("DELETE FROM playlist_follows WHERE user_id = ? AND playlist_id = ?", userID, playlistID)
+	return err
+}
+
+func IsFollowingPlaylist(userID, playlistID string) (bool, error) {
+	var n int
+	err := DB.QueryRow("SELECT COUNT(*) FROM playlist_follows WHERE user_id = ? AND playlist_id = ?", userID, playlistID).Scan(&n)
+	return n > 0, err
+}
+
+// deletePlaylistTx removes a playlist together with its items, sharing and
+// follows, since foreign keys are not enforced.
+func deletePlaylistTx(tx *sql.Tx, id string) error {
+	for _, q := range []string{
+		"DELETE FROM playlist_items WHERE playlist_id = ?",
+		"DELETE FROM playlist_collaborators WHERE playlist_id = ?",
+		"DELETE FROM playlist_follows WHERE playlist_id = ?",
+		"DELETE FROM playlists WHERE id = ?",
+	} {
+		if _, err := tx.Exec(q, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,55 +2,135 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"homemusic-server/internal/types"
 )
 
-func GetAllPlaylists() ([]map[string]interface{}, error) {
+// ErrPlaylistReadOnly is returned when editing a playlist whose contents
+// are computed: smart playlists and playlists discovered on a source.
+var ErrPlaylistReadOnly = errors.New("playlist is read-only")
+
+// GetAllPlaylists returns the playlists in the user's library: their own,
+// those shared with them as a collaborator, those they follow, and the
+// ownerless ones everybody sees.
+func GetAllPlaylists(user *types.User) ([]types.PlaylistSummary, error) {
+	return listPlaylists(user, "p.owner_id IS NULL OR p.owner_id = ? OR c.user_id IS NOT NULL OR f.user_id IS NOT NULL", user.ID)
+}
+
+func GetFollowedPlaylists(user *types.User) ([]types.PlaylistSummary, error) {
+	return listPlaylists(user, "f.user_id IS NOT NULL")
+}
+
+// GetPublicPlaylists lists every user's public playlists for browsing.
+func GetPublicPlaylists(user *types.User) ([]types.PlaylistSummary, error) {
+	return listPlaylists(user, "p.owner_id IS NOT NULL AND p.visibility = ?", types.VisibilityPublic)
+}
+
+// GetGuestPlaylists lists what anonymous LAN clients may browse: public
+// and ownerless playlists.
+func GetGuestPlaylists() ([]types.PlaylistSummary, error) {
+	return listPlaylists(&types.User{}, "p.owner_id IS NULL OR p.visibility = ?", types.VisibilityPublic)
+}
+
+func listPlaylists(user *types.User, where string, args ...interface{}) ([]types.PlaylistSummary, error) {
 	query := `
-		SELECT p.id, p.name, COUNT(pi.id) as track_count
+		SELECT p.id, p.name, p.smart, p.rules, p.source_id, p.source_path, p.owner_id, o.username, p.visibility,
+			c.permission, f.user_id IS NOT NULL, p.created_at, p.updated_at,
+			(SELECT COUNT(*) FROM playlist_items pi WHERE pi.playlist_id = p.id) as track_count,
+			(SELECT COALESCE(SUM(t.duration), 0) FROM playlist_items pi JOIN tracks t ON t.id = pi.track_id WHERE pi.playlist_id = p.id) as duration
 		FROM playlists p
-		LEFT JOIN playlist_items pi ON p.id = pi.playlist_id
-		GROUP BY p.id
+		LEFT JOIN users o ON o.id = p.owner_id
+		LEFT JOIN playlist_collaborators c ON c.playlist_id = p.id AND c.user_id = ?
+		LEFT JOIN playlist_follows f ON f.playlist_id = p.id AND f.user_id = ?
+		WHERE ` + where + `
 		ORDER BY p.name ASC
 	`
-	rows, err := DB.Query(query)
+	rows, err := DB.Query(query, append([]interface{}{user.ID, user.ID}, args...)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	playlists := []map[string]interface{}{}
+	playlists := []types.PlaylistSummary{}
 	for rows.Next() {
-		var id, name string
-		var count int
-		if err := rows.Scan(&id, &name, &count); err != nil {
+		var p types.PlaylistSummary
+		var rulesJSON sql.NullString
+		var permission *string
+		if err := rows.Scan(&p.ID, &p.Name, &p.Smart, &rulesJSON, &p.SourceID, &p.SourcePath, &p.OwnerID, &p.Owner, &p.Visibility,
+			&permission, &p.Following, &p.CreatedAt, &p.UpdatedAt, &p.TrackCount, &p.Duration); err != nil {
 			return nil, err
 		}
-		playlists = append(playlists, map[string]interface{}{
-			"id":         id,
-			"name":       name,
-			"trackCount": count,
-		})
+		// A playlist made private after being followed drops out of lists
+		p.Access = PlaylistAccessFor(user, p.OwnerID, string(p.Visibility), permission)
+		if p.Access == AccessNone {
+			continue
+		}
+		if p.Smart {
+			rules, err := decodeSmartRules(rulesJSON)
+			if err != nil {
+				return nil, err
+			}
+			p.Rules = rules
+		}
+		p.ReadOnly = p.Smart || p.SourceID != nil
+		playlists = append(playlists, p)
+	}
+	rows.Close()
+
+	// Smart playlists have no items; count what their rules currently match
+	for i := range playlists {
+		if !playlists[i].Smart {
+			continue
+		}
+		count, err := countSmartPlaylistTracks(playlists[i].Rules)
+		if err != nil {
+			return nil, err
+		}
+		playlists[i].TrackCount = count
 	}
 	return playlists, nil
 }
 
-func GetPlaylist(id string) (map[string]interface{}, error) {
-	var name string
-	err := DB.QueryRow("SELECT name FROM playlists WHERE id = ?", id).Scan(&name)
+// GetPlaylist returns a playlist with its tracks, or nil if there is none.
+// Access and Following depend on the caller and are left for them to fill.
+func GetPlaylist(id string) (*types.PlaylistDetail, error) {
+	p := &types.PlaylistDetail{}
+	p.ID = id
+	var rulesJSON sql.NullString
+	err := DB.QueryRow(`
+		SELECT p.name, p.smart, p.rules, p.source_id, p.source_path, p.owner_id, o.username, p.visibility, p.created_at, p.updated_at
+		FROM playlists p
+		LEFT JOIN users o ON o.id = p.owner_id
+		WHERE p.id = ?`, id).
+		Scan(&p.Name, &p.Smart, &rulesJSON, &p.SourceID, &p.SourcePath, &p.OwnerID, &p.Owner, &p.Visibility, &p.CreatedAt, &p.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	p.ReadOnly = p.Smart || p.SourceID != nil
+	p.Items = []types.PlaylistItem{}
+
+	if p.Smart {
+		if p.Rules, err = decodeSmartRules(rulesJSON); err != nil {
+			return nil, err
+		}
+		if p.Tracks, err = GetSmartPlaylistTracks(p.Rules); err != nil {
+			return nil, err
+		}
+		p.SummarizeTracks()
+		return p, nil
+	}
 
 	// Get tracks in playlist
 	query := `
-		SELECT t.id, t.title, t.artist, t.album, t.duration, t.track_number, t.year, t.path, t.source_id, t.album_id, t.artist_id
+		SELECT pi.id, pi."order", pi.created_at, ` + trackColumns + `
 		FROM tracks t
 		JOIN playlist_items pi ON t.id = pi.track_id
 		WHERE pi.playlist_id = ?
@@ -62,62 +142,337 @@ func GetPlaylist(id string) (map[string]interface{}, error) {
 	}
 	defer rows.Close()
 
-	tracks := []types.Track{}
+	p.Tracks = []types.Track{}
 	for rows.Next() {
 		var t types.Track
-		err := rows.Scan(&t.ID, &t.Title, &t.Artist, &t.Album, &t.Duration, &t.TrackNumber, &t.Year, &t.Path, &t.SourceID, &t.AlbumID, &t.ArtistID)
+		item := types.PlaylistItem{PlaylistID: id}
+		err := rows.Scan(&item.ID, &item.Order, &item.CreatedAt,
+			&t.ID, &t.Title, &t.Artist, &t.Album, &t.Duration, &t.TrackNumber, &t.Year, &t.Path, &t.FolderPath, &t.ImageUrl, &t.SourceMtime, &t.ArtistsDisplay, &t.SourceID, &t.AlbumID, &t.ArtistID, &t.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
-		tracks = append(tracks, t)
+		item.TrackID = t.ID
+		p.Tracks = append(p.Tracks, t)
+		p.Items = append(p.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	p.SummarizeTracks()
+	return p, nil
+}
+
+func CreatePlaylist(name, ownerID string) (*types.Playlist, error) {
+	p := &types.Playlist{
+		ID:         uuid.New().String(),
+		Name:       name,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+		OwnerID:    &ownerID,
+		Visibility: types.VisibilityPrivate,
 	}
 
-	return map[string]interface{}{
-		"id":     id,
-		"name":   name,
-		"tracks": tracks,
-	}, nil
+	_, err := DB.Exec("INSERT INTO playlists (id, name, owner_id, visibility, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		p.ID, p.Name, ownerID, p.Visibility, p.CreatedAt, p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
 }
 
-func CreatePlaylist(name string) (*types.Playlist, error) {
+func CreateSmartPlaylist(name, ownerID string, rules *types.SmartRules) (*types.Playlist, error) {
+	if err := ValidateSmartRules(rules); err != nil {
+		return nil, err
+	}
+	rulesJSON, err := json.Marshal(rules)
+	if err != nil {
+		return nil, err
+	}
+
 	p := &types.Playlist{
 		ID:        uuid.New().String(),
 		Name:      name,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
-	}
+		Smart:     true,
+		Rules:     rules,
 
-	_, err := DB.Exec("INSERT INTO playlists (id, name, created_at, updated_at) VALUES (?, ?, ?, ?)",
-		p.ID, p.Name, p.CreatedAt, p.UpdatedAt)
+		OwnerID:    &ownerID,
+		Visibility: types.VisibilityPrivate,
+	}
+	_, err = DB.Exec("INSERT INTO playlists (id, name, smart, rules, owner_id, visibility, created_at, updated_at) VALUES (?, ?, 1, ?, ?, ?, ?, ?)",
+		p.ID, p.Name, string(rulesJSON), ownerID, p.Visibility, p.CreatedAt, p.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return p, nil
 }
 
+// UpdateSmartPlaylistRules replaces the rules of an existing smart playlist.
+func UpdateSmartPlaylistRules(id string, rules *types.SmartRules) error {
+	if err := ValidateSmartRules(rules); err != nil {
+		return err
+	}
+	rulesJSON, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+
+	var smart bool
+	err = DB.QueryRow("SELECT smart FROM playlists WHERE id = ?", id).Scan(&smart)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !smart {
+		return fmt.Errorf("%w: not a smart playlist", ErrInvalidSmartRules)
+	}
+
+	_, err = DB.Exec("UPDATE playlists SET rules = ?, updated_at = ? WHERE id = ?", string(rulesJSON), time.Now(), id)
+	return err
+}
+
+func decodeSmartRules(rulesJSON sql.NullString) (*types.SmartRules, error) {
+	rules := &types.SmartRules{}
+	if !rulesJSON.Valid || rulesJSON.String == "" {
+		return rules, nil
+	}
+	if err := json.Unmarshal([]byte(rulesJSON.String), rules); err != nil {
+		return nil, fmt.Errorf("failed to decode smart playlist rules: %w", err)
+	}
+	return rules, nil
+}
+
 func AddTrackToPlaylist(playlistID, trackID string) error {
+	_, err := AddTracksToPlaylist(playlistID, []string{trackID})
+	return err
+}
+
+// AddTracksToPlaylist appends the tracks in the given order, skipping IDs
+// that do not exist in the library. It returns how many items were added.
+func AddTracksToPlaylist(playlistID string, trackIDs []string) (int, error) {
+	added := 0
+	err := withTx(func(tx *sql.Tx) error {
+		if err := lockPlaylist(tx, playlistID); err != nil {
+			return err
+		}
+		var err error
+		added, err = appendPlaylistTracks(tx, playlistID, trackIDs)
+		return err
+	})
+	return added, err
+}
+
+// ReplacePlaylistTracks swaps the playlist's items for the given tracks in
+// one transaction, so readers never see it empty or half filled. Unknown
+// IDs are skipped as in AddTracksToPlaylist.
+func ReplacePlaylistTracks(playlistID string, trackIDs []string) (int, error) {
+	added := 0
+	err := withTx(func(tx *sql.Tx) error {
+		if err := lockPlaylist(tx, playlistID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM playlist_items WHERE playlist_id = ?", playlistID); err != nil {
+			return err
+		}
+		var err error
+		added, err = appendPlaylistTracks(tx, playlistID, trackIDs)
+		return err
+	})
+	return added, err
+}
+
+func appendPlaylistTracks(tx *sql.Tx, playlistID string, trackIDs []string) (int, error) {
 	// Get current max order
 	var maxOrder int
-	err := DB.QueryRow("SELECT COALESCE(MAX(\"order\"), -1) FROM playlist_items WHERE playlist_id = ?", playlistID).Scan(&maxOrder)
+	err := tx.QueryRow("SELECT COALESCE(MAX(\"order\"), -1) FROM playlist_items WHERE playlist_id = ?", playlistID).Scan(&maxOrder)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	_, err = DB.Exec("INSERT INTO playlist_items (id, playlist_id, track_id, \"order\") VALUES (?, ?, ?, ?)",
-		uuid.New().String(), playlistID, trackID, maxOrder+1)
-	return err
+	added := 0
+	for _, trackID := range trackIDs {
+		var exists int
+		err := tx.QueryRow("SELECT COUNT(*) FROM tracks WHERE id = ?", trackID).Scan(&exists)
+		if err != nil {
+			return added, err
+		}
+		if exists == 0 {
+			continue
+		}
+		maxOrder++
+		_, err = tx.Exec("INSERT INTO playlist_items (id, playlist_id, track_id, \"order\") VALUES (?, ?, ?, ?)",
+			uuid.New().String(), playlistID, trackID, maxOrder)
+		if err != nil {
+			return added, err
+		}
+		added++
+	}
+	return added, nil
 }
 
+// RemoveTrackFromPlaylist removes every occurrence of the track.
 func RemoveTrackFromPlaylist(playlistID, trackID string) error {
-	_, err := DB.Exec("DELETE FROM playlist_items WHERE playlist_id = ? AND track_id = ?", playlistID, trackID)
-	return err
+	return withTx(func(tx *sql.Tx) error {
+		if err := lockPlaylist(tx, playlistID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM playlist_items WHERE playlist_id = ? AND track_id = ?", playlistID, trackID); err != nil {
+			return err
+		}
+		return renumberPlaylist(tx, playlistID)
+	})
 }
 
-func DeletePlaylist(id string) error {
-	_, err := DB.Exec("DELETE FROM playlist_items WHERE playlist_id = ?", id)
+// RemovePlaylistItem removes a single entry, leaving other copies of the
+// same track in place.
+func RemovePlaylistItem(playlistID, itemID string) error {
+	return withTx(func(tx *sql.Tx) error {
+		if err := lockPlaylist(tx, playlistID); err != nil {
+			return err
+		}
+		res, err := tx.Exec("DELETE FROM playlist_items WHERE playlist_id = ? AND id = ?", playlistID, itemID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+		return renumberPlaylist(tx, playlistID)
+	})
+}
+
+// MovePlaylistItem moves an entry to a zero-based position, clamping
+// positions past the end to the last slot.
+func MovePlaylistItem(playlistID, itemID string, position int) error {
+	return withTx(func(tx *sql.Tx) error {
+		if err := lockPlaylist(tx, playlistID); err != nil {
+			return err
+		}
+		ids, err := playlistItemIDs(tx, playlistID)
+		if err != nil {
+			return err
+		}
+
+		from := -1
+		for i, id := range ids {
+			if id == itemID {
+				from = i
+				break
+			}
+		}
+		if from == -1 {
+			return ErrNotFound
+		}
+
+		if position < 0 {
+			position = 0
+		}
+		if position >= len(ids) {
+			position = len(ids) - 1
+		}
+		ids = append(ids[:from], ids[from+1:]...)
+		ids = append(ids[:position], append([]string{itemID}, ids[position:]...)...)
+		return writePlaylistOrder(tx, ids)
+	})
+}
+
+func RenamePlaylist(id, name string) error {
+	var sourceID *string
+	err := DB.QueryRow("SELECT source_id FROM playlists WHERE id = ?", id).Scan(&sourceID)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
 	if err != nil {
 		return err
 	}
-	_, err = DB.Exec("DELETE FROM playlists WHERE id = ?", id)
+	// Source playlists take their name from the file on every scan
+	if sourceID != nil {
+		return ErrPlaylistReadOnly
+	}
+
+	_, err = DB.Exec("UPDATE playlists SET name = ?, updated_at = ? WHERE id = ?", name, time.Now(), id)
 	return err
 }
+
+func ClearPlaylist(id string) error {
+	return withTx(func(tx *sql.Tx) error {
+		if err := lockPlaylist(tx, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM playlist_items WHERE playlist_id = ?", id)
+		return err
+	})
+}
+
+// lockPlaylist checks the playlist exists and bumps updated_at. Writing
+// first makes SQLite take the write lock up front, so concurrent edits of
+// the same playlist serialise instead of interleaving their order values.
+func lockPlaylist(tx *sql.Tx, playlistID string) error {
+	res, err := tx.Exec("UPDATE playlists SET updated_at = ? WHERE id = ?", time.Now(), playlistID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	var smart bool
+	var sourceID *string
+	if err := tx.QueryRow("SELECT smart, source_id FROM playlists WHERE id = ?", playlistID).Scan(&smart, &sourceID); err != nil {
+		return err
+	}
+	if smart || sourceID != nil {
+		return ErrPlaylistReadOnly
+	}
+	return nil
+}
+
+func playlistItemIDs(tx *sql.Tx, playlistID string) ([]string, error) {
+	rows, err := tx.Query("SELECT id FROM playlist_items WHERE playlist_id = ? ORDER BY \"order\" ASC, created_at ASC", playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// renumberPlaylist closes gaps so "order" runs 0..n-1.
+func renumberPlaylist(tx *sql.Tx, playlistID string) error {
+	ids, err := playlistItemIDs(tx, playlistID)
+	if err != nil {
+		return err
+	}
+	return writePlaylistOrder(tx, ids)
+}
+
+func writePlaylistOrder(tx *sql.Tx, itemIDs []string) error {
+	stmt, err := tx.Prepare("UPDATE playlist_items SET \"order\" = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i, id := range itemIDs {
+		if _, err := stmt.Exec(i, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func DeletePlaylist(id string) error {
+	return withTx(func(tx *sql.Tx) error {
+		return deletePlaylistTx(tx, id)
+	})
+}
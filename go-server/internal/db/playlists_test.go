@@ -0,0 +1,52 @@
+package db
+
+import (
+	"slices"
+	"testing"
+
+	"homemusic-server/internal/types"
+)
+
+func TestReplacePlaylistTracks(t *testing.T) {
+	openTestDB(t)
+	if err := CreateSource(types.Source{ID: "src", Name: "NAS", Type: types.SourceTypeSMB, Host: "nas", Port: 445}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		_, err := DB.Exec(`INSERT INTO tracks (id, title, artist, album, duration, path, source_id)
+			VALUES (?, ?, 'A', 'B', 60, ?, 'src')`, id, id, id+".mp3")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := CreatePlaylist("Mix", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddTracksToPlaylist(p.ID, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := ReplacePlaylistTracks(p.ID, []string{"c", "missing", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 {
+		t.Errorf("added = %d, want 2", added)
+	}
+	detail, err := GetPlaylist(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, tr := range detail.Tracks {
+		ids = append(ids, tr.ID)
+	}
+	if want := []string{"c", "a"}; !slices.Equal(ids, want) {
+		t.Errorf("tracks = %v, want %v", ids, want)
+	}
+
+	if _, err := ReplacePlaylistTracks("nope", []string{"a"}); err != ErrNotFound {
+		t.Errorf("unknown playlist: err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,420 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"homemusic-server/internal/logging"
+)
+
+// ScannedTrack is what a scan learned about one file.
+type ScannedTrack struct {
+	Path       string
+	FolderPath string
+	Mtime      time.Time
+
+	Title          string
+	Artist         string
+	Album          string
+	ArtistsDisplay string
+	TrackNumber    *int
+	Year           *int
+	Duration       float64
+	ImageURL       *string
+
+	// Checksum of the audio, empty if the file could not be read
+	ContentHash string
+	// Whether title, artist and album came from tags rather than defaults
+	Tagged bool
+}
+
+// KnownFile is what the library already holds for a path.
+type KnownFile struct {
+	Mtime       time.Time
+	ContentHash string
+}
+
+// KnownFiles returns the modification time and audio checksum of each of
+// the source's tracks by path, so unchanged files need not be hashed again.
+func KnownFiles(sourceID string) (map[string]KnownFile, error) {
+	rows, err := DB.Query("SELECT path, source_mtime, content_hash FROM tracks WHERE source_id = ? AND content_hash IS NOT NULL", sourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	known := map[string]KnownFile{}
+	for rows.Next() {
+		var path string
+		var mtime sql.NullTime
+		var contentHash string
+		if err := rows.Scan(&path, &mtime, &contentHash); err != nil {
+			return nil, err
+		}
+		known[path] = KnownFile{Mtime: mtime.Time, ContentHash: contentHash}
+	}
+	return known, rows.Err()
+}
+
+// ClearStagedTracks drops the files staged for the source by a scan that
+// was interrupted or failed.
+func ClearStagedTracks(sourceID string) error {
+	_, err := DB.Exec("DELETE FROM scan_tracks WHERE source_id = ?", sourceID)
+	return err
+}
+
+// StageTracks adds files found by a running scan to scan_tracks, in one
+// transaction. Nothing in the library changes until CommitScan.
+func StageTracks(sourceID string, tracks []ScannedTrack) error {
+	return withTx(func(tx *sql.Tx) error {
+		stage, err := tx.Prepare(`INSERT OR REPLACE INTO scan_tracks
+			(source_id, path, folder_path, source_mtime, title, artist, album, artists_display,
+			track_number, year, duration, image_url, content_hash, tagged)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stage.Close()
+
+		for _, t := range tracks {
+			_, err = stage.Exec(sourceID, t.Path, t.FolderPath, t.Mtime.UTC(), t.Title, t.Artist, t.Album, t.ArtistsDisplay,
+				t.TrackNumber, t.Year, t.Duration, t.ImageURL, nullIfEmpty(t.ContentHash), t.Tagged)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CommitScan, in one transaction, creates the artists and albums the
+// source's staged files need, replaces the source's tracks with them and
+// records when the scan finished. seen holds every path found in the scan,
+// so moved files keep the ID of the track they were and tracks whose files
+// have gone are deleted.
+func CommitScan(sourceID string, seen map[string]bool) error {
+	return withTx(func(tx *sql.Tx) error {
+		b, err := newScanBatch(tx)
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+
+		tracks, err := newTrackWriter(tx, sourceID, seen)
+		if err != nil {
+			return err
+		}
+		defer tracks.Close()
+
+		rows, err := tx.Query(`SELECT path, folder_path, source_mtime, title, artist, album, artists_display,
+			track_number, year, duration, image_url, content_hash, tagged
+			FROM scan_tracks WHERE source_id = ? ORDER BY path`, sourceID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var t ScannedTrack
+			var mtime sql.NullTime
+			var contentHash sql.NullString
+			err := rows.Scan(&t.Path, &t.FolderPath, &mtime, &t.Title, &t.Artist, &t.Album, &t.ArtistsDisplay,
+				&t.TrackNumber, &t.Year, &t.Duration, &t.ImageURL, &contentHash, &t.Tagged)
+			if err != nil {
+				return err
+			}
+			t.Mtime = mtime.Time
+			t.ContentHash = contentHash.String
+			artistID, albumID, err := b.resolve(&t)
+			if err != nil {
+				return err
+			}
+			if err := tracks.save(&t, artistID, albumID); err != nil {
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		if err := deleteUnseenTracks(tx, sourceID, seen); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM scan_tracks WHERE source_id = ?", sourceID); err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE source_status SET last_scan = ? WHERE source_id = ?", time.Now().UTC(), sourceID)
+		return err
+	})
+}
+
+// deleteUnseenTracks removes the source's tracks whose paths were not found
+// in the scan, with their playlist items, plays and stars, since foreign
+// keys are not enforced. Moved files have already taken their new paths.
+func deleteUnseenTracks(tx *sql.Tx, sourceID string, seen map[string]bool) error {
+	rows, err := tx.Query("SELECT id, path FROM tracks WHERE source_id = ?", sourceID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var gone []string
+	for rows.Next() {
+		var id, path string
+		if err := rows.Scan(&id, &path); err != nil {
+			return err
+		}
+		if !seen[path] {
+			gone = append(gone, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, id := range gone {
+		for _, q := range []string{
+			"DELETE FROM playlist_items WHERE track_id = ?",
+			"DELETE FROM plays WHERE track_id = ?",
+			"DELETE FROM stars WHERE item_id = ?",
+			"DELETE FROM tracks WHERE id = ?",
+		} {
+			if _, err := tx.Exec(q, id); err != nil {
+				return err
+			}
+		}
+	}
+	if len(gone) > 0 {
+		logging.Infof("[Scanner] Removed %d tracks whose files are gone", len(gone))
+	}
+	return nil
+}
+
+type albumKey struct {
+	name     string
+	artistID string
+}
+
+// scanBatch holds the statements that create the artists and albums of a
+// committing scan, remembering the IDs it has resolved.
+type scanBatch struct {
+	insertArtist *sql.Stmt
+	selectArtist *sql.Stmt
+	insertAlbum  *sql.Stmt
+	selectAlbum  *sql.Stmt
+	setAlbumArt  *sql.Stmt
+
+	artists  map[string]string
+	albums   map[albumKey]string
+	albumArt map[string]bool
+}
+
+func newScanBatch(tx *sql.Tx) (*scanBatch, error) {
+	b := &scanBatch{
+		artists:  make(map[string]string),
+		albums:   make(map[albumKey]string),
+		albumArt: make(map[string]bool),
+	}
+	stmts := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&b.insertArtist, "INSERT OR IGNORE INTO artists (id, name) VALUES (?, ?)"},
+		{&b.selectArtist, "SELECT id FROM artists WHERE name = ?"},
+		{&b.insertAlbum, "INSERT OR IGNORE INTO albums (id, name, artist_id) VALUES (?, ?, ?)"},
+		{&b.selectAlbum, "SELECT id FROM albums WHERE name = ? AND artist_id = ?"},
+		{&b.setAlbumArt, "UPDATE albums SET image_url = ? WHERE id = ? AND (image_url IS NULL OR image_url = '')"},
+	}
+	for _, s := range stmts {
+		stmt, err := tx.Prepare(s.query)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+		*s.stmt = stmt
+	}
+	return b, nil
+}
+
+func (b *scanBatch) Close() {
+	for _, stmt := range []*sql.Stmt{b.insertArtist, b.selectArtist, b.insertAlbum, b.selectAlbum, b.setAlbumArt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// resolve returns t's artist and album IDs, creating them as needed, and
+// gives the album t's artwork if it has none.
+func (b *scanBatch) resolve(t *ScannedTrack) (string, string, error) {
+	artistID, ok := b.artists[t.Artist]
+	if !ok {
+		var err error
+		if artistID, err = b.artist(t.Artist); err != nil {
+			return "", "", err
+		}
+		b.artists[t.Artist] = artistID
+	}
+
+	key := albumKey{t.Album, artistID}
+	albumID, ok := b.albums[key]
+	if !ok {
+		var err error
+		if albumID, err = b.album(t.Album, artistID); err != nil {
+			return "", "", err
+		}
+		b.albums[key] = albumID
+	}
+
+	if t.ImageURL != nil && *t.ImageURL != "" && !b.albumArt[albumID] {
+		if _, err := b.setAlbumArt.Exec(*t.ImageURL, albumID); err != nil {
+			return "", "", err
+		}
+		b.albumArt[albumID] = true
+	}
+	return artistID, albumID, nil
+}
+
+func (b *scanBatch) artist(name string) (string, error) {
+	if _, err := b.insertArtist.Exec(uuid.New().String(), name); err != nil {
+		return "", err
+	}
+	var id string
+	err := b.selectArtist.QueryRow(name).Scan(&id)
+	return id, err
+}
+
+func (b *scanBatch) album(name, artistID string) (string, error) {
+	if _, err := b.insertAlbum.Exec(uuid.New().String(), name, artistID); err != nil {
+		return "", err
+	}
+	var id string
+	err := b.selectAlbum.QueryRow(name, artistID).Scan(&id)
+	return id, err
+}
+
+// FingerprintTolerance is how far, in seconds, durations of the same
+// recording may differ between scans.
+const FingerprintTolerance = 1.0
+
+// trackWriter saves scanned tracks inside one transaction, keeping the ID
+// of the track each file already is. That is the track at the same path
+// or, for a file that has moved, the track whose old path has gone and
+// whose audio or tags match. seen holds every path found in this scan of
+// the source.
+type trackWriter struct {
+	sourceID string
+	seen     map[string]bool
+
+	byPath *sql.Stmt
+	byHash *sql.Stmt
+	byTags *sql.Stmt
+	update *sql.Stmt
+	insert *sql.Stmt
+}
+
+func newTrackWriter(tx *sql.Tx, sourceID string, seen map[string]bool) (*trackWriter, error) {
+	w := &trackWriter{sourceID: sourceID, seen: seen}
+	stmts := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&w.byPath, "SELECT id FROM tracks WHERE source_id = ? AND path = ?"},
+		{&w.byHash, "SELECT id, path FROM tracks WHERE source_id = ? AND content_hash = ?"},
+		{&w.byTags, "SELECT id, path FROM tracks WHERE source_id = ? AND title = ? AND artist = ? AND album = ? AND ABS(duration - ?) < ?"},
+		{&w.update, `UPDATE tracks SET
+			title = ?, artist = ?, album = ?, duration = ?, track_number = ?, year = ?, path = ?, folder_path = ?,
+			image_url = ?, source_mtime = ?, artists_display = ?, album_id = ?, artist_id = ?, content_hash = ?
+			WHERE id = ?`},
+		{&w.insert, `INSERT INTO tracks
+			(id, title, artist, album, duration, track_number, year, path, folder_path, image_url, source_mtime, artists_display, source_id, album_id, artist_id, content_hash)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`},
+	}
+	for _, s := range stmts {
+		stmt, err := tx.Prepare(s.query)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		*s.stmt = stmt
+	}
+	return w, nil
+}
+
+func (w *trackWriter) Close() {
+	for _, stmt := range []*sql.Stmt{w.byPath, w.byHash, w.byTags, w.update, w.insert} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+func (w *trackWriter) save(t *ScannedTrack, artistID, albumID string) error {
+	var id string
+	err := w.byPath.QueryRow(w.sourceID, t.Path).Scan(&id)
+	if err == sql.ErrNoRows {
+		var oldPath string
+		id, oldPath, err = w.findMoved(t)
+		if id != "" {
+			logging.Infof("[Scanner] %s moved to %s", oldPath, t.Path)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		_, err = w.update.Exec(
+			t.Title, t.Artist, t.Album, t.Duration, t.TrackNumber, t.Year, t.Path, t.FolderPath,
+			t.ImageURL, t.Mtime, t.ArtistsDisplay, albumID, artistID, nullIfEmpty(t.ContentHash), id)
+		return err
+	}
+	_, err = w.insert.Exec(
+		uuid.New().String(), t.Title, t.Artist, t.Album, t.Duration, t.TrackNumber, t.Year, t.Path, t.FolderPath,
+		t.ImageURL, t.Mtime, t.ArtistsDisplay, w.sourceID, albumID, artistID, nullIfEmpty(t.ContentHash))
+	return err
+}
+
+// findMoved looks in the same source for a track whose file is no longer
+// where it was and that matches t by audio checksum or, failing that, by
+// tags and duration. Tracks whose path still exists are copies, not moves,
+// and are left alone.
+func (w *trackWriter) findMoved(t *ScannedTrack) (string, string, error) {
+	if t.ContentHash != "" {
+		id, path, err := w.firstUnseen(w.byHash, w.sourceID, t.ContentHash)
+		if id != "" || err != nil {
+			return id, path, err
+		}
+	}
+	if !t.Tagged {
+		return "", "", nil
+	}
+	return w.firstUnseen(w.byTags, w.sourceID, t.Title, t.Artist, t.Album, t.Duration, FingerprintTolerance)
+}
+
+// firstUnseen returns the first id and path from stmt whose path was not
+// found in this scan.
+func (w *trackWriter) firstUnseen(stmt *sql.Stmt, args ...interface{}) (string, string, error) {
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return "", "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, path string
+		if err := rows.Scan(&id, &path); err != nil {
+			return "", "", err
+		}
+		if !w.seen[path] {
+			return id, path, nil
+		}
+	}
+	return "", "", rows.Err()
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"homemusic-server/internal/types"
+)
+
+func TestCommitScanDeletesGoneTracks(t *testing.T) {
+	openTestDB(t)
+	if err := CreateSource(types.Source{ID: "src", Name: "NAS", Type: types.SourceTypeSMB, Host: "nas", Port: 445}); err != nil {
+		t.Fatal(err)
+	}
+	scan := func(paths ...string) {
+		t.Helper()
+		seen := map[string]bool{}
+		var staged []ScannedTrack
+		for _, p := range paths {
+			seen[p] = true
+			staged = append(staged, ScannedTrack{Path: p, FolderPath: "/music", Title: p, Artist: "A", Album: "B", Duration: 60})
+		}
+		if err := StageTracks("src", staged); err != nil {
+			t.Fatal(err)
+		}
+		if err := CommitScan("src", seen); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths := func() map[string]string {
+		t.Helper()
+		tracks, err := GetTracksBySource("src")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := map[string]string{}
+		for _, tr := range tracks {
+			ids[tr.Path] = tr.ID
+		}
+		return ids
+	}
+
+	scan("/music/a.mp3", "/music/b.mp3")
+	ids := paths()
+	user, err := CreateUser("alice", "x", types.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := CreatePlaylist("Mix", user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddTracksToPlaylist(p.ID, []string{ids["/music/a.mp3"], ids["/music/b.mp3"]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordPlay(user.ID, ids["/music/b.mp3"], time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := StarItem(user.ID, ids["/music/b.mp3"], StarTrack); err != nil {
+		t.Fatal(err)
+	}
+
+	// b.mp3 was deleted from the share
+	scan("/music/a.mp3")
+	if got := paths(); len(got) != 1 || got["/music/a.mp3"] != ids["/music/a.mp3"] {
+		t.Fatalf("tracks after rescan = %v", got)
+	}
+	detail, err := GetPlaylist(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(detail.Tracks) != 1 || detail.Tracks[0].ID != ids["/music/a.mp3"] {
+		t.Errorf("playlist tracks = %+v", detail.Tracks)
+	}
+	for _, q := range []string{"SELECT COUNT(*) FROM plays", "SELECT COUNT(*) FROM stars"} {
+		var n int
+		if err := DB.QueryRow(q).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%s = %d, want 0", q, n)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"homemusic-server/internal/types"
+)
+
+// ErrInvalidSmartRules wraps every validation failure of a rule tree.
+var ErrInvalidSmartRules = errors.New("invalid smart playlist rules")
+
+const (
+	maxSmartRuleDepth = 8
+	maxSmartLimit     = 5000
+)
+
+type smartFieldKind int
+
+const (
+	smartString smartFieldKind = iota
+	smartNumber
+	smartDate
+)
+
+type smartField struct {
+	column string
+	kind   smartFieldKind
+}
+
+// smartExtension is the text after the last dot of a track's path: RTRIM
+// strips every trailing non-dot character, leaving the prefix up to the
+// last dot, which REPLACE then removes from the path.
+const smartExtension = "LOWER(REPLACE(t.path, RTRIM(t.path, REPLACE(t.path, '.', '')), ''))"
+
+// smartFields maps rule field names (the JSON names of types.Track) to SQL.
+// "format" is the file extension without the dot, or empty when the file
+// name has none: a path without a dot would otherwise be its own
+// extension, and one whose only dots are in folder names would end in a
+// folder.
+var smartFields = map[string]smartField{
+	"title":       {"t.title", smartString},
+	"artist":      {"COALESCE(t.artists_display, t.artist)", smartString},
+	"album":       {"t.album", smartString},
+	"path":        {"t.path", smartString},
+	"folderPath":  {"t.folder_path", smartString},
+	"format":      {"CASE WHEN INSTR(t.path, '.') = 0 OR INSTR(" + smartExtension + ", '/') > 0 THEN '' ELSE " + smartExtension + " END", smartString},
+	"sourceId":    {"t.source_id", smartString},
+	"year":        {"t.year", smartNumber},
+	"trackNumber": {"t.track_number", smartNumber},
+	"duration":    {"t.duration", smartNumber},
+	"createdAt":   {"t.created_at", smartDate},
+	"sourceMtime": {"t.source_mtime", smartDate},
+}
+
+// compileSmartRules turns a rule tree into a WHERE clause, an ORDER BY
+// clause and a limit over the tracks table aliased as t.
+func compileSmartRules(rules *types.SmartRules) (where string, args []interface{}, orderBy string, limit int, err error) {
+	where, args, err = compileSmartRule(rules.SmartRule, 0)
+	if err != nil {
+		return "", nil, "", 0, err
+	}
+
+	orderBy = "t.artist ASC, t.album ASC, t.track_number ASC, t.title ASC"
+	if rules.Sort != "" {
+		dir := "ASC"
+		switch strings.ToLower(rules.Order) {
+		case "", "asc":
+		case "desc":
+			dir = "DESC"
+		default:
+			return "", nil, "", 0, fmt.Errorf("%w: unknown sort order %q", ErrInvalidSmartRules, rules.Order)
+		}
+		if rules.Sort == "random" {
+			orderBy = "RANDOM()"
+		} else if f, ok := smartFields[rules.Sort]; ok {
+			column := f.column
+			if f.kind == smartDate {
+				column = julianDayUTC(column)
+			}
+			orderBy = column + " " + dir + ", t.title ASC"
+		} else {
+			return "", nil, "", 0, fmt.Errorf("%w: unknown sort field %q", ErrInvalidSmartRules, rules.Sort)
+		}
+	}
+
+	limit = rules.Limit
+	if limit < 0 {
+		return "", nil, "", 0, fmt.Errorf("%w: limit must not be negative", ErrInvalidSmartRules)
+	}
+	if limit == 0 || limit > maxSmartLimit {
+		limit = maxSmartLimit
+	}
+	return where, args, orderBy, limit, nil
+}
+
+func compileSmartRule(rule types.SmartRule, depth int) (string, []interface{}, error) {
+	if depth > maxSmartRuleDepth {
+		return "", nil, fmt.Errorf("%w: rules nested too deeply", ErrInvalidSmartRules)
+	}
+
+	if rule.Field == "" {
+		joiner := " AND "
+		switch strings.ToLower(rule.Match) {
+		case "", "all":
+		case "any":
+			joiner = " OR "
+		default:
+			return "", nil, fmt.Errorf("%w: unknown match %q", ErrInvalidSmartRules, rule.Match)
+		}
+		if len(rule.Rules) == 0 {
+			return "1 = 1", nil, nil
+		}
+
+		var parts []string
+		var args []interface{}
+		for _, child := range rule.Rules {
+			sql, childArgs, err := compileSmartRule(child, depth+1)
+			if err != nil {
+				return "", nil, err
+			}
+			parts = append(parts, "("+sql+")")
+			args = append(args, childArgs...)
+		}
+		return strings.Join(parts, joiner), args, nil
+	}
+
+	f, ok := smartFields[rule.Field]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: unknown field %q", ErrInvalidSmartRules, rule.Field)
+	}
+	switch f.kind {
+	case smartString:
+		return compileStringCondition(f.column, rule)
+	case smartNumber:
+		return compileNumberCondition(f.column, rule)
+	default:
+		return compileDateCondition(f.column, rule)
+	}
+}
+
+func compileStringCondition(column string, rule types.SmartRule) (string, []interface{}, error) {
+	value, ok := rule.Value.(string)
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s expects a string value", ErrInvalidSmartRules, rule.Field)
+	}
+	if rule.Field == "format" {
+		value = strings.TrimPrefix(strings.ToLower(value), ".")
+	}
+
+	escaped := escapeLike(value)
+	switch rule.Operator {
+	case "is":
+		return column + " = ? COLLATE NOCASE", []interface{}{value}, nil
+	case "isNot":
+		return "COALESCE(" + column + ", '') <> ? COLLATE NOCASE", []interface{}{value}, nil
+	case "contains":
+		return column + ` LIKE ? ESCAPE '\'`, []interface{}{"%" + escaped + "%"}, nil
+	case "notContains":
+		return "COALESCE(" + column + `, '') NOT LIKE ? ESCAPE '\'`, []interface{}{"%" + escaped + "%"}, nil
+	case "startsWith":
+		return column + ` LIKE ? ESCAPE '\'`, []interface{}{escaped + "%"}, nil
+	case "endsWith":
+		return column + ` LIKE ? ESCAPE '\'`, []interface{}{"%" + escaped}, nil
+	}
+	return "", nil, fmt.Errorf("%w: operator %q not supported for %s", ErrInvalidSmartRules, rule.Operator, rule.Field)
+}
+
+func compileNumberCondition(column string, rule types.SmartRule) (string, []interface{}, error) {
+	if rule.Operator == "between" {
+		bounds, ok := rule.Value.([]interface{})
+		if !ok || len(bounds) != 2 {
+			return "", nil, fmt.Errorf("%w: between expects [min, max]", ErrInvalidSmartRules)
+		}
+		lo, ok1 := bounds[0].(float64)
+		hi, ok2 := bounds[1].(float64)
+		if !ok1 || !ok2 {
+			return "", nil, fmt.Errorf("%w: %s expects numeric bounds", ErrInvalidSmartRules, rule.Field)
+		}
+		return column + " BETWEEN ? AND ?", []interface{}{lo, hi}, nil
+	}
+
+	value, ok := rule.Value.(float64)
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s expects a numeric value", ErrInvalidSmartRules, rule.Field)
+	}
+	ops := map[string]string{"is": "=", "isNot": "<>", "gt": ">", "gte": ">=", "lt": "<", "lte": "<="}
+	op, ok := ops[rule.Operator]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: operator %q not supported for %s", ErrInvalidSmartRules, rule.Operator, rule.Field)
+	}
+	return column + " " + op + " ?", []interface{}{value}, nil
+}
+
+// julianDayUTC converts a stored time to a UTC Julian day. SQLite's
+// CURRENT_TIMESTAMP is "YYYY-MM-DD HH:MM:SS" in UTC, while the driver
+// stores time.Time as "YYYY-MM-DD HH:MM:SS[.fff] +hhmm ZONE" in the value's
+// own zone; the offset is rewritten as "+hh:mm" so julianday applies it.
+func julianDayUTC(column string) string {
+	rest := "substr(" + column + ", 20)"
+	offset := "substr(" + rest + ", instr(" + rest + ", ' ') + 1, 5)"
+	return "julianday(substr(" + column + ", 1, 19) || CASE WHEN " + offset + " GLOB '[+-][0-9][0-9][0-9][0-9]'" +
+		" THEN substr(" + offset + ", 1, 3) || ':' || substr(" + offset + ", 4, 2) ELSE '' END)"
+}
+
+// Dates are compared as UTC Julian days; see julianDayUTC.
+func compileDateCondition(column string, rule types.SmartRule) (string, []interface{}, error) {
+	column = julianDayUTC(column)
+	switch rule.Operator {
+	case "inLast", "notInLast":
+		days, ok := rule.Value.(float64)
+		if !ok || days < 0 {
+			return "", nil, fmt.Errorf("%w: %s expects a number of days", ErrInvalidSmartRules, rule.Operator)
+		}
+		cutoff := time.Now().UTC().Add(-time.Duration(days * float64(24*time.Hour))).Format("2006-01-02 15:04:05")
+		if rule.Operator == "inLast" {
+			return column + " >= julianday(?)", []interface{}{cutoff}, nil
+		}
+		return column + " < julianday(?)", []interface{}{cutoff}, nil
+	case "before", "after":
+		value, ok := rule.Value.(string)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %s expects a date", ErrInvalidSmartRules, rule.Operator)
+		}
+		t, err := parseSmartDate(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: invalid date %q", ErrInvalidSmartRules, value)
+		}
+		op := "<"
+		if rule.Operator == "after" {
+			op = ">"
+		}
+		return column + " " + op + " julianday(?)", []interface{}{t.UTC().Format("2006-01-02 15:04:05")}, nil
+	}
+	return "", nil, fmt.Errorf("%w: operator %q not supported for %s", ErrInvalidSmartRules, rule.Operator, rule.Field)
+}
+
+func parseSmartDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
+
+// ValidateSmartRules reports whether the rule tree can be compiled.
+func ValidateSmartRules(rules *types.SmartRules) error {
+	_, _, _, _, err := compileSmartRules(rules)
+	return err
+}
+
+func GetSmartPlaylistTracks(rules *types.SmartRules) ([]types.Track, error) {
+	where, args, orderBy, limit, err := compileSmartRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT t.id, t.title, t.artist, t.album, t.duration, t.track_number, t.year, t.path, t.folder_path, t.image_url, t.source_mtime, t.artists_display, t.source_id, t.album_id, t.artist_id, t.created_at
+		FROM tracks t WHERE ` + where + ` ORDER BY ` + orderBy + ` LIMIT ?`
+	rows, err := DB.Query(query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := []types.Track{}
+	for rows.Next() {
+		var t types.Track
+		err := rows.Scan(&t.ID, &t.Title, &t.Artist, &t.Album, &t.Duration, &t.TrackNumber, &t.Year, &t.Path, &t.FolderPath, &t.ImageUrl, &t.SourceMtime, &t.ArtistsDisplay, &t.SourceID, &t.AlbumID, &t.ArtistID, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, nil
+}
+
+func countSmartPlaylistTracks(rules *types.SmartRules) (int, error) {
+	where, args, _, limit, err := compileSmartRules(rules)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = DB.QueryRow("SELECT COUNT(*) FROM (SELECT 1 FROM tracks t WHERE "+where+" LIMIT ?)", append(args, limit)...).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"homemusic-server/internal/types"
+)
+
+func TestSmartDateRulesUseUTC(t *testing.T) {
+	openTestDB(t)
+	if err := CreateSource(types.Source{ID: "src", Name: "NAS", Type: types.SourceTypeSMB, Host: "nas", Port: 445}); err != nil {
+		t.Fatal(err)
+	}
+	// Sources report mtimes in the server's zone; two hours east of UTC,
+	// 90 minutes ago reads as 30 minutes ahead if the offset is ignored
+	east := time.FixedZone("EET", 2*60*60)
+	mtimes := map[string]time.Time{
+		"recent": time.Now().Add(-90 * time.Minute).In(east),
+		"older":  time.Now().Add(-3 * time.Hour).In(east),
+		"future": time.Now().Add(30 * time.Minute).In(east),
+	}
+	for id, mtime := range mtimes {
+		_, err := DB.Exec(`INSERT INTO tracks (id, title, artist, album, duration, path, source_id, source_mtime)
+			VALUES (?, ?, 'A', 'B', 60, ?, 'src', ?)`, id, id, id+".mp3", mtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		rule types.SmartRule
+		want []string
+	}{
+		{types.SmartRule{Field: "sourceMtime", Operator: "before", Value: time.Now().Add(-time.Hour).Format(time.RFC3339)}, []string{"older", "recent"}},
+		{types.SmartRule{Field: "sourceMtime", Operator: "after", Value: time.Now().Format(time.RFC3339)}, []string{"future"}},
+		{types.SmartRule{Field: "sourceMtime", Operator: "inLast", Value: 0.1}, []string{"future", "recent"}},
+		{types.SmartRule{Field: "createdAt", Operator: "inLast", Value: 1.0}, []string{"future", "older", "recent"}},
+	}
+	for _, tt := range tests {
+		tracks, err := GetSmartPlaylistTracks(&types.SmartRules{SmartRule: tt.rule, Sort: "title"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, tr := range tracks {
+			got = append(got, tr.ID)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s %s %v = %v, want %v", tt.rule.Field, tt.rule.Operator, tt.rule.Value, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s %s %v = %v, want %v", tt.rule.Field, tt.rule.Operator, tt.rule.Value, got, tt.want)
+				break
+			}
+		}
+	}
+
+	// Sorting compares instants too, not the text
+	tracks, err := GetSmartPlaylistTracks(&types.SmartRules{SmartRule: types.SmartRule{Field: "title", Operator: "contains", Value: "e"}, Sort: "sourceMtime"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 3 || tracks[0].ID != "older" || tracks[2].ID != "future" {
+		t.Errorf("sorted by sourceMtime: %v", tracks)
+	}
+}
+
+func TestSmartFormatRule(t *testing.T) {
+	openTestDB(t)
+	if err := CreateSource(types.Source{ID: "src", Name: "NAS", Type: types.SourceTypeSMB, Host: "nas", Port: 445}); err != nil {
+		t.Fatal(err)
+	}
+	for id, path := range map[string]string{
+		"flac":     "/music/a.FLAC",
+		"mp3":      "/music/v1.2/b.mp3",
+		"none":     "/music/README",
+		"dotted":   "/music/v1.2/c",
+		"trailing": "/music/d.",
+	} {
+		_, err := DB.Exec(`INSERT INTO tracks (id, title, artist, album, duration, path, source_id)
+			VALUES (?, ?, 'A', 'B', 60, ?, 'src')`, id, id, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{".flac", "flac"},
+		{"mp3", "mp3"},
+		{"", "dotted,none,trailing"},
+		{"README", ""},
+		{"2/c", ""},
+	}
+	for _, tt := range tests {
+		tracks, err := GetSmartPlaylistTracks(&types.SmartRules{SmartRule: types.SmartRule{Field: "format", Operator: "is", Value: tt.value}, Sort: "title"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, tr := range tracks {
+			ids = append(ids, tr.ID)
+		}
+		if got := strings.Join(ids, ","); got != tt.want {
+			t.Errorf("format is %q = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
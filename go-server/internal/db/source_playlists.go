@@ -0,0 +1,79 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"homemusic-server/internal/types"
+)
+
+// SyncSourcePlaylist creates or refreshes the read-only playlist backed by
+// a playlist file on a source, replacing its items with trackIDs.
+func SyncSourcePlaylist(sourceID, sourcePath, name string, trackIDs []string) error {
+	return withTx(func(tx *sql.Tx) error {
+		now := time.Now()
+		var id string
+		err := tx.QueryRow("SELECT id FROM playlists WHERE source_id = ? AND source_path = ?", sourceID, sourcePath).Scan(&id)
+		if err == sql.ErrNoRows {
+			id = uuid.New().String()
+			_, err = tx.Exec("INSERT INTO playlists (id, name, source_id, source_path, visibility, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				id, name, sourceID, sourcePath, types.VisibilityShared, now, now)
+		} else if err == nil {
+			_, err = tx.Exec("UPDATE playlists SET name = ?, updated_at = ? WHERE id = ?", name, now, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("DELETE FROM playlist_items WHERE playlist_id = ?", id); err != nil {
+			return err
+		}
+		stmt, err := tx.Prepare("INSERT INTO playlist_items (id, playlist_id, track_id, \"order\") VALUES (?, ?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for i, trackID := range trackIDs {
+			if _, err := stmt.Exec(uuid.New().String(), id, trackID, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteStaleSourcePlaylists removes source playlists whose file was not
+// seen in the latest scan of the source.
+func DeleteStaleSourcePlaylists(sourceID string, seenPaths []string) error {
+	return withTx(func(tx *sql.Tx) error {
+		seen := map[string]bool{}
+		for _, p := range seenPaths {
+			seen[p] = true
+		}
+
+		rows, err := tx.Query("SELECT id, source_path FROM playlists WHERE source_id = ?", sourceID)
+		if err != nil {
+			return err
+		}
+		var stale []string
+		for rows.Next() {
+			var id, path string
+			if err := rows.Scan(&id, &path); err != nil {
+				rows.Close()
+				return err
+			}
+			if !seen[path] {
+				stale = append(stale, id)
+			}
+		}
+		rows.Close()
+
+		for _, id := range stale {
+			if err := deletePlaylistTx(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -5,10 +5,12 @@ import (
 	"homemusic-server/internal/types"
 )
 
-func GetAllSources() ([]map[string]interface{}, error) {
+// GetAllSources lists the sources with their scan status. Passwords are
+// left out; HasPassword says whether one is stored.
+func GetAllSources() ([]types.SourceSummary, error) {
 	query := `
-		SELECT s.id, s.name, s.type, s.host, s.port, s.username, s.domain, s.share, s.base_path, s.enabled, s.created_at, s.updated_at,
-		       st.status, st.progress, st.total_files, st.scanned_files, st.last_error, st.last_scan
+		SELECT s.id, s.name, s.type, s.host, s.port, s.username, COALESCE(s.password, '') != '', s.domain, s.share, s.base_path, s.enabled, s.max_streams, s.max_bandwidth, s.created_at, s.updated_at,
+		       st.source_id, st.status, st.progress, st.total_files, st.scanned_files, st.last_error, st.last_scan
 		FROM sources s
 		LEFT JOIN source_status st ON s.id = st.source_id
 		ORDER BY s.name ASC
@@ -19,57 +21,26 @@ func GetAllSources() ([]map[string]interface{}, error) {
 	}
 	defer rows.Close()
 
-	sources := []map[string]interface{}{}
+	sources := []types.SourceSummary{}
 	for rows.Next() {
-		var s types.Source
-		var st types.SourceStatus
-		var lastError, lastScan sql.NullString
-		
+		var s types.SourceSummary
+		st := &s.Status
 		err := rows.Scan(
-			&s.ID, &s.Name, &s.Type, &s.Host, &s.Port, &s.Username, &s.Domain, &s.Share, &s.BasePath, &s.Enabled, &s.CreatedAt, &s.UpdatedAt,
-			&st.Status, &st.Progress, &st.TotalFiles, &st.ScannedFiles, &lastError, &lastScan,
+			&s.ID, &s.Name, &s.Type, &s.Host, &s.Port, &s.Username, &s.HasPassword, &s.Domain, &s.Share, &s.BasePath, &s.Enabled, &s.MaxStreams, &s.MaxBandwidth, &s.CreatedAt, &s.UpdatedAt,
+			&st.SourceID, &st.Status, &st.Progress, &st.TotalFiles, &st.ScannedFiles, &st.LastError, &st.LastScan,
 		)
 		if err != nil {
 			return nil, err
 		}
-
-		sourceMap := map[string]interface{}{
-			"id":        s.ID,
-			"name":      s.Name,
-			"type":      s.Type,
-			"host":      s.Host,
-			"port":      s.Port,
-			"username":  s.Username,
-			"domain":    s.Domain,
-			"share":     s.Share,
-			"basePath":  s.BasePath,
-			"enabled":   s.Enabled,
-			"createdAt": s.CreatedAt,
-			"updatedAt": s.UpdatedAt,
-			"status": map[string]interface{}{
-				"status":       st.Status,
-				"progress":     st.Progress,
-				"totalFiles":   st.TotalFiles,
-				"scannedFiles": st.ScannedFiles,
-			},
-		}
-		
-		if lastError.Valid {
-			sourceMap["status"].(map[string]interface{})["lastError"] = lastError.String
-		}
-		if lastScan.Valid {
-			sourceMap["status"].(map[string]interface{})["lastScan"] = lastScan.String
-		}
-
-		sources = append(sources, sourceMap)
+		sources = append(sources, s)
 	}
 	return sources, nil
 }
 
 func GetSource(id string) (*types.Source, error) {
 	var s types.Source
-	err := DB.QueryRow("SELECT id, name, type, host, port, username, password, domain, share, base_path, enabled, created_at, updated_at FROM sources WHERE id = ?", id).
-		Scan(&s.ID, &s.Name, &s.Type, &s.Host, &s.Port, &s.Username, &s.Password, &s.Domain, &s.Share, &s.BasePath, &s.Enabled, &s.CreatedAt, &s.UpdatedAt)
+	err := DB.QueryRow("SELECT id, name, type, host, port, username, password, domain, share, base_path, enabled, max_streams, max_bandwidth, created_at, updated_at FROM sources WHERE id = ?", id).
+		Scan(&s.ID, &s.Name, &s.Type, &s.Host, &s.Port, &s.Username, &s.Password, &s.Domain, &s.Share, &s.BasePath, &s.Enabled, &s.MaxStreams, &s.MaxBandwidth, &s.CreatedAt, &s.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -77,9 +48,9 @@ func GetSource(id string) (*types.Source, error) {
 }
 
 func CreateSource(s types.Source) error {
-	_, err := DB.Exec(`INSERT INTO sources (id, name, type, host, port, username, password, domain, share, base_path, enabled) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		s.ID, s.Name, s.Type, s.Host, s.Port, s.Username, s.Password, s.Domain, s.Share, s.BasePath, s.Enabled)
+	_, err := DB.Exec(`INSERT INTO sources (id, name, type, host, port, username, password, domain, share, base_path, enabled, max_streams, max_bandwidth) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.ID, s.Name, s.Type, s.Host, s.Port, s.Username, s.Password, s.Domain, s.Share, s.BasePath, s.Enabled, s.MaxStreams, s.MaxBandwidth)
 	if err != nil {
 		return err
 	}
@@ -102,3 +73,33 @@ func GetSourceStatus(sourceID string) (*types.SourceStatus, error) {
 	}
 	return &s, err
 }
+
+// UpdateSourceStatus records a scan's progress. It leaves last_scan alone;
+// the scanner sets that when it commits a scan.
+func UpdateSourceStatus(st types.SourceStatus) error {
+	_, err := DB.Exec(`UPDATE source_status SET
+		status = ?, progress = ?, total_files = ?, scanned_files = ?, last_error = ?
+		WHERE source_id = ?`,
+		st.Status, st.Progress, st.TotalFiles, st.ScannedFiles, st.LastError, st.SourceID)
+	return err
+}
+
+// GetSourceList returns every source in creation order, without
+// credentials or scan status.
+func GetSourceList() ([]types.Source, error) {
+	rows, err := DB.Query("SELECT id, name, type, enabled, created_at, updated_at FROM sources ORDER BY created_at ASC, id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := []types.Source{}
+	for rows.Next() {
+		var s types.Source
+		if err := rows.Scan(&s.ID, &s.Name, &s.Type, &s.Enabled, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		sources = append(sources, s)
+	}
+	return sources, rows.Err()
+}
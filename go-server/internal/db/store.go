@@ -0,0 +1,223 @@
+package db
+
+import (
+	"homemusic-server/internal/types"
+)
+
+// SourceStore holds the configured music sources.
+type SourceStore interface {
+	GetAllSources() ([]types.SourceSummary, error)
+	GetSourceList() ([]types.Source, error)
+	GetSource(id string) (*types.Source, error)
+	CreateSource(s types.Source) error
+	UpdateSource(s types.Source) error
+	DeleteSource(id string) error
+}
+
+// StatusStore holds the scan status of each source.
+type StatusStore interface {
+	GetSourceStatus(sourceID string) (*types.SourceStatus, error)
+	UpdateSourceStatus(status types.SourceStatus) error
+}
+
+// LibraryStore holds the scanned tracks, albums and artists.
+type LibraryStore interface {
+	GetTrack(id string) (*types.Track, error)
+	GetTrackBySourcePath(sourceID, relPath string) (*types.Track, error)
+	GetAllTracks() ([]types.Track, error)
+	GetTracksByAlbum(albumID string) ([]types.Track, error)
+	GetTracksByFolder(path string) ([]types.Track, error)
+	GetTracksBySource(sourceID string) ([]types.Track, error)
+	GetAllAlbums() ([]types.AlbumSummary, error)
+	GetAlbum(id string) (*types.AlbumDetail, error)
+	GetAllArtists() ([]types.Artist, error)
+	GetArtist(id string) (*types.Artist, error)
+	GetFolders() ([]types.Folder, error)
+}
+
+// PlaylistStore holds playlists, their items and who they are shared with.
+type PlaylistStore interface {
+	GetAllPlaylists(user *types.User) ([]types.PlaylistSummary, error)
+	GetFollowedPlaylists(user *types.User) ([]types.PlaylistSummary, error)
+	GetPublicPlaylists(user *types.User) ([]types.PlaylistSummary, error)
+	GetPlaylist(id string) (*types.PlaylistDetail, error)
+	CreatePlaylist(name, ownerID string) (*types.Playlist, error)
+	CreateSmartPlaylist(name, ownerID string, rules *types.SmartRules) (*types.Playlist, error)
+	UpdateSmartPlaylistRules(id string, rules *types.SmartRules) error
+	RenamePlaylist(id, name string) error
+	DeletePlaylist(id string) error
+
+	AddTracksToPlaylist(playlistID string, trackIDs []string) (int, error)
+	ReplacePlaylistTracks(playlistID string, trackIDs []string) (int, error)
+	RemoveTrackFromPlaylist(playlistID, trackID string) error
+	RemovePlaylistItem(playlistID, itemID string) error
+	MovePlaylistItem(playlistID, itemID string, position int) error
+	ClearPlaylist(id string) error
+
+	GetPlaylistAccess(playlistID string, user *types.User) (PlaylistAccess, error)
+	SetPlaylistVisibility(playlistID string, visibility types.PlaylistVisibility) error
+	GetPlaylistCollaborators(playlistID string) ([]types.PlaylistCollaborator, error)
+	SetPlaylistCollaborator(playlistID, userID string, permission types.CollaboratorPermission) error
+	RemovePlaylistCollaborator(playlistID, userID string) error
+	FollowPlaylist(userID, playlistID string) error
+	UnfollowPlaylist(userID, playlistID string) error
+	IsFollowingPlaylist(userID, playlistID string) (bool, error)
+
+	SyncSourcePlaylist(sourceID, sourcePath, name string, trackIDs []string) error
+	DeleteStaleSourcePlaylists(sourceID string, seenPaths []string) error
+}
+
+// UserStore looks up the accounts handlers refer to by name.
+type UserStore interface {
+	GetUserByUsername(username string) (*types.User, error)
+}
+
+// ScanStore stages the files a scan finds and merges them into the library
+// once the scan is done.
+type ScanStore interface {
+	KnownFiles(sourceID string) (map[string]KnownFile, error)
+	ClearStagedTracks(sourceID string) error
+	StageTracks(sourceID string, tracks []ScannedTrack) error
+	CommitScan(sourceID string, seen map[string]bool) error
+}
+
+// Store bundles the stores the HTTP handlers and the scanner work with.
+type Store struct {
+	Sources   SourceStore
+	Status    StatusStore
+	Library   LibraryStore
+	Playlists PlaylistStore
+	Users     UserStore
+	Scans     ScanStore
+}
+
+// SQLiteStore returns the stores backed by the database opened with Open.
+func SQLiteStore() *Store {
+	s := sqliteStore{}
+	return &Store{Sources: s, Status: s, Library: s, Playlists: s, Users: s, Scans: s}
+}
+
+// sqliteStore adapts the package's functions to the store interfaces.
+type sqliteStore struct{}
+
+func (sqliteStore) GetAllSources() ([]types.SourceSummary, error) { return GetAllSources() }
+func (sqliteStore) GetSourceList() ([]types.Source, error)        { return GetSourceList() }
+func (sqliteStore) GetSource(id string) (*types.Source, error)    { return GetSource(id) }
+func (sqliteStore) CreateSource(s types.Source) error             { return CreateSource(s) }
+func (sqliteStore) UpdateSource(s types.Source) error {
+	return UpdateSource(s)
+}
+func (sqliteStore) DeleteSource(id string) error { return DeleteSource(id) }
+
+func (sqliteStore) GetSourceStatus(sourceID string) (*types.SourceStatus, error) {
+	return GetSourceStatus(sourceID)
+}
+func (sqliteStore) UpdateSourceStatus(status types.SourceStatus) error {
+	return UpdateSourceStatus(status)
+}
+
+func (sqliteStore) GetTrack(id string) (*types.Track, error) { return GetTrack(id) }
+func (sqliteStore) GetTrackBySourcePath(sourceID, relPath string) (*types.Track, error) {
+	return GetTrackBySourcePath(sourceID, relPath)
+}
+func (sqliteStore) GetAllTracks() ([]types.Track, error) { return GetAllTracks() }
+func (sqliteStore) GetTracksByAlbum(albumID string) ([]types.Track, error) {
+	return GetTracksByAlbum(albumID)
+}
+func (sqliteStore) GetTracksByFolder(path string) ([]types.Track, error) {
+	return GetTracksByFolder(path)
+}
+func (sqliteStore) GetTracksBySource(sourceID string) ([]types.Track, error) {
+	return GetTracksBySource(sourceID)
+}
+func (sqliteStore) GetAllAlbums() ([]types.AlbumSummary, error)    { return GetAllAlbums() }
+func (sqliteStore) GetAlbum(id string) (*types.AlbumDetail, error) { return GetAlbum(id) }
+func (sqliteStore) GetAllArtists() ([]types.Artist, error)         { return GetAllArtists() }
+func (sqliteStore) GetArtist(id string) (*types.Artist, error)     { return GetArtist(id) }
+func (sqliteStore) GetFolders() ([]types.Folder, error)            { return GetFolders() }
+
+func (sqliteStore) GetAllPlaylists(user *types.User) ([]types.PlaylistSummary, error) {
+	return GetAllPlaylists(user)
+}
+func (sqliteStore) GetFollowedPlaylists(user *types.User) ([]types.PlaylistSummary, error) {
+	return GetFollowedPlaylists(user)
+}
+func (sqliteStore) GetPublicPlaylists(user *types.User) ([]types.PlaylistSummary, error) {
+	return GetPublicPlaylists(user)
+}
+func (sqliteStore) GetPlaylist(id string) (*types.PlaylistDetail, error) { return GetPlaylist(id) }
+func (sqliteStore) CreatePlaylist(name, ownerID string) (*types.Playlist, error) {
+	return CreatePlaylist(name, ownerID)
+}
+func (sqliteStore) CreateSmartPlaylist(name, ownerID string, rules *types.SmartRules) (*types.Playlist, error) {
+	return CreateSmartPlaylist(name, ownerID, rules)
+}
+func (sqliteStore) UpdateSmartPlaylistRules(id string, rules *types.SmartRules) error {
+	return UpdateSmartPlaylistRules(id, rules)
+}
+func (sqliteStore) RenamePlaylist(id, name string) error { return RenamePlaylist(id, name) }
+func (sqliteStore) DeletePlaylist(id string) error       { return DeletePlaylist(id) }
+
+func (sqliteStore) AddTracksToPlaylist(playlistID string, trackIDs []string) (int, error) {
+	return AddTracksToPlaylist(playlistID, trackIDs)
+}
+func (sqliteStore) ReplacePlaylistTracks(playlistID string, trackIDs []string) (int, error) {
+	return ReplacePlaylistTracks(playlistID, trackIDs)
+}
+func (sqliteStore) RemoveTrackFromPlaylist(playlistID, trackID string) error {
+	return RemoveTrackFromPlaylist(playlistID, trackID)
+}
+func (sqliteStore) RemovePlaylistItem(playlistID, itemID string) error {
+	return RemovePlaylistItem(playlistID, itemID)
+}
+func (sqliteStore) MovePlaylistItem(playlistID, itemID string, position int) error {
+	return MovePlaylistItem(playlistID, itemID, position)
+}
+func (sqliteStore) ClearPlaylist(id string) error { return ClearPlaylist(id) }
+
+func (sqliteStore) GetPlaylistAccess(playlistID string, user *types.User) (PlaylistAccess, error) {
+	return GetPlaylistAccess(playlistID, user)
+}
+func (sqliteStore) SetPlaylistVisibility(playlistID string, visibility types.PlaylistVisibility) error {
+	return SetPlaylistVisibility(playlistID, visibility)
+}
+func (sqliteStore) GetPlaylistCollaborators(playlistID string) ([]types.PlaylistCollaborator, error) {
+	return GetPlaylistCollaborators(playlistID)
+}
+func (sqliteStore) SetPlaylistCollaborator(playlistID, userID string, permission types.CollaboratorPermission) error {
+	return SetPlaylistCollaborator(playlistID, userID, permission)
+}
+func (sqliteStore) RemovePlaylistCollaborator(playlistID, userID string) error {
+	return RemovePlaylistCollaborator(playlistID, userID)
+}
+func (sqliteStore) FollowPlaylist(userID, playlistID string) error {
+	return FollowPlaylist(userID, playlistID)
+}
+func (sqliteStore) UnfollowPlaylist(userID, playlistID string) error {
+	return UnfollowPlaylist(userID, playlistID)
+}
+func (sqliteStore) IsFollowingPlaylist(userID, playlistID string) (bool, error) {
+	return IsFollowingPlaylist(userID, playlistID)
+}
+
+func (sqliteStore) SyncSourcePlaylist(sourceID, sourcePath, name string, trackIDs []string) error {
+	return SyncSourcePlaylist(sourceID, sourcePath, name, trackIDs)
+}
+func (sqliteStore) DeleteStaleSourcePlaylists(sourceID string, seenPaths []string) error {
+	return DeleteStaleSourcePlaylists(sourceID, seenPaths)
+}
+
+func (sqliteStore) GetUserByUsername(username string) (*types.User, error) {
+	return GetUserByUsername(username)
+}
+
+func (sqliteStore) KnownFiles(sourceID string) (map[string]KnownFile, error) {
+	return KnownFiles(sourceID)
+}
+func (sqliteStore) ClearStagedTracks(sourceID string) error { return ClearStagedTracks(sourceID) }
+func (sqliteStore) StageTracks(sourceID string, tracks []ScannedTrack) error {
+	return StageTracks(sourceID, tracks)
+}
+func (sqliteStore) CommitScan(sourceID string, seen map[string]bool) error {
+	return CommitScan(sourceID, seen)
+}
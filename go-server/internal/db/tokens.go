@@ -0,0 +1,95 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"homemusic-server/internal/types"
+)
+
+func CreateAPIToken(userID, name, tokenHash string, scopes []types.TokenScope, expiresAt *time.Time) (*types.APIToken, error) {
+	scopesJSON, err := json.Marshal(scopes)
+	if err != nil {
+		return nil, err
+	}
+	t := &types.APIToken{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      name,
+		TokenHash: tokenHash,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	_, err = DB.Exec("INSERT INTO api_tokens (id, user_id, name, token_hash, scopes, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		t.ID, t.UserID, t.Name, t.TokenHash, string(scopesJSON), t.CreatedAt, t.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func GetAPITokens(userID string) ([]types.APIToken, error) {
+	rows, err := DB.Query(`
+		SELECT id, user_id, name, token_hash, scopes, created_at, last_used_at, expires_at
+		FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []types.APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// GetAPITokenByHash returns the token with the given secret hash, or nil.
+// Expiry is left to the caller.
+func GetAPITokenByHash(tokenHash string) (*types.APIToken, error) {
+	row := DB.QueryRow(`
+		SELECT id, user_id, name, token_hash, scopes, created_at, last_used_at, expires_at
+		FROM api_tokens WHERE token_hash = ?`, tokenHash)
+	t, err := scanAPIToken(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*types.APIToken, error) {
+	var t types.APIToken
+	var scopesJSON string
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &scopesJSON, &t.CreatedAt, &t.LastUsedAt, &t.ExpiresAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(scopesJSON), &t.Scopes); err != nil {
+		return nil, fmt.Errorf("failed to decode token scopes: %w", err)
+	}
+	return &t, nil
+}
+
+func TouchAPIToken(id string, usedAt time.Time) error {
+	_, err := DB.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", usedAt, id)
+	return err
+}
+
+// DeleteAPIToken revokes one of the user's tokens.
+func DeleteAPIToken(userID, id string) error {
+	res, err := DB.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
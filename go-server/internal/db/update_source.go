@@ -2,23 +2,17 @@ package db
 
 import (
 	"time"
-)
-
-func UpdateSource(id string, updates map[string]interface{}) error {
-	query := "UPDATE sources SET updated_at = ?"
-	args := []interface{}{time.Now()}
 
-	for k, v := range updates {
-		// Only allow updating specific fields
-		if k == "name" || k == "host" || k == "port" || k == "username" || k == "password" || k == "domain" || k == "share" || k == "base_path" || k == "enabled" {
-			query += ", " + k + " = ?"
-			args = append(args, v)
-		}
-	}
-
-	query += " WHERE id = ?"
-	args = append(args, id)
+	"homemusic-server/internal/types"
+)
 
-	_, err := DB.Exec(query, args...)
+// UpdateSource saves every editable field of s. Callers merge their changes
+// into the stored source and validate it first.
+func UpdateSource(s types.Source) error {
+	_, err := DB.Exec(`UPDATE sources SET name = ?, type = ?, host = ?, port = ?, username = ?, password = ?, domain = ?, share = ?,
+		base_path = ?, enabled = ?, max_streams = ?, max_bandwidth = ?, updated_at = ?
+		WHERE id = ?`,
+		s.Name, s.Type, s.Host, s.Port, s.Username, s.Password, s.Domain, s.Share,
+		s.BasePath, s.Enabled, s.MaxStreams, s.MaxBandwidth, time.Now(), s.ID)
 	return err
 }
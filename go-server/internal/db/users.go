@@ -0,0 +1,293 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"homemusic-server/internal/types"
+)
+
+// ErrUsernameTaken is returned when creating a user whose name exists.
+var ErrUsernameTaken = errors.New("username already exists")
+
+// ErrSetupDone is returned when creating the first account after another
+// account already exists.
+var ErrSetupDone = errors.New("setup has already been completed")
+
+// ErrLastAdmin is returned when a change would leave no administrator.
+var ErrLastAdmin = errors.New("at least one admin account is required")
+
+func CountUsers() (int, error) {
+	var n int
+	err := DB.QueryRow("SELECT COUNT(*) FROM users").Scan(&n)
+	return n, err
+}
+
+func newUser(username, passwordHash string, role types.UserRole) *types.User {
+	return &types.User{
+		ID:           uuid.New().String(),
+		Username:     username,
+		PasswordHash: passwordHash,
+		Role:         role,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+}
+
+func CreateUser(username, passwordHash string, role types.UserRole) (*types.User, error) {
+	u := newUser(username, passwordHash, role)
+
+	var exists int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists > 0 {
+		return nil, ErrUsernameTaken
+	}
+
+	_, err := DB.Exec("INSERT INTO users (id, username, password_hash, role, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		u.ID, u.Username, u.PasswordHash, u.Role, u.CreatedAt, u.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// CreateFirstAdmin creates the initial admin account, failing with
+// ErrSetupDone if any account exists by the time the insert runs. The check
+// and the insert are one statement so concurrent setup requests cannot both
+// succeed.
+func CreateFirstAdmin(username, passwordHash string) (*types.User, error) {
+	u := newUser(username, passwordHash, types.RoleAdmin)
+	err := withTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`INSERT INTO users (id, username, password_hash, role, created_at, updated_at)
+			SELECT ?, ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM users)`,
+			u.ID, u.Username, u.PasswordHash, u.Role, u.CreatedAt, u.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrSetupDone
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func GetUser(id string) (*types.User, error) {
+	return scanUser(DB.QueryRow("SELECT id, username, password_hash, role, created_at, updated_at FROM users WHERE id = ?", id))
+}
+
+func GetUserByUsername(username string) (*types.User, error) {
+	return scanUser(DB.QueryRow("SELECT id, username, password_hash, role, created_at, updated_at FROM users WHERE username = ?", username))
+}
+
+func scanUser(row *sql.Row) (*types.User, error) {
+	var u types.User
+	err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.CreatedAt, &u.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func GetAllUsers() ([]types.User, error) {
+	rows, err := DB.Query("SELECT id, username, role, created_at, updated_at FROM users ORDER BY username ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []types.User{}
+	for rows.Next() {
+		var u types.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Role, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// UpdateUserPassword stores a new hash and signs the user out everywhere.
+func UpdateUserPassword(id, passwordHash string) error {
+	return withTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec("UPDATE users SET password_hash = ?, updated_at = ? WHERE id = ?", passwordHash, time.Now(), id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+		_, err = tx.Exec("DELETE FROM sessions WHERE user_id = ?", id)
+		return err
+	})
+}
+
+func UpdateUserRole(id string, role types.UserRole) error {
+	return withTx(func(tx *sql.Tx) error {
+		if role != types.RoleAdmin {
+			if err := ensureOtherAdmin(tx, id); err != nil {
+				return err
+			}
+		}
+		res, err := tx.Exec("UPDATE users SET role = ?, updated_at = ? WHERE id = ?", role, time.Now(), id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func DeleteUser(id string) error {
+	return withTx(func(tx *sql.Tx) error {
+		if err := ensureOtherAdmin(tx, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", id); err != nil {
+			return err
+		}
+		for _, q := range []string{
+			"DELETE FROM api_tokens WHERE user_id = ?",
+			"DELETE FROM stars WHERE user_id = ?",
+			"DELETE FROM plays WHERE user_id = ?",
+		} {
+			if _, err := tx.Exec(q, id); err != nil {
+				return err
+			}
+		}
+		if err := deleteUserPlaylists(tx, id); err != nil {
+			return err
+		}
+		res, err := tx.Exec("DELETE FROM users WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// deleteUserPlaylists removes the user's own playlists along with their
+// collaborator entries and follows on other people's playlists.
+func deleteUserPlaylists(tx *sql.Tx, userID string) error {
+	rows, err := tx.Query("SELECT id FROM playlists WHERE owner_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := deletePlaylistTx(tx, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM playlist_collaborators WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM playlist_follows WHERE user_id = ?", userID)
+	return err
+}
+
+// ensureOtherAdmin fails if userID is an admin and no other admin exists.
+func ensureOtherAdmin(tx *sql.Tx, userID string) error {
+	var others int
+	err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE role = ? AND id <> ?", types.RoleAdmin, userID).Scan(&others)
+	if err != nil {
+		return err
+	}
+	var role string
+	err = tx.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if types.UserRole(role) == types.RoleAdmin && others == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// SetSubsonicPassword stores the app password Subsonic clients sign in
+// with, or clears it when password is nil. The Subsonic token scheme needs
+// the password itself, so it cannot be hashed like the account password.
+func SetSubsonicPassword(userID string, password *string) error {
+	res, err := DB.Exec("UPDATE users SET subsonic_password = ?, updated_at = ? WHERE id = ?", password, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetSubsonicCredentials returns the user and their Subsonic app password,
+// or a nil user if the name is unknown. The password is empty when none
+// has been set.
+func GetSubsonicCredentials(username string) (*types.User, string, error) {
+	user, err := GetUserByUsername(username)
+	if err != nil || user == nil {
+		return nil, "", err
+	}
+	var password sql.NullString
+	if err := DB.QueryRow("SELECT subsonic_password FROM users WHERE id = ?", user.ID).Scan(&password); err != nil {
+		return nil, "", err
+	}
+	return user, password.String, nil
+}
+
+func CreateSession(tokenHash, userID string, expiresAt time.Time) error {
+	_, err := DB.Exec("INSERT INTO sessions (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		tokenHash, userID, time.Now(), expiresAt)
+	return err
+}
+
+// GetSessionUser returns the user owning an unexpired session, or nil.
+func GetSessionUser(tokenHash string) (*types.User, error) {
+	var userID string
+	var expiresAt time.Time
+	err := DB.QueryRow("SELECT user_id, expires_at FROM sessions WHERE token_hash = ?", tokenHash).Scan(&userID, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(expiresAt) {
+		DB.Exec("DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+		return nil, nil
+	}
+	return GetUser(userID)
+}
+
+func DeleteSession(tokenHash string) error {
+	_, err := DB.Exec("DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	return err
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCreateFirstAdminOnlyOnce(t *testing.T) {
+	openTestDB(t)
+
+	const n = 8
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = CreateFirstAdmin(fmt.Sprintf("admin%d", i), "hash")
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrSetupDone):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d setups succeeded, want 1", created)
+	}
+	if count, err := CountUsers(); err != nil || count != 1 {
+		t.Errorf("CountUsers() = %d, %v, want 1", count, err)
+	}
+}
@@ -0,0 +1,105 @@
+// Package dlna implements the UPnP plumbing of a DLNA MediaServer: SSDP
+// advertisement, device and service descriptions, SOAP envelopes and
+// DIDL-Lite metadata. The ContentDirectory itself lives in the api package.
+package dlna
+
+import (
+	"encoding/xml"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DeviceType            = "urn:schemas-upnp-org:device:MediaServer:1"
+	ContentDirectoryType  = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	ConnectionManagerType = "urn:schemas-upnp-org:service:ConnectionManager:1"
+
+	// Paths below the server's /dlna mount
+	DescriptionPath       = "/device.xml"
+	ContentDirectorySCPD  = "/ContentDirectory.xml"
+	ConnectionManagerSCPD = "/ConnectionManager.xml"
+	ContentDirectoryCtl   = "/control/ContentDirectory"
+	ConnectionManagerCtl  = "/control/ConnectionManager"
+	ContentDirectoryEvt   = "/event/ContentDirectory"
+	ConnectionManagerEvt  = "/event/ConnectionManager"
+)
+
+// Device identifies the media server on the network.
+type Device struct {
+	UDN          string
+	FriendlyName string
+	// MountPath is where the description and control URLs are served
+	MountPath string
+}
+
+// NewDevice derives the UDN from the host name so renderers recognise the
+// server again after a restart.
+func NewDevice(friendlyName, mountPath string) *Device {
+	host, _ := os.Hostname()
+	id := uuid.NewSHA1(uuid.NameSpaceDNS, []byte(host+".homemusic.dlna"))
+	return &Device{UDN: "uuid:" + id.String(), FriendlyName: friendlyName, MountPath: mountPath}
+}
+
+type deviceDescription struct {
+	XMLName     xml.Name    `xml:"urn:schemas-upnp-org:device-1-0 root"`
+	SpecVersion specVersion `xml:"specVersion"`
+	Device      deviceXML   `xml:"device"`
+}
+
+type specVersion struct {
+	Major int `xml:"major"`
+	Minor int `xml:"minor"`
+}
+
+type deviceXML struct {
+	DeviceType   string       `xml:"deviceType"`
+	FriendlyName string       `xml:"friendlyName"`
+	Manufacturer string       `xml:"manufacturer"`
+	ModelName    string       `xml:"modelName"`
+	UDN          string       `xml:"UDN"`
+	DLNADoc      string       `xml:"urn:schemas-dlna-org:device-1-0 X_DLNADOC"`
+	Services     []serviceXML `xml:"serviceList>service"`
+}
+
+type serviceXML struct {
+	ServiceType string `xml:"serviceType"`
+	ServiceID   string `xml:"serviceId"`
+	SCPDURL     string `xml:"SCPDURL"`
+	ControlURL  string `xml:"controlURL"`
+	EventSubURL string `xml:"eventSubURL"`
+}
+
+// Description returns the root device description served at
+// DescriptionPath.
+func (d *Device) Description() []byte {
+	desc := deviceDescription{
+		SpecVersion: specVersion{Major: 1, Minor: 0},
+		Device: deviceXML{
+			DeviceType:   DeviceType,
+			FriendlyName: d.FriendlyName,
+			Manufacturer: "HomeMusic",
+			ModelName:    "HomeMusic Media Server",
+			UDN:          d.UDN,
+			DLNADoc:      "DMS-1.50",
+			Services: []serviceXML{
+				{
+					ServiceType: ContentDirectoryType,
+					ServiceID:   "urn:upnp-org:serviceId:ContentDirectory",
+					SCPDURL:     d.MountPath + ContentDirectorySCPD,
+					ControlURL:  d.MountPath + ContentDirectoryCtl,
+					EventSubURL: d.MountPath + ContentDirectoryEvt,
+				},
+				{
+					ServiceType: ConnectionManagerType,
+					ServiceID:   "urn:upnp-org:serviceId:ConnectionManager",
+					SCPDURL:     d.MountPath + ConnectionManagerSCPD,
+					ControlURL:  d.MountPath + ConnectionManagerCtl,
+					EventSubURL: d.MountPath + ConnectionManagerEvt,
+				},
+			},
+		},
+	}
+	out, _ := xml.MarshalIndent(desc, "", "  ")
+	return append([]byte(xml.Header), out...)
+}
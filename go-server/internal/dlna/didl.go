@@ -0,0 +1,112 @@
+package dlna
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// UPnP classes of the objects the server exposes.
+const (
+	ClassStorageFolder = "object.container.storageFolder"
+	ClassMusicArtist   = "object.container.person.musicArtist"
+	ClassMusicAlbum    = "object.container.album.musicAlbum"
+	ClassPlaylist      = "object.container.playlistContainer"
+	ClassMusicTrack    = "object.item.audioItem.musicTrack"
+)
+
+// Container is a browsable DIDL-Lite container.
+type Container struct {
+	ID         string `xml:"id,attr"`
+	ParentID   string `xml:"parentID,attr"`
+	Restricted int    `xml:"restricted,attr"`
+	Searchable int    `xml:"searchable,attr"`
+	ChildCount int    `xml:"childCount,attr"`
+	Title      string `xml:"dc:title"`
+	Class      string `xml:"upnp:class"`
+	Artist     string `xml:"upnp:artist,omitempty"`
+	AlbumArt   string `xml:"upnp:albumArtURI,omitempty"`
+}
+
+// Item is a playable DIDL-Lite item.
+type Item struct {
+	ID          string   `xml:"id,attr"`
+	ParentID    string   `xml:"parentID,attr"`
+	Restricted  int      `xml:"restricted,attr"`
+	Title       string   `xml:"dc:title"`
+	Creator     string   `xml:"dc:creator,omitempty"`
+	Date        string   `xml:"dc:date,omitempty"`
+	Class       string   `xml:"upnp:class"`
+	Artist      string   `xml:"upnp:artist,omitempty"`
+	Album       string   `xml:"upnp:album,omitempty"`
+	TrackNumber int      `xml:"upnp:originalTrackNumber,omitempty"`
+	AlbumArt    string   `xml:"upnp:albumArtURI,omitempty"`
+	Res         Resource `xml:"res"`
+}
+
+// Resource points a renderer at the media itself.
+type Resource struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Duration     string `xml:"duration,attr,omitempty"`
+	Size         int64  `xml:"size,attr,omitempty"`
+	URL          string `xml:",chardata"`
+}
+
+// DIDL is a DIDL-Lite document, the Result of Browse and Search.
+type DIDL struct {
+	XMLName    xml.Name    `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ DIDL-Lite"`
+	DC         string      `xml:"xmlns:dc,attr"`
+	UPnP       string      `xml:"xmlns:upnp,attr"`
+	DLNA       string      `xml:"xmlns:dlna,attr"`
+	Containers []Container `xml:"container"`
+	Items      []Item      `xml:"item"`
+}
+
+// Len is the number of objects in the document.
+func (d *DIDL) Len() int {
+	return len(d.Containers) + len(d.Items)
+}
+
+// Slice keeps the objects in [start, start+count), counting containers
+// before items. A zero count keeps everything from start.
+func (d *DIDL) Slice(start, count int) {
+	end := d.Len()
+	if count > 0 && start+count < end {
+		end = start + count
+	}
+	if start > end {
+		start = end
+	}
+	nc := len(d.Containers)
+	d.Items = d.Items[min(max(start-nc, 0), len(d.Items)):max(end-nc, 0)]
+	d.Containers = d.Containers[min(start, nc):min(end, nc)]
+}
+
+// Marshal encodes the document for the Result argument.
+func (d *DIDL) Marshal() (string, error) {
+	d.DC = "http://purl.org/dc/elements/1.1/"
+	d.UPnP = "urn:schemas-upnp-org:metadata-1-0/upnp/"
+	d.DLNA = "urn:schemas-dlna-org:metadata-1-0/"
+	out, err := xml.Marshal(d)
+	return string(out), err
+}
+
+// FormatDuration renders a duration as H:MM:SS.mmm for res@duration.
+func FormatDuration(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	return fmt.Sprintf("%d:%02d:%02d.%03d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60, d.Milliseconds()%1000)
+}
+
+// ProtocolInfo is the res@protocolInfo for media served over HTTP with
+// byte-range seeking.
+func ProtocolInfo(contentType string) string {
+	return "http-get:*:" + MediaType(contentType) + ":DLNA.ORG_OP=01;DLNA.ORG_CI=0;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+}
+
+// MediaType drops parameters such as codecs from a content type, which
+// protocolInfo has no room for.
+func MediaType(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.TrimSpace(mediaType)
+}
@@ -0,0 +1,105 @@
+package dlna
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// UPnP error codes returned in SOAP faults.
+const (
+	ErrInvalidAction   = 401
+	ErrInvalidArgs     = 402
+	ErrActionFailed    = 501
+	ErrNoSuchObject    = 701
+	ErrInvalidCriteria = 708
+)
+
+// maxSOAPBody bounds the request bodies read from the network.
+const maxSOAPBody = 64 << 10
+
+// Action is a decoded SOAP action call.
+type Action struct {
+	ServiceType string
+	Name        string
+	Args        map[string]string
+}
+
+type soapEnvelope struct {
+	Body struct {
+		Action struct {
+			XMLName xml.Name
+			Args    []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+// ReadAction decodes the action named by the SOAPACTION header from the
+// request body.
+func ReadAction(r *http.Request) (*Action, error) {
+	header := strings.Trim(r.Header.Get("SOAPACTION"), `"`)
+	serviceType, name, ok := strings.Cut(header, "#")
+	if !ok {
+		return nil, errors.New("missing or malformed SOAPACTION header")
+	}
+
+	var env soapEnvelope
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxSOAPBody)).Decode(&env); err != nil {
+		return nil, err
+	}
+	if env.Body.Action.XMLName.Local != name {
+		return nil, fmt.Errorf("body does not contain the %s action", name)
+	}
+
+	action := &Action{ServiceType: serviceType, Name: name, Args: map[string]string{}}
+	for _, arg := range env.Body.Action.Args {
+		action.Args[arg.XMLName.Local] = arg.Value
+	}
+	return action, nil
+}
+
+// Arg is one output argument of an action response. Responses list them
+// in the order the service description declares.
+type Arg struct {
+	Name  string
+	Value string
+}
+
+// WriteResponse sends the successful result of an action.
+func WriteResponse(w http.ResponseWriter, a *Action, args ...Arg) {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<u:%sResponse xmlns:u="%s">`, a.Name, a.ServiceType)
+	for _, arg := range args {
+		b.WriteString("<" + arg.Name + ">")
+		xml.EscapeText(&b, []byte(arg.Value))
+		b.WriteString("</" + arg.Name + ">")
+	}
+	fmt.Fprintf(&b, `</u:%sResponse>`, a.Name)
+	writeEnvelope(w, http.StatusOK, b.String())
+}
+
+// WriteFault reports a failed action as a UPnP error.
+func WriteFault(w http.ResponseWriter, code int, description string) {
+	var desc strings.Builder
+	xml.EscapeText(&desc, []byte(description))
+	writeEnvelope(w, http.StatusInternalServerError, fmt.Sprintf(
+		`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+			`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError>`+
+			`</detail></s:Fault>`, code, desc.String()))
+}
+
+func writeEnvelope(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("EXT", "")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	io.WriteString(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	io.WriteString(w, body)
+	io.WriteString(w, `</s:Body></s:Envelope>`)
+}
@@ -0,0 +1,210 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpAddr   = "239.255.255.250:1900"
+	ssdpMaxAge = 1800
+	// Alive notifications are repeated well within the max-age
+	ssdpNotifyInterval = ssdpMaxAge / 3 * time.Second
+)
+
+var ssdpServer = runtime.GOOS + "/1.0 UPnP/1.0 HomeMusic/1.0"
+
+// Advertiser announces the device over SSDP and answers M-SEARCH queries
+// so control points can find it.
+type Advertiser struct {
+	Device *Device
+	// Scheme is how the device description is fetched, http or https;
+	// empty means http
+	Scheme string
+	// Port is the HTTP port serving the device description
+	Port int
+}
+
+// notificationTypes are the targets announced for the device, each
+// paired with its unique service name.
+func (a *Advertiser) notificationTypes() [][2]string {
+	udn := a.Device.UDN
+	types := [][2]string{
+		{"upnp:rootdevice", udn + "::upnp:rootdevice"},
+		{udn, udn},
+	}
+	for _, t := range []string{DeviceType, ContentDirectoryType, ConnectionManagerType} {
+		types = append(types, [2]string{t, udn + "::" + t})
+	}
+	return types
+}
+
+func (a *Advertiser) location(ip net.IP) string {
+	scheme := a.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(ip.String(), strconv.Itoa(a.Port)), a.Device.MountPath+DescriptionPath)
+}
+
+// Run advertises until ctx is cancelled, then says goodbye.
+func (a *Advertiser) Run(ctx context.Context) error {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// Run returns only after the byebye has gone out
+	notified := make(chan struct{})
+	go func() {
+		defer close(notified)
+		a.notify("ssdp:alive")
+		ticker := time.NewTicker(ssdpNotifyInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				a.notify("ssdp:byebye")
+				return
+			case <-ticker.C:
+				a.notify("ssdp:alive")
+			}
+		}
+	}()
+
+	buf := make([]byte, 8192)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				<-notified
+				return nil
+			}
+			return err
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+		go a.answer(conn, from, req.Header.Get("ST"), req.Header.Get("MX"))
+	}
+}
+
+// answer replies to an M-SEARCH after a random delay of up to MX seconds,
+// as the spec asks, so control points are not flooded.
+func (a *Advertiser) answer(conn *net.UDPConn, to *net.UDPAddr, st, mx string) {
+	var matches [][2]string
+	for _, nt := range a.notificationTypes() {
+		if st == "ssdp:all" || st == nt[0] {
+			matches = append(matches, nt)
+		}
+	}
+	if len(matches) == 0 {
+		return
+	}
+	ip := localIPFor(to)
+	if ip == nil {
+		return
+	}
+
+	wait, _ := strconv.Atoi(mx)
+	wait = min(max(wait, 1), 5)
+	time.Sleep(time.Duration(rand.Int64N(int64(wait) * int64(time.Second))))
+
+	for _, nt := range matches {
+		msg := "HTTP/1.1 200 OK\r\n" +
+			fmt.Sprintf("CACHE-CONTROL: max-age=%d\r\n", ssdpMaxAge) +
+			"DATE: " + time.Now().UTC().Format(http.TimeFormat) + "\r\n" +
+			"EXT:\r\n" +
+			"LOCATION: " + a.location(ip) + "\r\n" +
+			"SERVER: " + ssdpServer + "\r\n" +
+			"ST: " + nt[0] + "\r\n" +
+			"USN: " + nt[1] + "\r\n\r\n"
+		if _, err := conn.WriteToUDP([]byte(msg), to); err != nil {
+			log.Printf("[DLNA] Failed to answer M-SEARCH from %s: %v", to, err)
+			return
+		}
+	}
+}
+
+// notify multicasts a NOTIFY for every target from each interface, with a
+// description URL reachable on that interface's network.
+func (a *Advertiser) notify(nts string) {
+	group, _ := net.ResolveUDPAddr("udp4", ssdpAddr)
+	for _, ip := range multicastIPs() {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip})
+		if err != nil {
+			log.Printf("[DLNA] Failed to send %s on %s: %v", nts, ip, err)
+			continue
+		}
+		for _, nt := range a.notificationTypes() {
+			var msg strings.Builder
+			msg.WriteString("NOTIFY * HTTP/1.1\r\n")
+			msg.WriteString("HOST: " + ssdpAddr + "\r\n")
+			msg.WriteString("NT: " + nt[0] + "\r\n")
+			msg.WriteString("NTS: " + nts + "\r\n")
+			msg.WriteString("USN: " + nt[1] + "\r\n")
+			if nts == "ssdp:alive" {
+				fmt.Fprintf(&msg, "CACHE-CONTROL: max-age=%d\r\n", ssdpMaxAge)
+				msg.WriteString("LOCATION: " + a.location(ip) + "\r\n")
+				msg.WriteString("SERVER: " + ssdpServer + "\r\n")
+			}
+			msg.WriteString("\r\n")
+			conn.WriteToUDP([]byte(msg.String()), group)
+		}
+		conn.Close()
+	}
+}
+
+// localIPFor returns the address of this host on the route to addr.
+func localIPFor(addr *net.UDPAddr) net.IP {
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+// multicastIPs lists the IPv4 addresses of interfaces that are up and
+// support multicast, skipping loopback.
+func multicastIPs() []net.IP {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				ips = append(ips, ipnet.IP.To4())
+			}
+		}
+	}
+	return ips
+}
@@ -0,0 +1,68 @@
+// Package logging filters the server's log messages by level. Messages go
+// through the standard logger; failures are always logged with log.Printf,
+// routine progress with Infof and per-file detail with Debugf.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+)
+
+var levelNames = []string{"debug", "info", "warn"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelWarn {
+		return fmt.Sprintf("Level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel reads a level name as used in the config file.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, want one of %s", name, strings.Join(levelNames, ", "))
+}
+
+var level atomic.Int32
+
+func init() {
+	level.Store(int32(LevelInfo))
+}
+
+// SetLevel sets the lowest level that is logged.
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// Enabled reports whether messages at l are logged.
+func Enabled(l Level) bool {
+	return Level(level.Load()) <= l
+}
+
+// Debugf logs detail that is only useful when tracking down a problem.
+func Debugf(format string, args ...interface{}) {
+	if Enabled(LevelDebug) {
+		log.Output(2, fmt.Sprintf(format, args...))
+	}
+}
+
+// Infof logs routine progress.
+func Infof(format string, args ...interface{}) {
+	if Enabled(LevelInfo) {
+		log.Output(2, fmt.Sprintf(format, args...))
+	}
+}
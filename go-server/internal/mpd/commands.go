@@ -0,0 +1,1139 @@
+package mpd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/scanner"
+	"homemusic-server/internal/types"
+)
+
+type command struct {
+	handler func(*session, []string, *response) error
+	scope   types.TokenScope
+	// Also requires an admin account, as the sources API does
+	admin bool
+}
+
+var commands map[string]command
+
+var started = time.Now()
+
+func init() {
+	open := func(h func(*session, []string, *response) error) command { return command{handler: h} }
+	library := func(h func(*session, []string, *response) error) command {
+		return command{handler: h, scope: types.ScopeLibrary}
+	}
+	queue := func(h func(*player, []string, *response) error) command {
+		return library(playerCommand(h))
+	}
+	playlists := func(h func(*session, []string, *response) error) command {
+		return command{handler: h, scope: types.ScopePlaylists}
+	}
+
+	commands = map[string]command{
+		"ping":        open(func(*session, []string, *response) error { return nil }),
+		"password":    open(cmdPassword),
+		"commands":    open(listCommands(true)),
+		"notcommands": open(listCommands(false)),
+		"tagtypes":    open(cmdTagTypes),
+		"urlhandlers": open(func(*session, []string, *response) error { return nil }),
+		"decoders":    open(func(*session, []string, *response) error { return nil }),
+		"binarylimit": open(cmdBinaryLimit),
+
+		"status":             queue(cmdStatus),
+		"currentsong":        library(cmdCurrentSong),
+		"stats":              library(cmdStats),
+		"clearerror":         library(func(*session, []string, *response) error { return nil }),
+		"replay_gain_status": library(func(s *session, args []string, r *response) error { r.field("replay_gain_mode", "off"); return nil }),
+		"replay_gain_mode":   library(func(*session, []string, *response) error { return nil }),
+		"crossfade":          library(func(*session, []string, *response) error { return nil }),
+		"mixrampdb":          library(func(*session, []string, *response) error { return nil }),
+		"mixrampdelay":       library(func(*session, []string, *response) error { return nil }),
+		"outputs":            library(cmdOutputs),
+		"enableoutput":       library(cmdOutput),
+		"disableoutput":      library(cmdOutput),
+		"toggleoutput":       library(cmdOutput),
+
+		"play":     queue(cmdPlay),
+		"playid":   queue(cmdPlayID),
+		"pause":    queue(cmdPause),
+		"stop":     queue(func(p *player, args []string, r *response) error { p.stop(); return nil }),
+		"next":     queue(func(p *player, args []string, r *response) error { p.next(); return nil }),
+		"previous": queue(func(p *player, args []string, r *response) error { p.previous(); return nil }),
+		"seek":     queue(cmdSeek),
+		"seekid":   queue(cmdSeekID),
+		"seekcur":  queue(cmdSeekCur),
+		"setvol":   queue(cmdSetVol),
+		"getvol":   queue(func(p *player, args []string, r *response) error { r.field("volume", p.volume); return nil }),
+		"random":   queue(playerOption(func(p *player) *bool { return &p.random })),
+		"repeat":   queue(playerOption(func(p *player) *bool { return &p.repeat })),
+		"single":   queue(playerOption(func(p *player) *bool { return &p.single })),
+		"consume":  queue(playerOption(func(p *player) *bool { return &p.consume })),
+
+		"add":            library(cmdAdd),
+		"addid":          library(cmdAddID),
+		"clear":          queue(func(p *player, args []string, r *response) error { p.clear(); return nil }),
+		"delete":         queue(cmdDelete),
+		"deleteid":       queue(cmdDeleteID),
+		"move":           queue(cmdMove),
+		"moveid":         queue(cmdMoveID),
+		"swap":           queue(cmdSwap),
+		"swapid":         queue(cmdSwapID),
+		"shuffle":        queue(cmdShuffle),
+		"playlistinfo":   library(cmdPlaylistInfo),
+		"playlistid":     library(cmdPlaylistID),
+		"plchanges":      library(cmdPlChanges(false)),
+		"plchangesposid": library(cmdPlChanges(true)),
+		"playlistfind":   library(queueSearch(false)),
+		"playlistsearch": library(queueSearch(true)),
+
+		"lsinfo":      library(cmdLsInfo),
+		"listall":     library(listAll(false)),
+		"listallinfo": library(listAll(true)),
+		"list":        library(cmdList),
+		"find":        library(search(false)),
+		"search":      library(search(true)),
+		"findadd":     library(searchAdd(false)),
+		"searchadd":   library(searchAdd(true)),
+		"count":       library(cmdCount),
+		"albumart":    library(cmdAlbumArt),
+		"readpicture": library(cmdAlbumArt),
+
+		"listplaylists":    library(cmdListPlaylists),
+		"listplaylist":     library(listPlaylist(false)),
+		"listplaylistinfo": library(listPlaylist(true)),
+		"load":             library(cmdLoad),
+		"save":             playlists(cmdSave),
+		"rm":               playlists(cmdRm),
+		"rename":           playlists(cmdRename),
+		"playlistadd":      playlists(cmdPlaylistAdd),
+		"playlistclear":    playlists(cmdPlaylistClear),
+		"playlistdelete":   playlists(cmdPlaylistDelete),
+		"playlistmove":     playlists(cmdPlaylistMove),
+
+		"update": {handler: cmdUpdate, scope: types.ScopeSources, admin: true},
+		"rescan": {handler: cmdUpdate, scope: types.ScopeSources, admin: true},
+	}
+}
+
+// playerCommand runs h with the session's player locked.
+func playerCommand(h func(*player, []string, *response) error) func(*session, []string, *response) error {
+	return func(s *session, args []string, r *response) error {
+		s.player.mu.Lock()
+		defer s.player.mu.Unlock()
+		return h(s.player, args, r)
+	}
+}
+
+func parseInt(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, newAck(ackArg, "Integer expected: %s", s)
+	}
+	return n, nil
+}
+
+func parseBool(s string) (bool, error) {
+	switch s {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+	return false, newAck(ackArg, "Boolean (0/1) expected: %s", s)
+}
+
+// parseSeconds reads a time in seconds, possibly fractional.
+func parseSeconds(s string) (time.Duration, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, newAck(ackArg, "Float expected: %s", s)
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
+
+// queuePosition reads an insert position: absolute, or +N/-N relative to
+// the current song.
+func queuePosition(p *player, s string) (int, error) {
+	n, err := parseInt(strings.TrimPrefix(s, "+"))
+	if err != nil {
+		return 0, err
+	}
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		if p.current < 0 {
+			return 0, newAck(ackArg, "No current song")
+		}
+		if strings.HasPrefix(s, "+") {
+			n = p.current + 1 + n
+		} else {
+			n = p.current + n
+		}
+	}
+	if n < 0 || n > len(p.queue) {
+		return 0, newAck(ackArg, "Bad song index")
+	}
+	return n, nil
+}
+
+func songIndex(p *player, s string) (int, error) {
+	i, err := parseInt(s)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 || i >= len(p.queue) {
+		return 0, newAck(ackArg, "Bad song index")
+	}
+	return i, nil
+}
+
+func songID(p *player, s string) (int, error) {
+	id, err := parseInt(s)
+	if err != nil {
+		return 0, err
+	}
+	i := p.indexOf(id)
+	if i < 0 {
+		return 0, newAck(ackNoExist, "No such song")
+	}
+	return i, nil
+}
+
+// queueRange reads a position or range of the queue, requiring its start to
+// exist.
+func queueRange(p *player, s string) (int, int, error) {
+	start, end, err := parseRange(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start >= len(p.queue) {
+		return 0, 0, newAck(ackArg, "Bad song index")
+	}
+	start, end = clampRange(start, end, len(p.queue))
+	return start, end, nil
+}
+
+func cmdPassword(s *session, args []string, r *response) error {
+	if len(args) != 1 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	user, token, err := auth.AuthenticateAPIToken(args[0])
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return newAck(ackPassword, "incorrect password")
+	}
+	s.signIn(user, token)
+	return nil
+}
+
+func listCommands(available bool) func(*session, []string, *response) error {
+	return func(s *session, args []string, r *response) error {
+		var names []string
+		for name, cmd := range commands {
+			if s.allowed(cmd) == available {
+				names = append(names, name)
+			}
+		}
+		if available {
+			names = append(names, "close", "idle", "noidle", "command_list_begin", "command_list_ok_begin", "command_list_end")
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			r.field("command", name)
+		}
+		return nil
+	}
+}
+
+func cmdTagTypes(s *session, args []string, r *response) error {
+	// Subcommands that narrow the tag list are accepted, but every
+	// supported tag is always sent
+	if len(args) > 0 {
+		switch args[0] {
+		case "clear", "all", "enable", "disable":
+			return nil
+		}
+		return newAck(ackArg, "Unknown sub command")
+	}
+	for _, tag := range songTags {
+		r.field("tagtype", tag)
+	}
+	return nil
+}
+
+func cmdBinaryLimit(s *session, args []string, r *response) error {
+	if len(args) != 1 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	n, err := parseInt(args[0])
+	if err != nil {
+		return err
+	}
+	if n < 64 {
+		return newAck(ackArg, "Value too small")
+	}
+	s.binaryLimit = n
+	return nil
+}
+
+func cmdStatus(p *player, args []string, r *response) error {
+	r.field("volume", p.volume)
+	r.field("repeat", boolField(p.repeat))
+	r.field("random", boolField(p.random))
+	r.field("single", boolField(p.single))
+	r.field("consume", boolField(p.consume))
+	r.field("playlist", p.version)
+	r.field("playlistlength", len(p.queue))
+	r.field("mixrampdb", "0.000000")
+	r.field("state", p.state)
+	if p.current >= 0 {
+		r.field("song", p.current)
+		r.field("songid", p.queue[p.current].id)
+		if p.state != stateStop {
+			elapsed := p.position().Seconds()
+			total := p.queue[p.current].track.Duration
+			r.field("time", fmt.Sprintf("%d:%d", int(elapsed), int(math.Round(total))))
+			r.field("elapsed", fmt.Sprintf("%.3f", elapsed))
+			r.field("duration", fmt.Sprintf("%.3f", total))
+			r.field("bitrate", 0)
+			r.field("audio", "44100:16:2")
+		}
+		if next := p.current + 1; !p.random && next < len(p.queue) {
+			r.field("nextsong", next)
+			r.field("nextsongid", p.queue[next].id)
+		}
+	}
+	return nil
+}
+
+func boolField(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func cmdCurrentSong(s *session, args []string, r *response) error {
+	l, err := loadLibrary(s.server.store)
+	if err != nil {
+		return err
+	}
+	p := s.player
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current >= 0 {
+		writeQueueEntry(l, r, p, p.current)
+	}
+	return nil
+}
+
+func writeQueueEntry(l *library, r *response, p *player, i int) {
+	l.writeSong(r, &p.queue[i].track)
+	r.field("Pos", i)
+	r.field("Id", p.queue[i].id)
+}
+
+func cmdStats(s *session, args []string, r *response) error {
+	l, err := loadLibrary(s.server.store)
+	if err != nil {
+		return err
+	}
+	tracks, err := l.tracksUnder("")
+	if err != nil {
+		return err
+	}
+	artists := map[string]bool{}
+	albums := map[string]bool{}
+	var playtime float64
+	var updated time.Time
+	for _, t := range tracks {
+		artists[t.Artist] = true
+		albums[t.Artist+"\x00"+t.Album] = true
+		playtime += t.Duration
+		if t.CreatedAt.After(updated) {
+			updated = t.CreatedAt
+		}
+	}
+	r.field("artists", len(artists))
+	r.field("albums", len(albums))
+	r.field("songs", len(tracks))
+	r.field("uptime", int(time.Since(started).Seconds()))
+	r.field("db_playtime", int(playtime))
+	if !updated.IsZero() {
+		r.field("db_update", updated.Unix())
+	}
+	s.player.mu.Lock()
+	played := s.player.position()
+	s.player.mu.Unlock()
+	r.field("playtime", int(played.Seconds()))
+	return nil
+}
+
+// The server has a single virtual output that cannot be turned off.
+func cmdOutputs(s *session, args []string, r *response) error {
+	r.field("outputid", 0)
+	r.field("outputname", "Home Music")
+	r.field("plugin", "null")
+	r.field("outputenabled", 1)
+	return nil
+}
+
+func cmdOutput(s *session, args []string, r *response) error {
+	if len(args) != 1 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	if args[0] != "0" {
+		return newAck(ackNoExist, "No such audio output")
+	}
+	return nil
+}
+
+func cmdPlay(p *player, args []string, r *response) error {
+	if len(args) > 0 {
+		i, err := songIndex(p, args[0])
+		if err != nil {
+			return err
+		}
+		p.playAt(i, 0)
+		return nil
+	}
+	switch {
+	case p.state == statePause:
+		p.pause(false)
+	case p.state == statePlay:
+	case p.current >= 0:
+		p.playAt(p.current, 0)
+	case len(p.queue) > 0:
+		p.playAt(0, 0)
+	}
+	return nil
+}
+
+func cmdPlayID(p *player, args []string, r *response) error {
+	if len(args) == 0 {
+		return cmdPlay(p, nil, r)
+	}
+	i, err := songID(p, args[0])
+	if err != nil {
+		return err
+	}
+	p.playAt(i, 0)
+	return nil
+}
+
+func cmdPause(p *player, args []string, r *response) error {
+	paused := p.state != statePause
+	if len(args) > 0 {
+		var err error
+		if paused, err = parseBool(args[0]); err != nil {
+			return err
+		}
+	}
+	p.pause(paused)
+	return nil
+}
+
+func seekTo(p *player, i int, pos time.Duration) {
+	if pos < 0 {
+		pos = 0
+	}
+	if d := p.duration(i); d > 0 && pos > d {
+		pos = d
+	}
+	paused := p.state == statePause
+	p.playAt(i, pos)
+	if paused {
+		p.pause(true)
+	}
+}
+
+func cmdSeek(p *player, args []string, r *response) error {
+	if len(args) != 2 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	i, err := songIndex(p, args[0])
+	if err != nil {
+		return err
+	}
+	pos, err := parseSeconds(args[1])
+	if err != nil {
+		return err
+	}
+	seekTo(p, i, pos)
+	return nil
+}
+
+func cmdSeekID(p *player, args []string, r *response) error {
+	if len(args) != 2 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	i, err := songID(p, args[0])
+	if err != nil {
+		return err
+	}
+	pos, err := parseSeconds(args[1])
+	if err != nil {
+		return err
+	}
+	seekTo(p, i, pos)
+	return nil
+}
+
+func cmdSeekCur(p *player, args []string, r *response) error {
+	if len(args) != 1 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	if p.current < 0 || p.state == stateStop {
+		return newAck(ackNoExist, "Not playing")
+	}
+	pos, err := parseSeconds(args[0])
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(args[0], "+") || strings.HasPrefix(args[0], "-") {
+		pos += p.position()
+	}
+	seekTo(p, p.current, pos)
+	return nil
+}
+
+func cmdSetVol(p *player, args []string, r *response) error {
+	if len(args) != 1 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	v, err := parseInt(args[0])
+	if err != nil {
+		return err
+	}
+	if v < 0 || v > 100 {
+		return newAck(ackArg, "Invalid volume value")
+	}
+	p.volume = v
+	p.changed("mixer")
+	return nil
+}
+
+func playerOption(field func(*player) *bool) func(*player, []string, *response) error {
+	return func(p *player, args []string, r *response) error {
+		if len(args) != 1 {
+			return newAck(ackArg, "wrong number of arguments")
+		}
+		// single also takes "oneshot", treated here as on
+		v, err := parseBool(strings.Replace(args[0], "oneshot", "1", 1))
+		if err != nil {
+			return err
+		}
+		*field(p) = v
+		p.changed("options")
+		return nil
+	}
+}
+
+func cmdAdd(s *session, args []string, r *response) error {
+	if len(args) < 1 || len(args) > 2 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	l, err := loadLibrary(s.server.store)
+	if err != nil {
+		return err
+	}
+	tracks, err := l.resolve(args[0])
+	if err != nil {
+		return err
+	}
+	p := s.player
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pos := -1
+	if len(args) > 1 {
+		if pos, err = queuePosition(p, args[1]); err != nil {
+			return err
+		}
+	}
+	p.add(tracks, pos)
+	return nil
+}
+
+func cmdAddID(s *session, args []string, r *response) error {
+	if len(args) < 1 || len(args) > 2 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	l, err := loadLibrary(s.server.store)
+	if err != nil {
+		return err
+	}
+	t, err := l.track(args[0])
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return newAck(ackNoExist, "No such song")
+	}
+	p := s.player
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pos := -1
+	if len(args) > 1 {
+		if pos, err = queuePosition(p, args[1]); err != nil {
+			return err
+		}
+	}
+	r.field("Id", p.add([]types.Track{*t}, pos)[0])
+	return nil
+}
+
+func cmdDelete(p *player, args []string, r *response) error {
+	if len(args) != 1 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	start, end, err := queueRange(p, args[0])
+	if err != nil {
+		return err
+	}
+	p.remove(start, end)
+	return nil
+}
+
+func cmdDeleteID(p *player, args []string, r *response) error {
+	if len(args) != 1 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	i, err := songID(p, args[0])
+	if err != nil {
+		return err
+	}
+	p.remove(i, i+1)
+	return nil
+}
+
+func cmdMove(p *player, args []string, r *response) error {
+	if len(args) != 2 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	start, end, err := queueRange(p, args[0])
+	if err != nil {
+		return err
+	}
+	to, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	if to < 0 || to > len(p.queue)-(end-start) {
+		return newAck(ackArg, "Bad song index")
+	}
+	p.move(start, end, to)
+	return nil
+}
+
+func cmdMoveID(p *player, args []string, r *response) error {
+	if len(args) != 2 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	i, err := songID(p, args[0])
+	if err != nil {
+		return err
+	}
+	to, err := songIndex(p, args[1])
+	if err != nil {
+		return err
+	}
+	p.move(i, i+1, to)
+	return nil
+}
+
+func cmdSwap(p *player, args []string, r *response) error {
+	if len(args) != 2 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	a, err := songIndex(p, args[0])
+	if err != nil {
+		return err
+	}
+	b, err := songIndex(p, args[1])
+	if err != nil {
+		return err
+	}
+	p.swap(a, b)
+	return nil
+}
+
+func cmdSwapID(p *player, args []string, r *response) error {
+	if len(args) != 2 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	a, err := songID(p, args[0])
+	if err != nil {
+		return err
+	}
+	b, err := songID(p, args[1])
+	if err != nil {
+		return err
+	}
+	p.swap(a, b)
+	return nil
+}
+
+func cmdShuffle(p *player, args []string, r *response) error {
+	start, end := 0, len(p.queue)
+	if len(args) > 0 {
+		var err error
+		if start, end, err = queueRange(p, args[0]); err != nil {
+			return err
+		}
+	}
+	p.shuffle(start, end)
+	return nil
+}
+
+func cmdPlaylistInfo(s *session, args []string, r *response) error {
+	l, err := loadLibrary(s.server.store)
+	if err != nil {
+		return err
+	}
+	p := s.player
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	start, end := 0, len(p.queue)
+	if len(args) > 0 {
+		if start, end, err = queueRange(p, args[0]); err != nil {
+			return err
+		}
+	}
+	for i := start; i < end; i++ {
+		writeQueueEntry(l, r, p, i)
+	}
+	return nil
+}
+
+func cmdPlaylistID(s *session, args []string, r *response) error {
+	l, err := loadLibrary(s.server.store)
+	if err != nil {
+		return err
+	}
+	p := s.player
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(args) > 0 {
+		i, err := songID(p, args[0])
+		if err != nil {
+			return err
+		}
+		writeQueueEntry(l, r, p, i)
+		return nil
+	}
+	for i := range p.queue {
+		writeQueueEntry(l, r, p, i)
+	}
+	return nil
+}
+
+// cmdPlChanges reports the whole queue whenever the client's version is
+// stale; entries do not carry versions of their own.
+func cmdPlChanges(posID bool) func(*session, []string, *response) error {
+	return func(s *session, args []string, r *response) error {
+		if len(args) < 1 {
+			return newAck(ackArg, "wrong number of arguments")
+		}
+		version, err := parseInt(args[0])
+		if err != nil {
+			return err
+		}
+		l, err := loadLibrary(s.server.store)
+		if err != nil {
+			return err
+		}
+		p := s.player
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if version == p.version {
+			return nil
+		}
+		start, end := 0, len(p.queue)
+		if len(args) > 1 {
+			if start, end, err = parseRange(args[1]); err != nil {
+				return err
+			}
+			start, end = clampRange(start, end, len(p.queue))
+		}
+		for i := start; i < end; i++ {
+			if posID {
+				r.field("cpos", i)
+				r.field("Id", p.queue[i].id)
+			} else {
+				writeQueueEntry(l, r, p, i)
+			}
+		}
+		return nil
+	}
+}
+
+func queueSearch(fold bool) func(*session, []string, *response) error {
+	return func(s *session, args []string, r *response) error {
+		f, rest, err := parseFilter(args, fold)
+		if err != nil {
+			return err
+		}
+		if len(rest) > 0 || len(args) == 0 {
+			return newAck(ackArg, "wrong number of arguments")
+		}
+		l, err := loadLibrary(s.server.store)
+		if err != nil {
+			return err
+		}
+		p := s.player
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for i := range p.queue {
+			if f(l, &p.queue[i].track) {
+				writeQueueEntry(l, r, p, i)
+			}
+		}
+		return nil
+	}
+}
+
+func cmdLsInfo(s *session, args []string, r *response) error {
+	l, err := loadLibrary(s.server.store)
+	if err != nil {
+		return err
+	}
+	dir := ""
+	if len(args) > 0 {
+		dir = strings.Trim(args[0], "/")
+	}
+	if dir == "" {
+		for _, d := range l.dirs {
+			r.field("directory", d)
+		}
+		// MPD lists stored playlists at the root too
+		return cmdListPlaylists(s, nil, r)
+	}
+
+	t, err := l.track(dir)
+	if err != nil {
+		return err
+	}
+	if t != nil {
+		l.writeSong(r, t)
+		return nil
+	}
+	tracks, err := l.tracksUnder(dir)
+	if err != nil {
+		return err
+	}
+	if len(tracks) == 0 {
+		if _, ok := l.ids[dir]; !ok {
+			return newAck(ackNoExist, "No such directory")
+		}
+	}
+	seen := map[string]bool{}
+	for i := range tracks {
+		rel := strings.TrimPrefix(l.uri(&tracks[i]), dir+"/")
+		if sub, _, nested := strings.Cut(rel, "/"); nested {
+			if !seen[sub] {
+				seen[sub] = true
+				r.field("directory", dir+"/"+sub)
+			}
+			continue
+		}
+		l.writeSong(r, &tracks[i])
+	}
+	return nil
+}
+
+func listAll(info bool) func(*session, []string, *response) error {
+	return func(s *session, args []string, r *response) error {
+		l, err := loadLibrary(s.server.store)
+		if err != nil {
+			return err
+		}
+		dir := ""
+		if len(args) > 0 {
+			dir = strings.Trim(args[0], "/")
+		}
+		tracks, err := l.resolve(dir)
+		if err != nil {
+			return err
+		}
+		listed := map[string]bool{}
+		for i := range tracks {
+			uri := l.uri(&tracks[i])
+			// Announce each directory before the first song inside it
+			var parents []string
+			for d := path.Dir(uri); d != "." && d != dir; d = path.Dir(d) {
+				parents = append(parents, d)
+			}
+			for j := len(parents) - 1; j >= 0; j-- {
+				if !listed[parents[j]] {
+					listed[parents[j]] = true
+					r.field("directory", parents[j])
+				}
+			}
+			if info {
+				l.writeSong(r, &tracks[i])
+			} else {
+				r.field("file", uri)
+			}
+		}
+		return nil
+	}
+}
+
+func cmdList(s *session, args []string, r *response) error {
+	if len(args) < 1 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	tag, ok := canonicalTag(args[0])
+	if !ok || tag == "any" {
+		return newAck(ackArg, "Unknown tag type: %s", args[0])
+	}
+	args = args[1:]
+	// Old clients send "list album ARTIST"
+	if tag == "Album" && len(args) == 1 && !strings.HasPrefix(args[0], "(") {
+		args = []string{"Artist", args[0]}
+	}
+	f, rest, err := parseFilter(args, false)
+	if err != nil {
+		return err
+	}
+	opts, err := parseQueryOptions(rest)
+	if err != nil {
+		return err
+	}
+	l, err := loadLibrary(s.server.store)
+	if err != nil {
+		return err
+	}
+	tracks, err := l.query(f, &queryOptions{end: -1})
+	if err != nil {
+		return err
+	}
+
+	keys := append(append([]string(nil), opts.groups...), tag)
+	seen := map[string]bool{}
+	var rows [][]string
+	for i := range tracks {
+		for _, row := range tagRows(l, &tracks[i], keys) {
+			key := strings.Join(row, "\x00")
+			if !seen[key] {
+				seen[key] = true
+				rows = append(rows, row)
+			}
+		}
+	}
+	slices.SortFunc(rows, func(a, b []string) int {
+		return slices.Compare(a, b)
+	})
+
+	var previous []string
+	for _, row := range rows {
+		for j, key := range keys {
+			if j < len(keys)-1 && previous != nil && previous[j] == row[j] {
+				continue
+			}
+			if row[j] != "" || j < len(keys)-1 {
+				r.field(key, row[j])
+			}
+		}
+		previous = row
+	}
+	return nil
+}
+
+// tagRows returns each combination of the track's values for keys.
+func tagRows(l *library, t *types.Track, keys []string) [][]string {
+	rows := [][]string{{}}
+	for _, key := range keys {
+		var next [][]string
+		for _, row := range rows {
+			for _, v := range tagValues(l, t, key) {
+				next = append(next, append(append([]string(nil), row...), v))
+			}
+		}
+		rows = next
+	}
+	return rows
+}
+
+func search(fold bool) func(*session, []string, *response) error {
+	return func(s *session, args []string, r *response) error {
+		l, tracks, _, err := runSearch(s, args, fold)
+		if err != nil {
+			return err
+		}
+		for i := range tracks {
+			l.writeSong(r, &tracks[i])
+		}
+		return nil
+	}
+}
+
+func searchAdd(fold bool) func(*session, []string, *response) error {
+	return func(s *session, args []string, r *response) error {
+		_, tracks, position, err := runSearch(s, args, fold)
+		if err != nil {
+			return err
+		}
+		p := s.player
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		pos := -1
+		if position != "" {
+			if pos, err = queuePosition(p, position); err != nil {
+				return err
+			}
+		}
+		p.add(tracks, pos)
+		return nil
+	}
+}
+
+// runSearch parses and runs a find or search, returning the position
+// argument for the add variants.
+func runSearch(s *session, args []string, fold bool) (*library, []types.Track, string, error) {
+	if len(args) == 0 {
+		return nil, nil, "", newAck(ackArg, "wrong number of arguments")
+	}
+	f, rest, err := parseFilter(args, fold)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	position := ""
+	for i := 0; i+1 < len(rest); i += 2 {
+		if strings.EqualFold(rest[i], "position") {
+			position = rest[i+1]
+		}
+	}
+	opts, err := parseQueryOptions(rest)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	l, err := loadLibrary(s.server.store)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	tracks, err := l.query(f, opts)
+	return l, tracks, position, err
+}
+
+func cmdCount(s *session, args []string, r *response) error {
+	f, rest, err := parseFilter(args, false)
+	if err != nil {
+		return err
+	}
+	opts, err := parseQueryOptions(rest)
+	if err != nil {
+		return err
+	}
+	l, err := loadLibrary(s.server.store)
+	if err != nil {
+		return err
+	}
+	tracks, err := l.query(f, &queryOptions{end: -1})
+	if err != nil {
+		return err
+	}
+	if len(opts.groups) == 0 {
+		var playtime float64
+		for _, t := range tracks {
+			playtime += t.Duration
+		}
+		r.field("songs", len(tracks))
+		r.field("playtime", int(playtime))
+		return nil
+	}
+
+	group := opts.groups[0]
+	counts := map[string]int{}
+	playtimes := map[string]float64{}
+	for i := range tracks {
+		for _, v := range tagValues(l, &tracks[i], group) {
+			counts[v]++
+			playtimes[v] += tracks[i].Duration
+		}
+	}
+	values := make([]string, 0, len(counts))
+	for v := range counts {
+		values = append(values, v)
+	}
+	slices.Sort(values)
+	for _, v := range values {
+		r.field(group, v)
+		r.field("songs", counts[v])
+		r.field("playtime", int(playtimes[v]))
+	}
+	return nil
+}
+
+// cmdAlbumArt sends a song's artwork in chunks of at most the binary limit.
+func cmdAlbumArt(s *session, args []string, r *response) error {
+	if len(args) != 2 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	offset, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	l, err := loadLibrary(s.server.store)
+	if err != nil {
+		return err
+	}
+	t, err := l.track(args[0])
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return newAck(ackNoExist, "No such song")
+	}
+	imageURL := t.ImageUrl
+	if imageURL == nil && t.AlbumID != nil {
+		album, err := s.server.store.Library.GetAlbum(*t.AlbumID)
+		if err != nil {
+			return err
+		}
+		if album != nil {
+			imageURL = album.ImageUrl
+		}
+	}
+	if imageURL == nil {
+		return newAck(ackNoExist, "No file exists")
+	}
+	data, err := os.ReadFile(filepath.Join(scanner.ArtDir, path.Base(strings.TrimPrefix(*imageURL, "/api/art/"))))
+	if err != nil {
+		return newAck(ackNoExist, "No file exists")
+	}
+	if offset < 0 || offset > len(data) {
+		return newAck(ackArg, "Bad file offset")
+	}
+	chunk := data[offset:min(len(data), offset+s.binaryLimit)]
+	r.field("size", len(data))
+	r.field("binary", len(chunk))
+	r.buf.Write(chunk)
+	r.buf.WriteByte('\n')
+	return nil
+}
+
+func cmdUpdate(s *session, args []string, r *response) error {
+	go func() {
+		if err := s.server.scans.ScanAllSources(context.WithoutCancel(s.ctx)); err != nil {
+			log.Printf("[MPD] Scan failed: %v", err)
+		}
+	}()
+	r.field("updating_db", 1)
+	s.player.mu.Lock()
+	s.player.changed("update")
+	s.player.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,521 @@
+package mpd
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/types"
+)
+
+// library maps tracks to MPD URIs. Each source is a top-level directory
+// named after it, holding the source's files at their remote paths.
+type library struct {
+	store db.LibraryStore
+	names map[string]string // source ID to directory
+	ids   map[string]string // directory to source ID
+	dirs  []string
+}
+
+func loadLibrary(store *db.Store) (*library, error) {
+	sources, err := store.Sources.GetSourceList()
+	if err != nil {
+		return nil, err
+	}
+	l := &library{store: store.Library, names: map[string]string{}, ids: map[string]string{}}
+	for _, s := range sources {
+		name := strings.ReplaceAll(strings.TrimSpace(s.Name), "/", "_")
+		if _, taken := l.ids[name]; taken || name == "" {
+			name += " (" + s.ID[:8] + ")"
+		}
+		l.names[s.ID] = name
+		l.ids[name] = s.ID
+		l.dirs = append(l.dirs, name)
+	}
+	return l, nil
+}
+
+func remotePath(path string) string {
+	return strings.TrimLeft(strings.ReplaceAll(path, "\\", "/"), "/")
+}
+
+func (l *library) uri(t *types.Track) string {
+	return l.names[t.SourceID] + "/" + remotePath(t.Path)
+}
+
+// track resolves a song URI, returning nil when there is none.
+func (l *library) track(uri string) (*types.Track, error) {
+	dir, rel, ok := strings.Cut(strings.Trim(uri, "/"), "/")
+	sourceID := l.ids[dir]
+	if !ok || sourceID == "" {
+		return nil, nil
+	}
+	return l.store.GetTrackBySourcePath(sourceID, rel)
+}
+
+// tracksUnder returns the songs in a directory and below it, sorted by
+// URI. An empty dir is the whole library.
+func (l *library) tracksUnder(dir string) ([]types.Track, error) {
+	dir = strings.Trim(dir, "/")
+	var tracks []types.Track
+	var err error
+	source, rest, _ := strings.Cut(dir, "/")
+	if dir == "" {
+		tracks, err = l.store.GetAllTracks()
+	} else if sourceID := l.ids[source]; sourceID != "" {
+		tracks, err = l.store.GetTracksBySource(sourceID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	prefix := ""
+	if rest != "" {
+		prefix = rest + "/"
+	}
+	var result []types.Track
+	for _, t := range tracks {
+		if _, ok := l.names[t.SourceID]; ok && strings.HasPrefix(remotePath(t.Path), prefix) {
+			result = append(result, t)
+		}
+	}
+	slices.SortFunc(result, func(a, b types.Track) int { return strings.Compare(l.uri(&a), l.uri(&b)) })
+	return result, nil
+}
+
+// resolve expands a URI to the song it names or the songs of a directory.
+func (l *library) resolve(uri string) ([]types.Track, error) {
+	if t, err := l.track(uri); err != nil || t != nil {
+		if t == nil {
+			return nil, err
+		}
+		return []types.Track{*t}, nil
+	}
+	tracks, err := l.tracksUnder(uri)
+	if err != nil {
+		return nil, err
+	}
+	if len(tracks) == 0 {
+		return nil, newAck(ackNoExist, "No such song or directory")
+	}
+	return tracks, nil
+}
+
+func (l *library) writeSong(r *response, t *types.Track) {
+	r.field("file", l.uri(t))
+	modified := t.CreatedAt
+	if t.SourceMtime != nil {
+		modified = *t.SourceMtime
+	}
+	if !modified.IsZero() {
+		r.field("Last-Modified", modified.UTC().Format(time.RFC3339))
+	}
+	for _, tag := range songTags {
+		for _, v := range tagValues(l, t, tag) {
+			if v != "" {
+				r.field(tag, v)
+			}
+		}
+	}
+	r.field("Time", int(math.Round(t.Duration)))
+	r.field("duration", fmt.Sprintf("%.3f", t.Duration))
+}
+
+// songTags are the tags reported for songs, in MPD's canonical casing.
+var songTags = []string{"Artist", "AlbumArtist", "Title", "Album", "Track", "Date"}
+
+// emptyTags are tag types clients ask about that the library does not
+// store; they match as empty.
+var emptyTags = []string{"Genre", "Composer", "Performer", "Disc", "Comment", "Name"}
+
+func canonicalTag(tag string) (string, bool) {
+	for _, t := range append(append([]string{"file", "any"}, songTags...), emptyTags...) {
+		if strings.EqualFold(t, tag) {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+func tagValues(l *library, t *types.Track, tag string) []string {
+	switch tag {
+	case "file":
+		return []string{l.uri(t)}
+	case "Artist":
+		if t.ArtistsDisplay != nil && *t.ArtistsDisplay != "" {
+			return []string{*t.ArtistsDisplay}
+		}
+		return []string{t.Artist}
+	case "AlbumArtist":
+		return []string{t.Artist}
+	case "Title":
+		return []string{t.Title}
+	case "Album":
+		return []string{t.Album}
+	case "Track":
+		if t.TrackNumber != nil {
+			return []string{strconv.Itoa(*t.TrackNumber)}
+		}
+	case "Date":
+		if t.Year != nil && *t.Year > 0 {
+			return []string{strconv.Itoa(*t.Year)}
+		}
+	case "any":
+		var all []string
+		for _, tag := range songTags {
+			all = append(all, tagValues(l, t, tag)...)
+		}
+		return all
+	}
+	return []string{""}
+}
+
+type filter func(l *library, t *types.Track) bool
+
+// parseFilter reads the filter at the start of args, either as an MPD 0.21
+// expression or as legacy TAG VALUE pairs, and returns the arguments after
+// it. fold makes comparisons case-insensitive, as search does.
+func parseFilter(args []string, fold bool) (filter, []string, error) {
+	if len(args) > 0 && strings.HasPrefix(strings.TrimSpace(args[0]), "(") {
+		p := &exprParser{s: args[0], fold: fold}
+		f, err := p.parse()
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.skipSpace(); p.i < len(p.s) {
+			return nil, nil, newAck(ackArg, "Unparsed garbage after expression")
+		}
+		return f, args[1:], nil
+	}
+
+	var filters []filter
+	for len(args) > 0 && !isModifier(args[0]) {
+		if len(args) < 2 {
+			return nil, nil, newAck(ackArg, "Incorrect number of filter arguments")
+		}
+		op := "=="
+		if fold {
+			op = "contains"
+		}
+		f, err := tagFilter(args[0], op, args[1], fold)
+		if err != nil {
+			return nil, nil, err
+		}
+		filters = append(filters, f)
+		args = args[2:]
+	}
+	return allOf(filters), args, nil
+}
+
+func isModifier(arg string) bool {
+	switch strings.ToLower(arg) {
+	case "sort", "window", "group", "position":
+		return true
+	}
+	return false
+}
+
+func allOf(filters []filter) filter {
+	return func(l *library, t *types.Track) bool {
+		for _, f := range filters {
+			if !f(l, t) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func tagFilter(tag, op, value string, fold bool) (filter, error) {
+	name, ok := canonicalTag(tag)
+	if !ok {
+		if strings.EqualFold(tag, "base") {
+			dir := strings.Trim(value, "/") + "/"
+			return func(l *library, t *types.Track) bool { return strings.HasPrefix(l.uri(t), dir) }, nil
+		}
+		return nil, newAck(ackArg, "Unknown filter type: %s", tag)
+	}
+
+	norm := func(s string) string { return s }
+	if fold {
+		norm = strings.ToLower
+	}
+	want := norm(value)
+	var match func(v string) bool
+	switch op {
+	case "==":
+		match = func(v string) bool { return norm(v) == want }
+	case "!=":
+		match = func(v string) bool { return norm(v) != want }
+	case "contains":
+		match = func(v string) bool { return strings.Contains(norm(v), want) }
+	case "starts_with":
+		match = func(v string) bool { return strings.HasPrefix(norm(v), want) }
+	case "=~", "!~":
+		pattern := value
+		if fold {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, newAck(ackArg, "Invalid regular expression: %v", err)
+		}
+		negate := op == "!~"
+		match = func(v string) bool { return re.MatchString(v) != negate }
+	default:
+		return nil, newAck(ackArg, "Unknown filter operator: %s", op)
+	}
+
+	return func(l *library, t *types.Track) bool {
+		for _, v := range tagValues(l, t, name) {
+			if match(v) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// exprParser parses filter expressions such as
+// ((artist == 'X') AND (!(album contains "live"))).
+type exprParser struct {
+	s    string
+	i    int
+	fold bool
+}
+
+func (p *exprParser) skipSpace() {
+	for p.i < len(p.s) && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+func (p *exprParser) expect(c byte) error {
+	p.skipSpace()
+	if p.i >= len(p.s) || p.s[p.i] != c {
+		return newAck(ackArg, "'%c' expected", c)
+	}
+	p.i++
+	return nil
+}
+
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.i >= len(p.s) {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *exprParser) word() string {
+	p.skipSpace()
+	start := p.i
+	for p.i < len(p.s) && p.s[p.i] != ' ' && p.s[p.i] != '(' && p.s[p.i] != ')' && p.s[p.i] != '"' && p.s[p.i] != '\'' {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+func (p *exprParser) quoted() (string, error) {
+	q := p.peek()
+	if q != '"' && q != '\'' {
+		return "", newAck(ackArg, "Quoted string expected")
+	}
+	p.i++
+	var b strings.Builder
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		p.i++
+		if c == q {
+			return b.String(), nil
+		}
+		if c == '\\' && p.i < len(p.s) {
+			c = p.s[p.i]
+			p.i++
+		}
+		b.WriteByte(c)
+	}
+	return "", newAck(ackArg, "Closing quote not found")
+}
+
+func (p *exprParser) parse() (filter, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	switch p.peek() {
+	case '!':
+		p.i++
+		inner, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		return func(l *library, t *types.Track) bool { return !inner(l, t) }, nil
+	case '(':
+		filters := []filter{}
+		for {
+			f, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, f)
+			if p.peek() == ')' {
+				p.i++
+				return allOf(filters), nil
+			}
+			if w := p.word(); w != "AND" {
+				return nil, newAck(ackArg, "'AND' expected")
+			}
+		}
+	}
+
+	tag := p.word()
+	op := "=="
+	if !strings.EqualFold(tag, "base") {
+		switch c := p.peek(); c {
+		case '=', '!':
+			if p.i+1 >= len(p.s) {
+				return nil, newAck(ackArg, "Operator expected")
+			}
+			op = p.s[p.i : p.i+2]
+			p.i += 2
+		default:
+			op = p.word()
+		}
+	}
+	value, err := p.quoted()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return tagFilter(tag, op, value, p.fold)
+}
+
+// queryOptions are the sort and window modifiers after a filter.
+type queryOptions struct {
+	sort       string
+	descending bool
+	start, end int
+	groups     []string
+}
+
+func parseQueryOptions(args []string) (*queryOptions, error) {
+	opts := &queryOptions{end: -1}
+	for len(args) > 0 {
+		if len(args) < 2 {
+			return nil, newAck(ackArg, "Missing value for %s", args[0])
+		}
+		switch strings.ToLower(args[0]) {
+		case "sort":
+			name := args[1]
+			if strings.HasPrefix(name, "-") {
+				opts.descending = true
+				name = name[1:]
+			}
+			tag, ok := canonicalTag(name)
+			if !ok && !strings.EqualFold(name, "Last-Modified") {
+				return nil, newAck(ackArg, "Unknown sort tag: %s", name)
+			}
+			opts.sort = tag
+			if !ok {
+				opts.sort = "Last-Modified"
+			}
+		case "window":
+			start, end, err := parseRange(args[1])
+			if err != nil {
+				return nil, err
+			}
+			opts.start, opts.end = start, end
+		case "group":
+			tag, ok := canonicalTag(args[1])
+			if !ok || tag == "any" {
+				return nil, newAck(ackArg, "Unknown group tag: %s", args[1])
+			}
+			opts.groups = append(opts.groups, tag)
+		case "position":
+			// Only meaningful for findadd and searchadd, which append
+		default:
+			return nil, newAck(ackArg, "Unknown argument: %s", args[0])
+		}
+		args = args[2:]
+	}
+	return opts, nil
+}
+
+// query filters the whole library and applies sort and window.
+func (l *library) query(f filter, opts *queryOptions) ([]types.Track, error) {
+	tracks, err := l.tracksUnder("")
+	if err != nil {
+		return nil, err
+	}
+	var result []types.Track
+	for i := range tracks {
+		if f(l, &tracks[i]) {
+			result = append(result, tracks[i])
+		}
+	}
+	if opts.sort != "" {
+		slices.SortStableFunc(result, func(a, b types.Track) int {
+			c := compareTag(l, &a, &b, opts.sort)
+			if opts.descending {
+				return -c
+			}
+			return c
+		})
+	}
+	start, end := clampRange(opts.start, opts.end, len(result))
+	return result[start:end], nil
+}
+
+func compareTag(l *library, a, b *types.Track, tag string) int {
+	switch tag {
+	case "Last-Modified":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	case "Track", "Date":
+		return compareInts(tagValues(l, a, tag)[0], tagValues(l, b, tag)[0])
+	}
+	return strings.Compare(strings.ToLower(tagValues(l, a, tag)[0]), strings.ToLower(tagValues(l, b, tag)[0]))
+}
+
+func compareInts(a, b string) int {
+	x, _ := strconv.Atoi(a)
+	y, _ := strconv.Atoi(b)
+	return x - y
+}
+
+// parseRange reads N or START:END, where END may be left open.
+func parseRange(s string) (int, int, error) {
+	startStr, endStr, isRange := strings.Cut(s, ":")
+	start, err := strconv.Atoi(startStr)
+	if err != nil || start < 0 {
+		return 0, 0, newAck(ackArg, "Integer or range expected: %s", s)
+	}
+	if !isRange {
+		return start, start + 1, nil
+	}
+	if endStr == "" {
+		return start, -1, nil
+	}
+	end, err := strconv.Atoi(endStr)
+	if err != nil || end < start {
+		return 0, 0, newAck(ackArg, "Integer or range expected: %s", s)
+	}
+	return start, end, nil
+}
+
+// clampRange fits a parsed range to a list of n items; an end of -1 is
+// open.
+func clampRange(start, end, n int) (int, int) {
+	if end < 0 || end > n {
+		end = n
+	}
+	return min(start, end), end
+}
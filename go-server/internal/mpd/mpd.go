@@ -0,0 +1,433 @@
+// Package mpd speaks the Music Player Daemon protocol so MPD clients can
+// browse the library, manage stored playlists and keep a queue. The server
+// has no audio output of its own: each user's queue plays on a virtual
+// clock that clients display and control as they would a real player.
+package mpd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"homemusic-server/internal/auth"
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/scanner"
+	"homemusic-server/internal/types"
+)
+
+const protocolVersion = "0.23.5"
+
+// ACK error codes from the MPD protocol.
+const (
+	ackNotList    = 1
+	ackArg        = 2
+	ackPassword   = 3
+	ackPermission = 4
+	ackUnknown    = 5
+	ackNoExist    = 50
+	ackSystem     = 52
+	ackExist      = 56
+)
+
+type ackError struct {
+	code int
+	msg  string
+}
+
+func (e *ackError) Error() string { return e.msg }
+
+func newAck(code int, format string, a ...interface{}) error {
+	return &ackError{code: code, msg: fmt.Sprintf(format, a...)}
+}
+
+// maxLineLength bounds a single command line from a client.
+const maxLineLength = 1 << 20
+
+// Limits matching MPD's own defaults: max_command_list_size is 2048 KiB,
+// max_connections 100 and connection_timeout 60 seconds. The timeout only
+// applies before a client signs in, since signed-in clients idle for hours.
+const (
+	maxCommandListSize = 2048 << 10
+	maxConnections     = 100
+	signInTimeout      = 60 * time.Second
+)
+
+// Server accepts MPD client connections. Clients sign in by sending an
+// API token with the password command.
+type Server struct {
+	Addr  string
+	store *db.Store
+	// Runs the scans the update command asks for
+	scans *scanner.Scanner
+
+	mu      sync.Mutex
+	players map[string]*player
+	// Holds a slot per open connection
+	slots chan struct{}
+}
+
+// NewServer serves the library and playlists in store.
+func NewServer(addr string, store *db.Store, scans *scanner.Scanner) *Server {
+	return &Server{Addr: addr, store: store, scans: scans, players: map[string]*player{}, slots: make(chan struct{}, maxConnections)}
+}
+
+// ListenAndServe accepts clients until ctx is done, then closes the
+// listener and every open connection and returns nil once they have ended.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("[MPD] Listening on %s", ln.Addr())
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	var conns sync.WaitGroup
+	defer conns.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			log.Printf("[MPD] Refusing %s: %d connections already open", conn.RemoteAddr(), maxConnections)
+			conn.Close()
+			continue
+		}
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			defer func() { <-s.slots }()
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stop()
+			s.serve(conn)
+		}()
+	}
+}
+
+// player returns the user's player, shared by all their connections.
+func (s *Server) player(userID string) *player {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.players[userID]
+	if !ok {
+		p = newPlayer()
+		s.players[userID] = p
+	}
+	return p
+}
+
+type session struct {
+	server *Server
+	w      *bufio.Writer
+	ctx    context.Context
+	user   *types.User
+	player *player
+	// Largest binary chunk sent at once, as set by binarylimit
+	binaryLimit int
+
+	// Subsystems changed since the client last idled
+	mu      sync.Mutex
+	pending map[string]bool
+	wake    chan struct{}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	sess := &session{
+		server:      s,
+		w:           bufio.NewWriter(conn),
+		ctx:         context.Background(),
+		binaryLimit: 8192,
+		pending:     map[string]bool{},
+		wake:        make(chan struct{}, 1),
+	}
+	defer sess.detach()
+
+	lines := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 4096), maxLineLength)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Drop connections that never sign in; the deadline also interrupts the
+	// reader goroutine's pending read
+	conn.SetReadDeadline(time.Now().Add(signInTimeout))
+	fmt.Fprintf(sess.w, "OK MPD %s\n", protocolVersion)
+	sess.w.Flush()
+	for line := range lines {
+		if !sess.handleLine(line, lines) {
+			sess.w.Flush()
+			return
+		}
+		if err := sess.w.Flush(); err != nil {
+			return
+		}
+		if sess.user == nil {
+			conn.SetReadDeadline(time.Now().Add(signInTimeout))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+	}
+}
+
+// handleLine runs one command or command list, returning false when the
+// connection should close.
+func (s *session) handleLine(line string, lines <-chan string) bool {
+	switch strings.TrimSpace(line) {
+	case "":
+		return true
+	case "command_list_begin", "command_list_ok_begin":
+		listOK := strings.TrimSpace(line) == "command_list_ok_begin"
+		var list []string
+		size := 0
+		for l := range lines {
+			if strings.TrimSpace(l) == "command_list_end" {
+				return s.runList(list, listOK)
+			}
+			// MPD closes the connection rather than buffer an oversized list
+			if size += len(l); size > maxCommandListSize {
+				log.Printf("[MPD] Closing connection: command list larger than %d bytes", maxCommandListSize)
+				return false
+			}
+			list = append(list, l)
+		}
+		return false
+	}
+
+	args, err := splitArgs(line)
+	if err != nil {
+		s.writeAck(ackArg, 0, "", err.Error())
+		return true
+	}
+	switch args[0] {
+	case "close":
+		return false
+	case "idle":
+		return s.idle(args[1:], lines)
+	case "noidle":
+		// Not idling, so there is nothing to interrupt
+		return true
+	}
+	var resp bytes.Buffer
+	if err := s.run(args, &resp); err != nil {
+		s.writeError(err, 0, args[0])
+		return true
+	}
+	s.w.Write(resp.Bytes())
+	s.w.WriteString("OK\n")
+	return true
+}
+
+func (s *session) runList(list []string, listOK bool) bool {
+	for i, line := range list {
+		args, err := splitArgs(line)
+		if err != nil {
+			s.writeAck(ackArg, i, "", err.Error())
+			return true
+		}
+		if args[0] == "close" {
+			return false
+		}
+		if args[0] == "idle" || args[0] == "noidle" {
+			s.writeAck(ackNotList, i, args[0], "not allowed in a command list")
+			return true
+		}
+		var resp bytes.Buffer
+		if err := s.run(args, &resp); err != nil {
+			s.writeError(err, i, args[0])
+			return true
+		}
+		s.w.Write(resp.Bytes())
+		if listOK {
+			s.w.WriteString("list_OK\n")
+		}
+	}
+	s.w.WriteString("OK\n")
+	return true
+}
+
+func (s *session) run(args []string, resp *bytes.Buffer) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return newAck(ackUnknown, "unknown command \"%s\"", args[0])
+	}
+	if !s.allowed(cmd) {
+		return newAck(ackPermission, "you don't have permission for \"%s\"", args[0])
+	}
+	return cmd.handler(s, args[1:], &response{resp})
+}
+
+func (s *session) allowed(cmd command) bool {
+	if cmd.scope == "" {
+		return true
+	}
+	if cmd.admin && (s.user == nil || s.user.Role != types.RoleAdmin) {
+		return false
+	}
+	return s.user != nil && auth.HasScope(s.ctx, cmd.scope)
+}
+
+func (s *session) writeError(err error, index int, cmd string) {
+	var ack *ackError
+	if errors.As(err, &ack) {
+		s.writeAck(ack.code, index, cmd, ack.msg)
+		return
+	}
+	// Anything else is internal; its detail goes to the log, not the client
+	log.Printf("[MPD] %s failed: %v", cmd, err)
+	s.writeAck(ackSystem, index, cmd, "internal error")
+}
+
+func (s *session) writeAck(code, index int, cmd, msg string) {
+	fmt.Fprintf(s.w, "ACK [%d@%d] {%s} %s\n", code, index, cmd, strings.ReplaceAll(msg, "\n", " "))
+}
+
+// signIn switches the session to the token's user and their player.
+func (s *session) signIn(user *types.User, token *types.APIToken) {
+	s.detach()
+	s.user = user
+	s.ctx = auth.WithIdentity(context.Background(), user, token)
+	s.player = s.server.player(user.ID)
+	s.player.attach(s)
+}
+
+func (s *session) detach() {
+	if s.player != nil {
+		s.player.detach(s)
+	}
+}
+
+// notify records changed subsystems for this session's next idle.
+func (s *session) notify(subsystems ...string) {
+	s.mu.Lock()
+	for _, sub := range subsystems {
+		s.pending[sub] = true
+	}
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *session) takeEvents(filter []string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []string
+	for sub := range s.pending {
+		if len(filter) == 0 || slices.Contains(filter, sub) {
+			events = append(events, sub)
+			delete(s.pending, sub)
+		}
+	}
+	slices.Sort(events)
+	return events
+}
+
+// idle waits for a change in one of the subsystems, or any when none are
+// named, until the client sends noidle. Any other command while idling
+// ends the connection, as in MPD.
+func (s *session) idle(filter []string, lines <-chan string) bool {
+	for {
+		if events := s.takeEvents(filter); len(events) > 0 {
+			s.writeEvents(events)
+			return true
+		}
+		select {
+		case <-s.wake:
+		case line, ok := <-lines:
+			if !ok || strings.TrimSpace(line) != "noidle" {
+				return false
+			}
+			s.writeEvents(s.takeEvents(filter))
+			return true
+		}
+	}
+}
+
+func (s *session) writeEvents(events []string) {
+	for _, sub := range events {
+		fmt.Fprintf(s.w, "changed: %s\n", sub)
+	}
+	s.w.WriteString("OK\n")
+}
+
+// splitArgs tokenizes a command line: bare words, or double-quoted strings
+// with backslash escapes.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+		if line[i] != '"' {
+			start := i
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				i++
+			}
+			args = append(args, line[start:i])
+			continue
+		}
+		var b strings.Builder
+		i++
+		for {
+			if i >= len(line) {
+				return nil, errors.New("missing closing '\"'")
+			}
+			c := line[i]
+			if c == '"' {
+				i++
+				break
+			}
+			if c == '\\' && i+1 < len(line) {
+				i++
+				c = line[i]
+			}
+			b.WriteByte(c)
+			i++
+		}
+		args = append(args, b.String())
+	}
+	if len(args) == 0 {
+		return nil, errors.New("no command given")
+	}
+	return args, nil
+}
+
+// response collects a command's output so a failing command sends only
+// its ACK.
+type response struct {
+	buf *bytes.Buffer
+}
+
+func (r *response) field(key string, value interface{}) {
+	fmt.Fprintf(r.buf, "%s: %v\n", key, value)
+}
@@ -0,0 +1,304 @@
+package mpd
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"homemusic-server/internal/types"
+)
+
+const (
+	stateStop  = "stop"
+	statePlay  = "play"
+	statePause = "pause"
+)
+
+type queueEntry struct {
+	id    int
+	track types.Track
+}
+
+// player is one user's queue and virtual playback position. Songs advance
+// when their duration has elapsed, as they would on a real output.
+type player struct {
+	mu sync.Mutex
+
+	queue   []queueEntry
+	nextID  int
+	version int
+
+	current int // index into queue, -1 when no song is selected
+	state   string
+	// Position at the last state change, and when playback resumed
+	elapsed time.Duration
+	since   time.Time
+	timer   *time.Timer
+	// Bumped on every reschedule so stale timers do nothing
+	generation int
+
+	volume                          int
+	random, repeat, single, consume bool
+
+	sessions map[*session]struct{}
+}
+
+func newPlayer() *player {
+	return &player{current: -1, state: stateStop, volume: 100, version: 1, sessions: map[*session]struct{}{}}
+}
+
+func (p *player) attach(s *session) {
+	p.mu.Lock()
+	p.sessions[s] = struct{}{}
+	p.mu.Unlock()
+}
+
+func (p *player) detach(s *session) {
+	p.mu.Lock()
+	delete(p.sessions, s)
+	p.mu.Unlock()
+}
+
+// changed must be called with the lock held.
+func (p *player) changed(subsystems ...string) {
+	for _, sub := range subsystems {
+		if sub == "playlist" {
+			p.version++
+		}
+	}
+	for s := range p.sessions {
+		s.notify(subsystems...)
+	}
+}
+
+func (p *player) position() time.Duration {
+	if p.state == statePlay {
+		return p.elapsed + time.Since(p.since)
+	}
+	return p.elapsed
+}
+
+func (p *player) duration(i int) time.Duration {
+	return time.Duration(p.queue[i].track.Duration * float64(time.Second))
+}
+
+// schedule arms the timer that moves on when the current song ends.
+func (p *player) schedule() {
+	p.generation++
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if p.state != statePlay || p.current < 0 || p.queue[p.current].track.Duration <= 0 {
+		return
+	}
+	gen := p.generation
+	p.timer = time.AfterFunc(p.duration(p.current)-p.elapsed, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if gen == p.generation {
+			p.songFinished()
+		}
+	})
+}
+
+func (p *player) playAt(i int, pos time.Duration) {
+	p.current = i
+	p.state = statePlay
+	p.elapsed = pos
+	p.since = time.Now()
+	p.schedule()
+	p.changed("player")
+}
+
+func (p *player) stop() {
+	p.state = stateStop
+	p.elapsed = 0
+	p.schedule()
+	p.changed("player")
+}
+
+func (p *player) pause(paused bool) {
+	if p.state == stateStop {
+		return
+	}
+	if paused && p.state == statePlay {
+		p.elapsed = p.position()
+		p.state = statePause
+	} else if !paused && p.state == statePause {
+		p.state = statePlay
+		p.since = time.Now()
+	}
+	p.schedule()
+	p.changed("player")
+}
+
+// nextIndex is the song after the current one, or -1 at the end of the
+// queue without repeat.
+func (p *player) nextIndex() int {
+	if len(p.queue) == 0 {
+		return -1
+	}
+	if p.random {
+		return rand.IntN(len(p.queue))
+	}
+	if p.current+1 < len(p.queue) {
+		return p.current + 1
+	}
+	if p.repeat {
+		return 0
+	}
+	return -1
+}
+
+func (p *player) songFinished() {
+	if p.single && !p.repeat {
+		p.stop()
+		return
+	}
+	if p.single {
+		p.playAt(p.current, 0)
+		return
+	}
+	if p.consume {
+		finished := p.current
+		p.remove(finished, finished+1)
+		if p.random && len(p.queue) > 0 {
+			p.playAt(rand.IntN(len(p.queue)), 0)
+		} else if finished < len(p.queue) {
+			p.playAt(finished, 0)
+		} else if p.repeat && len(p.queue) > 0 {
+			p.playAt(0, 0)
+		} else {
+			p.current = -1
+			p.stop()
+		}
+		return
+	}
+	p.next()
+}
+
+func (p *player) next() {
+	if p.current < 0 {
+		return
+	}
+	if i := p.nextIndex(); i >= 0 {
+		p.playAt(i, 0)
+		return
+	}
+	p.current = -1
+	p.stop()
+}
+
+func (p *player) previous() {
+	if p.current < 0 {
+		return
+	}
+	i := p.current - 1
+	if i < 0 {
+		if !p.repeat {
+			i = 0
+		} else {
+			i = len(p.queue) - 1
+		}
+	}
+	p.playAt(i, 0)
+}
+
+func (p *player) add(tracks []types.Track, pos int) []int {
+	if pos < 0 || pos > len(p.queue) {
+		pos = len(p.queue)
+	}
+	entries := make([]queueEntry, len(tracks))
+	ids := make([]int, len(tracks))
+	for i, t := range tracks {
+		p.nextID++
+		entries[i] = queueEntry{id: p.nextID, track: t}
+		ids[i] = p.nextID
+	}
+	p.queue = append(p.queue[:pos], append(entries, p.queue[pos:]...)...)
+	if p.current >= pos {
+		p.current += len(tracks)
+	}
+	p.changed("playlist")
+	return ids
+}
+
+// remove deletes queue[start:end]. Removing the playing song moves on to
+// the one that takes its place.
+func (p *player) remove(start, end int) {
+	wasCurrent := p.current >= start && p.current < end
+	p.queue = append(p.queue[:start], p.queue[end:]...)
+	switch {
+	case p.current >= end:
+		p.current -= end - start
+	case wasCurrent:
+		if start < len(p.queue) && p.state != stateStop {
+			p.playAt(start, 0)
+		} else {
+			p.current = -1
+			if p.state != stateStop {
+				p.stop()
+			}
+		}
+	}
+	p.changed("playlist")
+}
+
+func (p *player) clear() {
+	p.queue = nil
+	p.current = -1
+	if p.state != stateStop {
+		p.stop()
+	}
+	p.changed("playlist")
+}
+
+// move places queue[start:end] so that it begins at to in the new order.
+func (p *player) move(start, end, to int) {
+	var currentID int
+	if p.current >= 0 {
+		currentID = p.queue[p.current].id
+	}
+	moved := append([]queueEntry(nil), p.queue[start:end]...)
+	rest := append(append([]queueEntry(nil), p.queue[:start]...), p.queue[end:]...)
+	p.queue = append(rest[:to], append(moved, rest[to:]...)...)
+	if p.current >= 0 {
+		p.current = p.indexOf(currentID)
+	}
+	p.changed("playlist")
+}
+
+func (p *player) swap(a, b int) {
+	p.queue[a], p.queue[b] = p.queue[b], p.queue[a]
+	switch p.current {
+	case a:
+		p.current = b
+	case b:
+		p.current = a
+	}
+	p.changed("playlist")
+}
+
+// shuffle reorders queue[start:end], keeping track of the current song.
+func (p *player) shuffle(start, end int) {
+	var currentID int
+	if p.current >= 0 {
+		currentID = p.queue[p.current].id
+	}
+	part := p.queue[start:end]
+	rand.Shuffle(len(part), func(i, j int) { part[i], part[j] = part[j], part[i] })
+	if p.current >= 0 {
+		p.current = p.indexOf(currentID)
+	}
+	p.changed("playlist")
+}
+
+func (p *player) indexOf(id int) int {
+	for i, e := range p.queue {
+		if e.id == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,344 @@
+package mpd
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"homemusic-server/internal/db"
+	"homemusic-server/internal/types"
+)
+
+// Stored playlists are the playlists in the signed-in user's library,
+// addressed by name.
+
+func (s *session) storedPlaylist(name string) (*types.PlaylistSummary, error) {
+	playlists, err := s.server.store.Playlists.GetAllPlaylists(s.user)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range playlists {
+		if p.Name == name {
+			return &p, nil
+		}
+	}
+	return nil, newAck(ackNoExist, "No such playlist")
+}
+
+// editablePlaylist returns the ID of a playlist the user may change,
+// creating it when create is set and there is none by that name.
+func (s *session) editablePlaylist(name string, create bool) (string, error) {
+	p, err := s.storedPlaylist(name)
+	var ack *ackError
+	if create && errors.As(err, &ack) && ack.code == ackNoExist {
+		created, err := s.server.store.Playlists.CreatePlaylist(name, s.user.ID)
+		if err != nil {
+			return "", err
+		}
+		return created.ID, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if p.Access < db.AccessEdit {
+		return "", newAck(ackPermission, "You do not have permission to change this playlist")
+	}
+	if p.ReadOnly {
+		return "", newAck(ackPermission, "Playlist is read-only")
+	}
+	return p.ID, nil
+}
+
+func playlistError(err error) error {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return newAck(ackNoExist, "No such playlist")
+	case errors.Is(err, db.ErrPlaylistReadOnly):
+		return newAck(ackPermission, "Playlist is read-only")
+	}
+	return err
+}
+
+func (s *session) playlistTracks(name string) ([]types.Track, error) {
+	p, err := s.storedPlaylist(name)
+	if err != nil {
+		return nil, err
+	}
+	detail, err := s.server.store.Playlists.GetPlaylist(p.ID)
+	if err != nil {
+		return nil, err
+	}
+	if detail == nil {
+		return nil, newAck(ackNoExist, "No such playlist")
+	}
+	return detail.Tracks, nil
+}
+
+func (s *session) playlistItems(id string) ([]types.PlaylistItem, error) {
+	detail, err := s.server.store.Playlists.GetPlaylist(id)
+	if err != nil {
+		return nil, err
+	}
+	if detail == nil {
+		return nil, newAck(ackNoExist, "No such playlist")
+	}
+	return detail.Items, nil
+}
+
+// storedPlaylistChanged tells the user's idling clients about the edit.
+func (s *session) storedPlaylistChanged() {
+	s.player.mu.Lock()
+	s.player.changed("stored_playlist")
+	s.player.mu.Unlock()
+}
+
+func cmdListPlaylists(s *session, args []string, r *response) error {
+	playlists, err := s.server.store.Playlists.GetAllPlaylists(s.user)
+	if err != nil {
+		return err
+	}
+	for _, p := range playlists {
+		r.field("playlist", p.Name)
+		r.field("Last-Modified", p.UpdatedAt.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func listPlaylist(info bool) func(*session, []string, *response) error {
+	return func(s *session, args []string, r *response) error {
+		if len(args) < 1 {
+			return newAck(ackArg, "wrong number of arguments")
+		}
+		tracks, err := s.playlistTracks(args[0])
+		if err != nil {
+			return err
+		}
+		start, end := 0, -1
+		if len(args) > 1 {
+			if start, end, err = parseRange(args[1]); err != nil {
+				return err
+			}
+		}
+		start, end = clampRange(start, end, len(tracks))
+		l, err := loadLibrary(s.server.store)
+		if err != nil {
+			return err
+		}
+		for i := start; i < end; i++ {
+			if info {
+				l.writeSong(r, &tracks[i])
+			} else {
+				r.field("file", l.uri(&tracks[i]))
+			}
+		}
+		return nil
+	}
+}
+
+func cmdLoad(s *session, args []string, r *response) error {
+	if len(args) < 1 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	tracks, err := s.playlistTracks(args[0])
+	if err != nil {
+		return err
+	}
+	start, end := 0, -1
+	if len(args) > 1 {
+		if start, end, err = parseRange(args[1]); err != nil {
+			return err
+		}
+	}
+	start, end = clampRange(start, end, len(tracks))
+
+	p := s.player
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pos := -1
+	if len(args) > 2 {
+		if pos, err = queuePosition(p, args[2]); err != nil {
+			return err
+		}
+	}
+	p.add(tracks[start:end], pos)
+	return nil
+}
+
+func cmdSave(s *session, args []string, r *response) error {
+	if len(args) < 1 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	mode := "create"
+	if len(args) > 1 {
+		mode = args[1]
+	}
+	var id string
+	var err error
+	switch mode {
+	case "create":
+		if _, err := s.storedPlaylist(args[0]); err == nil {
+			return newAck(ackExist, "Playlist already exists")
+		}
+		id, err = s.editablePlaylist(args[0], true)
+	case "replace", "append":
+		id, err = s.editablePlaylist(args[0], mode == "replace")
+	default:
+		return newAck(ackArg, "Unrecognized save mode: %s", mode)
+	}
+	if err != nil {
+		return playlistError(err)
+	}
+
+	s.player.mu.Lock()
+	ids := make([]string, len(s.player.queue))
+	for i, e := range s.player.queue {
+		ids[i] = e.track.ID
+	}
+	s.player.mu.Unlock()
+	add := s.server.store.Playlists.AddTracksToPlaylist
+	if mode == "replace" {
+		add = s.server.store.Playlists.ReplacePlaylistTracks
+	}
+	if _, err := add(id, ids); err != nil {
+		return playlistError(err)
+	}
+	s.storedPlaylistChanged()
+	return nil
+}
+
+func cmdRm(s *session, args []string, r *response) error {
+	if len(args) != 1 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	p, err := s.storedPlaylist(args[0])
+	if err != nil {
+		return err
+	}
+	if p.Access < db.AccessOwner {
+		return newAck(ackPermission, "Only the owner can delete this playlist")
+	}
+	if err := s.server.store.Playlists.DeletePlaylist(p.ID); err != nil {
+		return playlistError(err)
+	}
+	s.storedPlaylistChanged()
+	return nil
+}
+
+func cmdRename(s *session, args []string, r *response) error {
+	if len(args) != 2 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	if args[1] == "" {
+		return newAck(ackArg, "Playlist name is required")
+	}
+	id, err := s.editablePlaylist(args[0], false)
+	if err != nil {
+		return err
+	}
+	if _, err := s.storedPlaylist(args[1]); err == nil {
+		return newAck(ackExist, "Playlist already exists")
+	}
+	if err := s.server.store.Playlists.RenamePlaylist(id, args[1]); err != nil {
+		return playlistError(err)
+	}
+	s.storedPlaylistChanged()
+	return nil
+}
+
+func cmdPlaylistAdd(s *session, args []string, r *response) error {
+	if len(args) < 2 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	l, err := loadLibrary(s.server.store)
+	if err != nil {
+		return err
+	}
+	tracks, err := l.resolve(args[1])
+	if err != nil {
+		return err
+	}
+	id, err := s.editablePlaylist(args[0], true)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(tracks))
+	for i, t := range tracks {
+		ids[i] = t.ID
+	}
+	if _, err := s.server.store.Playlists.AddTracksToPlaylist(id, ids); err != nil {
+		return playlistError(err)
+	}
+	s.storedPlaylistChanged()
+	return nil
+}
+
+func cmdPlaylistClear(s *session, args []string, r *response) error {
+	if len(args) != 1 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	id, err := s.editablePlaylist(args[0], true)
+	if err != nil {
+		return err
+	}
+	if err := s.server.store.Playlists.ClearPlaylist(id); err != nil {
+		return playlistError(err)
+	}
+	s.storedPlaylistChanged()
+	return nil
+}
+
+func cmdPlaylistDelete(s *session, args []string, r *response) error {
+	if len(args) != 2 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	id, err := s.editablePlaylist(args[0], false)
+	if err != nil {
+		return err
+	}
+	start, end, err := parseRange(args[1])
+	if err != nil {
+		return err
+	}
+	items, err := s.playlistItems(id)
+	if err != nil {
+		return err
+	}
+	if start >= len(items) {
+		return newAck(ackArg, "Bad song index")
+	}
+	start, end = clampRange(start, end, len(items))
+	for _, item := range items[start:end] {
+		if err := s.server.store.Playlists.RemovePlaylistItem(id, item.ID); err != nil {
+			return playlistError(err)
+		}
+	}
+	s.storedPlaylistChanged()
+	return nil
+}
+
+func cmdPlaylistMove(s *session, args []string, r *response) error {
+	if len(args) != 3 {
+		return newAck(ackArg, "wrong number of arguments")
+	}
+	id, err := s.editablePlaylist(args[0], false)
+	if err != nil {
+		return err
+	}
+	from, err1 := strconv.Atoi(args[1])
+	to, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil || from < 0 || to < 0 {
+		return newAck(ackArg, "Integer expected")
+	}
+	items, err := s.playlistItems(id)
+	if err != nil {
+		return err
+	}
+	if from >= len(items) || to >= len(items) {
+		return newAck(ackArg, "Bad song index")
+	}
+	if err := s.server.store.Playlists.MovePlaylistItem(id, items[from].ID, to); err != nil {
+		return playlistError(err)
+	}
+	s.storedPlaylistChanged()
+	return nil
+}
@@ -0,0 +1,372 @@
+package playlistfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type Format string
+
+const (
+	FormatM3U  Format = "m3u"
+	FormatM3U8 Format = "m3u8"
+	FormatPLS  Format = "pls"
+	FormatXSPF Format = "xspf"
+)
+
+// Entry is one item of a playlist file. Location is kept as written; use
+// Resolver to map it onto library tracks.
+type Entry struct {
+	Location string `json:"location"`
+	Title    string `json:"title,omitempty"`
+	Artist   string `json:"artist,omitempty"`
+	Album    string `json:"album,omitempty"`
+	Duration int    `json:"duration,omitempty"` // seconds, 0 when unknown
+}
+
+func ParseFormat(s string) (Format, bool) {
+	switch Format(strings.ToLower(strings.TrimPrefix(s, "."))) {
+	case FormatM3U:
+		return FormatM3U, true
+	case FormatM3U8:
+		return FormatM3U8, true
+	case FormatPLS:
+		return FormatPLS, true
+	case FormatXSPF:
+		return FormatXSPF, true
+	}
+	return "", false
+}
+
+// FormatFromFilename picks the format from a file extension.
+func FormatFromFilename(name string) (Format, bool) {
+	return ParseFormat(path.Ext(strings.ToLower(name)))
+}
+
+// DetectFormat sniffs the format from the file contents.
+func DetectFormat(data []byte) Format {
+	head := strings.ToLower(string(bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))))
+	switch {
+	case strings.HasPrefix(head, "[playlist]"):
+		return FormatPLS
+	case strings.HasPrefix(head, "<?xml") || strings.HasPrefix(head, "<playlist"):
+		return FormatXSPF
+	}
+	return FormatM3U8
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatM3U:
+		return "audio/x-mpegurl"
+	case FormatM3U8:
+		return "application/vnd.apple.mpegurl; charset=utf-8"
+	case FormatPLS:
+		return "audio/x-scpls; charset=utf-8"
+	case FormatXSPF:
+		return "application/xspf+xml; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+func Parse(data []byte, f Format) ([]Entry, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	switch f {
+	case FormatM3U, FormatM3U8:
+		return parseM3U(decodeText(data))
+	case FormatPLS:
+		return parsePLS(decodeText(data))
+	case FormatXSPF:
+		return parseXSPF(data)
+	}
+	return nil, fmt.Errorf("unsupported playlist format %q", f)
+}
+
+func Write(w io.Writer, name string, entries []Entry, f Format) error {
+	switch f {
+	case FormatM3U, FormatM3U8:
+		return writeM3U(w, entries)
+	case FormatPLS:
+		return writePLS(w, entries)
+	case FormatXSPF:
+		return writeXSPF(w, name, entries)
+	}
+	return fmt.Errorf("unsupported playlist format %q", f)
+}
+
+func parseM3U(data []byte) ([]Entry, error) {
+	var entries []Entry
+	var pending Entry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#EXTINF:") {
+			info := strings.TrimPrefix(line, "#EXTINF:")
+			durPart, title, _ := strings.Cut(info, ",")
+			// Attributes such as tvg-id may follow the duration
+			durPart, _, _ = strings.Cut(durPart, " ")
+			if d, err := strconv.ParseFloat(durPart, 64); err == nil && d > 0 {
+				pending.Duration = int(d)
+			}
+			pending.Artist, pending.Title = splitArtistTitle(strings.TrimSpace(title))
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		pending.Location = line
+		entries = append(entries, pending)
+		pending = Entry{}
+	}
+	return entries, sc.Err()
+}
+
+// cp1252 holds the characters Windows-1252 puts in 0x80-0x9F, where
+// Latin-1 has control codes. Unassigned bytes map to themselves.
+var cp1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8d, 'Ž', 0x8f,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9d, 'ž', 'Ÿ',
+}
+
+// decodeText returns UTF-8 text unchanged and reads anything else as
+// Windows-1252, the encoding older players wrote plain .m3u files in.
+// Windows-1252 is a superset of the printable Latin-1 range, so Latin-1
+// files decode the same way.
+func decodeText(data []byte) []byte {
+	if utf8.Valid(data) {
+		return data
+	}
+	out := make([]byte, 0, len(data)+len(data)/4)
+	for _, b := range data {
+		switch {
+		case b < 0x80:
+			out = append(out, b)
+		case b < 0xa0:
+			out = utf8.AppendRune(out, cp1252[b-0x80])
+		default:
+			out = utf8.AppendRune(out, rune(b))
+		}
+	}
+	return out
+}
+
+func splitArtistTitle(s string) (string, string) {
+	if artist, title, ok := strings.Cut(s, " - "); ok {
+		return strings.TrimSpace(artist), strings.TrimSpace(title)
+	}
+	return "", s
+}
+
+func parsePLS(data []byte) ([]Entry, error) {
+	byIndex := map[int]*Entry{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(sc.Text()), "=")
+		if !ok {
+			continue
+		}
+		lower := strings.ToLower(key)
+		var field string
+		for _, prefix := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(lower, prefix) {
+				field = prefix
+				break
+			}
+		}
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(key[len(field):])
+		if err != nil {
+			continue
+		}
+		e, ok := byIndex[n]
+		if !ok {
+			e = &Entry{}
+			byIndex[n] = e
+		}
+		switch field {
+		case "file":
+			e.Location = strings.TrimSpace(value)
+		case "title":
+			e.Artist, e.Title = splitArtistTitle(strings.TrimSpace(value))
+		case "length":
+			if d, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && d > 0 {
+				e.Duration = d
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	indexes := make([]int, 0, len(byIndex))
+	for n := range byIndex {
+		indexes = append(indexes, n)
+	}
+	sort.Ints(indexes)
+
+	var entries []Entry
+	for _, n := range indexes {
+		if byIndex[n].Location != "" {
+			entries = append(entries, *byIndex[n])
+		}
+	}
+	return entries, nil
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	Duration int    `xml:"duration,omitempty"` // milliseconds
+}
+
+func parseXSPF(data []byte) ([]Entry, error) {
+	var doc struct {
+		Tracks []xspfTrack `xml:"trackList>track"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid XSPF: %w", err)
+	}
+
+	var entries []Entry
+	for _, t := range doc.Tracks {
+		loc := strings.TrimSpace(t.Location)
+		if loc == "" {
+			continue
+		}
+		entries = append(entries, Entry{
+			Location: locationFromURI(loc),
+			Title:    t.Title,
+			Artist:   t.Creator,
+			Album:    t.Album,
+			Duration: t.Duration / 1000,
+		})
+	}
+	return entries, nil
+}
+
+func writeM3U(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("#EXTM3U\n")
+	for _, e := range entries {
+		duration := e.Duration
+		if duration == 0 {
+			duration = -1
+		}
+		fmt.Fprintf(bw, "#EXTINF:%d,%s\n%s\n", duration, displayTitle(e), e.Location)
+	}
+	return bw.Flush()
+}
+
+func writePLS(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("[playlist]\n")
+	for i, e := range entries {
+		n := i + 1
+		duration := e.Duration
+		if duration == 0 {
+			duration = -1
+		}
+		fmt.Fprintf(bw, "File%d=%s\nTitle%d=%s\nLength%d=%d\n", n, e.Location, n, displayTitle(e), n, duration)
+	}
+	fmt.Fprintf(bw, "NumberOfEntries=%d\nVersion=2\n", len(entries))
+	return bw.Flush()
+}
+
+func writeXSPF(w io.Writer, name string, entries []Entry) error {
+	doc := xspfPlaylist{Version: "1", Title: name}
+	for _, e := range entries {
+		doc.Tracks = append(doc.Tracks, xspfTrack{
+			Location: locationToURI(e.Location),
+			Title:    e.Title,
+			Creator:  e.Artist,
+			Album:    e.Album,
+			Duration: e.Duration * 1000,
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func displayTitle(e Entry) string {
+	if e.Artist != "" {
+		return e.Artist + " - " + e.Title
+	}
+	return e.Title
+}
+
+// locationToURI encodes a path as the URI XSPF requires. URLs pass
+// through, absolute paths become file URIs and relative paths stay
+// relative references.
+func locationToURI(loc string) string {
+	if strings.Contains(loc, "://") {
+		return loc
+	}
+	p := strings.ReplaceAll(loc, "\\", "/")
+	if isWindowsAbs(p) {
+		p = "/" + p
+	}
+	u := url.URL{Path: p}
+	if strings.HasPrefix(p, "/") {
+		u.Scheme = "file"
+	}
+	return u.String()
+}
+
+func locationFromURI(loc string) string {
+	u, err := url.Parse(loc)
+	if err != nil {
+		return loc
+	}
+	switch u.Scheme {
+	case "file":
+		p := u.Path
+		if u.Host != "" && u.Host != "localhost" {
+			// UNC path: file://server/share/x
+			return "//" + u.Host + p
+		}
+		if isWindowsAbs(strings.TrimPrefix(p, "/")) {
+			p = strings.TrimPrefix(p, "/")
+		}
+		return p
+	case "":
+		return u.Path
+	}
+	return loc
+}
+
+func isWindowsAbs(p string) bool {
+	return len(p) >= 2 && p[1] == ':' && ((p[0] >= 'a' && p[0] <= 'z') || (p[0] >= 'A' && p[0] <= 'Z'))
+}
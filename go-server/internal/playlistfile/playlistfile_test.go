@@ -0,0 +1,90 @@
+package playlistfile
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		data   string
+		want   []Entry
+	}{
+		{"extended m3u", FormatM3U8, "\xef\xbb\xbf#EXTM3U\n#EXTINF:215,Artist - Song\nMusic/song.mp3\n\n# comment\nhttp://radio.example/stream\n",
+			[]Entry{{Location: "Music/song.mp3", Artist: "Artist", Title: "Song", Duration: 215}, {Location: "http://radio.example/stream"}}},
+		{"m3u attributes and unknown length", FormatM3U, "#EXTINF:-1 tvg-id=\"x\",Just A Title\r\nC:\\Music\\a.flac\r\n",
+			[]Entry{{Location: "C:\\Music\\a.flac", Title: "Just A Title"}}},
+		{"cp1252 m3u", FormatM3U, "#EXTINF:10,Bj\xf6rk \x96 J\xf3ga\n\\Musik\\Bj\xf6rk\\J\xf3ga.mp3\n",
+			[]Entry{{Location: "\\Musik\\Björk\\Jóga.mp3", Title: "Björk – Jóga", Duration: 10}}},
+		{"utf-8 m3u left alone", FormatM3U, "Musik/Björk/Jóga.mp3\n",
+			[]Entry{{Location: "Musik/Björk/Jóga.mp3"}}},
+		{"pls out of order", FormatPLS, "[playlist]\nFile2=b.mp3\nfile1=a.mp3\nTitle1=A - One\nLength1=61\nLength2=-1\nTitle3=no file\nNumberOfEntries=2\n",
+			[]Entry{{Location: "a.mp3", Artist: "A", Title: "One", Duration: 61}, {Location: "b.mp3"}}},
+		{"latin-1 pls", FormatPLS, "[playlist]\nFile1=Caf\xe9.mp3\n",
+			[]Entry{{Location: "Café.mp3"}}},
+		{"xspf", FormatXSPF, `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/"><trackList>
+<track><location>file:///music/a%20b.mp3</location><title>T</title><creator>C</creator><album>L</album><duration>90500</duration></track>
+<track><location>file://nas/share/c.mp3</location></track>
+<track><location>file:///C:/Music/d.mp3</location></track>
+<track><location>rel/e.mp3</location></track>
+<track><title>no location</title></track>
+</trackList></playlist>`,
+			[]Entry{
+				{Location: "/music/a b.mp3", Title: "T", Artist: "C", Album: "L", Duration: 90},
+				{Location: "//nas/share/c.mp3"},
+				{Location: "C:/Music/d.mp3"},
+				{Location: "rel/e.mp3"},
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.data), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := map[string]Format{
+		"[playlist]\nFile1=a.mp3":           FormatPLS,
+		"\xef\xbb\xbf  [Playlist]":          FormatPLS,
+		"<?xml version=\"1.0\"?><playlist>": FormatXSPF,
+		"<playlist xmlns=\"\">":             FormatXSPF,
+		"#EXTM3U\na.mp3":                    FormatM3U8,
+		"a.mp3":                             FormatM3U8,
+	}
+	for data, want := range tests {
+		if got := DetectFormat([]byte(data)); got != want {
+			t.Errorf("DetectFormat(%q) = %q, want %q", data, got, want)
+		}
+	}
+}
+
+func TestWriteParseRoundTrip(t *testing.T) {
+	entries := []Entry{
+		{Location: "/music/Björk/Jóga.mp3", Artist: "Björk", Title: "Jóga", Duration: 305},
+		{Location: "relative/song.flac", Title: "Song"},
+	}
+	for _, f := range []Format{FormatM3U8, FormatPLS, FormatXSPF} {
+		var buf bytes.Buffer
+		if err := Write(&buf, "Mix", entries, f); err != nil {
+			t.Fatal(err)
+		}
+		got, err := Parse(buf.Bytes(), f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, entries) {
+			t.Errorf("%s: got %+v\nwant %+v", f, got, entries)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package playlistfile
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// TrackRef is the minimum a Resolver needs to know about a library track.
+// FolderPath is optional; when it differs from the directory of Path the
+// track is also found under FolderPath plus the file name.
+type TrackRef struct {
+	ID         string
+	SourceID   string
+	Path       string
+	FolderPath string
+}
+
+// Resolver maps playlist locations written on other machines onto library
+// tracks. Exact paths win; otherwise the track sharing the longest run of
+// trailing path segments with the location is chosen.
+type Resolver struct {
+	refs   []TrackRef
+	keys   []resolverKey
+	byPath map[string][]int
+	byName map[string][]int
+}
+
+// resolverKey is one normalized path a track is known by.
+type resolverKey struct {
+	ref      int
+	segments []string
+}
+
+func NewResolver(refs []TrackRef) *Resolver {
+	r := &Resolver{
+		refs:   refs,
+		byPath: map[string][]int{},
+		byName: map[string][]int{},
+	}
+	for i, ref := range refs {
+		key := normalizePath(ref.Path)
+		r.addKey(i, key)
+		if ref.FolderPath != "" {
+			name := path.Base(strings.ReplaceAll(ref.Path, "\\", "/"))
+			if alt := normalizePath(path.Join(strings.ReplaceAll(ref.FolderPath, "\\", "/"), name)); alt != key {
+				r.addKey(i, alt)
+			}
+		}
+	}
+	return r
+}
+
+func (r *Resolver) addKey(ref int, key string) {
+	k := len(r.keys)
+	segs := strings.Split(key, "/")
+	r.keys = append(r.keys, resolverKey{ref: ref, segments: segs})
+	r.byPath[key] = append(r.byPath[key], k)
+	name := segs[len(segs)-1]
+	r.byName[name] = append(r.byName[name], k)
+}
+
+// Resolve returns the ID of the track a location refers to. baseDir is the
+// directory of the playlist file, used for relative entries, and sourceID,
+// when set, restricts matches to one source.
+func (r *Resolver) Resolve(location, baseDir, sourceID string) (string, bool) {
+	loc := strings.TrimSpace(location)
+	if loc == "" {
+		return "", false
+	}
+	if strings.Contains(loc, "://") {
+		u, err := url.Parse(loc)
+		if err != nil {
+			return "", false
+		}
+		if u.Scheme != "file" {
+			// Our own stream URLs carry the track ID directly
+			if id, ok := strings.CutPrefix(u.Path, "/api/stream/"); ok && id != "" && !strings.Contains(id, "/") {
+				return r.byID(id, sourceID)
+			}
+			return "", false
+		}
+		loc = locationFromURI(loc)
+	}
+
+	loc = strings.ReplaceAll(loc, "\\", "/")
+	absolute := strings.HasPrefix(loc, "/") || isWindowsAbs(loc)
+	if isWindowsAbs(loc) {
+		loc = loc[2:]
+	}
+
+	if !absolute && baseDir != "" {
+		if id, ok := r.exact(path.Join(strings.ReplaceAll(baseDir, "\\", "/"), loc), sourceID); ok {
+			return id, true
+		}
+	}
+	if id, ok := r.exact(loc, sourceID); ok {
+		return id, true
+	}
+	return r.bySuffix(loc, sourceID)
+}
+
+func (r *Resolver) byID(id, sourceID string) (string, bool) {
+	for _, ref := range r.refs {
+		if ref.ID == id && (sourceID == "" || ref.SourceID == sourceID) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func (r *Resolver) exact(p, sourceID string) (string, bool) {
+	for _, k := range r.byPath[normalizePath(p)] {
+		ref := r.refs[r.keys[k].ref]
+		if sourceID == "" || ref.SourceID == sourceID {
+			return ref.ID, true
+		}
+	}
+	return "", false
+}
+
+func (r *Resolver) bySuffix(p, sourceID string) (string, bool) {
+	// Cleaning against the root drops leading ".." of unanchored relative paths
+	segs := strings.Split(normalizePath(p), "/")
+
+	best, bestScore, tied := -1, 0, false
+	for _, k := range r.byName[segs[len(segs)-1]] {
+		i := r.keys[k].ref
+		if sourceID != "" && r.refs[i].SourceID != sourceID {
+			continue
+		}
+		score := commonSuffix(segs, r.keys[k].segments)
+		switch {
+		case score > bestScore:
+			best, bestScore, tied = i, score, false
+		case score == bestScore && r.refs[i].ID != r.refs[best].ID:
+			tied = true
+		}
+	}
+	// A bare file name shared by several tracks is too ambiguous to guess
+	if best == -1 || (tied && bestScore == 1) {
+		return "", false
+	}
+	return r.refs[best].ID, true
+}
+
+func commonSuffix(a, b []string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
+
+// normalizePath lower-cases the path, unifies separators and drops leading
+// slashes so share-relative SMB paths and absolute SFTP paths compare alike.
+func normalizePath(p string) string {
+	p = strings.ReplaceAll(p, "\\", "/")
+	if isWindowsAbs(p) {
+		p = p[2:]
+	}
+	p = path.Clean("/" + strings.ToLower(p))
+	return strings.TrimPrefix(p, "/")
+}
@@ -0,0 +1,50 @@
+package playlistfile
+
+import "testing"
+
+func TestResolve(t *testing.T) {
+	r := NewResolver([]TrackRef{
+		{ID: "jóga", SourceID: "nas", Path: "Music/Björk/Homogenic/Jóga.mp3", FolderPath: "Music/Björk/Homogenic"},
+		{ID: "intro-a", SourceID: "nas", Path: "Music/A/intro.mp3"},
+		{ID: "intro-b", SourceID: "nas", Path: "Music/B/intro.mp3"},
+		{ID: "sftp-song", SourceID: "sftp", Path: "/home/me/music/Song.flac"},
+		// Moved on disk: the folder was renamed since the path was recorded
+		{ID: "moved", SourceID: "nas", Path: "Old/Album/track.mp3", FolderPath: "New Album"},
+		{ID: "other-track", SourceID: "nas", Path: "Else/track.mp3", FolderPath: "Else"},
+	})
+
+	tests := []struct {
+		name     string
+		location string
+		baseDir  string
+		sourceID string
+		want     string
+	}{
+		{"exact", "Music/Björk/Homogenic/Jóga.mp3", "", "", "jóga"},
+		{"case and leading slash", "/music/björk/homogenic/jóga.MP3", "", "", "jóga"},
+		{"relative to playlist", "Homogenic/Jóga.mp3", "Music/Björk", "", "jóga"},
+		{"parent relative", "../A/intro.mp3", "Music/Playlists", "", "intro-a"},
+		{"windows absolute", `D:\Music\Björk\Homogenic\Jóga.mp3`, "", "", "jóga"},
+		{"unc path", `\\nas\share\Music\B\intro.mp3`, "", "", "intro-b"},
+		{"file uri", "file:///home/me/music/Song.flac", "", "", "sftp-song"},
+		{"foreign prefix by suffix", "/mnt/old-nas/Björk/Homogenic/Jóga.mp3", "", "", "jóga"},
+		{"folder and file name", "New Album/track.mp3", "", "", "moved"},
+		{"folder relative to playlist", "track.mp3", "New Album", "", "moved"},
+		{"ambiguous file name", "intro.mp3", "", "", ""},
+		{"unique file name", "Song.flac", "", "", "sftp-song"},
+		{"other source", "Music/A/intro.mp3", "", "sftp", ""},
+		{"stream url", "http://host:3001/api/stream/intro-b", "", "", "intro-b"},
+		{"stream url unknown id", "http://host:3001/api/stream/nope", "", "", ""},
+		{"internet radio", "http://radio.example/live.mp3", "", "", ""},
+		{"missing", "Music/C/outro.mp3", "", "", ""},
+		{"blank", "  ", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := r.Resolve(tt.location, tt.baseDir, tt.sourceID)
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("Resolve(%q, %q, %q) = %q, %v, want %q", tt.location, tt.baseDir, tt.sourceID, got, ok, tt.want)
+			}
+		})
+	}
+}
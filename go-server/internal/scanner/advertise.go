@@ -0,0 +1,44 @@
+package scanner
+
+import (
+	"github.com/grandcat/zeroconf"
+	"homemusic-server/internal/logging"
+)
+
+// Announcement is a service this server publishes about itself over mDNS.
+type Announcement struct {
+	Instance string
+	Service  string
+	Port     int
+	Text     []string
+}
+
+// Advertiser keeps the server's own services registered so clients can
+// find it on the local network without knowing its address.
+type Advertiser struct {
+	servers []*zeroconf.Server
+}
+
+// Advertise registers each announcement on all multicast interfaces.
+func Advertise(announcements []Announcement) (*Advertiser, error) {
+	a := &Advertiser{}
+	for _, ann := range announcements {
+		server, err := zeroconf.Register(ann.Instance, ann.Service, "local.", ann.Port, ann.Text, nil)
+		if err != nil {
+			a.Shutdown()
+			return nil, err
+		}
+		logging.Infof("[Discovery] Advertising %s as %q on port %d", ann.Service, ann.Instance, ann.Port)
+		a.servers = append(a.servers, server)
+	}
+	return a, nil
+}
+
+// Shutdown unregisters the services, sending goodbye records so clients
+// drop them straight away rather than waiting for the TTL to run out.
+func (a *Advertiser) Shutdown() {
+	for _, server := range a.servers {
+		server.Shutdown()
+	}
+	a.servers = nil
+}
@@ -0,0 +1,65 @@
+package scanner
+
+import (
+	"homemusic-server/internal/db"
+)
+
+// scanBatchSize is how many files are staged at a time.
+const scanBatchSize = 200
+
+// scanWriter stages the files of one scan, a batch at a time, and has the
+// store merge them into the library at once when the scan is done. Artists
+// and albums are only created then too, so readers never see a
+// half-scanned source, and a scan that dies leaves the library as it was.
+type scanWriter struct {
+	scans    db.ScanStore
+	sourceID string
+	seen     map[string]bool
+	pending  []db.ScannedTrack
+	staged   int
+}
+
+// newScanWriter clears whatever an earlier, interrupted scan of the source
+// left staged.
+func newScanWriter(scans db.ScanStore, sourceID string, seen map[string]bool) (*scanWriter, error) {
+	if err := scans.ClearStagedTracks(sourceID); err != nil {
+		return nil, err
+	}
+	return &scanWriter{scans: scans, sourceID: sourceID, seen: seen}, nil
+}
+
+// add queues t and stages the queue once it holds a full batch.
+func (w *scanWriter) add(t *db.ScannedTrack) error {
+	w.pending = append(w.pending, *t)
+	if len(w.pending) < scanBatchSize {
+		return nil
+	}
+	return w.flush()
+}
+
+// flush stages the queued files.
+func (w *scanWriter) flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	if err := w.scans.StageTracks(w.sourceID, w.pending); err != nil {
+		return err
+	}
+	w.staged += len(w.pending)
+	w.pending = w.pending[:0]
+	return nil
+}
+
+// commit stages what is left and replaces the source's tracks with the
+// staged files.
+func (w *scanWriter) commit() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.scans.CommitScan(w.sourceID, w.seen)
+}
+
+// discard drops whatever the scan staged.
+func (w *scanWriter) discard() {
+	w.scans.ClearStagedTracks(w.sourceID)
+}
@@ -0,0 +1,91 @@
+package scanner
+
+import (
+	"fmt"
+	"io"
+
+	"homemusic-server/internal/sources"
+	"homemusic-server/internal/types"
+)
+
+// sourceConn is an open connection to a source's files.
+type sourceConn interface {
+	// walk lists the music and playlist files under the source's base path.
+	walk() (music, playlists []musicFile, err error)
+	open(path string) (io.ReadSeekCloser, error)
+	Close()
+}
+
+// connectSource connects to an SMB share or SSH host.
+func connectSource(source *types.Source) (sourceConn, error) {
+	switch source.Type {
+	case types.SourceTypeSMB:
+		client := sources.NewSMBClient(sources.SMBConfig{
+			Host:     source.Host,
+			Share:    getString(source.Share),
+			Username: getString(source.Username),
+			Password: getString(source.Password),
+			Domain:   getString(source.Domain),
+		})
+		if err := client.Connect(); err != nil {
+			return nil, err
+		}
+		basePath := "."
+		if source.BasePath != nil && *source.BasePath != "" {
+			basePath = *source.BasePath
+		}
+		return &smbConn{client, basePath}, nil
+	case types.SourceTypeSSH:
+		client := sources.NewSSHClient(sources.SSHConfig{
+			Host:     source.Host,
+			Port:     source.Port,
+			Username: getString(source.Username),
+			Password: getString(source.Password),
+		})
+		if err := client.Connect(); err != nil {
+			return nil, err
+		}
+		basePath := "/"
+		if source.BasePath != nil && *source.BasePath != "" {
+			basePath = *source.BasePath
+		}
+		return &sshConn{client, basePath}, nil
+	}
+	return nil, fmt.Errorf("unsupported source type: %s", source.Type)
+}
+
+type smbConn struct {
+	*sources.SMBClient
+	basePath string
+}
+
+func (c *smbConn) walk() (music, playlists []musicFile, err error) {
+	err = walkSMB(c.SMBClient, c.basePath, &music, &playlists)
+	return music, playlists, err
+}
+
+func (c *smbConn) open(path string) (io.ReadSeekCloser, error) {
+	f, err := c.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type sshConn struct {
+	*sources.SSHClient
+	basePath string
+}
+
+func (c *sshConn) walk() (music, playlists []musicFile, err error) {
+	err = walkSSH(c.SSHClient, c.basePath, &music, &playlists)
+	return music, playlists, err
+}
+
+func (c *sshConn) open(path string) (io.ReadSeekCloser, error) {
+	f, err := c.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
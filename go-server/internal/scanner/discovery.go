@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/grandcat/zeroconf"
+	"homemusic-server/internal/logging"
 )
 
 type DiscoveredService struct {
@@ -64,7 +65,7 @@ func (m *DiscoveryManager) browse(ctx context.Context, serviceType, friendlyType
 						Port:      entry.Port,
 						Addresses: m.entryToAddresses(entry),
 					})
-					log.Printf("[Discovery] Found %s service: %s at %v", friendlyType, name, entry.AddrIPv4)
+					logging.Infof("[Discovery] Found %s service: %s at %v", friendlyType, name, entry.AddrIPv4)
 				}
 				m.mu.Unlock()
 			}
@@ -0,0 +1,68 @@
+package scanner
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+
+	"github.com/dhowden/tag"
+	"homemusic-server/internal/db"
+)
+
+// unchanged reports whether the file at mf is the one the library already
+// has a checksum for. Without a modification time that cannot be told.
+func unchanged(k db.KnownFile, mf musicFile) bool {
+	return k.ContentHash != "" && !mf.mtime.IsZero() && k.Mtime.Equal(mf.mtime)
+}
+
+// audioChecksum hashes a file's audio, leaving out its tags so that
+// retagging does not change it. tag.Sum hashes from the start of the file
+// for MP3s with ID3v2 tags, so those are handled here.
+func audioChecksum(r io.ReadSeeker) (string, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	if string(header[:3]) != "ID3" {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		return tag.Sum(r)
+	}
+
+	var size int64
+	for _, b := range header[6:10] {
+		size = size<<7 | int64(b&0x7f)
+	}
+	start := 10 + size
+	if header[5]&0x10 != 0 {
+		start += 10 // ID3v2.4 footer
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+	if end-start >= 128 {
+		trailer := make([]byte, 3)
+		if _, err := r.Seek(end-128, io.SeekStart); err != nil {
+			return "", err
+		}
+		if _, err := io.ReadFull(r, trailer); err == nil && string(trailer) == "TAG" {
+			end -= 128
+		}
+	}
+	if end <= start {
+		return "", io.ErrUnexpectedEOF
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	if _, err := io.CopyN(h, r, end-start); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,86 @@
+package scanner
+
+import (
+	"io"
+	"log"
+	"path"
+	"strings"
+
+	"homemusic-server/internal/logging"
+	"homemusic-server/internal/playlistfile"
+)
+
+// maxPlaylistFileSize guards against reading huge files that merely carry
+// a playlist extension.
+const maxPlaylistFileSize = 5 << 20
+
+// syncSourcePlaylists turns playlist files found on a source into read-only
+// playlists, resolving entries relative to each file's directory against
+// tracks of the same source, and drops playlists whose file disappeared.
+func (s *Scanner) syncSourcePlaylists(sourceID string, files []musicFile, open func(path string) (io.ReadCloser, error)) {
+	tracks, err := s.store.Library.GetTracksBySource(sourceID)
+	if err != nil {
+		log.Printf("[Scanner] Failed to load tracks for playlist resolution: %v", err)
+		return
+	}
+	refs := make([]playlistfile.TrackRef, len(tracks))
+	for i, t := range tracks {
+		refs[i] = playlistfile.TrackRef{ID: t.ID, SourceID: t.SourceID, Path: t.Path}
+		if t.FolderPath != nil {
+			refs[i].FolderPath = *t.FolderPath
+		}
+	}
+	resolver := playlistfile.NewResolver(refs)
+
+	var seen []string
+	for _, pf := range files {
+		// Keep the previous version of a playlist that fails to read this time
+		seen = append(seen, pf.path)
+
+		entries, err := readPlaylistFile(pf.path, open)
+		if err != nil {
+			log.Printf("[Scanner] Failed to read playlist %s: %v", pf.path, err)
+			continue
+		}
+
+		baseDir := path.Dir(strings.ReplaceAll(pf.path, "\\", "/"))
+		var trackIDs []string
+		unmatched := 0
+		for _, e := range entries {
+			if id, ok := resolver.Resolve(e.Location, baseDir, sourceID); ok {
+				trackIDs = append(trackIDs, id)
+			} else {
+				unmatched++
+			}
+		}
+
+		name := strings.TrimSuffix(path.Base(pf.path), path.Ext(pf.path))
+		if err := s.store.Playlists.SyncSourcePlaylist(sourceID, pf.path, name, trackIDs); err != nil {
+			log.Printf("[Scanner] Failed to save playlist %s: %v", pf.path, err)
+			continue
+		}
+		logging.Infof("[Scanner] Synced playlist %s (%d tracks, %d unmatched)", pf.path, len(trackIDs), unmatched)
+	}
+
+	if err := s.store.Playlists.DeleteStaleSourcePlaylists(sourceID, seen); err != nil {
+		log.Printf("[Scanner] Failed to remove stale playlists: %v", err)
+	}
+}
+
+func readPlaylistFile(filePath string, open func(path string) (io.ReadCloser, error)) ([]playlistfile.Entry, error) {
+	f, err := open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxPlaylistFileSize))
+	if err != nil {
+		return nil, err
+	}
+	format, ok := playlistfile.FormatFromFilename(filePath)
+	if !ok {
+		format = playlistfile.DetectFormat(data)
+	}
+	return playlistfile.Parse(data, format)
+}
@@ -1,6 +1,7 @@
 package scanner
 
 import (
+	"context"
 	"crypto/md5"
 	"fmt"
 	"io"
@@ -8,12 +9,13 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dhowden/tag"
-	"github.com/google/uuid"
 	"github.com/tcolgate/mp3"
 	"homemusic-server/internal/db"
+	"homemusic-server/internal/logging"
 	"homemusic-server/internal/sources"
 	"homemusic-server/internal/types"
 )
@@ -23,6 +25,7 @@ var musicExtensions = map[string]bool{
 	".flac": true,
 	".m4a":  true,
 	".ogg":  true,
+	".opus": true,
 	".wav":  true,
 	".aac":  true,
 }
@@ -32,6 +35,18 @@ func isMusicFile(filename string) bool {
 	return musicExtensions[ext]
 }
 
+var playlistExtensions = map[string]bool{
+	".m3u":  true,
+	".m3u8": true,
+	".pls":  true,
+	".xspf": true,
+}
+
+func isPlaylistFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return playlistExtensions[ext]
+}
+
 type musicFile struct {
 	path  string
 	mtime time.Time
@@ -44,140 +59,197 @@ func getString(s *string) string {
 	return *s
 }
 
-func ScanSource(sourceID string) error {
-	source, err := db.GetSource(sourceID)
+// ArtDir is where artwork extracted from tags is saved and served from
+// as /api/art/.
+var ArtDir = filepath.Join("public", "art")
+
+// scanning holds the sources with a scan in progress, which own their
+// rows in scan_tracks.
+var scanning sync.Map
+
+// scanSlots limits how many sources are scanned at once; see SetConcurrency.
+var scanSlots = make(chan struct{}, 2)
+
+// SetConcurrency sets how many sources may be scanned at the same time.
+// Further scans wait for a running one to finish. Call it before the first
+// scan starts.
+func SetConcurrency(n int) {
+	scanSlots = make(chan struct{}, max(n, 1))
+}
+
+// Scanner reads the files of music sources into a store.
+type Scanner struct {
+	store *db.Store
+	// connect opens a source's files; tests replace it
+	connect func(source *types.Source) (sourceConn, error)
+}
+
+// New returns a Scanner that reads sources from store and saves what it
+// finds there.
+func New(store *db.Store) *Scanner {
+	return &Scanner{store: store, connect: connectSource}
+}
+
+// ScanSource reads the source's files into the store. A cancelled ctx stops
+// the scan at the next file without saving anything.
+func (s *Scanner) ScanSource(ctx context.Context, sourceID string) error {
+	source, err := s.store.Sources.GetSource(sourceID)
 	if err != nil {
 		return err
 	}
 	if source == nil {
 		return fmt.Errorf("source not found")
 	}
+	if _, busy := scanning.LoadOrStore(sourceID, true); busy {
+		return fmt.Errorf("a scan of %s is already running", source.Name)
+	}
+	defer scanning.Delete(sourceID)
 
-	log.Printf("[Scanner] Starting scan for source: %s", source.Name)
-	updateStatus(sourceID, "scanning", 0, 0, 0, nil)
+	// Shown as scanning while waiting for a slot, so the UI keeps polling
+	s.updateStatus(sourceID, "scanning", 0, 0, 0, nil)
+	slots := scanSlots
+	slots <- struct{}{}
+	defer func() { <-slots }()
+	logging.Infof("[Scanner] Starting scan for source: %s", source.Name)
 
 	var musicFiles []musicFile
-	var scanErr error
-
-	var smbClient *sources.SMBClient
-	var sshClient *sources.SSHClient
-
-	if source.Type == types.SourceTypeSMB {
-		smbClient = sources.NewSMBClient(sources.SMBConfig{
-			Host:     source.Host,
-			Share:    getString(source.Share),
-			Username: getString(source.Username),
-			Password: getString(source.Password),
-			Domain:   getString(source.Domain),
-		})
-		if err := smbClient.Connect(); err != nil {
-			scanErr = err
-		} else {
-			defer smbClient.Close()
-			basePath := "."
-			if source.BasePath != nil && *source.BasePath != "" {
-				basePath = *source.BasePath
-			}
-			scanErr = walkSMB(smbClient, basePath, &musicFiles)
-		}
-	} else if source.Type == types.SourceTypeSSH {
-		sshClient = sources.NewSSHClient(sources.SSHConfig{
-			Host:     source.Host,
-			Port:     source.Port,
-			Username: getString(source.Username),
-			Password: getString(source.Password),
-		})
-		if err := sshClient.Connect(); err != nil {
-			scanErr = err
-		} else {
-			defer sshClient.Close()
-			basePath := "/"
-			if source.BasePath != nil && *source.BasePath != "" {
-				basePath = *source.BasePath
-			}
-			scanErr = walkSSH(sshClient, basePath, &musicFiles)
-		}
+	var playlistFiles []musicFile
+
+	conn, scanErr := s.connect(source)
+	if scanErr == nil {
+		defer conn.Close()
+		musicFiles, playlistFiles, scanErr = conn.walk()
 	}
 
 	if scanErr != nil {
 		errStr := scanErr.Error()
-		updateStatus(sourceID, "error", 0, 0, 0, &errStr)
+		s.updateStatus(sourceID, "error", 0, 0, 0, &errStr)
 		return scanErr
 	}
 
 	total := len(musicFiles)
-	log.Printf("[Scanner] Found %d music files in %s", total, source.Name)
-	updateStatus(sourceID, "scanning", 5, total, 0, nil)
+	logging.Infof("[Scanner] Found %d music files in %s", total, source.Name)
+	s.updateStatus(sourceID, "scanning", 5, total, 0, nil)
+
+	seen := make(map[string]bool, total)
+	for _, mf := range musicFiles {
+		seen[mf.path] = true
+	}
+	known, err := s.store.Scans.KnownFiles(sourceID)
+	if err != nil {
+		errStr := err.Error()
+		s.updateStatus(sourceID, "error", 0, total, 0, &errStr)
+		return err
+	}
+	writer, err := newScanWriter(s.store.Scans, sourceID, seen)
+	if err != nil {
+		errStr := err.Error()
+		s.updateStatus(sourceID, "error", 0, total, 0, &errStr)
+		return err
+	}
 
 	for i, mf := range musicFiles {
 		processed := i + 1
 		path := mf.path
-		log.Printf("[Scanner] Processing (%d/%d): %s", processed, total, path)
-		
-		var reader io.ReadSeeker
-		if source.Type == types.SourceTypeSMB {
-			f, err := smbClient.Open(path)
-			if err != nil {
-				log.Printf("[Scanner] Failed to open SMB file %s: %v", path, err)
-			} else {
-				defer f.Close()
-				reader = f
-			}
-		} else {
-			f, err := sshClient.Open(path)
-			if err != nil {
-				log.Printf("[Scanner] Failed to open SSH file %s: %v", path, err)
-			} else {
-				defer f.Close()
-				reader = f
-			}
+		logging.Debugf("[Scanner] Processing (%d/%d): %s", processed, total, path)
+
+		track, err := s.scanFile(ctx, conn, source, mf, known[path])
+		if err != nil {
+			writer.discard()
+			errStr := err.Error()
+			s.updateStatus(sourceID, "error", 0, total, processed, &errStr)
+			return err
 		}
-
-		if reader != nil {
-			// Try to calculate duration for MP3
-			duration := 0.0
-			if strings.ToLower(filepath.Ext(path)) == ".mp3" {
-				d := mp3.NewDecoder(reader)
-				var f mp3.Frame
-				var skipped int
-				for {
-					if err := d.Decode(&f, &skipped); err != nil {
-						if err == io.EOF {
-							break
-						}
-						break
-					}
-					duration += f.Duration().Seconds()
-				}
-				// Reset reader for metadata extraction
-				if seeker, ok := reader.(io.Seeker); ok {
-					seeker.Seek(0, io.SeekStart)
-				}
-			}
-
-			metadata, err := tag.ReadFrom(reader)
-			if err != nil {
-				log.Printf("[Scanner] Failed to extract metadata for %s: %v", path, err)
-				upsertBasicInfo(sourceID, path, mf.mtime, duration)
-			} else {
-				upsertMetadata(sourceID, path, metadata, mf.mtime, duration)
+		if track != nil {
+			if err := writer.add(track); err != nil {
+				log.Printf("[Scanner] Database error for %s: %v", path, err)
+				writer.discard()
+				errStr := err.Error()
+				s.updateStatus(sourceID, "error", 0, total, processed, &errStr)
+				return err
 			}
 		}
 
 		if i%10 == 0 || processed == total {
 			progress := 5 + (float64(processed)/float64(total))*95
-			updateStatus(sourceID, "scanning", progress, total, processed, nil)
+			s.updateStatus(sourceID, "scanning", progress, total, processed, nil)
 		}
 	}
 
-	now := time.Now()
-	updateStatus(sourceID, "complete", 100, total, total, nil)
-	db.DB.Exec("UPDATE source_status SET last_scan = ? WHERE source_id = ?", now, sourceID)
+	if err := writer.commit(); err != nil {
+		log.Printf("[Scanner] Failed to save scan of %s: %v", source.Name, err)
+		writer.discard()
+		errStr := err.Error()
+		s.updateStatus(sourceID, "error", 0, total, total, &errStr)
+		return err
+	}
+	logging.Infof("[Scanner] Saved %d tracks from %s", writer.staged, source.Name)
+
+	s.syncSourcePlaylists(sourceID, playlistFiles, func(path string) (io.ReadCloser, error) {
+		return conn.open(path)
+	})
+
+	s.updateStatus(sourceID, "complete", 100, total, total, nil)
 
 	return nil
 }
 
-func upsertMetadata(sourceID, path string, metadata tag.Metadata, mtime time.Time, duration float64) {
+// scanFile reads one file's duration, tags and checksum, holding one of the
+// source's stream slots only while the file is open so scans queue behind
+// listeners file by file. It returns a nil track when the file can't be
+// opened, and an error only when ctx is done.
+func (s *Scanner) scanFile(ctx context.Context, conn sourceConn, source *types.Source, mf musicFile, known db.KnownFile) (*db.ScannedTrack, error) {
+	release, err := sources.Limits.AcquireWait(ctx, source.ID, source.MaxStreams, source.MaxBandwidth)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	path := mf.path
+	f, err := conn.open(path)
+	if err != nil {
+		log.Printf("[Scanner] Failed to open %s: %v", path, err)
+		return nil, nil
+	}
+	defer f.Close()
+	reader := sources.Limits.Reader(ctx, source.ID, f)
+
+	// Try to calculate duration for MP3
+	duration := 0.0
+	if strings.ToLower(filepath.Ext(path)) == ".mp3" {
+		d := mp3.NewDecoder(reader)
+		var f mp3.Frame
+		var skipped int
+		for {
+			if err := d.Decode(&f, &skipped); err != nil {
+				break
+			}
+			duration += f.Duration().Seconds()
+		}
+		// Reset reader for metadata extraction
+		reader.Seek(0, io.SeekStart)
+	}
+
+	metadata, err := tag.ReadFrom(reader)
+
+	// Hashing reads the whole file, so only new and changed files are
+	// hashed; unchanged files keep their stored hash
+	contentHash := known.ContentHash
+	if !unchanged(known, mf) {
+		var herr error
+		if contentHash, herr = audioChecksum(reader); herr != nil {
+			log.Printf("[Scanner] Failed to checksum %s: %v", path, herr)
+		}
+	}
+
+	if err != nil {
+		log.Printf("[Scanner] Failed to extract metadata for %s: %v", path, err)
+		return basicTrack(path, mf.mtime, duration, contentHash), nil
+	}
+	return metadataTrack(path, metadata, mf.mtime, duration, contentHash), nil
+}
+
+func metadataTrack(path string, metadata tag.Metadata, mtime time.Time, duration float64, contentHash string) *db.ScannedTrack {
 	artistTag := metadata.Artist()
 	if artistTag == "" {
 		artistTag = "Unknown Artist"
@@ -224,7 +296,7 @@ func upsertMetadata(sourceID, path string, metadata tag.Metadata, mtime time.Tim
 	if p := metadata.Picture(); p != nil {
 		hash := md5.Sum([]byte(artistName + albumName))
 		filename := fmt.Sprintf("%x.%s", hash, p.Ext)
-		savePath := filepath.Join("public", "art", filename)
+		savePath := filepath.Join(ArtDir, filename)
 		
 		if _, err := os.Stat(savePath); os.IsNotExist(err) {
 			os.MkdirAll(filepath.Dir(savePath), 0755)
@@ -236,71 +308,60 @@ func upsertMetadata(sourceID, path string, metadata tag.Metadata, mtime time.Tim
 		}
 	}
 
-	artistID := uuid.New().String()
-	_, err := db.DB.Exec("INSERT OR IGNORE INTO artists (id, name) VALUES (?, ?)", artistID, artistName)
-	if err == nil {
-		db.DB.QueryRow("SELECT id FROM artists WHERE name = ?", artistName).Scan(&artistID)
-	}
-
-	albumID := uuid.New().String()
-	_, err = db.DB.Exec("INSERT OR IGNORE INTO albums (id, name, artist_id, image_url) VALUES (?, ?, ?, ?)", albumID, albumName, artistID, artworkURL)
-	if err != nil {
-		if artworkURL != "" {
-			db.DB.Exec("UPDATE albums SET image_url = ? WHERE name = ? AND artist_id = ? AND (image_url IS NULL OR image_url = '')", artworkURL, albumName, artistID)
-		}
-		db.DB.QueryRow("SELECT id FROM albums WHERE name = ? AND artist_id = ?", albumName, artistID).Scan(&albumID)
-	}
-
-	trackID := uuid.New().String()
 	trackNum, _ := metadata.Track()
 	year := metadata.Year()
 
-	_, err = db.DB.Exec(`INSERT OR REPLACE INTO tracks 
-		(id, title, artist, album, duration, track_number, year, path, folder_path, image_url, source_mtime, artists_display, source_id, album_id, artist_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		trackID, title, artistName, albumName, duration, trackNum, year, path, folderPath, artworkURL, mtime, displayArtist, sourceID, albumID, artistID)
-	
-	if err != nil {
-		log.Printf("[Scanner] Database error for %s: %v", path, err)
+	return &db.ScannedTrack{
+		Path:           path,
+		FolderPath:     folderPath,
+		Mtime:          mtime,
+		Title:          title,
+		Artist:         artistName,
+		Album:          albumName,
+		ArtistsDisplay: displayArtist,
+		TrackNumber:    &trackNum,
+		Year:           &year,
+		Duration:       duration,
+		ImageURL:       &artworkURL,
+		ContentHash:    contentHash,
+		Tagged:         metadata.Title() != "",
 	}
 }
 
-func upsertBasicInfo(sourceID, path string, mtime time.Time, duration float64) {
+func basicTrack(path string, mtime time.Time, duration float64, contentHash string) *db.ScannedTrack {
 	artistName := "Unknown Artist"
 	albumName := "Unknown Album"
 	title := filepath.Base(path)
 	folderPath := filepath.Dir(path)
 
-	artistID := uuid.New().String()
-	_, _ = db.DB.Exec("INSERT OR IGNORE INTO artists (id, name) VALUES (?, ?)", artistID, artistName)
-	db.DB.QueryRow("SELECT id FROM artists WHERE name = ?", artistName).Scan(&artistID)
-
-	albumID := uuid.New().String()
-	_, _ = db.DB.Exec("INSERT OR IGNORE INTO albums (id, name, artist_id) VALUES (?, ?, ?)", albumID, albumName, artistID)
-	db.DB.QueryRow("SELECT id FROM albums WHERE name = ? AND artist_id = ?", albumName, artistID).Scan(&albumID)
-
-	trackID := uuid.New().String()
-	_, err := db.DB.Exec(`INSERT OR REPLACE INTO tracks 
-		(id, title, artist, album, duration, path, folder_path, source_mtime, artists_display, source_id, album_id, artist_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		trackID, title, artistName, albumName, duration, path, folderPath, mtime, artistName, sourceID, albumID, artistID)
-	
-	if err != nil {
-		log.Printf("[Scanner] Database error (basic) for %s: %v", path, err)
+	return &db.ScannedTrack{
+		Path:           path,
+		FolderPath:     folderPath,
+		Mtime:          mtime,
+		Title:          title,
+		Artist:         artistName,
+		Album:          albumName,
+		ArtistsDisplay: artistName,
+		Duration:       duration,
+		ContentHash:    contentHash,
 	}
 }
 
-func updateStatus(sourceID string, status string, progress float64, total, scanned int, lastErr *string) {
-	_, err := db.DB.Exec(`UPDATE source_status SET 
-		status = ?, progress = ?, total_files = ?, scanned_files = ?, last_error = ?
-		WHERE source_id = ?`,
-		status, progress, total, scanned, lastErr, sourceID)
+func (s *Scanner) updateStatus(sourceID string, status string, progress float64, total, scanned int, lastErr *string) {
+	err := s.store.Status.UpdateSourceStatus(types.SourceStatus{
+		SourceID:     sourceID,
+		Status:       status,
+		Progress:     progress,
+		TotalFiles:   total,
+		ScannedFiles: scanned,
+		LastError:    lastErr,
+	})
 	if err != nil {
 		log.Printf("[Scanner] Failed to update status: %v", err)
 	}
 }
 
-func walkSMB(client *sources.SMBClient, path string, files *[]musicFile) error {
+func walkSMB(client *sources.SMBClient, path string, files *[]musicFile, playlists *[]musicFile) error {
 	// Clean the path for go-smb2: remove leading slashes and use backslashes internally if needed
 	// But go-smb2 usually likes '.' for root and 'Folder/Subfolder' for children
 	smbPath := strings.TrimPrefix(path, "/")
@@ -309,7 +370,7 @@ func walkSMB(client *sources.SMBClient, path string, files *[]musicFile) error {
 		smbPath = "."
 	}
 
-	log.Printf("[Scanner] Walking SMB path: %s (internal: %s)", path, smbPath)
+	logging.Debugf("[Scanner] Walking SMB path: %s (internal: %s)", path, smbPath)
 	entries, err := client.ReadDir(smbPath)
 	if err != nil {
 		log.Printf("[Scanner] Error reading SMB dir %s: %v", smbPath, err)
@@ -331,34 +392,39 @@ func walkSMB(client *sources.SMBClient, path string, files *[]musicFile) error {
 		}
 
 		if entry.IsDir() {
-			if err := walkSMB(client, nextPath, files); err != nil {
+			if err := walkSMB(client, nextPath, files, playlists); err != nil {
 				return err
 			}
 		} else if isMusicFile(name) {
-			log.Printf("[Scanner] Found music file: %s", nextPath)
+			logging.Debugf("[Scanner] Found music file: %s", nextPath)
 			*files = append(*files, musicFile{
 				path:  nextPath,
 				mtime: entry.ModTime(),
 			})
+		} else if isPlaylistFile(name) {
+			logging.Debugf("[Scanner] Found playlist file: %s", nextPath)
+			*playlists = append(*playlists, musicFile{
+				path:  nextPath,
+				mtime: entry.ModTime(),
+			})
 		}
 	}
 
 	return nil
 }
 
-func ScanAllSources() error {
-	sources, err := db.GetAllSources()
+func (s *Scanner) ScanAllSources(ctx context.Context) error {
+	sources, err := s.store.Sources.GetAllSources()
 	if err != nil {
 		return err
 	}
 
-	for _, s := range sources {
-		enabled, ok := s["enabled"].(bool)
-		if ok && enabled {
-			id := s["id"].(string)
+	for _, src := range sources {
+		if src.Enabled {
+			id := src.ID
 			// Run each scan in its own goroutine
 			go func(sourceID string) {
-				if err := ScanSource(sourceID); err != nil {
+				if err := s.ScanSource(ctx, sourceID); err != nil {
 					log.Printf("[Scanner] Background scan failed for %s: %v", sourceID, err)
 				}
 			}(id)
@@ -367,7 +433,7 @@ func ScanAllSources() error {
 	return nil
 }
 
-func walkSSH(client *sources.SSHClient, path string, files *[]musicFile) error {
+func walkSSH(client *sources.SSHClient, path string, files *[]musicFile, playlists *[]musicFile) error {
 	entries, err := client.ReadDir(path)
 	if err != nil {
 		log.Printf("[Scanner] Error reading SSH dir %s: %v", path, err)
@@ -382,7 +448,7 @@ func walkSSH(client *sources.SSHClient, path string, files *[]musicFile) error {
 
 		fullPath := filepath.Join(path, name)
 		if entry.IsDir() {
-			if err := walkSSH(client, fullPath, files); err != nil {
+			if err := walkSSH(client, fullPath, files, playlists); err != nil {
 				return err
 			}
 		} else if isMusicFile(name) {
@@ -390,6 +456,11 @@ func walkSSH(client *sources.SSHClient, path string, files *[]musicFile) error {
 				path:  fullPath,
 				mtime: entry.ModTime(),
 			})
+		} else if isPlaylistFile(name) {
+			*playlists = append(*playlists, musicFile{
+				path:  fullPath,
+				mtime: entry.ModTime(),
+			})
 		}
 	}
 
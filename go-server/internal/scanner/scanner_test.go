@@ -0,0 +1,193 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"homemusic-server/internal/db/memstore"
+	"homemusic-server/internal/sources"
+	"homemusic-server/internal/types"
+)
+
+// fakeConn serves files from memory and counts how many are open at once.
+type fakeConn struct {
+	files map[string][]byte
+	mtime time.Time
+
+	opened, maxOpened int
+}
+
+func (c *fakeConn) walk() (music, playlists []musicFile, err error) {
+	for p := range c.files {
+		if isMusicFile(p) {
+			music = append(music, musicFile{path: p, mtime: c.mtime})
+		} else if isPlaylistFile(p) {
+			playlists = append(playlists, musicFile{path: p, mtime: c.mtime})
+		}
+	}
+	sort.Slice(music, func(i, j int) bool { return music[i].path < music[j].path })
+	return music, playlists, nil
+}
+
+func (c *fakeConn) open(p string) (io.ReadSeekCloser, error) {
+	c.opened++
+	c.maxOpened = max(c.maxOpened, c.opened)
+	return fakeFile{bytes.NewReader(c.files[p]), c}, nil
+}
+
+func (c *fakeConn) Close() {}
+
+type fakeFile struct {
+	io.ReadSeeker
+	conn *fakeConn
+}
+
+func (f fakeFile) Close() error {
+	f.conn.opened--
+	return nil
+}
+
+// taggedFile is an ID3v2.3 tag followed by audio that differs per seed.
+func taggedFile(title, artist, album, seed string) []byte {
+	var frames bytes.Buffer
+	for _, f := range [][2]string{{"TIT2", title}, {"TPE1", artist}, {"TALB", album}} {
+		size := len(f[1]) + 1
+		frames.WriteString(f[0])
+		frames.Write([]byte{byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size), 0, 0, 0})
+		frames.WriteString(f[1])
+	}
+	n := frames.Len()
+	file := []byte{'I', 'D', '3', 3, 0, 0, byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+	file = append(file, frames.Bytes()...)
+	return append(file, bytes.Repeat([]byte(seed), 256)...)
+}
+
+func TestScanSource(t *testing.T) {
+	mem := memstore.New()
+	mem.CreateSource(types.Source{ID: "src", Name: "Box", Type: types.SourceTypeSSH, Enabled: true})
+	conn := &fakeConn{
+		files: map[string][]byte{
+			"/music/a.mp3":    taggedFile("Alpha", "Band", "First", "a"),
+			"/music/b.mp3":    taggedFile("Beta", "Band", "First", "b"),
+			"/music/list.m3u": []byte("b.mp3\na.mp3\nmissing.mp3\n"),
+		},
+		mtime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	s := New(mem.Stores())
+	s.connect = func(*types.Source) (sourceConn, error) { return conn, nil }
+
+	if err := s.ScanSource(context.Background(), "src"); err != nil {
+		t.Fatal(err)
+	}
+	tracks, _ := mem.GetTracksBySource("src")
+	ids := map[string]string{}
+	for _, tr := range tracks {
+		ids[tr.Title] = tr.ID
+		if tr.AlbumID == nil || tr.ArtistID == nil || tr.Album != "First" || tr.Artist != "Band" {
+			t.Errorf("track %+v was not filed under Band - First", tr)
+		}
+	}
+	if len(tracks) != 2 || ids["Alpha"] == "" || ids["Beta"] == "" {
+		t.Fatalf("tracks = %+v", tracks)
+	}
+	if conn.opened != 0 || conn.maxOpened != 1 {
+		t.Errorf("%d files left open, up to %d at once; want each closed before the next", conn.opened, conn.maxOpened)
+	}
+	if albums, _ := mem.GetAllAlbums(); len(albums) != 1 || albums[0].TrackCount != 2 {
+		t.Errorf("albums = %+v", albums)
+	}
+	status, _ := mem.GetSourceStatus("src")
+	if status.Status != "complete" || status.LastScan == nil {
+		t.Errorf("status = %+v", status)
+	}
+	playlists, _ := mem.GetAllPlaylists(&types.User{ID: "u"})
+	if len(playlists) != 1 || playlists[0].Name != "list" || playlists[0].TrackCount != 2 {
+		t.Errorf("playlists = %+v", playlists)
+	}
+
+	// A moved file keeps its track, as does one that was retagged
+	conn.files["/music/old/a.mp3"] = conn.files["/music/a.mp3"]
+	delete(conn.files, "/music/a.mp3")
+	conn.files["/music/b.mp3"] = taggedFile("Beta (Remastered)", "Band", "First", "b")
+	if err := s.ScanSource(context.Background(), "src"); err != nil {
+		t.Fatal(err)
+	}
+	tracks, _ = mem.GetTracksBySource("src")
+	if len(tracks) != 2 {
+		t.Fatalf("tracks after rescan = %+v", tracks)
+	}
+	for _, tr := range tracks {
+		switch tr.Title {
+		case "Alpha":
+			if tr.ID != ids["Alpha"] || tr.Path != "/music/old/a.mp3" || path.Dir(tr.Path) != *tr.FolderPath {
+				t.Errorf("moved track = %+v, want ID %s at /music/old/a.mp3", tr, ids["Alpha"])
+			}
+		case "Beta (Remastered)":
+			if tr.ID != ids["Beta"] {
+				t.Errorf("retagged track ID = %s, want %s", tr.ID, ids["Beta"])
+			}
+		default:
+			t.Errorf("unexpected track %+v", tr)
+		}
+	}
+
+	// A deleted file's track goes, and with it its place in the playlist
+	delete(conn.files, "/music/b.mp3")
+	if err := s.ScanSource(context.Background(), "src"); err != nil {
+		t.Fatal(err)
+	}
+	if tracks, _ = mem.GetTracksBySource("src"); len(tracks) != 1 || tracks[0].ID != ids["Alpha"] {
+		t.Errorf("tracks after delete = %+v", tracks)
+	}
+	if playlists, _ = mem.GetAllPlaylists(&types.User{ID: "u"}); len(playlists) != 1 || playlists[0].TrackCount != 1 {
+		t.Errorf("playlists after delete = %+v", playlists)
+	}
+}
+
+func TestScanSourceConnectError(t *testing.T) {
+	mem := memstore.New()
+	mem.CreateSource(types.Source{ID: "src", Name: "Box", Type: types.SourceTypeSSH, Enabled: true})
+	s := New(mem.Stores())
+	s.connect = func(*types.Source) (sourceConn, error) { return nil, io.ErrUnexpectedEOF }
+
+	if err := s.ScanSource(context.Background(), "src"); err != io.ErrUnexpectedEOF {
+		t.Fatalf("err = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	status, _ := mem.GetSourceStatus("src")
+	if status.Status != "error" || status.LastError == nil || status.LastScan != nil {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestScanSourceCancelled(t *testing.T) {
+	mem := memstore.New()
+	mem.CreateSource(types.Source{ID: "busy", Name: "Box", Type: types.SourceTypeSSH, Enabled: true, MaxStreams: 1})
+	conn := &fakeConn{files: map[string][]byte{"/music/a.mp3": taggedFile("Alpha", "Band", "First", "a")}}
+	s := New(mem.Stores())
+	s.connect = func(*types.Source) (sourceConn, error) { return conn, nil }
+
+	// A listener holds the only stream slot, so the scan waits for it
+	release, err := sources.Limits.Acquire("busy", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	if err := s.ScanSource(ctx, "busy"); err != context.Canceled {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+	if tracks, _ := mem.GetTracksBySource("busy"); len(tracks) != 0 {
+		t.Errorf("tracks = %+v", tracks)
+	}
+	status, _ := mem.GetSourceStatus("busy")
+	if status.Status != "error" || status.LastScan != nil {
+		t.Errorf("status = %+v", status)
+	}
+}
@@ -0,0 +1,202 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"homemusic-server/internal/types"
+)
+
+// ErrSourceSaturated is returned when a source already has its maximum
+// number of open streams.
+var ErrSourceSaturated = errors.New("source has reached its concurrent stream limit")
+
+// maxThrottledChunk bounds a single throttled read so bandwidth is spread
+// evenly instead of arriving in large bursts.
+const maxThrottledChunk = 32 * 1024
+
+type sourceLimiter struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	active       int
+	maxStreams   int
+	maxBandwidth int64 // bytes per second, 0 means unlimited
+
+	tokens     float64
+	lastRefill time.Time
+
+	bytesRead int64
+	rejected  int64
+}
+
+// LimitManager tracks open streams and read bandwidth for every source.
+type LimitManager struct {
+	mu       sync.Mutex
+	limiters map[string]*sourceLimiter
+}
+
+var Limits = &LimitManager{
+	limiters: map[string]*sourceLimiter{},
+}
+
+func (m *LimitManager) get(sourceID string) *sourceLimiter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.limiters[sourceID]
+	if !ok {
+		l = &sourceLimiter{lastRefill: time.Now()}
+		l.cond = sync.NewCond(&l.mu)
+		m.limiters[sourceID] = l
+	}
+	return l
+}
+
+func (l *sourceLimiter) configure(maxStreams int, maxBandwidth int64) {
+	l.maxStreams = maxStreams
+	if l.maxBandwidth != maxBandwidth {
+		l.maxBandwidth = maxBandwidth
+		l.tokens = float64(maxBandwidth)
+		l.lastRefill = time.Now()
+	}
+}
+
+func (l *sourceLimiter) full() bool {
+	return l.maxStreams > 0 && l.active >= l.maxStreams
+}
+
+func (l *sourceLimiter) release() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// Acquire reserves a stream slot on the source, applying the given limits.
+// It fails with ErrSourceSaturated instead of waiting when no slot is free.
+func (m *LimitManager) Acquire(sourceID string, maxStreams int, maxBandwidth int64) (func(), error) {
+	l := m.get(sourceID)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.configure(maxStreams, maxBandwidth)
+	if l.full() {
+		l.rejected++
+		return nil, ErrSourceSaturated
+	}
+	l.active++
+	return sync.OnceFunc(l.release), nil
+}
+
+// AcquireWait is like Acquire but blocks until a slot is free. It is meant
+// for background work such as scans that should queue behind listeners.
+// It gives up with ctx's error once ctx is done.
+func (m *LimitManager) AcquireWait(ctx context.Context, sourceID string, maxStreams int, maxBandwidth int64) (func(), error) {
+	l := m.get(sourceID)
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.cond.Broadcast()
+	})
+	defer stop()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.configure(maxStreams, maxBandwidth)
+	for l.full() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		l.cond.Wait()
+	}
+	l.active++
+	return sync.OnceFunc(l.release), nil
+}
+
+// Reader wraps r so reads count against the source's bandwidth limit.
+// A read waiting for bandwidth returns early once ctx is done.
+func (m *LimitManager) Reader(ctx context.Context, sourceID string, r io.ReadSeeker) io.ReadSeeker {
+	return &throttledReader{ctx: ctx, r: r, l: m.get(sourceID)}
+}
+
+func (m *LimitManager) Stats(sourceID string) types.SourceStats {
+	l := m.get(sourceID)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return types.SourceStats{
+		ActiveStreams: l.active,
+		MaxStreams:    l.maxStreams,
+		MaxBandwidth:  l.maxBandwidth,
+		BytesRead:     l.bytesRead,
+		Rejected:      l.rejected,
+	}
+}
+
+// reserve takes n bytes from the token bucket and returns how long the
+// caller must wait before the bytes are within the bandwidth budget.
+func (l *sourceLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bytesRead += int64(n)
+	if l.maxBandwidth <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	rate := float64(l.maxBandwidth)
+	l.tokens += now.Sub(l.lastRefill).Seconds() * rate
+	if l.tokens > rate {
+		l.tokens = rate
+	}
+	l.lastRefill = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / rate * float64(time.Second))
+}
+
+func (l *sourceLimiter) chunkSize(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxBandwidth <= 0 {
+		return n
+	}
+	limit := maxThrottledChunk
+	if quarter := int(l.maxBandwidth / 4); quarter > 0 && quarter < limit {
+		limit = quarter
+	}
+	if n > limit {
+		return limit
+	}
+	return n
+}
+
+type throttledReader struct {
+	ctx context.Context
+	r   io.ReadSeeker
+	l   *sourceLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	p = p[:t.l.chunkSize(len(p))]
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if d := t.l.reserve(n); d > 0 {
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-t.ctx.Done():
+				return n, t.ctx.Err()
+			}
+		}
+	}
+	return n, err
+}
+
+func (t *throttledReader) Seek(offset int64, whence int) (int64, error) {
+	return t.r.Seek(offset, whence)
+}
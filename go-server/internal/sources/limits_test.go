@@ -0,0 +1,58 @@
+package sources
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestThrottledReadStopsWhenCancelled(t *testing.T) {
+	m := &LimitManager{limiters: map[string]*sourceLimiter{}}
+	release, err := m.Acquire("slow", 0, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := m.Reader(ctx, "slow", bytes.NewReader(make([]byte, 64*1024)))
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = io.Copy(io.Discard, r)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	// At 1 KiB/s the whole 64 KiB would take about a minute
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("read returned after %s", elapsed)
+	}
+}
+
+func TestAcquireWaitStopsWhenCancelled(t *testing.T) {
+	m := &LimitManager{limiters: map[string]*sourceLimiter{}}
+	release, err := m.Acquire("busy", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := m.AcquireWait(ctx, "busy", 1, 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if got := m.Stats("busy").ActiveStreams; got != 1 {
+		t.Errorf("active streams = %d, want 1", got)
+	}
+
+	// A waiter still gets the slot once it is released
+	time.AfterFunc(50*time.Millisecond, release)
+	next, err := m.AcquireWait(context.Background(), "busy", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next()
+}
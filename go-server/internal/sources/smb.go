@@ -2,13 +2,13 @@ package sources
 
 import (
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/hirochachacha/go-smb2"
+	"homemusic-server/internal/logging"
 )
 
 type SMBConfig struct {
@@ -111,7 +111,7 @@ func EnumerateShares(host, username, password, domain string) ([]string, error)
 		},
 	}
 
-	log.Printf("[SMB] Dialing %s with user=%s, domain=%s", addr, username, domain)
+	logging.Debugf("[SMB] Dialing %s with user=%s, domain=%s", addr, username, domain)
 	
 	s, err := d.Dial(conn)
 	if err != nil {
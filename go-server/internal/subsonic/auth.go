@@ -0,0 +1,32 @@
+package subsonic
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// DecodePassword undoes the optional "enc:" hex encoding legacy clients
+// apply to the p parameter.
+func DecodePassword(p string) string {
+	if enc, ok := strings.CutPrefix(p, "enc:"); ok {
+		if b, err := hex.DecodeString(enc); err == nil {
+			return string(b)
+		}
+	}
+	return p
+}
+
+// CheckToken verifies the t parameter, which clients compute as
+// md5(password + salt).
+func CheckToken(password, salt, token string) bool {
+	sum := md5.Sum([]byte(password + salt))
+	expected := hex.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(token))) == 1
+}
+
+// CheckPassword compares a plain-text password in constant time.
+func CheckPassword(password, given string) bool {
+	return subtle.ConstantTimeCompare([]byte(password), []byte(given)) == 1
+}
@@ -0,0 +1,272 @@
+// Package subsonic holds the wire types of the Subsonic REST API. Every
+// type marshals to both the XML and the JSON form of the protocol.
+package subsonic
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+const (
+	// APIVersion is the Subsonic API version we implement
+	APIVersion = "1.16.1"
+	ServerType = "homemusic"
+	xmlns      = "http://subsonic.org/restapi"
+)
+
+// Error codes defined by the Subsonic API.
+const (
+	ErrGeneric             = 0
+	ErrMissingParameter    = 10
+	ErrClientTooOld        = 20
+	ErrWrongCredentials    = 40
+	ErrTokenAuthNotAllowed = 41
+	ErrAuthNotSupported    = 42
+	ErrConflictingAuth     = 43
+	ErrInvalidAPIKey       = 44
+	ErrNotAuthorized       = 50
+	ErrNotFound            = 70
+)
+
+type Response struct {
+	XMLName       xml.Name `xml:"subsonic-response" json:"-"`
+	Xmlns         string   `xml:"xmlns,attr" json:"-"`
+	Status        string   `xml:"status,attr" json:"status"`
+	Version       string   `xml:"version,attr" json:"version"`
+	Type          string   `xml:"type,attr" json:"type"`
+	ServerVersion string   `xml:"serverVersion,attr" json:"serverVersion"`
+	OpenSubsonic  bool     `xml:"openSubsonic,attr" json:"openSubsonic"`
+
+	Error                  *Error             `xml:"error,omitempty" json:"error,omitempty"`
+	License                *License           `xml:"license,omitempty" json:"license,omitempty"`
+	OpenSubsonicExtensions []Extension        `xml:"openSubsonicExtensions,omitempty" json:"openSubsonicExtensions,omitempty"`
+	User                   *User              `xml:"user,omitempty" json:"user,omitempty"`
+	MusicFolders           *MusicFolders      `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Indexes                *Indexes           `xml:"indexes,omitempty" json:"indexes,omitempty"`
+	Directory              *Directory         `xml:"directory,omitempty" json:"directory,omitempty"`
+	Artists                *Artists           `xml:"artists,omitempty" json:"artists,omitempty"`
+	Artist                 *ArtistWithAlbums  `xml:"artist,omitempty" json:"artist,omitempty"`
+	Album                  *AlbumWithSongs    `xml:"album,omitempty" json:"album,omitempty"`
+	Song                   *Child             `xml:"song,omitempty" json:"song,omitempty"`
+	AlbumList              *AlbumList         `xml:"albumList,omitempty" json:"albumList,omitempty"`
+	AlbumList2             *AlbumList2        `xml:"albumList2,omitempty" json:"albumList2,omitempty"`
+	RandomSongs            *Songs             `xml:"randomSongs,omitempty" json:"randomSongs,omitempty"`
+	SearchResult2          *SearchResult2     `xml:"searchResult2,omitempty" json:"searchResult2,omitempty"`
+	SearchResult3          *SearchResult3     `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+	Playlists              *Playlists         `xml:"playlists,omitempty" json:"playlists,omitempty"`
+	Playlist               *PlaylistWithSongs `xml:"playlist,omitempty" json:"playlist,omitempty"`
+	Starred                *Starred           `xml:"starred,omitempty" json:"starred,omitempty"`
+	Starred2               *Starred2          `xml:"starred2,omitempty" json:"starred2,omitempty"`
+}
+
+// NewResponse returns an empty successful response.
+func NewResponse() *Response {
+	return &Response{
+		Xmlns:         xmlns,
+		Status:        "ok",
+		Version:       APIVersion,
+		Type:          ServerType,
+		ServerVersion: APIVersion,
+		OpenSubsonic:  true,
+	}
+}
+
+// NewError returns a failed response carrying a Subsonic error code.
+func NewError(code int, message string) *Response {
+	r := NewResponse()
+	r.Status = "failed"
+	r.Error = &Error{Code: code, Message: message}
+	return r
+}
+
+type Error struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+type License struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+type Extension struct {
+	Name     string `xml:"name,attr" json:"name"`
+	Versions []int  `xml:"versions" json:"versions"`
+}
+
+type User struct {
+	Username          string `xml:"username,attr" json:"username"`
+	ScrobblingEnabled bool   `xml:"scrobblingEnabled,attr" json:"scrobblingEnabled"`
+	AdminRole         bool   `xml:"adminRole,attr" json:"adminRole"`
+	SettingsRole      bool   `xml:"settingsRole,attr" json:"settingsRole"`
+	DownloadRole      bool   `xml:"downloadRole,attr" json:"downloadRole"`
+	UploadRole        bool   `xml:"uploadRole,attr" json:"uploadRole"`
+	PlaylistRole      bool   `xml:"playlistRole,attr" json:"playlistRole"`
+	CoverArtRole      bool   `xml:"coverArtRole,attr" json:"coverArtRole"`
+	CommentRole       bool   `xml:"commentRole,attr" json:"commentRole"`
+	PodcastRole       bool   `xml:"podcastRole,attr" json:"podcastRole"`
+	StreamRole        bool   `xml:"streamRole,attr" json:"streamRole"`
+	JukeboxRole       bool   `xml:"jukeboxRole,attr" json:"jukeboxRole"`
+	ShareRole         bool   `xml:"shareRole,attr" json:"shareRole"`
+	Folders           []int  `xml:"folder" json:"folder"`
+}
+
+type MusicFolders struct {
+	Folders []MusicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+type MusicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+// Indexes is the folder-based artist index returned by getIndexes.
+type Indexes struct {
+	LastModified    int64   `xml:"lastModified,attr" json:"lastModified"`
+	IgnoredArticles string  `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []Index `xml:"index" json:"index"`
+}
+
+type Index struct {
+	Name    string        `xml:"name,attr" json:"name"`
+	Artists []IndexArtist `xml:"artist" json:"artist"`
+}
+
+type IndexArtist struct {
+	ID      string     `xml:"id,attr" json:"id"`
+	Name    string     `xml:"name,attr" json:"name"`
+	Starred *time.Time `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+}
+
+// Artists is the tag-based artist index returned by getArtists.
+type Artists struct {
+	IgnoredArticles string       `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []ArtistsIdx `xml:"index" json:"index"`
+}
+
+type ArtistsIdx struct {
+	Name    string   `xml:"name,attr" json:"name"`
+	Artists []Artist `xml:"artist" json:"artist"`
+}
+
+type Artist struct {
+	ID         string     `xml:"id,attr" json:"id"`
+	Name       string     `xml:"name,attr" json:"name"`
+	CoverArt   string     `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	AlbumCount int        `xml:"albumCount,attr" json:"albumCount"`
+	Starred    *time.Time `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+}
+
+type ArtistWithAlbums struct {
+	Artist
+	Albums []Album `xml:"album" json:"album"`
+}
+
+type Album struct {
+	ID        string     `xml:"id,attr" json:"id"`
+	Name      string     `xml:"name,attr" json:"name"`
+	Artist    string     `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	ArtistID  string     `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	CoverArt  string     `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	SongCount int        `xml:"songCount,attr" json:"songCount"`
+	Duration  int        `xml:"duration,attr" json:"duration"`
+	PlayCount int64      `xml:"playCount,attr,omitempty" json:"playCount,omitempty"`
+	Created   time.Time  `xml:"created,attr" json:"created"`
+	Year      int        `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Starred   *time.Time `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+}
+
+type AlbumWithSongs struct {
+	Album
+	Songs []Child `xml:"song" json:"song"`
+}
+
+// Child is a song or, in the folder-based API, a directory.
+type Child struct {
+	ID          string     `xml:"id,attr" json:"id"`
+	Parent      string     `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir       bool       `xml:"isDir,attr" json:"isDir"`
+	Title       string     `xml:"title,attr" json:"title"`
+	Album       string     `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Artist      string     `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Track       int        `xml:"track,attr,omitempty" json:"track,omitempty"`
+	Year        int        `xml:"year,attr,omitempty" json:"year,omitempty"`
+	CoverArt    string     `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	ContentType string     `xml:"contentType,attr,omitempty" json:"contentType,omitempty"`
+	Suffix      string     `xml:"suffix,attr,omitempty" json:"suffix,omitempty"`
+	Duration    int        `xml:"duration,attr,omitempty" json:"duration,omitempty"`
+	Path        string     `xml:"path,attr,omitempty" json:"path,omitempty"`
+	PlayCount   int64      `xml:"playCount,attr,omitempty" json:"playCount,omitempty"`
+	Played      *time.Time `xml:"played,attr,omitempty" json:"played,omitempty"`
+	Created     *time.Time `xml:"created,attr,omitempty" json:"created,omitempty"`
+	Starred     *time.Time `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+	AlbumID     string     `xml:"albumId,attr,omitempty" json:"albumId,omitempty"`
+	ArtistID    string     `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	Type        string     `xml:"type,attr,omitempty" json:"type,omitempty"`
+}
+
+type Directory struct {
+	ID       string     `xml:"id,attr" json:"id"`
+	Parent   string     `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	Name     string     `xml:"name,attr" json:"name"`
+	Starred  *time.Time `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+	Children []Child    `xml:"child" json:"child"`
+}
+
+type AlbumList struct {
+	Albums []Child `xml:"album" json:"album"`
+}
+
+type AlbumList2 struct {
+	Albums []Album `xml:"album" json:"album"`
+}
+
+type Songs struct {
+	Songs []Child `xml:"song" json:"song"`
+}
+
+type SearchResult2 struct {
+	Artists []IndexArtist `xml:"artist" json:"artist"`
+	Albums  []Child       `xml:"album" json:"album"`
+	Songs   []Child       `xml:"song" json:"song"`
+}
+
+type SearchResult3 struct {
+	Artists []Artist `xml:"artist" json:"artist"`
+	Albums  []Album  `xml:"album" json:"album"`
+	Songs   []Child  `xml:"song" json:"song"`
+}
+
+type Playlists struct {
+	Playlists []Playlist `xml:"playlist" json:"playlist"`
+}
+
+type Playlist struct {
+	ID        string    `xml:"id,attr" json:"id"`
+	Name      string    `xml:"name,attr" json:"name"`
+	Comment   string    `xml:"comment,attr,omitempty" json:"comment,omitempty"`
+	Owner     string    `xml:"owner,attr,omitempty" json:"owner,omitempty"`
+	Public    bool      `xml:"public,attr" json:"public"`
+	SongCount int       `xml:"songCount,attr" json:"songCount"`
+	Duration  int       `xml:"duration,attr" json:"duration"`
+	Created   time.Time `xml:"created,attr" json:"created"`
+	Changed   time.Time `xml:"changed,attr" json:"changed"`
+	CoverArt  string    `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	Readonly  bool      `xml:"readonly,attr,omitempty" json:"readonly,omitempty"`
+}
+
+type PlaylistWithSongs struct {
+	Playlist
+	Entries []Child `xml:"entry" json:"entry"`
+}
+
+type Starred struct {
+	Artists []IndexArtist `xml:"artist" json:"artist"`
+	Albums  []Child       `xml:"album" json:"album"`
+	Songs   []Child       `xml:"song" json:"song"`
+}
+
+type Starred2 struct {
+	Artists []Artist `xml:"artist" json:"artist"`
+	Albums  []Album  `xml:"album" json:"album"`
+	Songs   []Child  `xml:"song" json:"song"`
+}
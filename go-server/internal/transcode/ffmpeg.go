@@ -0,0 +1,37 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// FFmpegPath is the ffmpeg binary used for all transcoding. It is resolved
+// through PATH unless it contains a separator.
+var FFmpegPath = "ffmpeg"
+
+// Available reports whether the ffmpeg binary can be found.
+func Available() bool {
+	_, err := exec.LookPath(FFmpegPath)
+	return err == nil
+}
+
+func runFFmpeg(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, FFmpegPath, append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return ffmpegError(err, stderr.String())
+	}
+	return nil
+}
+
+func ffmpegError(err error, stderr string) error {
+	msg := strings.TrimSpace(stderr)
+	if msg == "" {
+		return fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	return fmt.Errorf("ffmpeg failed: %w: %s", err, msg)
+}
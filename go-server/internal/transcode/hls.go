@@ -0,0 +1,62 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// HLSSegmentSeconds is the target duration of each HLS segment.
+const HLSSegmentSeconds = 6
+
+type HLSVariant struct {
+	Name    string
+	Bitrate int // bits per second
+}
+
+// HLSVariants are the renditions offered in every master playlist, lowest first.
+var HLSVariants = []HLSVariant{
+	{Name: "low", Bitrate: 64000},
+	{Name: "medium", Bitrate: 128000},
+	{Name: "high", Bitrate: 256000},
+}
+
+func FindHLSVariant(name string) (HLSVariant, bool) {
+	for _, v := range HLSVariants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return HLSVariant{}, false
+}
+
+// MasterPlaylist renders an HLS master playlist whose variant URIs are
+// relative to the master playlist's own URL.
+func MasterPlaylist() string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, v := range HLSVariants {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=\"mp4a.40.2\"\n", v.Bitrate)
+		fmt.Fprintf(&b, "%s/index.m3u8\n", v.Name)
+	}
+	return b.String()
+}
+
+// GenerateHLS transcodes srcPath into AAC MPEG-TS segments plus an
+// index.m3u8 playlist inside outDir.
+func GenerateHLS(ctx context.Context, srcPath, outDir string, v HLSVariant) error {
+	return runFFmpeg(ctx,
+		"-i", srcPath,
+		"-map", "0:a:0",
+		"-vn",
+		"-c:a", "aac",
+		"-b:a", strconv.Itoa(v.Bitrate),
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(HLSSegmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(outDir, "seg_%04d.ts"),
+		filepath.Join(outDir, "index.m3u8"),
+	)
+}
@@ -0,0 +1,60 @@
+package transcode
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Profile describes a target encoding for on-the-fly transcoding.
+type Profile struct {
+	Name        string
+	Codec       string // ffmpeg audio encoder
+	Format      string // ffmpeg muxer, must support non-seekable output
+	Bitrate     int    // bits per second
+	Ext         string
+	ContentType string
+}
+
+var Profiles = []Profile{
+	{Name: "mp3-320", Codec: "libmp3lame", Format: "mp3", Bitrate: 320000, Ext: ".mp3", ContentType: "audio/mpeg"},
+	{Name: "mp3-192", Codec: "libmp3lame", Format: "mp3", Bitrate: 192000, Ext: ".mp3", ContentType: "audio/mpeg"},
+	{Name: "mp3-128", Codec: "libmp3lame", Format: "mp3", Bitrate: 128000, Ext: ".mp3", ContentType: "audio/mpeg"},
+	{Name: "aac-128", Codec: "aac", Format: "adts", Bitrate: 128000, Ext: ".aac", ContentType: "audio/aac"},
+	{Name: "opus-96", Codec: "libopus", Format: "ogg", Bitrate: 96000, Ext: ".opus", ContentType: "audio/ogg"},
+}
+
+func FindProfile(name string) (Profile, bool) {
+	for _, p := range Profiles {
+		if strings.EqualFold(p.Name, name) {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// Stream transcodes in to out using p, piping through ffmpeg without
+// touching the disk. Containers that need random access on input (such as
+// MP4 files with a trailing moov atom) may fail to decode from a pipe.
+func Stream(ctx context.Context, in io.Reader, out io.Writer, p Profile) error {
+	cmd := exec.CommandContext(ctx, FFmpegPath,
+		"-hide_banner", "-loglevel", "error",
+		"-i", "pipe:0",
+		"-map", "0:a:0",
+		"-vn",
+		"-c:a", p.Codec,
+		"-b:a", strconv.Itoa(p.Bitrate),
+		"-f", p.Format,
+		"pipe:1",
+	)
+	cmd.Stdin = in
+	cmd.Stdout = out
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return ffmpegError(err, stderr.String())
+	}
+	return nil
+}
@@ -25,6 +25,21 @@ type Source struct {
 	Enabled   bool       `json:"enabled" db:"enabled"`
 	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
 	UpdatedAt time.Time  `json:"updatedAt" db:"updated_at"`
+
+	// Zero means unlimited for both limits
+	MaxStreams   int   `json:"maxStreams" db:"max_streams"`
+	MaxBandwidth int64 `json:"maxBandwidth" db:"max_bandwidth"` // bytes per second
+
+	// HasPassword stands in for Password in responses, see Redacted
+	HasPassword bool `json:"hasPassword" db:"-"`
+}
+
+// Redacted returns s as it may be sent to clients, with the password
+// replaced by whether one is stored.
+func (s Source) Redacted() Source {
+	s.HasPassword = s.Password != nil && *s.Password != ""
+	s.Password = nil
+	return s
 }
 
 type SourceStatus struct {
@@ -37,6 +52,23 @@ type SourceStatus struct {
 	LastScan     *time.Time `json:"lastScan,omitempty" db:"last_scan"`
 }
 
+// SourceStats reports a source's stream usage since the server started.
+type SourceStats struct {
+	ActiveStreams int   `json:"activeStreams"`
+	MaxStreams    int   `json:"maxStreams"`
+	MaxBandwidth  int64 `json:"maxBandwidth"`
+	BytesRead     int64 `json:"bytesRead"`
+	Rejected      int64 `json:"rejected"`
+}
+
+// SourceSummary is a source as the API lists it. Passwords are never
+// included.
+type SourceSummary struct {
+	Source
+	Status SourceStatus `json:"status"`
+	Stats  SourceStats  `json:"stats"`
+}
+
 type Artist struct {
 	ID        string    `json:"id" db:"id"`
 	Name      string    `json:"name" db:"name"`
@@ -51,6 +83,47 @@ type Album struct {
 	CreatedAt time.Time `json:"createdAt" db:"created_at"`
 }
 
+// ArtistSummary is an artist with aggregates over its library tracks.
+type ArtistSummary struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	AlbumCount int     `json:"albumCount"`
+	ImageUrl   *string `json:"imageUrl,omitempty"`
+}
+
+// AlbumSummary is an album with aggregates over its library tracks.
+type AlbumSummary struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	ArtistID   string    `json:"artistId"`
+	Artist     string    `json:"artist"`
+	ImageUrl   *string   `json:"imageUrl,omitempty"`
+	TrackCount int       `json:"trackCount"`
+	Duration   float64   `json:"duration"`
+	Year       *int      `json:"year,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// AlbumDetail is an album with its tracks in disc order.
+type AlbumDetail struct {
+	AlbumSummary
+	Tracks []Track `json:"tracks"`
+}
+
+// Folder is a directory holding tracks; its ID is the folder path.
+type Folder struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	TrackCount int     `json:"trackCount"`
+	ImageUrl   *string `json:"imageUrl,omitempty"`
+}
+
+// PlayStat is how often and when a user last played a track.
+type PlayStat struct {
+	Count      int64     `json:"count"`
+	LastPlayed time.Time `json:"lastPlayed"`
+}
+
 type Track struct {
 	ID          string    `json:"id" db:"id"`
 	Title       string    `json:"title" db:"title"`
@@ -75,6 +148,120 @@ type Playlist struct {
 	Name      string    `json:"name" db:"name"`
 	CreatedAt time.Time `json:"createdAt" db:"created_at"`
 	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+
+	Smart bool        `json:"smart" db:"smart"`
+	Rules *SmartRules `json:"rules,omitempty" db:"rules"`
+
+	// Set for playlists discovered as files on a source during a scan
+	SourceID   *string `json:"sourceId,omitempty" db:"source_id"`
+	SourcePath *string `json:"sourcePath,omitempty" db:"source_path"`
+
+	// Playlists without an owner predate accounts or come from a source;
+	// every user can see them and only admins can change them
+	OwnerID    *string            `json:"ownerId,omitempty" db:"owner_id"`
+	Visibility PlaylistVisibility `json:"visibility" db:"visibility"`
+}
+
+type PlaylistVisibility string
+
+const (
+	// VisibilityPrivate limits a playlist to its owner and collaborators
+	VisibilityPrivate PlaylistVisibility = "private"
+	// VisibilityShared lets any signed-in user open and follow the playlist
+	VisibilityShared PlaylistVisibility = "shared"
+	// VisibilityPublic also lists the playlist for everyone to browse
+	VisibilityPublic PlaylistVisibility = "public"
+)
+
+// PlaylistAccess is what a user may do with a playlist. Levels are ordered
+// so callers can compare against the minimum they need.
+type PlaylistAccess int
+
+const (
+	AccessNone PlaylistAccess = iota
+	AccessView
+	AccessEdit
+	AccessOwner
+)
+
+func (a PlaylistAccess) String() string {
+	switch a {
+	case AccessView:
+		return "view"
+	case AccessEdit:
+		return "edit"
+	case AccessOwner:
+		return "owner"
+	default:
+		return "none"
+	}
+}
+
+func (a PlaylistAccess) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// PlaylistSummary is a playlist as listed for a user, with what they may
+// do with it and aggregates over its tracks.
+type PlaylistSummary struct {
+	Playlist
+	ReadOnly   bool           `json:"readOnly"`
+	Owner      *string        `json:"owner,omitempty"`
+	Access     PlaylistAccess `json:"access"`
+	Following  bool           `json:"following"`
+	TrackCount int            `json:"trackCount"`
+	Duration   float64        `json:"duration"`
+}
+
+// PlaylistDetail is a playlist with its tracks. Items runs parallel to
+// Tracks so clients can address individual entries; smart playlists have
+// no items.
+type PlaylistDetail struct {
+	PlaylistSummary
+	Tracks []Track        `json:"tracks"`
+	Items  []PlaylistItem `json:"items"`
+}
+
+// SummarizeTracks sets TrackCount and Duration from Tracks.
+func (p *PlaylistDetail) SummarizeTracks() {
+	p.TrackCount = len(p.Tracks)
+	p.Duration = 0
+	for _, t := range p.Tracks {
+		p.Duration += t.Duration
+	}
+}
+
+type CollaboratorPermission string
+
+const (
+	PermissionView CollaboratorPermission = "view"
+	PermissionEdit CollaboratorPermission = "edit"
+)
+
+type PlaylistCollaborator struct {
+	UserID     string                 `json:"userId" db:"user_id"`
+	Username   string                 `json:"username" db:"username"`
+	Permission CollaboratorPermission `json:"permission" db:"permission"`
+	CreatedAt  time.Time              `json:"createdAt" db:"created_at"`
+}
+
+// SmartRule is either a condition (Field, Operator, Value) or a group
+// combining nested rules with Match "all" (AND) or "any" (OR).
+type SmartRule struct {
+	Field    string      `json:"field,omitempty"`
+	Operator string      `json:"operator,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+
+	Match string      `json:"match,omitempty"`
+	Rules []SmartRule `json:"rules,omitempty"`
+}
+
+// SmartRules is the root of a smart playlist definition, stored as JSON.
+type SmartRules struct {
+	SmartRule
+	Sort  string `json:"sort,omitempty"`
+	Order string `json:"order,omitempty"` // "asc" or "desc"
+	Limit int    `json:"limit,omitempty"`
 }
 
 type PlaylistItem struct {
@@ -84,3 +271,43 @@ type PlaylistItem struct {
 	Order      int       `json:"order" db:"order"`
 	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
 }
+
+type UserRole string
+
+const (
+	RoleAdmin UserRole = "admin"
+	RoleUser  UserRole = "user"
+)
+
+type User struct {
+	ID           string    `json:"id" db:"id"`
+	Username     string    `json:"username" db:"username"`
+	PasswordHash string    `json:"-" db:"password_hash"`
+	Role         UserRole  `json:"role" db:"role"`
+	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt    time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+type TokenScope string
+
+const (
+	ScopeLibrary   TokenScope = "library"
+	ScopeStream    TokenScope = "stream"
+	ScopePlaylists TokenScope = "playlists"
+	ScopeSources   TokenScope = "sources"
+	// ScopeAdmin grants every other scope as well
+	ScopeAdmin TokenScope = "admin"
+)
+
+// APIToken is a long-lived credential for scripts. Only a hash of the
+// secret is stored; the secret itself is shown once at creation.
+type APIToken struct {
+	ID         string       `json:"id" db:"id"`
+	UserID     string       `json:"userId" db:"user_id"`
+	Name       string       `json:"name" db:"name"`
+	TokenHash  string       `json:"-" db:"token_hash"`
+	Scopes     []TokenScope `json:"scopes" db:"scopes"`
+	CreatedAt  time.Time    `json:"createdAt" db:"created_at"`
+	LastUsedAt *time.Time   `json:"lastUsedAt,omitempty" db:"last_used_at"`
+	ExpiresAt  *time.Time   `json:"expiresAt,omitempty" db:"expires_at"`
+}